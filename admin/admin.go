@@ -0,0 +1,427 @@
+// Package admin 实现 `stripe-pay admin` 运维命令行工具，复用 conf、db、cache 和支付服务
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"stripe-pay/biz"
+	"stripe-pay/biz/models"
+	"stripe-pay/biz/services"
+	"stripe-pay/cache"
+	"stripe-pay/db"
+	"stripe-pay/tenant"
+	"time"
+)
+
+// errUsage 参数错误（打印用法并以退出码 2 退出）
+var errUsage = errors.New("usage error")
+
+// command 一个 admin 子命令
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"payment", "payment (-payment-id ID | -intent pi_... | -user USER [-limit N]) [-tenant ID]", "look up payments", runPayment},
+	{"refund", "refund -intent pi_... [-amount CENTS] [-reason REASON] [-tenant ID] [-yes]", "issue a full or partial refund", runRefund},
+	{"config", "config get [-currency hkd] [-tenant ID] | config set -amount CENTS [-currency hkd] [-description TEXT] [-tenant ID]", "show or update payment_config", runConfig},
+	{"cache", "cache flush (-payment-id ID | -intent pi_... | -user USER) [-tenant ID]", "flush cache keys for a payment or user", runCache},
+	{"webhook", "webhook replay -event evt_... [-account id] [-test]", "re-fetch a Stripe event and process it like a webhook", runWebhook},
+	{"user-info", "user-info -user USER [-tenant ID]", "print the user_payment_info record for a user", runUserInfo},
+	{"reconcile", "reconcile [-dry-run] [-tenant ID] | reconcile history [-limit N] [-tenant ID]", "reconcile payment_history with Stripe or show past runs", runReconcile},
+}
+
+// output 命令结果输出目标
+var output io.Writer = os.Stdout
+
+// Run 执行 admin 子命令，返回进程退出码
+// 调用前需要先完成 conf.Init 和日志初始化
+func Run(args []string) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage()
+		return 2
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == args[0] {
+			cmd = &commands[i]
+			break
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown admin command: %s\n\n", args[0])
+		printUsage()
+		return 2
+	}
+
+	if err := db.Init(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	// Redis 是可选的：未配置时缓存相关操作直接跳过
+	if err := cache.Init(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to initialize Redis cache: %v\n", err)
+	}
+	defer cache.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	if err := cmd.run(ctx, args[1:]); err != nil {
+		if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "usage: stripe-pay admin %s\n", cmd.usage)
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "admin %s failed: %v\n", cmd.name, err)
+		return 1
+	}
+	return 0
+}
+
+// printUsage 打印 admin 命令用法
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: stripe-pay admin <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+		fmt.Fprintf(os.Stderr, "  %-10s   stripe-pay admin %s\n", "", cmd.usage)
+	}
+}
+
+// newFlagSet 创建子命令参数解析器（错误由调用方统一处理）
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// tenantFlag 添加 -tenant 参数：按用户、payment_id 查询和缓存键都按租户隔离
+func tenantFlag(fs *flag.FlagSet) *string {
+	return fs.String("tenant", tenant.DefaultID, "tenant id")
+}
+
+// printJSON 以缩进 JSON 格式输出
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(output)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// runPayment 按 payment_id、payment_intent_id 或 user_id 查询支付记录
+func runPayment(ctx context.Context, args []string) error {
+	fs := newFlagSet("payment")
+	paymentID := fs.String("payment-id", "", "internal payment_id (UUID)")
+	intentID := fs.String("intent", "", "Stripe PaymentIntent ID")
+	userID := fs.String("user", "", "user_id")
+	limit := fs.Int("limit", 20, "max rows when querying by user")
+	tenantID := tenantFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx = tenant.WithID(ctx, *tenantID)
+
+	switch {
+	case *paymentID != "":
+		payment, err := db.GetPaymentByPaymentID(ctx, *paymentID)
+		if err != nil {
+			return err
+		}
+		if payment == nil {
+			return fmt.Errorf("payment %s not found", *paymentID)
+		}
+		return printJSON(payment)
+
+	case *intentID != "":
+		payment, err := db.GetPaymentByIntentID(ctx, *intentID)
+		if err != nil {
+			return err
+		}
+		if payment == nil {
+			return fmt.Errorf("payment intent %s not found", *intentID)
+		}
+		return printJSON(payment)
+
+	case *userID != "":
+		if err := biz.ValidateUserID(*userID); err != nil {
+			return err
+		}
+		history, err := db.GetPaymentHistory(ctx, *userID, *limit)
+		if err != nil {
+			return err
+		}
+		return printJSON(history)
+
+	default:
+		return errUsage
+	}
+}
+
+// runRefund 发起退款（默认需要 -yes 确认）
+func runRefund(ctx context.Context, args []string) error {
+	fs := newFlagSet("refund")
+	intentID := fs.String("intent", "", "Stripe PaymentIntent ID to refund")
+	amount := fs.Int64("amount", 0, "refund amount in cents (default: full refund)")
+	reason := fs.String("reason", "", "duplicate, fraudulent or requested_by_customer")
+	yes := fs.Bool("yes", false, "skip confirmation prompt")
+	tenantID := tenantFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *intentID == "" {
+		return errUsage
+	}
+	ctx = tenant.WithID(ctx, *tenantID)
+
+	payment, err := db.GetPaymentByIntentID(ctx, *intentID)
+	if err != nil {
+		return err
+	}
+	if payment != nil {
+		fmt.Fprintf(output, "payment %s: user=%s amount=%d %s status=%s\n",
+			payment.PaymentID, payment.UserID, payment.Amount, payment.Currency, payment.Status)
+	}
+
+	if !*yes {
+		what := "full refund"
+		if *amount > 0 {
+			what = fmt.Sprintf("refund of %d", *amount)
+		}
+		if !confirm(fmt.Sprintf("Issue %s for %s?", what, *intentID)) {
+			return errors.New("aborted")
+		}
+	}
+
+	result, err := services.NewPaymentService().RefundPayment(ctx, &models.RefundRequest{
+		PaymentIntentID: *intentID,
+		Amount:          *amount,
+		Reason:          *reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	return printJSON(map[string]interface{}{
+		"refund_id": result.ID,
+		"status":    result.Status,
+		"amount":    result.Amount,
+		"currency":  result.Currency,
+	})
+}
+
+// runConfig 查看或更新 payment_config
+func runConfig(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	fs := newFlagSet("config " + args[0])
+	currency := fs.String("currency", "hkd", "currency")
+	amount := fs.Int64("amount", 0, "amount in cents")
+	description := fs.String("description", "", "description")
+	tenantID := tenantFlag(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	ctx = tenant.WithID(ctx, *tenantID)
+	if err := biz.ValidateCurrency(*currency); err != nil {
+		return err
+	}
+	*currency = strings.ToLower(*currency)
+
+	switch args[0] {
+	case "get":
+	case "set":
+		if err := biz.ValidateAmount(*amount); err != nil {
+			return err
+		}
+		if err := biz.ValidateDescription(*description); err != nil {
+			return err
+		}
+		if *description == "" {
+			*description = "Payment amount configuration"
+		}
+		if err := db.UpdatePaymentConfig(ctx, *currency, *amount, *description); err != nil {
+			return err
+		}
+	default:
+		return errUsage
+	}
+
+	config, err := db.GetPaymentConfig(ctx, *currency)
+	if err != nil {
+		return err
+	}
+	return printJSON(config)
+}
+
+// runCache 清除支付或用户相关的缓存
+func runCache(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "flush" {
+		return errUsage
+	}
+
+	fs := newFlagSet("cache flush")
+	paymentID := fs.String("payment-id", "", "internal payment_id (UUID)")
+	intentID := fs.String("intent", "", "Stripe PaymentIntent ID")
+	userID := fs.String("user", "", "user_id")
+	tenantID := tenantFlag(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	ctx = tenant.WithID(ctx, *tenantID)
+	if *paymentID == "" && *intentID == "" && *userID == "" {
+		return errUsage
+	}
+
+	if !cache.IsAvailable() {
+		fmt.Fprintln(output, "Redis not configured, nothing to flush")
+		return nil
+	}
+
+	// 补全 payment_id / payment_intent_id，两个维度的缓存都要清除
+	if *paymentID != "" && *intentID == "" {
+		if payment, err := db.GetPaymentByPaymentID(ctx, *paymentID); err == nil && payment != nil {
+			*intentID = payment.PaymentIntentID
+		}
+	}
+	if *intentID != "" && *paymentID == "" {
+		if payment, err := db.GetPaymentByIntentID(ctx, *intentID); err == nil && payment != nil {
+			*paymentID = payment.PaymentID
+		}
+	}
+
+	var errs []error
+	if *paymentID != "" {
+		errs = append(errs, cache.DeletePayment(ctx, *paymentID))
+		fmt.Fprintf(output, "flushed %s\n", tenant.Key(ctx, cache.PaymentKeyPrefix+*paymentID))
+	}
+	if *intentID != "" {
+		errs = append(errs,
+			cache.DeletePaymentByIntentID(ctx, *intentID),
+			cache.DeleteStripeStatus(ctx, *intentID),
+			cache.ClearStatusChangeEvent(ctx, *intentID),
+			// Webhook 路径按 payment_intent_id 删除 payment: 键，这里保持一致
+			cache.DeletePayment(ctx, *intentID),
+		)
+		fmt.Fprintf(output, "flushed %s, %s, %s\n",
+			tenant.Key(ctx, cache.PaymentIntentKeyPrefix+*intentID),
+			tenant.Key(ctx, cache.StripeStatusKeyPrefix+*intentID),
+			tenant.Key(ctx, cache.StatusChangeEventPrefix+*intentID))
+	}
+	if *userID != "" {
+		errs = append(errs, cache.InvalidateUserPaymentCache(ctx, *userID))
+		fmt.Fprintf(output, "flushed %s:*\n", tenant.Key(ctx, cache.UserPaymentKeyPrefix+*userID))
+	}
+
+	return errors.Join(errs...)
+}
+
+// runWebhook 重放 Stripe 事件
+func runWebhook(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "replay" {
+		return errUsage
+	}
+
+	fs := newFlagSet("webhook replay")
+	eventID := fs.String("event", "", "Stripe event ID (evt_...)")
+	account := fs.String("account", "default", "Stripe account id from stripe.accounts")
+	testMode := fs.Bool("test", false, "fetch the event with the account's test mode key")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *eventID == "" {
+		return errUsage
+	}
+
+	evt, err := services.NewPaymentService().ReplayStripeEvent(ctx, *account, *testMode, *eventID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(output, "replayed %s (%s, created %s)\n",
+		evt.ID, evt.Type, time.Unix(evt.Created, 0).Format(time.RFC3339))
+	return nil
+}
+
+// runUserInfo 输出 user_payment_info 表中的用户支付汇总
+func runUserInfo(ctx context.Context, args []string) error {
+	fs := newFlagSet("user-info")
+	userID := fs.String("user", "", "user_id")
+	tenantID := tenantFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx = tenant.WithID(ctx, *tenantID)
+	if *userID == "" {
+		return errUsage
+	}
+	if err := biz.ValidateUserID(*userID); err != nil {
+		return err
+	}
+
+	record, err := db.GetUserPaymentInfoRecord(ctx, *userID)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return fmt.Errorf("no user_payment_info record for user %s", *userID)
+	}
+	return printJSON(record)
+}
+
+// runReconcile 立即执行一次对账，或查看历史对账记录
+func runReconcile(ctx context.Context, args []string) error {
+	if len(args) > 0 && args[0] == "history" {
+		fs := newFlagSet("reconcile history")
+		limit := fs.Int("limit", 20, "max runs to show")
+		tenantID := tenantFlag(fs)
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		runs, err := db.ListReconciliationRuns(tenant.WithID(ctx, *tenantID), *limit)
+		if err != nil {
+			return err
+		}
+		return printJSON(runs)
+	}
+
+	fs := newFlagSet("reconcile")
+	dryRun := fs.Bool("dry-run", false, "report mismatches without correcting them")
+	tenantID := tenantFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := services.NewPaymentService().ReconcilePayments(tenant.WithID(ctx, *tenantID), services.ReconcileOptions{
+		TriggerSource: "admin",
+		DryRun:        *dryRun,
+	})
+	if report != nil {
+		if printErr := printJSON(report); printErr != nil {
+			return printErr
+		}
+	}
+	return err
+}
+
+// confirm 交互式确认
+func confirm(prompt string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", prompt)
+	var answer string
+	fmt.Fscanln(os.Stdin, &answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
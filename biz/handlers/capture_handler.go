@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"stripe-pay/biz"
+	"stripe-pay/biz/models"
+	"stripe-pay/biz/services"
+	"stripe-pay/common"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/stripe/stripe-go/v78"
+	"go.uber.org/zap"
+)
+
+// CapturePayment captures an authorized (capture_method=manual) payment, fully or partially
+func CapturePayment(ctx context.Context, c *app.RequestContext) {
+	var req models.CaptureRequest
+	if err := c.BindAndValidate(&req); err != nil || req.PaymentIntentID == "" {
+		common.SendError(c, common.ErrMissingParameter.WithDetails("payment_intent_id required"))
+		return
+	}
+	if req.Amount < 0 {
+		common.SendError(c, common.ErrValidationFailed.WithDetails("amount must be positive"))
+		return
+	}
+
+	pi, err := getPaymentService().CapturePayment(ctx, &req, getIdempotencyKey(c))
+	if err != nil {
+		sendAuthorizationError(c, "Failed to capture payment", req.PaymentIntentID, err)
+		return
+	}
+
+	c.JSON(consts.StatusOK, authorizationResponse(pi))
+}
+
+// CancelAuthorization cancels an authorized payment that has not been captured
+func CancelAuthorization(ctx context.Context, c *app.RequestContext) {
+	var req models.CancelAuthorizationRequest
+	if err := c.BindAndValidate(&req); err != nil || req.PaymentIntentID == "" {
+		common.SendError(c, common.ErrMissingParameter.WithDetails("payment_intent_id required"))
+		return
+	}
+
+	pi, err := getPaymentService().CancelAuthorization(ctx, &req)
+	if err != nil {
+		sendAuthorizationError(c, "Failed to cancel authorization", req.PaymentIntentID, err)
+		return
+	}
+
+	c.JSON(consts.StatusOK, authorizationResponse(pi))
+}
+
+// sendAuthorizationError maps capture/cancel errors to API errors
+func sendAuthorizationError(c *app.RequestContext, message, paymentIntentID string, err error) {
+	var validationErr *biz.ValidationError
+	var stateErr *services.PaymentStateError
+	switch {
+	case errors.As(err, &validationErr):
+		common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+	case errors.As(err, &stateErr):
+		common.SendError(c, common.ErrConflict.WithDetails(err.Error()))
+	case services.IsNotFoundError(err):
+		common.SendError(c, common.ErrPaymentNotFound)
+	default:
+		zap.L().Error(message, zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
+		sendUpstreamError(c, err, common.ErrPaymentProcessing.WithDetails(err.Error()))
+	}
+}
+
+// authorizationResponse builds the capture/cancel response body
+func authorizationResponse(pi *stripe.PaymentIntent) utils.H {
+	return utils.H{
+		"payment_intent_id": pi.ID,
+		"status":            pi.Status,
+		"amount":            pi.Amount,
+		"amount_received":   pi.AmountReceived,
+		"currency":          pi.Currency,
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"stripe-pay/biz/models"
+	"stripe-pay/biz/services"
+	"stripe-pay/common"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"go.uber.org/zap"
+)
+
+// CreateCheckoutSession creates a Stripe-hosted Checkout Session at the current price
+func CreateCheckoutSession(ctx context.Context, c *app.RequestContext) {
+	var req models.CreateCheckoutSessionRequest
+	if err := c.BindAndValidate(&req); err != nil || req.UserID == "" {
+		common.SendError(c, common.ErrMissingParameter.WithDetails("user_id required"))
+		return
+	}
+	req.ClientIP = c.ClientIP()
+
+	response, err := getPaymentService().CreateCheckoutSession(ctx, &req, getIdempotencyKey(c))
+	if err != nil {
+		if alreadyPaidErr, ok := err.(*services.AlreadyPaidError); ok {
+			c.JSON(consts.StatusOK, utils.H{
+				"already_paid":   true,
+				"message":        "User has already paid successfully, no need to pay again",
+				"user_info":      alreadyPaidErr.UserInfo,
+				"days_remaining": alreadyPaidErr.DaysRemaining,
+			})
+			return
+		}
+
+		errStr := strings.ToLower(err.Error())
+		switch {
+		case isFraudBlockedError(err):
+			common.SendError(c, common.ErrForbidden.WithDetails(err.Error()))
+		case strings.Contains(errStr, "idempotency key already used"):
+			common.SendError(c, common.ErrConflict.WithDetails(err.Error()))
+		case strings.Contains(errStr, "required") || strings.Contains(errStr, "invalid"):
+			common.SendError(c, err)
+		default:
+			zap.L().Error("Failed to create checkout session", zap.Error(err), zap.String("user_id", req.UserID))
+			sendUpstreamError(c, err, common.ErrPaymentProcessing.WithDetails(err.Error()))
+		}
+		return
+	}
+
+	c.JSON(consts.StatusOK, response)
+}
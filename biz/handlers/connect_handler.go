@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"stripe-pay/biz"
+	"stripe-pay/biz/services"
+	"stripe-pay/common"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"go.uber.org/zap"
+)
+
+// isConnectError reports whether a payment creation error was caused by the creator_id (Connect disabled,
+// invalid creator or a creator that cannot receive payments yet)
+func isConnectError(err error) bool {
+	var unavailableErr *services.ConnectAccountUnavailableError
+	var validationErr *biz.ValidationError
+	return errors.Is(err, services.ErrConnectDisabled) ||
+		errors.As(err, &unavailableErr) ||
+		(errors.As(err, &validationErr) && validationErr.Field == "creator_id")
+}
+
+// CreateConnectOnboardingLink creates the creator's connected account if needed and returns
+// a one-time Stripe onboarding link, or an account update link once details are submitted.
+// The route requires a user token for user_id (UserAuthMiddleware)
+func CreateConnectOnboardingLink(ctx context.Context, c *app.RequestContext) {
+	userID := c.Param("user_id")
+	if err := biz.ValidateUserID(userID); err != nil {
+		common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+		return
+	}
+
+	result, err := getPaymentService().CreateConnectOnboardingLink(ctx, userID)
+	if err != nil {
+		var validationErr *biz.ValidationError
+		switch {
+		case errors.Is(err, services.ErrConnectDisabled):
+			common.SendError(c, common.ErrForbidden.WithDetails(err.Error()))
+		case errors.As(err, &validationErr):
+			common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+		default:
+			zap.L().Error("Failed to create connect onboarding link", zap.Error(err), zap.String("user_id", userID))
+			sendUpstreamError(c, err, common.ErrExternalService.WithDetails("Failed to create onboarding link"))
+		}
+		return
+	}
+
+	c.JSON(consts.StatusOK, result)
+}
+
+// GetConnectedAccount gets the creator's connected account and whether it can receive payments
+func GetConnectedAccount(ctx context.Context, c *app.RequestContext) {
+	userID := c.Param("user_id")
+	if err := biz.ValidateUserID(userID); err != nil {
+		common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+		return
+	}
+
+	account, err := getPaymentService().GetConnectedAccount(ctx, userID)
+	if err != nil {
+		if errors.Is(err, services.ErrConnectDisabled) {
+			common.SendError(c, common.ErrForbidden.WithDetails(err.Error()))
+			return
+		}
+		zap.L().Error("Failed to get connected account", zap.Error(err), zap.String("user_id", userID))
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to get connected account"))
+		return
+	}
+	if account == nil {
+		common.SendError(c, common.ErrNotFound.WithDetails("no connected account for user: "+userID))
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"user_id":           account.UserID,
+		"account_id":        account.AccountID,
+		"account_type":      account.AccountType,
+		"charges_enabled":   account.ChargesEnabled,
+		"payouts_enabled":   account.PayoutsEnabled,
+		"details_submitted": account.DetailsSubmitted,
+		"disabled_reason":   account.DisabledReason,
+		"livemode":          account.Livemode,
+		"updated_at":        account.UpdatedAt,
+	})
+}
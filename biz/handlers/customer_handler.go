@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"stripe-pay/biz"
+	"stripe-pay/biz/models"
+	"stripe-pay/biz/services"
+	"stripe-pay/common"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"go.uber.org/zap"
+)
+
+// CreateSetupIntent creates a SetupIntent so the user can save a card for later payments
+func CreateSetupIntent(ctx context.Context, c *app.RequestContext) {
+	var req models.CreateSetupIntentRequest
+	if err := c.BindAndValidate(&req); err != nil {
+		common.SendError(c, common.ErrInvalidRequest.WithDetails("Failed to bind request"))
+		return
+	}
+
+	if err := biz.ValidateUserID(req.UserID); err != nil {
+		common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+		return
+	}
+	// user_id is in the body, so the token is checked here instead of by UserAuthMiddleware
+	if !common.AuthenticateUser(ctx, c, req.UserID) {
+		return
+	}
+
+	result, err := getPaymentService().CreateSetupIntent(ctx, req.UserID)
+	if err != nil {
+		zap.L().Error("Failed to create setup intent", zap.Error(err), zap.String("user_id", req.UserID))
+		sendUpstreamError(c, err, common.ErrPaymentProcessing.WithDetails(err.Error()))
+		return
+	}
+
+	c.JSON(consts.StatusOK, result)
+}
+
+// ListSavedPaymentMethods lists the cards saved on the user's Stripe customer
+func ListSavedPaymentMethods(ctx context.Context, c *app.RequestContext) {
+	userID := c.Param("user_id")
+	if err := biz.ValidateUserID(userID); err != nil {
+		common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+		return
+	}
+
+	methods, err := getPaymentService().ListSavedPaymentMethods(ctx, userID)
+	if err != nil {
+		zap.L().Error("Failed to list saved payment methods", zap.Error(err), zap.String("user_id", userID))
+		sendUpstreamError(c, err, common.ErrExternalService.WithDetails("Failed to list payment methods"))
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"user_id":         userID,
+		"count":           len(methods),
+		"payment_methods": methods,
+	})
+}
+
+// DeleteSavedPaymentMethod detaches a saved card from the user's Stripe customer
+func DeleteSavedPaymentMethod(ctx context.Context, c *app.RequestContext) {
+	userID := c.Param("user_id")
+	paymentMethodID := c.Param("payment_method_id")
+	if err := biz.ValidateUserID(userID); err != nil {
+		common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+		return
+	}
+	if err := biz.ValidatePaymentMethodID(paymentMethodID); err != nil {
+		common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+		return
+	}
+
+	if err := getPaymentService().DeleteSavedPaymentMethod(ctx, userID, paymentMethodID); err != nil {
+		var ownershipErr *services.PaymentMethodOwnershipError
+		if errors.As(err, &ownershipErr) {
+			common.SendError(c, common.ErrNotFound.WithDetails(err.Error()))
+			return
+		}
+		zap.L().Error("Failed to delete saved payment method", zap.Error(err), zap.String("user_id", userID))
+		sendUpstreamError(c, err, common.ErrExternalService.WithDetails("Failed to delete payment method"))
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"user_id":           userID,
+		"payment_method_id": paymentMethodID,
+		"deleted":           true,
+	})
+}
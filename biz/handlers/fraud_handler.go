@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"stripe-pay/biz"
+	"stripe-pay/biz/models"
+	"stripe-pay/biz/services"
+	"stripe-pay/common"
+	"stripe-pay/db"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"go.uber.org/zap"
+)
+
+// isFraudBlockedError reports whether a payment creation error was caused by a fraud rule
+func isFraudBlockedError(err error) bool {
+	var fraudErr *services.FraudBlockedError
+	return errors.As(err, &fraudErr)
+}
+
+// CreateFraudRule creates a fraud rule (admin)
+func CreateFraudRule(ctx context.Context, c *app.RequestContext) {
+	var req models.CreateFraudRuleRequest
+	if err := c.BindAndValidate(&req); err != nil {
+		common.SendError(c, common.ErrInvalidRequest.WithDetails("Failed to bind request: "+err.Error()))
+		return
+	}
+
+	rule, err := getPaymentService().CreateFraudRule(ctx, &req)
+	if err != nil {
+		var validationErr *biz.ValidationError
+		if errors.As(err, &validationErr) {
+			common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+			return
+		}
+		zap.L().Error("Failed to create fraud rule", zap.Error(err))
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to create fraud rule"))
+		return
+	}
+
+	c.JSON(consts.StatusOK, rule)
+}
+
+// ListFraudRules lists fraud rules; ?active=true returns only active, unexpired rules (admin)
+func ListFraudRules(ctx context.Context, c *app.RequestContext) {
+	if db.DB == nil {
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Database not available"))
+		return
+	}
+
+	activeOnly, _ := strconv.ParseBool(c.Query("active"))
+	rules, err := db.ListFraudRules(ctx, activeOnly)
+	if err != nil {
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to list fraud rules"))
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"count": len(rules),
+		"rules": rules,
+	})
+}
+
+// DeactivateFraudRule deactivates a fraud rule (admin)
+func DeactivateFraudRule(ctx context.Context, c *app.RequestContext) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		common.SendError(c, common.ErrValidationFailed.WithDetails("invalid rule id"))
+		return
+	}
+
+	found, err := getPaymentService().DeactivateFraudRule(ctx, id)
+	if err != nil {
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to deactivate fraud rule"))
+		return
+	}
+	if !found {
+		common.SendError(c, common.ErrNotFound.WithDetails("fraud rule not found: "+c.Param("id")))
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"id":      id,
+		"active":  false,
+		"message": "Fraud rule deactivated",
+	})
+}
+
+// ListFraudDecisions lists recorded fraud decisions, newest first (admin)
+// Optional filters: decision (allow, block, review), user_id, limit (default 100, max 500)
+func ListFraudDecisions(ctx context.Context, c *app.RequestContext) {
+	if db.DB == nil {
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Database not available"))
+		return
+	}
+
+	decision := c.Query("decision")
+	switch decision {
+	case "", db.FraudDecisionAllow, db.FraudDecisionBlock, db.FraudDecisionReview:
+	default:
+		common.SendError(c, common.ErrValidationFailed.WithDetails("decision must be allow, block or review"))
+		return
+	}
+
+	userID := c.Query("user_id")
+	if userID != "" {
+		if err := biz.ValidateUserID(userID); err != nil {
+			common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+			return
+		}
+	}
+
+	limit := 100
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 500 {
+			limit = parsedLimit
+		}
+	}
+
+	decisions, err := db.ListFraudDecisions(ctx, decision, userID, limit)
+	if err != nil {
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to list fraud decisions"))
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"count":     len(decisions),
+		"decisions": decisions,
+	})
+}
@@ -3,12 +3,16 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"stripe-pay/biz/services"
 	"stripe-pay/cache"
+	"stripe-pay/common"
 	"stripe-pay/conf"
 	"stripe-pay/db"
+	"sync"
 	"time"
 
 	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
 	"github.com/cloudwego/hertz/pkg/protocol/consts"
 	"go.uber.org/zap"
 )
@@ -20,6 +24,64 @@ type HealthResponse struct {
 	Version   string            `json:"version"`
 	Uptime    string            `json:"uptime"`
 	Services  map[string]string `json:"services"`
+	Breakers  map[string]string `json:"circuit_breakers,omitempty"` // upstream -> closed, half_open, open
+}
+
+// 依赖状态
+const (
+	DependencyUp       = "up"
+	DependencyDown     = "down"
+	DependencyDegraded = "degraded" // 可用但部分异常（如熔断中、部分从库不可用）
+	DependencyDisabled = "disabled" // 未配置
+)
+
+// 就绪状态
+const (
+	ReadinessOK          = "ok"
+	ReadinessDegraded    = "degraded"    // 非关键依赖异常，仍然就绪
+	ReadinessUnavailable = "unavailable" // 关键依赖异常，不就绪
+)
+
+// 默认就绪检查参数
+const (
+	defaultReadinessCacheTTL = 2 * time.Second
+	defaultDependencyTimeout = 2 * time.Second
+)
+
+// defaultCriticalDependencies 默认关键依赖：异常时 /readyz 返回 503
+var defaultCriticalDependencies = map[string]bool{
+	"database":          true,
+	"database_replicas": false,
+	"redis":             false,
+	"stripe":            true,
+	"apple":             false,
+}
+
+// DependencyStatus 单个依赖的检查结果
+type DependencyStatus struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ReadinessResponse 就绪检查响应
+type ReadinessResponse struct {
+	Status       string                      `json:"status"`
+	Ready        bool                        `json:"ready"`
+	CheckedAt    time.Time                   `json:"checked_at"`
+	Cached       bool                        `json:"cached"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+	Details      *ReadinessDetails           `json:"details,omitempty"`
+}
+
+// ReadinessDetails verbose 模式下的运行信息
+type ReadinessDetails struct {
+	Version          string                 `json:"version"`
+	Uptime           string                 `json:"uptime"`
+	MigrationVersion *int64                 `json:"migration_version,omitempty"`
+	Breakers         map[string]string      `json:"circuit_breakers"`
+	Pools            map[string]interface{} `json:"pools"`
 }
 
 var (
@@ -27,70 +89,252 @@ var (
 	version   = "1.0.0" // 可以通过构建时注入：-ldflags "-X stripe-pay/biz/handlers.version=1.0.0"
 )
 
-// HealthCheck 健康检查处理器
+// readinessCache 缓存最近一次就绪检查结果，并发探测共享同一次检查
+var readinessCache struct {
+	mu        sync.Mutex
+	result    *ReadinessResponse
+	expiresAt time.Time
+}
+
+// Livez liveness probe: the process is running and serving HTTP. Dependencies are not checked,
+// so an outage of MySQL or Redis never gets the pod restarted.
+func Livez(ctx context.Context, c *app.RequestContext) {
+	c.JSON(consts.StatusOK, utils.H{
+		"status": "ok",
+		"uptime": formatUptime(time.Since(startTime)),
+	})
+}
+
+// Readyz readiness probe: 200 while every critical dependency is up, 503 otherwise.
+// Add ?verbose=true for migration version, circuit breaker states and connection pool stats.
+func Readyz(ctx context.Context, c *app.RequestContext) {
+	result := checkReadiness(ctx)
+	if verbose := string(c.Query("verbose")); verbose == "true" || verbose == "1" {
+		result.Details = readinessDetails(ctx)
+	}
+
+	statusCode := consts.StatusOK
+	if !result.Ready {
+		statusCode = consts.StatusServiceUnavailable
+	}
+	c.JSON(statusCode, result)
+}
+
+// HealthCheck 健康检查处理器（兼容旧接口，基于就绪检查结果：只有关键依赖异常时返回 503）
 func HealthCheck(ctx context.Context, c *app.RequestContext) {
+	result := checkReadiness(ctx)
 	response := HealthResponse{
 		Status:    "healthy",
 		Timestamp: time.Now(),
 		Version:   version,
 		Uptime:    formatUptime(time.Since(startTime)),
-		Services:  make(map[string]string),
+		Services:  make(map[string]string, len(result.Dependencies)),
+		Breakers:  common.BreakerStates(),
+	}
+	for name, dep := range result.Dependencies {
+		response.Services[name] = dep.Status
 	}
 
-	// 检查数据库连接
-	if db.DB != nil {
-		ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
-		defer cancel()
+	statusCode := consts.StatusOK
+	switch result.Status {
+	case ReadinessDegraded:
+		response.Status = "degraded"
+	case ReadinessUnavailable:
+		response.Status = "unhealthy"
+		statusCode = consts.StatusServiceUnavailable
+	}
+	c.JSON(statusCode, response)
+}
 
-		if err := db.DB.PingContext(ctx); err != nil {
-			response.Status = "unhealthy"
-			response.Services["database"] = "disconnected"
-			zap.L().Error("Database health check failed", zap.Error(err))
-		} else {
-			response.Services["database"] = "connected"
+// checkReadiness 返回就绪检查结果，缓存时间内直接返回上次结果
+func checkReadiness(ctx context.Context) ReadinessResponse {
+	cfg := conf.GetConf()
+	cacheTTL := defaultReadinessCacheTTL
+	if cfg.Health.CacheTTL > 0 {
+		cacheTTL = time.Duration(cfg.Health.CacheTTL) * time.Second
+	} else if cfg.Health.CacheTTL < 0 {
+		cacheTTL = 0
+	}
+
+	readinessCache.mu.Lock()
+	defer readinessCache.mu.Unlock()
+
+	if readinessCache.result != nil && time.Now().Before(readinessCache.expiresAt) {
+		result := *readinessCache.result
+		result.Cached = true
+		return result
+	}
+
+	deps := checkDependencies(ctx, cfg)
+	status, ready := readinessStatus(deps)
+	result := &ReadinessResponse{
+		Status:       status,
+		Ready:        ready,
+		CheckedAt:    time.Now(),
+		Dependencies: deps,
+	}
+	if !ready {
+		zap.L().Warn("Readiness check failed", zap.Any("dependencies", deps))
+	}
+
+	readinessCache.result = result
+	readinessCache.expiresAt = time.Now().Add(cacheTTL)
+	return *result
+}
+
+// checkDependencies 并发检查所有依赖
+func checkDependencies(ctx context.Context, cfg *conf.Config) map[string]DependencyStatus {
+	timeout := defaultDependencyTimeout
+	if cfg.Health.Timeout > 0 {
+		timeout = time.Duration(cfg.Health.Timeout) * time.Second
+	}
+
+	checks := map[string]func(ctx context.Context) (string, error){
+		"database": func(ctx context.Context) (string, error) {
+			if db.DB == nil {
+				return DependencyDisabled, nil
+			}
+			return pingStatus(db.DB.PingContext(ctx))
+		},
+		"redis": func(ctx context.Context) (string, error) {
+			client := cache.GetClient()
+			if !cache.IsAvailable() || client == nil {
+				return DependencyDisabled, nil
+			}
+			return pingStatus(client.Ping(ctx).Err())
+		},
+		"stripe": func(ctx context.Context) (string, error) {
+			if cfg.Stripe.SecretKey == "" {
+				return DependencyDown, fmt.Errorf("secret key not configured")
+			}
+			return breakerStatus(services.UpstreamStripe)
+		},
+		"apple": func(ctx context.Context) (string, error) {
+			if cfg.Apple.SharedSecret == "" {
+				return DependencyDisabled, nil
+			}
+			return breakerStatus(services.UpstreamApple)
+		},
+	}
+	if replicas := db.ReplicaStatuses(); len(replicas) > 0 {
+		checks["database_replicas"] = func(ctx context.Context) (string, error) {
+			return replicaStatus(replicas)
 		}
-	} else {
-		response.Services["database"] = "not configured"
 	}
 
-	// 检查 Redis 连接
-	if cache.IsAvailable() {
-		ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
-		defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	deps := make(map[string]DependencyStatus, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) (string, error)) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
 
-		client := cache.GetClient()
-		if client != nil {
-			if err := client.Ping(ctx).Err(); err != nil {
-				response.Status = "unhealthy"
-				response.Services["redis"] = "disconnected"
-				zap.L().Error("Redis health check failed", zap.Error(err))
-			} else {
-				response.Services["redis"] = "connected"
+			start := time.Now()
+			status, err := check(checkCtx)
+			dep := DependencyStatus{
+				Status:    status,
+				Critical:  isCriticalDependency(cfg, name),
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
 			}
-		} else {
-			response.Services["redis"] = "not available"
+			if err != nil {
+				dep.Error = err.Error()
+			}
+
+			mu.Lock()
+			deps[name] = dep
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return deps
+}
+
+// isCriticalDependency 依赖是否关键（配置优先，未配置时使用默认值）
+func isCriticalDependency(cfg *conf.Config, name string) bool {
+	if critical, ok := cfg.Health.Critical[name]; ok {
+		return critical
+	}
+	return defaultCriticalDependencies[name]
+}
+
+// pingStatus Ping 结果转换为依赖状态
+func pingStatus(err error) (string, error) {
+	if err != nil {
+		return DependencyDown, err
+	}
+	return DependencyUp, nil
+}
+
+// breakerStatus 外部服务按熔断状态判断：熔断中为 degraded（本服务仍可处理不依赖该服务的请求）
+func breakerStatus(upstream string) (string, error) {
+	if common.BreakerStates()[upstream] == common.BreakerOpen {
+		return DependencyDegraded, fmt.Errorf("circuit breaker open")
+	}
+	return DependencyUp, nil
+}
+
+// replicaStatus 从库状态：全部健康为 up，部分健康为 degraded，全部异常为 down（读取回退到主库）
+func replicaStatus(replicas []db.ReplicaStatus) (string, error) {
+	healthy := 0
+	for _, r := range replicas {
+		if r.Healthy {
+			healthy++
 		}
-	} else {
-		response.Services["redis"] = "not configured"
 	}
+	switch {
+	case healthy == len(replicas):
+		return DependencyUp, nil
+	case healthy == 0:
+		return DependencyDown, fmt.Errorf("no healthy replica, reads use the primary")
+	default:
+		return DependencyDegraded, fmt.Errorf("%d of %d replicas healthy", healthy, len(replicas))
+	}
+}
 
-	// 检查 Stripe 配置
-	cfg := conf.GetConf()
-	if cfg != nil && cfg.Stripe.SecretKey != "" {
-		response.Services["stripe"] = "configured"
-	} else {
-		response.Status = "unhealthy"
-		response.Services["stripe"] = "not configured"
-		zap.L().Warn("Stripe secret key not configured")
+// readinessStatus 汇总依赖状态：关键依赖 down 时不就绪，其他异常为 degraded
+func readinessStatus(deps map[string]DependencyStatus) (string, bool) {
+	status := ReadinessOK
+	for _, dep := range deps {
+		switch {
+		case dep.Status == DependencyDown && dep.Critical:
+			return ReadinessUnavailable, false
+		case dep.Status == DependencyDown || dep.Status == DependencyDegraded:
+			status = ReadinessDegraded
+		}
 	}
+	return status, true
+}
 
-	// 根据状态返回相应的 HTTP 状态码
-	statusCode := consts.StatusOK
-	if response.Status == "unhealthy" {
-		statusCode = consts.StatusServiceUnavailable
+// readinessDetails verbose 模式的运行信息（每次请求实时获取，不缓存）
+func readinessDetails(ctx context.Context) *ReadinessDetails {
+	details := &ReadinessDetails{
+		Version:  version,
+		Uptime:   formatUptime(time.Since(startTime)),
+		Breakers: common.BreakerStates(),
+		Pools:    make(map[string]interface{}),
 	}
 
-	c.JSON(statusCode, response)
+	if db.DB != nil {
+		details.Pools["database"] = db.DB.Stats()
+		if replicas := db.ReplicaStatuses(); len(replicas) > 0 {
+			details.Pools["database_replicas"] = replicas
+		}
+
+		queryCtx, cancel := context.WithTimeout(ctx, defaultDependencyTimeout)
+		defer cancel()
+		if v, err := db.CurrentSchemaVersion(queryCtx); err == nil {
+			details.MigrationVersion = &v
+		} else {
+			zap.L().Warn("Failed to read schema version for readiness details", zap.Error(err))
+		}
+	}
+	if client := cache.GetClient(); cache.IsAvailable() && client != nil {
+		details.Pools["redis"] = client.PoolStats()
+	}
+	return details
 }
 
 // formatUptime 格式化运行时间
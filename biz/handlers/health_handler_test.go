@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"stripe-pay/db"
+	"testing"
+)
+
+// TestReadinessStatus 测试依赖状态汇总
+func TestReadinessStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		deps       map[string]DependencyStatus
+		wantStatus string
+		wantReady  bool
+	}{
+		{"全部正常", map[string]DependencyStatus{
+			"database": {Status: DependencyUp, Critical: true},
+			"redis":    {Status: DependencyDisabled},
+		}, ReadinessOK, true},
+		{"非关键依赖异常仍就绪", map[string]DependencyStatus{
+			"database": {Status: DependencyUp, Critical: true},
+			"redis":    {Status: DependencyDown},
+		}, ReadinessDegraded, true},
+		{"关键依赖熔断仍就绪", map[string]DependencyStatus{
+			"stripe": {Status: DependencyDegraded, Critical: true},
+		}, ReadinessDegraded, true},
+		{"关键依赖异常不就绪", map[string]DependencyStatus{
+			"database": {Status: DependencyDown, Critical: true},
+			"redis":    {Status: DependencyUp},
+		}, ReadinessUnavailable, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, ready := readinessStatus(tt.deps)
+			if status != tt.wantStatus || ready != tt.wantReady {
+				t.Errorf("readinessStatus() = %s, %v, want %s, %v", status, ready, tt.wantStatus, tt.wantReady)
+			}
+		})
+	}
+}
+
+// TestReplicaStatus 测试从库健康状态汇总
+func TestReplicaStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		healthy []bool
+		want    string
+	}{
+		{"全部健康", []bool{true, true}, DependencyUp},
+		{"部分健康", []bool{true, false}, DependencyDegraded},
+		{"全部异常", []bool{false, false}, DependencyDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replicas := make([]db.ReplicaStatus, 0, len(tt.healthy))
+			for _, h := range tt.healthy {
+				replicas = append(replicas, db.ReplicaStatus{Healthy: h})
+			}
+			if got, _ := replicaStatus(replicas); got != tt.want {
+				t.Errorf("replicaStatus() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
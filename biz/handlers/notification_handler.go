@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"stripe-pay/biz"
+	"stripe-pay/biz/models"
+	"stripe-pay/common"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"go.uber.org/zap"
+)
+
+// GetNotificationSettings returns the user's notification email, locale and opt-out flag
+func GetNotificationSettings(ctx context.Context, c *app.RequestContext) {
+	userID := c.Param("user_id")
+	if err := biz.ValidateUserID(userID); err != nil {
+		common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+		return
+	}
+
+	settings, err := getPaymentService().GetNotificationSettings(ctx, userID)
+	if err != nil {
+		zap.L().Error("Failed to get notification settings", zap.Error(err), zap.String("user_id", userID))
+		common.SendError(c, common.ErrDatabaseError)
+		return
+	}
+
+	c.JSON(consts.StatusOK, settings)
+}
+
+// UpdateNotificationSettings replaces the user's notification settings
+func UpdateNotificationSettings(ctx context.Context, c *app.RequestContext) {
+	userID := c.Param("user_id")
+	if err := biz.ValidateUserID(userID); err != nil {
+		common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+		return
+	}
+
+	var req models.UpdateNotificationSettingsRequest
+	if err := c.BindAndValidate(&req); err != nil {
+		common.SendError(c, common.ErrInvalidRequest.WithDetails("Failed to bind request"))
+		return
+	}
+
+	settings, err := getPaymentService().UpdateNotificationSettings(ctx, userID, &req)
+	if err != nil {
+		var validationErr *biz.ValidationError
+		if errors.As(err, &validationErr) {
+			common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+			return
+		}
+		zap.L().Error("Failed to update notification settings", zap.Error(err), zap.String("user_id", userID))
+		common.SendError(c, common.ErrDatabaseError)
+		return
+	}
+
+	c.JSON(consts.StatusOK, settings)
+}
@@ -2,9 +2,8 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"io"
-	"net/http"
 	"strconv"
 	"strings"
 	"stripe-pay/biz"
@@ -12,8 +11,8 @@ import (
 	"stripe-pay/biz/services"
 	"stripe-pay/cache"
 	"stripe-pay/common"
-	"stripe-pay/conf"
 	"stripe-pay/db"
+	"stripe-pay/tenant"
 	"time"
 
 	"sync"
@@ -22,9 +21,6 @@ import (
 	"github.com/cloudwego/hertz/pkg/common/utils"
 	"github.com/cloudwego/hertz/pkg/protocol/consts"
 	"github.com/stripe/stripe-go/v78"
-	"github.com/stripe/stripe-go/v78/paymentintent"
-	"github.com/stripe/stripe-go/v78/refund"
-	"github.com/stripe/stripe-go/v78/webhook"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -55,6 +51,17 @@ func getIdempotencyKey(c *app.RequestContext) string {
 	return ""
 }
 
+// sendUpstreamError sends 503 with Retry-After when an upstream circuit breaker is open, otherwise the fallback error
+func sendUpstreamError(c *app.RequestContext, err error, fallback *common.APIError) {
+	var openErr *common.CircuitOpenError
+	if errors.As(err, &openErr) {
+		c.Header("Retry-After", strconv.Itoa(int(openErr.RetryAfter.Seconds())+1))
+		common.SendError(c, common.ErrServiceUnavailable.WithDetails(openErr.Error()))
+		return
+	}
+	common.SendError(c, fallback)
+}
+
 // CreateStripePayment creates a Stripe payment
 func CreateStripePayment(ctx context.Context, c *app.RequestContext) {
 	common.LogStage(c, "request_received", zap.String("handler", "CreateStripePayment"))
@@ -66,6 +73,7 @@ func CreateStripePayment(ctx context.Context, c *app.RequestContext) {
 		common.SendError(c, common.ErrInvalidRequest.WithDetails("Failed to bind request: "+err.Error()))
 		return
 	}
+	req.ClientIP = c.ClientIP()
 	common.LogStage(c, "request_bound", zap.String("user_id", req.UserID), zap.String("description", req.Description))
 
 	// Explicitly validate required fields (because BindAndValidate may allow empty strings)
@@ -90,6 +98,13 @@ func CreateStripePayment(ctx context.Context, c *app.RequestContext) {
 	}
 	common.LogStage(c, "validation_passed")
 
+	// A saved card charges the user's Stripe customer, so it needs the user's X-User-Token
+	// (user_id is in the body, so the token is checked here instead of by UserAuthMiddleware)
+	if req.PaymentMethod != "" && !common.AuthenticateUser(ctx, c, req.UserID) {
+		common.LogStageWithLevel(c, zapcore.WarnLevel, "user_auth_failed", zap.String("user_id", req.UserID))
+		return
+	}
+
 	// Get Idempotency Key
 	idempotencyKey := getIdempotencyKey(c)
 	common.LogStage(c, "checking_idempotency", zap.String("idempotency_key", idempotencyKey))
@@ -128,6 +143,24 @@ func CreateStripePayment(ctx context.Context, c *app.RequestContext) {
 			return
 		}
 
+		if isFraudBlockedError(err) {
+			common.SendError(c, common.ErrForbidden.WithDetails(err.Error()))
+			return
+		}
+		if isPromoCodeError(err) {
+			common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+			return
+		}
+		if isConnectError(err) {
+			common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+			return
+		}
+		var ownershipErr *services.PaymentMethodOwnershipError
+		if errors.As(err, &ownershipErr) {
+			common.SendError(c, common.ErrForbidden.WithDetails(err.Error()))
+			return
+		}
+
 		// Check if it's a validation error (should return 400 instead of 500)
 		errStr := strings.ToLower(err.Error())
 		if strings.Contains(errStr, "required") ||
@@ -139,7 +172,7 @@ func CreateStripePayment(ctx context.Context, c *app.RequestContext) {
 		}
 
 		// Other errors as payment processing errors
-		common.SendError(c, common.ErrPaymentProcessing.WithDetails(err.Error()))
+		sendUpstreamError(c, err, common.ErrPaymentProcessing.WithDetails(err.Error()))
 		return
 	}
 
@@ -153,7 +186,7 @@ func CreateStripePayment(ctx context.Context, c *app.RequestContext) {
 		go func() {
 			// Get complete information from database and cache
 			if db.DB != nil {
-				payment, err := db.GetPaymentByPaymentID(response.PaymentID)
+				payment, err := db.GetPaymentByPaymentID(ctx, response.PaymentID)
 				if err == nil && payment != nil {
 					cacheData := &cache.PaymentCacheData{
 						PaymentID:       payment.PaymentID,
@@ -167,8 +200,8 @@ func CreateStripePayment(ctx context.Context, c *app.RequestContext) {
 						CreatedAt:       payment.CreatedAt.Format(time.RFC3339),
 						UpdatedAt:       payment.UpdatedAt.Format(time.RFC3339),
 					}
-					cache.SetPayment(context.Background(), response.PaymentID, cacheData, cache.DefaultPaymentCacheTTL)
-					cache.SetPaymentByIntentID(context.Background(), response.PaymentIntentID, cacheData, cache.DefaultPaymentCacheTTL)
+					cache.SetPayment(context.WithoutCancel(ctx), response.PaymentID, cacheData, cache.DefaultPaymentCacheTTL)
+					cache.SetPaymentByIntentID(context.WithoutCancel(ctx), response.PaymentIntentID, cacheData, cache.DefaultPaymentCacheTTL)
 				}
 			}
 		}()
@@ -186,6 +219,7 @@ func CreateStripeWeChatPayment(ctx context.Context, c *app.RequestContext) {
 		common.SendError(c, common.ErrInvalidRequest.WithDetails("Failed to bind request"))
 		return
 	}
+	req.ClientIP = c.ClientIP()
 
 	idempotencyKey := getIdempotencyKey(c)
 
@@ -208,7 +242,19 @@ func CreateStripeWeChatPayment(ctx context.Context, c *app.RequestContext) {
 	// Create payment
 	response, err := getPaymentService().CreateWeChatPayment(ctx, &req, idempotencyKey)
 	if err != nil {
-		common.SendError(c, common.ErrPaymentProcessing.WithDetails(err.Error()))
+		if isFraudBlockedError(err) {
+			common.SendError(c, common.ErrForbidden.WithDetails(err.Error()))
+			return
+		}
+		if isPromoCodeError(err) {
+			common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+			return
+		}
+		if isConnectError(err) {
+			common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+			return
+		}
+		sendUpstreamError(c, err, common.ErrPaymentProcessing.WithDetails(err.Error()))
 		return
 	}
 
@@ -222,6 +268,7 @@ func CreateStripeAlipayPayment(ctx context.Context, c *app.RequestContext) {
 		common.SendError(c, common.ErrInvalidRequest.WithDetails("Failed to bind request"))
 		return
 	}
+	req.ClientIP = c.ClientIP()
 
 	idempotencyKey := getIdempotencyKey(c)
 
@@ -245,7 +292,19 @@ func CreateStripeAlipayPayment(ctx context.Context, c *app.RequestContext) {
 	// Create payment
 	response, err := getPaymentService().CreateAlipayPayment(ctx, &req, idempotencyKey)
 	if err != nil {
-		common.SendError(c, common.ErrPaymentProcessing.WithDetails(err.Error()))
+		if isFraudBlockedError(err) {
+			common.SendError(c, common.ErrForbidden.WithDetails(err.Error()))
+			return
+		}
+		if isPromoCodeError(err) {
+			common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+			return
+		}
+		if isConnectError(err) {
+			common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+			return
+		}
+		sendUpstreamError(c, err, common.ErrPaymentProcessing.WithDetails(err.Error()))
 		return
 	}
 
@@ -254,7 +313,7 @@ func CreateStripeAlipayPayment(ctx context.Context, c *app.RequestContext) {
 
 // GetPricing gets pricing information
 func GetPricing(ctx context.Context, c *app.RequestContext) {
-	pricing, err := getPaymentService().GetCurrentPricing()
+	pricing, err := getPaymentService().GetCurrentPricing(ctx)
 	if err != nil {
 		common.SendError(c, common.ErrInternalServer.WithDetails("Failed to get pricing"))
 		return
@@ -281,10 +340,7 @@ func ConfirmStripePayment(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	cfg := conf.GetConf()
-	stripe.Key = cfg.Stripe.SecretKey
-
-	intent, err := paymentintent.Get(req.PaymentID, nil)
+	intent, err := getPaymentService().GetPaymentIntent(ctx, req.PaymentID)
 	if err != nil {
 		common.SendError(c, common.ErrPaymentNotFound)
 		return
@@ -313,7 +369,7 @@ func UpdatePaymentConfig(ctx context.Context, c *app.RequestContext) {
 	}
 
 	if req.Currency == "" {
-		req.Currency = "hkd"
+		req.Currency = tenant.FromContext(ctx).PricingCurrency()
 	}
 
 	if err := biz.ValidateCurrency(req.Currency); err != nil {
@@ -335,13 +391,13 @@ func UpdatePaymentConfig(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	err := db.UpdatePaymentConfig(req.Currency, req.Amount, req.Description)
+	err := db.UpdatePaymentConfig(ctx, req.Currency, req.Amount, req.Description)
 	if err != nil {
 		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to update payment config"))
 		return
 	}
 
-	config, err := db.GetPaymentConfig(req.Currency)
+	config, err := db.GetPaymentConfig(ctx, req.Currency)
 	if err != nil {
 		zap.L().Warn("Failed to get updated config", zap.Error(err))
 		c.JSON(consts.StatusOK, utils.H{
@@ -369,7 +425,7 @@ func UpdatePaymentConfig(ctx context.Context, c *app.RequestContext) {
 func GetPaymentConfig(ctx context.Context, c *app.RequestContext) {
 	currency := c.Query("currency")
 	if currency == "" {
-		currency = "hkd"
+		currency = tenant.FromContext(ctx).PricingCurrency()
 	}
 
 	if db.DB == nil {
@@ -377,7 +433,7 @@ func GetPaymentConfig(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	config, err := db.GetPaymentConfig(currency)
+	config, err := db.GetPaymentConfig(ctx, currency)
 	if err != nil {
 		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to get payment config"))
 		return
@@ -412,7 +468,7 @@ func GetUserPaymentInfo(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	info, err := db.GetUserPaymentInfo(userID)
+	info, err := db.GetUserPaymentInfo(ctx, userID)
 	if err != nil {
 		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to get user payment info"))
 		return
@@ -438,68 +494,17 @@ func UpdatePaymentStatusFromFrontend(ctx context.Context, c *app.RequestContext)
 		return
 	}
 
-	cfg := conf.GetConf()
-	stripe.Key = cfg.Stripe.SecretKey
-
-	intent, err := paymentintent.Get(req.PaymentIntentID, nil)
-	if err != nil {
+	// Same path as the webhook: user payment stats (counted once per intent, using the captured amount),
+	// promo redemption, receipt, notifications and caches
+	intent, err := getPaymentService().SyncPaymentIntent(ctx, req.PaymentIntentID)
+	if intent == nil {
 		common.SendError(c, common.ErrPaymentNotFound)
 		return
 	}
-
-	actualStatus := string(intent.Status)
-
-	if db.DB != nil {
-		if err := db.UpdatePaymentStatus(req.PaymentIntentID, actualStatus); err != nil {
-			zap.L().Warn("Failed to update payment status", zap.Error(err))
-		} else {
-			// Update cache (asynchronously)
-			if cache.IsAvailable() {
-				go func() {
-					// Update Stripe status cache (cache strategy based on status)
-					updateStripeStatusCache(context.Background(), req.PaymentIntentID, intent)
-
-					// Find payment_id by payment_intent_id and update payment cache
-					payment, err := db.GetPaymentByPaymentID(req.PaymentIntentID)
-					if err == nil && payment == nil {
-						// If not found by payment_intent_id, delete related cache
-						cache.DeletePayment(context.Background(), req.PaymentIntentID)
-					} else if payment != nil {
-						cacheData := &cache.PaymentCacheData{
-							PaymentID:       payment.PaymentID,
-							PaymentIntentID: payment.PaymentIntentID,
-							UserID:          payment.UserID,
-							Amount:          intent.Amount,
-							Currency:        string(intent.Currency),
-							Status:          actualStatus,
-							PaymentMethod:   payment.PaymentMethod,
-							Description:     payment.Description,
-							CreatedAt:       payment.CreatedAt.Format(time.RFC3339),
-							UpdatedAt:       time.Now().Format(time.RFC3339),
-						}
-						cache.SetPayment(context.Background(), payment.PaymentID, cacheData, cache.DefaultPaymentCacheTTL)
-						cache.SetPaymentByIntentID(context.Background(), req.PaymentIntentID, cacheData, cache.DefaultPaymentCacheTTL)
-					}
-				}()
-			}
-		}
-
-		if actualStatus == "succeeded" {
-			userID := intent.Metadata["user_id"]
-			if userID != "" {
-				if err := db.UpdateUserPaymentInfo(userID, intent.Amount); err != nil {
-					zap.L().Warn("Failed to update user payment info", zap.Error(err))
-				} else {
-					// Invalidate user payment cache
-					if cache.IsAvailable() {
-						go func() {
-							cache.InvalidateUserPaymentCache(context.Background(), userID)
-						}()
-					}
-				}
-			}
-		}
+	if err != nil {
+		zap.L().Warn("Failed to sync payment status", zap.Error(err), zap.String("payment_intent_id", req.PaymentIntentID))
 	}
+	actualStatus := string(intent.Status)
 
 	c.JSON(consts.StatusOK, utils.H{
 		"payment_intent_id": req.PaymentIntentID,
@@ -509,6 +514,8 @@ func UpdatePaymentStatusFromFrontend(ctx context.Context, c *app.RequestContext)
 }
 
 // GetUserPaymentHistory gets user payment history
+// Query parameters: cursor, limit (max 100), status (comma separated), payment_method, currency,
+// start, end (YYYY-MM-DD inclusive, or RFC3339)
 func GetUserPaymentHistory(ctx context.Context, c *app.RequestContext) {
 	userID := string(c.Param("user_id"))
 	if userID == "" {
@@ -521,31 +528,81 @@ func GetUserPaymentHistory(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	limit := 50
-	if limitStr := c.Query("limit"); limitStr != "" {
-		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
-			limit = parsedLimit
-		}
-	}
+	params := paymentSearchParams(c)
+	params.TenantID = tenant.ID(ctx)
+	params.UserID = userID
 
-	if db.DB == nil {
-		common.SendError(c, common.ErrDatabaseError.WithDetails("Database not available"))
+	page, ok := searchPayments(ctx, c, params)
+	if !ok {
 		return
 	}
 
-	history, err := db.GetPaymentHistory(userID, limit)
-	if err != nil {
-		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to get payment history"))
+	c.JSON(consts.StatusOK, utils.H{
+		"user_id":     userID,
+		"count":       len(page.Payments),
+		"history":     page.Payments,
+		"next_cursor": page.NextCursor,
+		"has_more":    page.HasMore,
+	})
+}
+
+// SearchPayments searches payments across all users (admin)
+// Accepts the same query parameters as GetUserPaymentHistory plus optional user_id and tenant_id;
+// without tenant_id it searches all tenants unless the X-Tenant-ID header selects one
+func SearchPayments(ctx context.Context, c *app.RequestContext) {
+	params := paymentSearchParams(c)
+	params.UserID = c.Query("user_id")
+	params.TenantID = c.Query("tenant_id")
+	if params.TenantID == "" && len(c.GetHeader(common.AdminTenantHeader)) > 0 {
+		params.TenantID = tenant.ID(ctx)
+	}
+
+	page, ok := searchPayments(ctx, c, params)
+	if !ok {
 		return
 	}
 
 	c.JSON(consts.StatusOK, utils.H{
-		"user_id": userID,
-		"count":   len(history),
-		"history": history,
+		"count":       len(page.Payments),
+		"payments":    page.Payments,
+		"next_cursor": page.NextCursor,
+		"has_more":    page.HasMore,
 	})
 }
 
+// paymentSearchParams reads the shared payment search query parameters
+func paymentSearchParams(c *app.RequestContext) services.PaymentSearchParams {
+	return services.PaymentSearchParams{
+		Status:        c.Query("status"),
+		PaymentMethod: c.Query("payment_method"),
+		Currency:      c.Query("currency"),
+		Start:         c.Query("start"),
+		End:           c.Query("end"),
+		Cursor:        c.Query("cursor"),
+		Limit:         c.Query("limit"),
+	}
+}
+
+// searchPayments runs the search and writes the error response on failure
+func searchPayments(ctx context.Context, c *app.RequestContext, params services.PaymentSearchParams) (*db.PaymentPage, bool) {
+	if db.DB == nil {
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Database not available"))
+		return nil, false
+	}
+
+	page, err := getPaymentService().SearchPayments(ctx, params)
+	if err != nil {
+		var validationErr *biz.ValidationError
+		if errors.As(err, &validationErr) {
+			common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+			return nil, false
+		}
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to get payment history"))
+		return nil, false
+	}
+	return page, true
+}
+
 // RefundPayment processes a refund
 func RefundPayment(ctx context.Context, c *app.RequestContext) {
 	var req models.RefundRequest
@@ -569,22 +626,13 @@ func RefundPayment(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	cfg := conf.GetConf()
-	stripe.Key = cfg.Stripe.SecretKey
-
-	params := &stripe.RefundParams{
-		PaymentIntent: stripe.String(req.PaymentIntentID),
-	}
-	if req.Amount > 0 {
-		params.Amount = stripe.Int64(req.Amount)
-	}
-	if req.Reason != "" {
-		params.Reason = stripe.String(req.Reason)
+	refundResult, err := getPaymentService().RefundPayment(ctx, &req)
+	if services.IsNotFoundError(err) {
+		common.SendError(c, common.ErrPaymentNotFound)
+		return
 	}
-
-	refundResult, err := refund.New(params)
 	if err != nil {
-		common.SendError(c, common.ErrPaymentProcessing.WithDetails(err.Error()))
+		sendUpstreamError(c, err, common.ErrPaymentProcessing.WithDetails(err.Error()))
 		return
 	}
 
@@ -596,9 +644,23 @@ func RefundPayment(ctx context.Context, c *app.RequestContext) {
 	})
 }
 
-// StripeWebhook handles Stripe webhook
+// StripeWebhook handles Stripe webhook.
+// Each Stripe account posts to /stripe/webhook/:account; /stripe/webhook is the default account
 func StripeWebhook(ctx context.Context, c *app.RequestContext) {
-	cfg := conf.GetConf()
+	handleStripeWebhook(ctx, c, services.WebhookEndpointAccount)
+}
+
+// StripeConnectWebhook handles events of connected accounts (account.updated) from a Connect webhook endpoint,
+// which Stripe signs with a different secret. Posted to /stripe/connect-webhook[/:account]
+func StripeConnectWebhook(ctx context.Context, c *app.RequestContext) {
+	handleStripeWebhook(ctx, c, services.WebhookEndpointConnect)
+}
+
+func handleStripeWebhook(ctx context.Context, c *app.RequestContext, endpoint services.WebhookEndpoint) {
+	account := c.Param("account")
+	if account == "" {
+		account = db.DefaultStripeAccount
+	}
 
 	// Read request body
 	body, err := io.ReadAll(c.Request.BodyStream())
@@ -615,121 +677,45 @@ func StripeWebhook(ctx context.Context, c *app.RequestContext) {
 	}
 	signature := string(signatureBytes)
 
-	// Verify signature using Stripe official library
-	endpointSecret := cfg.Stripe.WebhookSecret
-	if endpointSecret == "" {
+	// Verify signature against every active secret of the account (supports secret rotation)
+	event, secretName, err := services.ConstructStripeEvent(account, endpoint, body, signature)
+	if errors.Is(err, services.ErrStripeAccountNotFound) {
+		common.SendError(c, common.ErrNotFound.WithDetails("Unknown Stripe account"))
+		return
+	}
+	if errors.Is(err, services.ErrWebhookSecretNotConfigured) {
 		common.SendError(c, common.ErrInternalServer.WithDetails("Webhook secret not configured"))
 		return
 	}
-
-	event, err := webhook.ConstructEvent(body, signature, endpointSecret)
 	if err != nil {
+		zap.L().Warn("Invalid Stripe webhook", zap.Error(err),
+			zap.String("stripe_account", account),
+			zap.String("endpoint", string(endpoint)))
 		common.SendError(c, common.ErrInvalidRequest.WithDetails("Invalid signature"))
 		return
 	}
+	zap.L().Debug("Stripe webhook verified",
+		zap.String("event_id", event.ID),
+		zap.String("stripe_account", account),
+		zap.String("secret", secretName))
+
+	// A replayed request (or a redelivery of an event already handled) is acknowledged without processing
+	claimed, release := services.ClaimStripeEvent(ctx, account, &event)
+	if !claimed {
+		zap.L().Info("Duplicate Stripe event ignored",
+			zap.String("event_id", event.ID),
+			zap.String("stripe_account", account))
+		c.JSON(consts.StatusOK, utils.H{"received": true, "duplicate": true})
+		return
+	}
 
-	// Handle different types of events
-	switch event.Type {
-	case "payment_intent.succeeded":
-		zap.L().Info("Payment succeeded", zap.String("event_id", event.ID))
-
-		// Parse PaymentIntent
-		var pi stripe.PaymentIntent
-		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
-			zap.L().Error("Failed to parse payment intent", zap.Error(err))
-		} else {
-			// Update payment status in database
-			if db.DB != nil {
-				// Update payment history status
-				if err := db.UpdatePaymentStatus(pi.ID, string(pi.Status)); err != nil {
-					zap.L().Warn("Failed to update payment status", zap.Error(err))
-				} else {
-					// Accuracy first: immediately update cache for final status to ensure accuracy
-					if cache.IsAvailable() {
-						go func() {
-							// Final status: delete cache, force next query to Stripe to get latest status
-							// This ensures the returned status is accurate
-							if cache.IsFinalStatus(string(pi.Status)) {
-								zap.L().Info("Final status in webhook, deleting cache for accuracy",
-									zap.String("payment_intent_id", pi.ID),
-									zap.String("status", string(pi.Status)))
-								cache.DeleteStripeStatus(context.Background(), pi.ID)
-								// Delete payment cache, force next read from database
-								cache.DeletePayment(context.Background(), pi.ID)
-							} else {
-								// Intermediate status: update cache
-								stripeStatusData := &cache.StripeStatusCacheData{
-									PaymentIntentID: pi.ID,
-									Status:          string(pi.Status),
-									Amount:          pi.Amount,
-									Currency:        string(pi.Currency),
-									CachedAt:        time.Now().Format(time.RFC3339),
-								}
-								ttl := cache.GetStripeStatusTTL(string(pi.Status))
-								cache.SetStripeStatus(context.Background(), pi.ID, stripeStatusData, ttl)
-							}
-						}()
-					}
-				}
-
-				// Get user ID (from metadata)
-				userID := pi.Metadata["user_id"]
-				if userID != "" {
-					// Update user payment information
-					if err := db.UpdateUserPaymentInfo(userID, pi.Amount); err != nil {
-						zap.L().Warn("Failed to update user payment info", zap.Error(err))
-					} else {
-						// Invalidate user payment cache
-						if cache.IsAvailable() {
-							go func() {
-								cache.InvalidateUserPaymentCache(context.Background(), userID)
-							}()
-						}
-					}
-				}
-			}
-		}
-
-	case "payment_intent.payment_failed":
-		zap.L().Info("Payment failed", zap.String("event_id", event.ID))
-
-		// Parse PaymentIntent and update status
-		var pi stripe.PaymentIntent
-		if err := json.Unmarshal(event.Data.Raw, &pi); err == nil && db.DB != nil {
-			db.UpdatePaymentStatus(pi.ID, string(pi.Status))
-			// Accuracy first: delete cache for final status (failed) to ensure accuracy
-			if cache.IsAvailable() {
-				go func() {
-					zap.L().Info("Final status (failed) in webhook, deleting cache for accuracy",
-						zap.String("payment_intent_id", pi.ID),
-						zap.String("status", string(pi.Status)))
-					cache.DeleteStripeStatus(context.Background(), pi.ID)
-					cache.DeletePayment(context.Background(), pi.ID)
-				}()
-			}
-		}
-
-	case "payment_intent.canceled":
-		zap.L().Info("Payment canceled", zap.String("event_id", event.ID))
-
-		// Parse PaymentIntent and update status
-		var pi stripe.PaymentIntent
-		if err := json.Unmarshal(event.Data.Raw, &pi); err == nil && db.DB != nil {
-			db.UpdatePaymentStatus(pi.ID, string(pi.Status))
-			// Accuracy first: delete cache for final status (canceled) to ensure accuracy
-			if cache.IsAvailable() {
-				go func() {
-					zap.L().Info("Final status (canceled) in webhook, deleting cache for accuracy",
-						zap.String("payment_intent_id", pi.ID),
-						zap.String("status", string(pi.Status)))
-					cache.DeleteStripeStatus(context.Background(), pi.ID)
-					cache.DeletePayment(context.Background(), pi.ID)
-				}()
-			}
-		}
-
-	default:
-		zap.L().Info("Unhandled event type", zap.String("type", string(event.Type)))
+	// Handle event (same processing path as admin event replay).
+	// On failure release the claim and return 5xx so Stripe redelivers the event and it is processed again
+	if err := getPaymentService().HandleStripeEvent(ctx, account, &event); err != nil {
+		release()
+		zap.L().Warn("Failed to process Stripe event", zap.Error(err), zap.String("event_id", event.ID))
+		common.SendError(c, common.ErrInternalServer.WithDetails("Failed to process event"))
+		return
 	}
 
 	c.JSON(consts.StatusOK, utils.H{"received": true})
@@ -749,44 +735,17 @@ func VerifyApplePurchase(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	cfg := conf.GetConf()
-
-	// Prepare request data
-	requestData := map[string]interface{}{
-		"receipt-data": req.ReceiptData,
-		"password":     cfg.Apple.SharedSecret,
-	}
-
-	jsonData, _ := json.Marshal(requestData)
-
-	// Try production environment first
-	prodResp, err := http.Post(cfg.Apple.ProductionURL, "application/json",
-		io.NopCloser(strings.NewReader(string(jsonData))))
-	if err != nil {
-		common.SendError(c, common.ErrExternalService.WithDetails("Failed to connect to Apple"))
+	verifyResp, err := getPaymentService().VerifyAppleReceipt(ctx, req.ReceiptData)
+	if errors.Is(err, services.ErrAppleBundleMismatch) {
+		common.SendError(c, common.ErrForbidden.WithDetails("receipt does not belong to this app"))
 		return
 	}
-	defer prodResp.Body.Close()
-
-	// If production environment returns 21007 (sandbox receipt), request sandbox environment
-	var verifyResp models.AppleVerifyResponse
-	if err := json.NewDecoder(prodResp.Body).Decode(&verifyResp); err != nil {
-		common.SendError(c, common.ErrExternalService.WithDetails("Failed to parse response"))
+	if err != nil {
+		zap.L().Warn("Apple receipt verification failed", zap.Error(err))
+		sendUpstreamError(c, err, common.ErrExternalService.WithDetails("Failed to verify receipt with Apple"))
 		return
 	}
 
-	if verifyResp.Status == 21007 {
-		// Sandbox receipt, use sandbox URL
-		sandboxResp, err := http.Post(cfg.Apple.SandboxURL, "application/json",
-			io.NopCloser(strings.NewReader(string(jsonData))))
-		if err != nil {
-			common.SendError(c, common.ErrExternalService.WithDetails("Failed to connect to Apple sandbox"))
-			return
-		}
-		defer sandboxResp.Body.Close()
-		json.NewDecoder(sandboxResp.Body).Decode(&verifyResp)
-	}
-
 	c.JSON(consts.StatusOK, verifyResp)
 }
 
@@ -868,46 +827,22 @@ func GetPaymentStatus(ctx context.Context, c *app.RequestContext) {
 							response["message"] = "Payment status has changed. Please query again for the latest status."
 						}
 
+						common.RecordPaymentStatusLookup(services.StatusLookupHit)
 						c.JSON(consts.StatusOK, response)
 
 						// 后台异步验证并更新缓存（stale-while-revalidate）
-						go func() {
-							intent, err := getPaymentService().GetPaymentIntent(cachedData.PaymentIntentID)
-							if err == nil {
-								// 如果状态发生变化，记录状态变化事件并更新缓存
-								if string(intent.Status) != stripeStatus.Status {
-									zap.L().Info("Status changed during revalidation, recording event and updating cache",
-										zap.String("payment_intent_id", cachedData.PaymentIntentID),
-										zap.String("old_status", stripeStatus.Status),
-										zap.String("new_status", string(intent.Status)))
-
-									// 记录状态变化事件（客户端可以查询）
-									cache.RecordStatusChange(context.Background(),
-										cachedData.PaymentIntentID,
-										stripeStatus.Status,
-										string(intent.Status),
-										"revalidate")
-
-									// 更新数据库状态
-									if db.DB != nil {
-										db.UpdatePaymentStatus(cachedData.PaymentIntentID, string(intent.Status))
-									}
-								}
-								updateStripeStatusCache(context.Background(), cachedData.PaymentIntentID, intent)
-								updateCacheFromStripe(context.Background(), cachedData.PaymentID, cachedData.PaymentIntentID, intent)
-							}
-						}()
+						go revalidatePaymentStatus(context.WithoutCancel(ctx), cachedData.PaymentID, cachedData.PaymentIntentID, stripeStatus.Status, true)
 						return
 					}
 				}
 
 				// 1.2 Stripe 状态缓存未命中或最终状态，查询 Stripe API（保证准确性）
-				intent, err := getPaymentService().GetPaymentIntent(cachedData.PaymentIntentID)
+				intent, err := getPaymentService().LookupPaymentIntent(ctx, cachedData.PaymentIntentID)
 				if err == nil {
 					// 更新缓存（根据状态决定是否缓存）
 					go func() {
-						updateStripeStatusCache(context.Background(), cachedData.PaymentIntentID, intent)
-						updateCacheFromStripe(context.Background(), cachedData.PaymentID, cachedData.PaymentIntentID, intent)
+						updateStripeStatusCache(context.WithoutCancel(ctx), cachedData.PaymentIntentID, intent)
+						updateCacheFromStripe(context.WithoutCancel(ctx), cachedData.PaymentID, cachedData.PaymentIntentID, intent)
 					}()
 
 					c.JSON(consts.StatusOK, utils.H{
@@ -950,7 +885,7 @@ func GetPaymentStatus(ctx context.Context, c *app.RequestContext) {
 	} else {
 		var err error
 		common.LogStage(c, "querying_database", zap.String("payment_id", paymentID))
-		payment, err = db.GetPaymentByPaymentID(paymentID)
+		payment, err = db.GetPaymentByPaymentID(ctx, paymentID)
 		if err != nil {
 			common.LogStageWithLevel(c, zapcore.WarnLevel, "database_query_failed", zap.Error(err), zap.String("payment_id", paymentID))
 			zap.L().Warn("Failed to get payment from database", zap.Error(err), zap.String("payment_id", paymentID))
@@ -983,7 +918,7 @@ func GetPaymentStatus(ctx context.Context, c *app.RequestContext) {
 						CreatedAt:       payment.CreatedAt.Format(time.RFC3339),
 						UpdatedAt:       payment.UpdatedAt.Format(time.RFC3339),
 					}
-					cache.SetPayment(context.Background(), paymentID, cacheData, cache.DefaultPaymentCacheTTL)
+					cache.SetPayment(context.WithoutCancel(ctx), paymentID, cacheData, cache.DefaultPaymentCacheTTL)
 				}()
 			}
 		} else {
@@ -1034,31 +969,11 @@ func GetPaymentStatus(ctx context.Context, c *app.RequestContext) {
 						response["message"] = "Payment status has changed. Please query again for the latest status."
 					}
 
+					common.RecordPaymentStatusLookup(services.StatusLookupHit)
 					c.JSON(consts.StatusOK, response)
 
 					// 后台异步验证
-					go func() {
-						intent, err := getPaymentService().GetPaymentIntent(paymentIntentID)
-						if err == nil {
-							// 如果状态发生变化，记录状态变化事件
-							if string(intent.Status) != stripeStatus.Status {
-								cache.RecordStatusChange(context.Background(),
-									paymentIntentID,
-									stripeStatus.Status,
-									string(intent.Status),
-									"revalidate")
-
-								// 更新数据库状态
-								if db.DB != nil {
-									db.UpdatePaymentStatus(paymentIntentID, string(intent.Status))
-								}
-							}
-							updateStripeStatusCache(context.Background(), paymentIntentID, intent)
-							if payment != nil {
-								updateCacheFromStripe(context.Background(), paymentID, paymentIntentID, intent)
-							}
-						}
-					}()
+					go revalidatePaymentStatus(context.WithoutCancel(ctx), paymentID, paymentIntentID, stripeStatus.Status, true)
 					return
 				}
 			}
@@ -1066,7 +981,7 @@ func GetPaymentStatus(ctx context.Context, c *app.RequestContext) {
 
 		// 3.2 查询 Stripe API 获取最新状态（保证准确性）
 		common.LogStage(c, "querying_stripe_api", zap.String("payment_intent_id", paymentIntentID))
-		intent, err := getPaymentService().GetPaymentIntent(paymentIntentID)
+		intent, err := getPaymentService().LookupPaymentIntent(ctx, paymentIntentID)
 		if err != nil {
 			common.LogStageWithLevel(c, zapcore.WarnLevel, "stripe_query_failed", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
 			zap.L().Warn("Failed to get payment intent from Stripe, using database status",
@@ -1088,9 +1003,9 @@ func GetPaymentStatus(ctx context.Context, c *app.RequestContext) {
 		// 3.3 成功从Stripe获取，更新缓存（根据状态决定缓存策略）
 		if cache.IsAvailable() {
 			go func() {
-				updateStripeStatusCache(context.Background(), paymentIntentID, intent)
+				updateStripeStatusCache(context.WithoutCancel(ctx), paymentIntentID, intent)
 				if payment != nil {
-					updateCacheFromStripe(context.Background(), paymentID, paymentIntentID, intent)
+					updateCacheFromStripe(context.WithoutCancel(ctx), paymentID, paymentIntentID, intent)
 				}
 			}()
 		}
@@ -1147,30 +1062,18 @@ func GetPaymentStatus(ctx context.Context, c *app.RequestContext) {
 						response["message"] = "Payment status has changed. Please query again for the latest status."
 					}
 
+					common.RecordPaymentStatusLookup(services.StatusLookupHit)
 					c.JSON(consts.StatusOK, response)
 
 					// 后台异步验证
-					go func() {
-						intent, err := getPaymentService().GetPaymentIntent(paymentID)
-						if err == nil {
-							// 如果状态发生变化，记录状态变化事件
-							if string(intent.Status) != stripeStatus.Status {
-								cache.RecordStatusChange(context.Background(),
-									paymentID,
-									stripeStatus.Status,
-									string(intent.Status),
-									"revalidate")
-							}
-							updateStripeStatusCache(context.Background(), paymentID, intent)
-						}
-					}()
+					go revalidatePaymentStatus(context.WithoutCancel(ctx), paymentID, paymentID, stripeStatus.Status, false)
 					return
 				}
 			}
 		}
 
 		// 4.2 查询 Stripe API 获取最新状态（保证准确性）
-		intent, err := getPaymentService().GetPaymentIntent(paymentID)
+		intent, err := getPaymentService().LookupPaymentIntent(ctx, paymentID)
 		if err != nil {
 			common.SendError(c, common.ErrPaymentNotFound)
 			return
@@ -1179,7 +1082,7 @@ func GetPaymentStatus(ctx context.Context, c *app.RequestContext) {
 		// 4.3 更新缓存（根据状态决定缓存策略）
 		if cache.IsAvailable() {
 			go func() {
-				updateStripeStatusCache(context.Background(), paymentID, intent)
+				updateStripeStatusCache(context.WithoutCancel(ctx), paymentID, intent)
 			}()
 		}
 
@@ -1238,6 +1141,39 @@ func CheckStatusChange(ctx context.Context, c *app.RequestContext) {
 	}
 }
 
+// revalidatePaymentStatus 后台刷新缓存中的中间状态（stale-while-revalidate），同一 PaymentIntent 在最小间隔内只刷新一次。
+// updatePayment 为 false 时（直接用 payment_intent_id 查询）只更新 Stripe 状态缓存；ctx 需保留请求的租户（缓存键按租户隔离）
+func revalidatePaymentStatus(ctx context.Context, paymentID, paymentIntentID, cachedStatus string, updatePayment bool) {
+	svc := getPaymentService()
+	if !svc.ShouldRevalidate(ctx, paymentIntentID) {
+		return
+	}
+
+	intent, err := svc.LookupPaymentIntent(ctx, paymentIntentID)
+	if err != nil {
+		zap.L().Debug("Payment status revalidation failed", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
+		return
+	}
+
+	// 如果状态发生变化，记录状态变化事件（客户端可以查询）并更新数据库
+	if string(intent.Status) != cachedStatus {
+		zap.L().Info("Status changed during revalidation, recording event and updating cache",
+			zap.String("payment_intent_id", paymentIntentID),
+			zap.String("old_status", cachedStatus),
+			zap.String("new_status", string(intent.Status)))
+		cache.RecordStatusChange(ctx, paymentIntentID, cachedStatus, string(intent.Status), "revalidate")
+		if updatePayment && db.DB != nil {
+			db.UpdatePaymentStatus(ctx, paymentIntentID, string(intent.Status))
+		}
+	}
+
+	if updatePayment {
+		updateCacheFromStripe(ctx, paymentID, paymentIntentID, intent)
+	} else {
+		updateStripeStatusCache(ctx, paymentIntentID, intent)
+	}
+}
+
 // updateStripeStatusCache 更新 Stripe 状态缓存（根据状态决定缓存策略）
 func updateStripeStatusCache(ctx context.Context, paymentIntentID string, intent *stripe.PaymentIntent) {
 	if !cache.IsAvailable() {
@@ -1290,7 +1226,7 @@ func updateCacheFromStripe(ctx context.Context, paymentID, paymentIntentID strin
 
 	// 从数据库获取完整信息并更新支付缓存
 	if db.DB != nil {
-		payment, err := db.GetPaymentByPaymentID(paymentID)
+		payment, err := db.GetPaymentByPaymentID(ctx, paymentID)
 		if err == nil && payment != nil {
 			cacheData := &cache.PaymentCacheData{
 				PaymentID:       payment.PaymentID,
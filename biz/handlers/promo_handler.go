@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"stripe-pay/biz"
+	"stripe-pay/biz/models"
+	"stripe-pay/common"
+	"stripe-pay/db"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"go.uber.org/zap"
+)
+
+// isPromoCodeError reports whether a payment creation error was caused by an invalid or unavailable promo code
+func isPromoCodeError(err error) bool {
+	var promoErr *db.PromoCodeUnavailableError
+	var validationErr *biz.ValidationError
+	return errors.As(err, &promoErr) || (errors.As(err, &validationErr) && validationErr.Field == "promo_code")
+}
+
+// CreatePromoCode creates a promo code (admin)
+func CreatePromoCode(ctx context.Context, c *app.RequestContext) {
+	var req models.CreatePromoCodeRequest
+	if err := c.BindAndValidate(&req); err != nil {
+		common.SendError(c, common.ErrInvalidRequest.WithDetails("Failed to bind request: "+err.Error()))
+		return
+	}
+
+	promo, err := getPaymentService().CreatePromoCode(ctx, &req)
+	if err != nil {
+		var validationErr *biz.ValidationError
+		switch {
+		case errors.As(err, &validationErr):
+			common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+		case strings.Contains(err.Error(), "already exists"):
+			common.SendError(c, common.ErrConflict.WithDetails(err.Error()))
+		default:
+			zap.L().Error("Failed to create promo code", zap.Error(err))
+			common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to create promo code"))
+		}
+		return
+	}
+
+	c.JSON(consts.StatusOK, promo)
+}
+
+// ListPromoCodes lists promo codes, newest first (admin)
+func ListPromoCodes(ctx context.Context, c *app.RequestContext) {
+	if db.DB == nil {
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Database not available"))
+		return
+	}
+
+	limit := 100
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 500 {
+			limit = parsedLimit
+		}
+	}
+
+	codes, err := db.ListPromoCodes(ctx, limit)
+	if err != nil {
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to list promo codes"))
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"count":       len(codes),
+		"promo_codes": codes,
+	})
+}
+
+// DeactivatePromoCode deactivates a promo code; existing reservations are not affected (admin)
+func DeactivatePromoCode(ctx context.Context, c *app.RequestContext) {
+	code := db.NormalizePromoCode(c.Param("code"))
+	if err := biz.ValidatePromoCode(code); err != nil || code == "" {
+		common.SendError(c, common.ErrValidationFailed.WithDetails("invalid promo code"))
+		return
+	}
+
+	if db.DB == nil {
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Database not available"))
+		return
+	}
+
+	found, err := db.SetPromoCodeActive(ctx, code, false)
+	if err != nil {
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to deactivate promo code"))
+		return
+	}
+	if !found {
+		common.SendError(c, common.ErrNotFound.WithDetails("promo code not found: "+code))
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"code":    code,
+		"active":  false,
+		"message": "Promo code deactivated",
+	})
+}
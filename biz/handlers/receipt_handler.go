@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"stripe-pay/biz"
+	"stripe-pay/biz/services"
+	"stripe-pay/common"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"go.uber.org/zap"
+)
+
+// GetPaymentReceipt renders the receipt of a successful payment
+// Path parameter id: payment_id or payment_intent_id
+// Query parameters: user_id (required, must own the payment), format (html or pdf, default html)
+// Header X-User-Token: token signed for user_id, so only the payer can read the receipt
+func GetPaymentReceipt(ctx context.Context, c *app.RequestContext) {
+	id := string(c.Param("id"))
+	userID := c.Query("user_id")
+	if id == "" || userID == "" {
+		common.SendError(c, common.ErrMissingParameter.WithDetails("id and user_id are required"))
+		return
+	}
+	if !common.AuthenticateUser(ctx, c, userID) {
+		return
+	}
+	format := c.DefaultQuery("format", services.ReceiptFormatHTML)
+
+	rendered, err := getPaymentService().RenderReceipt(ctx, id, userID, format)
+	if err != nil {
+		var validationErr *biz.ValidationError
+		var stateErr *services.PaymentStateError
+		switch {
+		case errors.As(err, &validationErr):
+			common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+		case errors.As(err, &stateErr):
+			common.SendError(c, common.ErrConflict.WithDetails("receipts are only available for succeeded payments"))
+		case services.IsNotFoundError(err):
+			common.SendError(c, common.ErrPaymentNotFound)
+		default:
+			zap.L().Error("Failed to render receipt", zap.Error(err), zap.String("id", id))
+			common.SendError(c, common.ErrInternalServer)
+		}
+		return
+	}
+
+	if format == services.ReceiptFormatPDF {
+		c.Response.Header.Set("Content-Disposition", fmt.Sprintf(`inline; filename="receipt-%s.pdf"`, rendered.Number))
+	}
+	c.Data(consts.StatusOK, rendered.ContentType, rendered.Body)
+}
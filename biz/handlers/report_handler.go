@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strings"
+	"stripe-pay/biz"
+	"stripe-pay/biz/services"
+	"stripe-pay/common"
+	"sync"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"go.uber.org/zap"
+)
+
+var (
+	reportService     *services.ReportService
+	reportServiceOnce sync.Once
+)
+
+// getReportService gets the report service (lazy loading)
+func getReportService() *services.ReportService {
+	reportServiceOnce.Do(func() {
+		reportService = services.NewReportService()
+	})
+	return reportService
+}
+
+// GetReport returns an aggregate payment report (revenue, success-rate, refund-rate, payers)
+// Query parameters: start, end (YYYY-MM-DD, inclusive), group_by (day,currency,method),
+// currency, payment_method, source (live|rollup), format (json|csv)
+func GetReport(ctx context.Context, c *app.RequestContext) {
+	name := c.Param("name")
+	if !services.IsValidReport(name) {
+		common.SendError(c, common.ErrNotFound.WithDetails("unknown report: "+name))
+		return
+	}
+
+	query := services.ReportQuery{
+		Start:         c.Query("start"),
+		End:           c.Query("end"),
+		GroupBy:       c.Query("group_by"),
+		Currency:      c.Query("currency"),
+		PaymentMethod: c.Query("payment_method"),
+		Source:        c.Query("source"),
+	}
+
+	report, err := getReportService().GetReport(ctx, name, query)
+	if err != nil {
+		var validationErr *biz.ValidationError
+		if errors.As(err, &validationErr) {
+			common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+			return
+		}
+		zap.L().Error("Failed to generate report", zap.Error(err), zap.String("report", name))
+		common.SendError(c, common.ErrDatabaseError.WithDetails(err.Error()))
+		return
+	}
+
+	format := strings.ToLower(c.Query("format"))
+	if format == "" && strings.Contains(string(c.GetHeader("Accept")), "text/csv") {
+		format = "csv"
+	}
+
+	if format == "csv" {
+		data, err := reportToCSV(report)
+		if err != nil {
+			common.SendError(c, common.ErrInternalServer.WithDetails(err.Error()))
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s_%s_%s.csv"`, report.Name, report.Start, report.End))
+		c.Data(consts.StatusOK, "text/csv; charset=utf-8", data)
+		return
+	}
+
+	c.JSON(consts.StatusOK, report)
+}
+
+// reportToCSV renders the report as CSV (header row followed by one row per group)
+func reportToCSV(report *services.Report) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write(report.Columns); err != nil {
+		return nil, err
+	}
+	for _, row := range report.Rows {
+		record := make([]string, len(report.Columns))
+		for i, column := range report.Columns {
+			record[i] = fmt.Sprint(row[column])
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"stripe-pay/biz"
+	"stripe-pay/biz/models"
+	"stripe-pay/biz/services"
+	"stripe-pay/common"
+	"stripe-pay/db"
+	"stripe-pay/tenant"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"go.uber.org/zap"
+)
+
+// sendTenantError maps tenant service errors to API errors
+func sendTenantError(c *app.RequestContext, err error, action string) {
+	var validationErr *biz.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		common.SendError(c, common.ErrValidationFailed.WithDetails(err.Error()))
+	case errors.Is(err, services.ErrTenantNotFound):
+		common.SendError(c, common.ErrNotFound.WithDetails(err.Error()))
+	case strings.Contains(err.Error(), "already exists"):
+		common.SendError(c, common.ErrConflict.WithDetails(err.Error()))
+	default:
+		zap.L().Error("Failed to "+action+" tenant", zap.Error(err))
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to "+action+" tenant"))
+	}
+}
+
+// CreateTenant creates a tenant and returns its API key; the key is only shown once (admin)
+func CreateTenant(ctx context.Context, c *app.RequestContext) {
+	var req models.CreateTenantRequest
+	if err := c.BindAndValidate(&req); err != nil {
+		common.SendError(c, common.ErrInvalidRequest.WithDetails("Failed to bind request: "+err.Error()))
+		return
+	}
+
+	t, apiKey, err := getPaymentService().CreateTenant(ctx, &req)
+	if err != nil {
+		sendTenantError(c, err, "create")
+		return
+	}
+	common.InvalidateTenants()
+
+	c.JSON(consts.StatusOK, utils.H{
+		"tenant":  t,
+		"api_key": apiKey,
+	})
+}
+
+// ListTenants lists all tenants, including inactive ones (admin)
+func ListTenants(ctx context.Context, c *app.RequestContext) {
+	if db.DB == nil {
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Database not available"))
+		return
+	}
+
+	tenants, err := db.ListTenants(ctx, false)
+	if err != nil {
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to list tenants"))
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"count":   len(tenants),
+		"tenants": tenants,
+	})
+}
+
+// GetTenant gets a tenant by id (admin)
+func GetTenant(ctx context.Context, c *app.RequestContext) {
+	t, ok := loadTenantParam(ctx, c)
+	if !ok {
+		return
+	}
+	c.JSON(consts.StatusOK, t)
+}
+
+// UpdateTenant updates a tenant's configuration; omitted fields are unchanged (admin)
+func UpdateTenant(ctx context.Context, c *app.RequestContext) {
+	var req models.UpdateTenantRequest
+	if err := c.BindAndValidate(&req); err != nil {
+		common.SendError(c, common.ErrInvalidRequest.WithDetails("Failed to bind request: "+err.Error()))
+		return
+	}
+
+	t, err := getPaymentService().UpdateTenant(ctx, c.Param("id"), &req)
+	if err != nil {
+		sendTenantError(c, err, "update")
+		return
+	}
+	common.InvalidateTenants()
+
+	c.JSON(consts.StatusOK, t)
+}
+
+// DeactivateTenant deactivates a tenant; its API key and hosts stop resolving (admin)
+func DeactivateTenant(ctx context.Context, c *app.RequestContext) {
+	active := false
+	t, err := getPaymentService().UpdateTenant(ctx, c.Param("id"), &models.UpdateTenantRequest{Active: &active})
+	if err != nil {
+		sendTenantError(c, err, "deactivate")
+		return
+	}
+	common.InvalidateTenants()
+
+	c.JSON(consts.StatusOK, utils.H{
+		"id":      t.ID,
+		"active":  false,
+		"message": "Tenant deactivated",
+	})
+}
+
+// RotateTenantAPIKey issues a new API key for a tenant; the old key stops working immediately (admin)
+func RotateTenantAPIKey(ctx context.Context, c *app.RequestContext) {
+	id := c.Param("id")
+	apiKey, err := getPaymentService().RotateTenantAPIKey(ctx, id)
+	if err != nil {
+		sendTenantError(c, err, "rotate API key for")
+		return
+	}
+	common.InvalidateTenants()
+
+	c.JSON(consts.StatusOK, utils.H{
+		"id":      id,
+		"api_key": apiKey,
+	})
+}
+
+// GetTenantPricing gets a tenant's payment config (admin)
+func GetTenantPricing(ctx context.Context, c *app.RequestContext) {
+	t, ok := loadTenantParam(ctx, c)
+	if !ok {
+		return
+	}
+	GetPaymentConfig(tenant.WithTenant(ctx, t), c)
+}
+
+// UpdateTenantPricing updates a tenant's payment config (admin)
+func UpdateTenantPricing(ctx context.Context, c *app.RequestContext) {
+	t, ok := loadTenantParam(ctx, c)
+	if !ok {
+		return
+	}
+	UpdatePaymentConfig(tenant.WithTenant(ctx, t), c)
+}
+
+// loadTenantParam loads the tenant named by the :id path parameter, sending 404 when it does not exist
+func loadTenantParam(ctx context.Context, c *app.RequestContext) (*tenant.Tenant, bool) {
+	if db.DB == nil {
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Database not available"))
+		return nil, false
+	}
+
+	id := c.Param("id")
+	t, err := db.GetTenant(ctx, id)
+	if err != nil {
+		common.SendError(c, common.ErrDatabaseError.WithDetails("Failed to get tenant"))
+		return nil, false
+	}
+	if t == nil {
+		common.SendError(c, common.ErrNotFound.WithDetails("tenant not found: "+id))
+		return nil, false
+	}
+	return t, true
+}
@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"stripe-pay/biz/services"
+	"stripe-pay/conf"
+	"time"
+)
+
+// RegisterJobs 根据配置注册后台任务
+func RegisterJobs(s *Scheduler, cfg *conf.Config) {
+	paymentService := services.NewPaymentService()
+
+	if cfg.Reconciliation.Enabled {
+		interval := services.DefaultReconcileInterval
+		if cfg.Reconciliation.Interval > 0 {
+			interval = time.Duration(cfg.Reconciliation.Interval) * time.Second
+		}
+
+		s.Register(Job{
+			Name:     "reconciliation",
+			Interval: interval,
+			Run: func(ctx context.Context) error {
+				return paymentService.ReconcileAllTenants(ctx, services.ReconcileOptions{TriggerSource: "scheduler"})
+			},
+		})
+	}
+
+	if cfg.PaymentExpiry.Enabled {
+		interval := services.DefaultPaymentExpiryInterval
+		if cfg.PaymentExpiry.Interval > 0 {
+			interval = time.Duration(cfg.PaymentExpiry.Interval) * time.Second
+		}
+
+		s.Register(Job{
+			Name:     "payment_expiry",
+			Interval: interval,
+			Run: func(ctx context.Context) error {
+				_, err := paymentService.ExpireAbandonedPayments(ctx)
+				return err
+			},
+		})
+	}
+
+	if cfg.Capture.ExpiryEnabled {
+		interval := services.DefaultCaptureExpiryInterval
+		if cfg.Capture.Interval > 0 {
+			interval = time.Duration(cfg.Capture.Interval) * time.Second
+		}
+
+		s.Register(Job{
+			Name:     "authorization_expiry",
+			Interval: interval,
+			Run: func(ctx context.Context) error {
+				_, err := paymentService.CheckExpiringAuthorizations(ctx)
+				return err
+			},
+		})
+	}
+
+	if cfg.Notifications.Enabled {
+		interval := services.DefaultNotificationInterval
+		if cfg.Notifications.Interval > 0 {
+			interval = time.Duration(cfg.Notifications.Interval) * time.Second
+		}
+
+		s.Register(Job{
+			Name:     "notifications",
+			Interval: interval,
+			Run: func(ctx context.Context) error {
+				_, remindErr := paymentService.SendExpiryReminders(ctx)
+				_, retryErr := paymentService.RetryFailedNotifications(ctx)
+				return errors.Join(remindErr, retryErr)
+			},
+		})
+	}
+
+	if cfg.Analytics.RollupEnabled {
+		interval := services.DefaultRollupInterval
+		if cfg.Analytics.RollupInterval > 0 {
+			interval = time.Duration(cfg.Analytics.RollupInterval) * time.Second
+		}
+
+		reportService := services.NewReportService()
+		s.Register(Job{
+			Name:     "daily_rollup",
+			Interval: interval,
+			Run:      reportService.RefreshDailyRollup,
+		})
+	}
+}
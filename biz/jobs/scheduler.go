@@ -0,0 +1,134 @@
+// Package jobs 后台定时任务（对账等），多实例部署时通过 MySQL 命名锁保证同一时刻只有一个实例执行
+package jobs
+
+import (
+	"context"
+	"math/rand"
+	"stripe-pay/common"
+	"stripe-pay/db"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// lockPrefix 任务命名锁前缀
+const lockPrefix = "stripe-pay:job:"
+
+// Job 定时任务
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Timeout  time.Duration // 单次运行超时，默认等于 Interval
+	Run      func(ctx context.Context) error
+}
+
+// Scheduler 定时任务调度器
+type Scheduler struct {
+	jobs   []Job
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewScheduler 创建调度器
+func NewScheduler() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{ctx: ctx, cancel: cancel}
+}
+
+// Register 注册任务（必须在 Start 之前调用）
+func (s *Scheduler) Register(job Job) {
+	if job.Timeout <= 0 {
+		job.Timeout = job.Interval
+	}
+	s.jobs = append(s.jobs, job)
+}
+
+// Len 已注册的任务数
+func (s *Scheduler) Len() int {
+	return len(s.jobs)
+}
+
+// Start 启动所有任务
+func (s *Scheduler) Start() {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(job)
+		zap.L().Info("Background job started",
+			zap.String("job", job.Name),
+			zap.Duration("interval", job.Interval))
+	}
+}
+
+// Stop 停止所有任务并等待正在运行的任务结束
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loop 按间隔循环执行任务，首次执行前随机延迟，避免多实例同时启动时集中抢锁
+func (s *Scheduler) loop(job Job) {
+	defer s.wg.Done()
+
+	jitter := time.Duration(rand.Int63n(int64(job.Interval)/10 + 1))
+	timer := time.NewTimer(jitter)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		s.runOnce(job)
+		timer.Reset(job.Interval)
+	}
+}
+
+// runOnce 获取命名锁后执行一次任务
+func (s *Scheduler) runOnce(job Job) {
+	defer func() {
+		if r := recover(); r != nil {
+			zap.L().Error("Background job panicked", zap.String("job", job.Name), zap.Any("panic", r))
+			common.RecordJobRun(job.Name, "error", 0)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(s.ctx, job.Timeout)
+	defer cancel()
+
+	unlock, acquired, err := db.TryLock(ctx, lockPrefix+job.Name)
+	if err != nil {
+		zap.L().Warn("Background job failed to acquire lock", zap.String("job", job.Name), zap.Error(err))
+		common.RecordJobRun(job.Name, "error", 0)
+		return
+	}
+	if !acquired {
+		zap.L().Debug("Background job is running on another instance, skipping", zap.String("job", job.Name))
+		common.RecordJobRun(job.Name, "skipped", 0)
+		return
+	}
+	defer unlock()
+
+	start := time.Now()
+	if err := job.Run(ctx); err != nil {
+		zap.L().Warn("Background job failed", zap.String("job", job.Name), zap.Error(err))
+		common.RecordJobRun(job.Name, "error", time.Since(start))
+		return
+	}
+	common.RecordJobRun(job.Name, "success", time.Since(start))
+}
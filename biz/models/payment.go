@@ -1,11 +1,18 @@
 package models
 
+import "stripe-pay/tenant"
+
 // 支付相关请求和响应模型
 
 // CreatePaymentRequest 创建支付请求
 type CreatePaymentRequest struct {
-	UserID      string `json:"user_id" binding:"required"` // 用户ID（必填）
-	Description string `json:"description"`                // 描述（可选）
+	UserID        string `json:"user_id" binding:"required"` // 用户ID（必填）
+	Description   string `json:"description"`                // 描述（可选）
+	PromoCode     string `json:"promo_code"`                 // 可选：优惠码
+	PaymentMethod string `json:"payment_method"`             // 可选：已保存的支付方式（pm_xxx，必须属于该用户）
+	CaptureMethod string `json:"capture_method"`             // 可选：automatic（默认）或 manual（先授权，稍后扣款）
+	CreatorID     string `json:"creator_id"`                 // 可选：收款创作者的用户ID（Stripe Connect 分账）
+	ClientIP      string `json:"-"`                          // 客户端 IP（由 handler 填充，用于风控）
 }
 
 // CreateWeChatPaymentRequest 创建微信支付请求
@@ -14,6 +21,9 @@ type CreateWeChatPaymentRequest struct {
 	Description string `json:"description"`                // 可选描述
 	ReturnURL   string `json:"return_url"`                 // 可选：支付完成后跳转地址
 	Client      string `json:"client"`                     // 可选：web 或 mobile，默认 web
+	PromoCode   string `json:"promo_code"`                 // 可选：优惠码
+	CreatorID   string `json:"creator_id"`                 // 可选：收款创作者的用户ID（Stripe Connect 分账）
+	ClientIP    string `json:"-"`                          // 客户端 IP（由 handler 填充，用于风控）
 }
 
 // CreateAlipayPaymentRequest 创建支付宝支付请求
@@ -21,6 +31,32 @@ type CreateAlipayPaymentRequest struct {
 	UserID      string `json:"user_id" binding:"required"` // 用户ID（必填）
 	Description string `json:"description"`                // 可选描述
 	ReturnURL   string `json:"return_url"`                 // 可选：支付完成后跳转地址
+	PromoCode   string `json:"promo_code"`                 // 可选：优惠码
+	CreatorID   string `json:"creator_id"`                 // 可选：收款创作者的用户ID（Stripe Connect 分账）
+	ClientIP    string `json:"-"`                          // 客户端 IP（由 handler 填充，用于风控）
+}
+
+// CreateCheckoutSessionRequest 创建 Checkout Session 请求（Stripe 托管支付页面）
+type CreateCheckoutSessionRequest struct {
+	UserID      string `json:"user_id"`     // 必填：用户ID
+	Description string `json:"description"` // 可选描述
+	SuccessURL  string `json:"success_url"` // 必填：支付成功后跳转地址（可包含 {CHECKOUT_SESSION_ID}）
+	CancelURL   string `json:"cancel_url"`  // 必填：用户取消后跳转地址
+	ClientIP    string `json:"-"`           // 客户端 IP（由 handler 填充，用于风控）
+}
+
+// CheckoutSessionResponse Checkout Session 响应
+type CheckoutSessionResponse struct {
+	CheckoutSessionID string `json:"checkout_session_id"`
+	URL               string `json:"url"`
+	PaymentID         string `json:"payment_id"`
+	Status            string `json:"status"`
+	ExpiresAt         int64  `json:"expires_at"`
+}
+
+// CreateSetupIntentRequest 创建 SetupIntent 请求（保存卡片）
+type CreateSetupIntentRequest struct {
+	UserID string `json:"user_id"` // 必填：用户ID
 }
 
 // PaymentResponse 支付响应
@@ -45,7 +81,7 @@ type ConfirmPaymentRequest struct {
 // UpdatePaymentConfigRequest 更新支付配置请求
 type UpdatePaymentConfigRequest struct {
 	Amount      int64  `json:"amount" binding:"required"` // 金额（分），必填
-	Currency    string `json:"currency"`                  // 币种，可选，默认为 hkd
+	Currency    string `json:"currency"`                  // 币种，可选，默认为租户的定价币种（hkd）
 	Description string `json:"description"`               // 描述，可选
 }
 
@@ -62,6 +98,49 @@ type RefundRequest struct {
 	Reason          string `json:"reason,omitempty"`  // 可选：退款原因（duplicate, fraudulent, requested_by_customer）
 }
 
+// CreatePromoCodeRequest 创建优惠码请求（管理接口）
+type CreatePromoCodeRequest struct {
+	Code           string `json:"code"`                      // 必填：优惠码（不区分大小写，保存为大写）
+	PercentOff     int64  `json:"percent_off,omitempty"`     // 折扣百分比（1-100），与 amount_off 二选一
+	AmountOff      int64  `json:"amount_off,omitempty"`      // 减免金额（分），与 percent_off 二选一，需要同时指定 currency
+	Currency       string `json:"currency,omitempty"`        // 可选：限定币种
+	MaxRedemptions int    `json:"max_redemptions,omitempty"` // 可选：最大使用次数（0 不限）
+	PerUserLimit   *int   `json:"per_user_limit,omitempty"`  // 可选：每个用户最大使用次数（默认 1，0 不限）
+	ExpiresAt      string `json:"expires_at,omitempty"`      // 可选：过期时间（RFC3339）
+	Description    string `json:"description,omitempty"`     // 可选：备注
+}
+
+// CreateFraudRuleRequest 创建风控规则请求（管理接口）
+type CreateFraudRuleRequest struct {
+	RuleType      string `json:"rule_type"`                // 必填：block_user, block_ip, block_cidr, block_email, block_card_fingerprint, velocity_user_daily, max_amount
+	Value         string `json:"value,omitempty"`          // 黑名单值：用户ID、IP、CIDR、邮箱或 @域名、卡指纹
+	Threshold     int64  `json:"threshold,omitempty"`      // velocity_user_daily 的 24 小时次数上限，或 max_amount 的金额上限（分）
+	Currency      string `json:"currency,omitempty"`       // 可选：max_amount 适用的币种
+	PaymentMethod string `json:"payment_method,omitempty"` // 可选：只对该支付方式生效（card, wechat_pay, alipay, checkout）
+	Action        string `json:"action,omitempty"`         // 可选：block（默认）或 review（放行但标记待审核）
+	ExpiresAt     string `json:"expires_at,omitempty"`     // 可选：过期时间（RFC3339）
+	Description   string `json:"description,omitempty"`    // 可选：备注
+}
+
+// CaptureRequest 扣款请求（手动扣款模式）
+type CaptureRequest struct {
+	PaymentIntentID string `json:"payment_intent_id"` // 必填：已授权的 PaymentIntent ID
+	Amount          int64  `json:"amount,omitempty"`  // 可选：扣款金额（分），不填则扣全部授权金额
+}
+
+// CancelAuthorizationRequest 取消授权请求
+type CancelAuthorizationRequest struct {
+	PaymentIntentID string `json:"payment_intent_id"` // 必填：已授权的 PaymentIntent ID
+	Reason          string `json:"reason,omitempty"`  // 可选：取消原因（duplicate, fraudulent, requested_by_customer）
+}
+
+// UpdateNotificationSettingsRequest 更新通知设置请求
+type UpdateNotificationSettingsRequest struct {
+	Email    string `json:"email"`               // 通知邮箱（为空时使用支付时填写的 receipt_email）
+	Locale   string `json:"locale,omitempty"`    // 可选：通知语言（en, zh）
+	OptedOut bool   `json:"opted_out,omitempty"` // 可选：退订所有通知
+}
+
 // AppleVerifyRequest Apple内购验证请求
 type AppleVerifyRequest struct {
 	ReceiptData string `json:"receipt_data"`
@@ -75,3 +154,25 @@ type AppleVerifyResponse struct {
 	LatestReceiptInfo  any `json:"latest_receipt_info,omitempty"`
 	PendingRenewalInfo any `json:"pending_renewal_info,omitempty"`
 }
+
+// CreateTenantRequest 创建租户请求（管理接口）
+type CreateTenantRequest struct {
+	ID            string          `json:"id"`                        // 必填：租户标识（小写字母、数字、- 和 _）
+	Name          string          `json:"name"`                      // 必填：名称
+	Hosts         []string        `json:"hosts,omitempty"`           // 可选：按 Host 识别租户
+	StripeAccount string          `json:"stripe_account,omitempty"`  // 可选：stripe.accounts 中的账户标识
+	AppleBundleID string          `json:"apple_bundle_id,omitempty"` // 可选：Apple 收据必须属于该 bundle
+	Currency      string          `json:"currency,omitempty"`        // 可选：定价币种，默认 hkd
+	Receipt       *tenant.Receipt `json:"receipt,omitempty"`         // 可选：收据设置，未设置的项使用全局配置
+}
+
+// UpdateTenantRequest 更新租户请求（管理接口，未提供的字段保持不变）
+type UpdateTenantRequest struct {
+	Name          *string         `json:"name,omitempty"`
+	Hosts         *[]string       `json:"hosts,omitempty"`
+	StripeAccount *string         `json:"stripe_account,omitempty"`
+	AppleBundleID *string         `json:"apple_bundle_id,omitempty"`
+	Currency      *string         `json:"currency,omitempty"`
+	Receipt       *tenant.Receipt `json:"receipt,omitempty"` // 整体替换收据设置
+	Active        *bool           `json:"active,omitempty"`
+}
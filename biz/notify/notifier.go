@@ -0,0 +1,69 @@
+// Package notify 邮件通知：模板渲染和发送（SMTP、本地文件、日志）
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"stripe-pay/conf"
+)
+
+// 通知类型
+const (
+	KindPaymentSucceeded    = "payment_succeeded"
+	KindRefundIssued        = "refund_issued"
+	KindEntitlementExpiring = "entitlement_expiring"
+)
+
+// 发送方式
+const (
+	DriverSMTP = "smtp"
+	DriverFile = "file"
+	DriverLog  = "log"
+)
+
+// Message 一封待发送的邮件
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Notifier 通知发送接口
+type Notifier interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// New 按配置创建 Notifier，未启用时返回 nil
+func New(cfg *conf.Config) (Notifier, error) {
+	n := cfg.Notifications
+	if !n.Enabled {
+		return nil, nil
+	}
+
+	switch strings.ToLower(n.Driver) {
+	case DriverSMTP:
+		if n.SMTP.Host == "" {
+			return nil, fmt.Errorf("notifications.smtp.host is required for the smtp driver")
+		}
+		return NewSMTPNotifier(SMTPConfig{
+			Host:     n.SMTP.Host,
+			Port:     n.SMTP.Port,
+			Username: n.SMTP.Username,
+			Password: n.SMTP.Password,
+			TLS:      n.SMTP.TLS,
+			Timeout:  n.SMTP.Timeout,
+			PasswordFunc: func() string {
+				return conf.GetConf().Notifications.SMTP.Password
+			},
+		}), nil
+	case DriverFile:
+		return NewFileNotifier(n.FileDir)
+	case DriverLog, "":
+		return &LogNotifier{}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifications.driver %q (expected smtp, file or log)", n.Driver)
+	}
+}
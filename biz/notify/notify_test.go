@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNormalizeLocale 测试语言规范化
+func TestNormalizeLocale(t *testing.T) {
+	tests := []struct {
+		name   string
+		locale string
+		want   string
+	}{
+		{"英文", "en", "en"},
+		{"带地区的中文", "zh-CN", "zh"},
+		{"下划线和大写", "ZH_tw", "zh"},
+		{"不支持的语言", "fr", ""},
+		{"空", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeLocale(tt.locale); got != tt.want {
+				t.Errorf("NormalizeLocale(%q) = %q, want %q", tt.locale, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRender 测试通知模板渲染
+func TestRender(t *testing.T) {
+	data := &Data{
+		UserID:        "user_1",
+		PaymentID:     "pay_1",
+		Description:   "<b>Premium</b>",
+		Amount:        "HKD 59.00",
+		RefundAmount:  "HKD 10.00",
+		ReceiptURL:    "https://example.com/api/v1/payment/pay_1/receipt?user_id=user_1",
+		DaysRemaining: 3,
+		ExpiresAt:     "2024-03-31",
+	}
+
+	tests := []struct {
+		name        string
+		kind        string
+		locale      string
+		wantSubject string
+	}{
+		{"支付成功-英文", KindPaymentSucceeded, "en", "Payment received: HKD 59.00"},
+		{"支付成功-中文", KindPaymentSucceeded, "zh-CN", "支付成功：HKD 59.00"},
+		{"退款-不支持的语言回退英文", KindRefundIssued, "fr", "Refund issued: HKD 10.00"},
+		{"到期提醒-中文", KindEntitlementExpiring, "zh", "您的权益将在 3 天后到期"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := Render(tt.kind, tt.locale, data)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if content.Subject != tt.wantSubject {
+				t.Errorf("Render() subject = %q, want %q", content.Subject, tt.wantSubject)
+			}
+			if strings.Contains(content.HTML, "<b>Premium</b>") {
+				t.Errorf("Render() html did not escape description")
+			}
+		})
+	}
+
+	if _, err := Render("unknown", "en", data); err == nil {
+		t.Errorf("Render() expected error for unknown kind")
+	}
+}
+
+// TestBuildMIME 测试邮件生成
+func TestBuildMIME(t *testing.T) {
+	msg := &Message{
+		From:    "Example <no-reply@example.com>",
+		To:      "user@example.com",
+		Subject: "支付成功",
+		Text:    "hello",
+		HTML:    "<p>hello</p>",
+	}
+	data, err := BuildMIME(msg, time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("BuildMIME() error = %v", err)
+	}
+	out := string(data)
+	for _, want := range []string{"To: user@example.com\r\n", "Subject: =?utf-8?b?", "@example.com>\r\n", "text/plain", "text/html"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("BuildMIME() output missing %q", want)
+		}
+	}
+
+	msg.To = "user@example.com\r\nBcc: other@example.com"
+	if _, err := BuildMIME(msg, time.Now()); err == nil {
+		t.Errorf("BuildMIME() expected error for header injection")
+	}
+}
+
+// TestFileNotifier 测试本地文件发送
+func TestFileNotifier(t *testing.T) {
+	dir := t.TempDir()
+	n, err := NewFileNotifier(dir)
+	if err != nil {
+		t.Fatalf("NewFileNotifier() error = %v", err)
+	}
+	if err := n.Send(context.Background(), &Message{From: "a@example.com", To: "b@example.com", Subject: "hi", Text: "hi"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), ".eml") {
+		t.Errorf("Send() expected one .eml file, got %v", entries)
+	}
+}
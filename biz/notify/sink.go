@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LogNotifier 只记录日志不发送（本地开发）
+type LogNotifier struct{}
+
+// Send 记录邮件摘要
+func (n *LogNotifier) Send(ctx context.Context, msg *Message) error {
+	zap.L().Info("Notification (log driver)",
+		zap.String("to", msg.To),
+		zap.String("subject", msg.Subject),
+		zap.String("text", msg.Text))
+	return nil
+}
+
+// FileNotifier 把邮件写成 .eml 文件（本地开发，可用邮件客户端直接打开）
+type FileNotifier struct {
+	dir string
+	seq atomic.Int64
+}
+
+// NewFileNotifier 创建 FileNotifier，目录不存在时自动创建
+func NewFileNotifier(dir string) (*FileNotifier, error) {
+	if dir == "" {
+		dir = "notifications"
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create notifications directory: %w", err)
+	}
+	return &FileNotifier{dir: dir}, nil
+}
+
+// Send 写入 <时间>-<序号>.eml
+func (n *FileNotifier) Send(ctx context.Context, msg *Message) error {
+	data, err := BuildMIME(msg, time.Now())
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%d.eml", time.Now().Format("20060102T150405.000"), n.seq.Add(1))
+	return os.WriteFile(filepath.Join(n.dir, name), data, 0o644)
+}
@@ -0,0 +1,134 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+// DefaultLocale 未设置语言或语言不支持时使用
+const DefaultLocale = "en"
+
+// supportedLocales 已提供模板的语言
+var supportedLocales = map[string]bool{
+	"en": true,
+	"zh": true,
+}
+
+// Data 模板数据（同时作为通知记录的 payload 保存，重试时重新渲染）
+type Data struct {
+	UserID        string `json:"user_id"`
+	PaymentID     string `json:"payment_id,omitempty"`
+	Description   string `json:"description,omitempty"`
+	Amount        string `json:"amount,omitempty"`        // 已格式化，如 HKD 59.00
+	RefundAmount  string `json:"refund_amount,omitempty"` // 已格式化
+	ReceiptURL    string `json:"receipt_url,omitempty"`
+	DaysRemaining int    `json:"days_remaining,omitempty"`
+	ExpiresAt     string `json:"expires_at,omitempty"` // 已格式化的到期日期
+}
+
+// Content 渲染后的邮件内容
+type Content struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// templateSet 一个通知类型在一种语言下的模板（subject、text、html 三个块）
+type templateSet struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var (
+	templatesOnce sync.Once
+	templates     map[string]*templateSet
+	templatesErr  error
+)
+
+// loadTemplates 解析 templates/<kind>.<locale>.tmpl
+func loadTemplates() {
+	templates = make(map[string]*templateSet)
+	entries, err := templateFS.ReadDir("templates")
+	if err != nil {
+		templatesErr = err
+		return
+	}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".tmpl")
+		data, err := templateFS.ReadFile("templates/" + entry.Name())
+		if err != nil {
+			templatesErr = err
+			return
+		}
+		text, err := texttemplate.New(name).Parse(string(data))
+		if err != nil {
+			templatesErr = fmt.Errorf("failed to parse template %s: %w", entry.Name(), err)
+			return
+		}
+		html, err := htmltemplate.New(name).Parse(string(data))
+		if err != nil {
+			templatesErr = fmt.Errorf("failed to parse template %s: %w", entry.Name(), err)
+			return
+		}
+		templates[name] = &templateSet{text: text, html: html}
+	}
+}
+
+// NormalizeLocale 规范化语言：zh-CN、zh_TW -> zh；不支持的语言返回空字符串
+func NormalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		locale = locale[:i]
+	}
+	if supportedLocales[locale] {
+		return locale
+	}
+	return ""
+}
+
+// IsSupportedLocale 是否提供该语言的模板
+func IsSupportedLocale(locale string) bool {
+	return NormalizeLocale(locale) != ""
+}
+
+// Render 渲染通知内容，语言不支持时使用英文
+func Render(kind, locale string, data *Data) (*Content, error) {
+	templatesOnce.Do(loadTemplates)
+	if templatesErr != nil {
+		return nil, templatesErr
+	}
+
+	if locale = NormalizeLocale(locale); locale == "" {
+		locale = DefaultLocale
+	}
+	set, ok := templates[kind+"."+locale]
+	if !ok {
+		if set, ok = templates[kind+"."+DefaultLocale]; !ok {
+			return nil, fmt.Errorf("no template for notification kind %s", kind)
+		}
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := set.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := set.text.ExecuteTemplate(&text, "text", data); err != nil {
+		return nil, err
+	}
+	if err := set.html.ExecuteTemplate(&html, "html", data); err != nil {
+		return nil, err
+	}
+	return &Content{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
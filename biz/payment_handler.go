@@ -9,6 +9,7 @@ import (
 	"strings"
 	"stripe-pay/conf"
 	"stripe-pay/db"
+	"stripe-pay/tenant"
 	"time"
 
 	"github.com/cloudwego/hertz/pkg/app"
@@ -45,16 +46,19 @@ type CreateAlipayPaymentRequest struct {
 }
 
 // getCurrentPricing 返回当前售卖价格（单位：分）与币种
-// 从数据库读取支付金额配置
-func getCurrentPricing() (amount int64, currency string, label string) {
+// 从数据库读取当前租户定价币种的支付金额配置
+func getCurrentPricing(ctx context.Context) (amount int64, currency string, label string) {
 	// 从数据库读取配置
 	if db.DB != nil {
-		config, err := db.GetPaymentConfig("hkd")
+		config, err := db.GetPaymentConfig(ctx, "")
 		if err == nil && config != nil {
 			amount = config.Amount
 			currency = config.Currency
 			// 生成显示标签，如 HK$59
 			label = "HK$" + formatAmount(amount)
+			if currency != "hkd" {
+				label = strings.ToUpper(currency) + " " + formatAmount(amount)
+			}
 			return
 		}
 		zap.L().Warn("Failed to get payment config from database, using default", zap.Error(err))
@@ -157,7 +161,7 @@ func CreateStripePayment(ctx context.Context, c *app.RequestContext) {
 	// 幂等性检查：获取Idempotency Key
 	idempotencyKey := getIdempotencyKey(c)
 	if idempotencyKey != "" && db.DB != nil {
-		existingPayment, err := db.GetPaymentByIdempotencyKey(idempotencyKey)
+		existingPayment, err := db.GetPaymentByIdempotencyKey(ctx, idempotencyKey)
 		if err != nil {
 			zap.L().Error("Failed to check idempotency", zap.Error(err))
 			// 继续执行，不阻止请求
@@ -193,7 +197,7 @@ func CreateStripePayment(ctx context.Context, c *app.RequestContext) {
 
 	// 先检查用户是否已经支付成功过（30天内有效）
 	if db.DB != nil {
-		userInfo, err := db.GetUserPaymentInfo(req.UserID)
+		userInfo, err := db.GetUserPaymentInfo(db.WithPrimary(ctx), req.UserID)
 		if err == nil && userInfo != nil && userInfo.HasPaid {
 			// 检查上次支付时间是否在30天内
 			if userInfo.LastPaymentAt != nil {
@@ -226,7 +230,7 @@ func CreateStripePayment(ctx context.Context, c *app.RequestContext) {
 	stripe.Key = cfg.Stripe.SecretKey
 
 	// 使用后端定义的定价（示例：HKD 59）
-	defaultAmount, defaultCurrency, _ := getCurrentPricing()
+	defaultAmount, defaultCurrency, _ := getCurrentPricing(ctx)
 
 	// 创建 Payment Intent
 	params := &stripe.PaymentIntentParams{
@@ -267,7 +271,8 @@ func CreateStripePayment(ctx context.Context, c *app.RequestContext) {
 			zap.String("payment_intent_id", intent.ID),
 			zap.String("idempotency_key", idempotencyKey))
 
-		err = db.SavePaymentWithMetadata(
+		err = db.SavePaymentWithMetadata(ctx,
+			db.PaymentAccount{}, // 旧接口只使用默认账户的主密钥
 			intent.ID,
 			paymentID,
 			idempotencyKey, // 保存幂等性密钥
@@ -285,7 +290,7 @@ func CreateStripePayment(ctx context.Context, c *app.RequestContext) {
 				zap.L().Info("Concurrent request with same idempotency_key detected",
 					zap.String("idempotency_key", dupErr.Key))
 				// 查询已存在的支付记录并返回
-				existingPayment, queryErr := db.GetPaymentByIdempotencyKey(dupErr.Key)
+				existingPayment, queryErr := db.GetPaymentByIdempotencyKey(ctx, dupErr.Key)
 				if queryErr == nil && existingPayment != nil {
 					// 从Stripe获取最新的PaymentIntent信息
 					intent, getErr := paymentintent.Get(existingPayment.PaymentIntentID, nil)
@@ -346,7 +351,7 @@ func CreateStripeWeChatPayment(ctx context.Context, c *app.RequestContext) {
 	// 幂等性检查：获取Idempotency Key
 	idempotencyKey := getIdempotencyKey(c)
 	if idempotencyKey != "" && db.DB != nil {
-		existingPayment, err := db.GetPaymentByIdempotencyKey(idempotencyKey)
+		existingPayment, err := db.GetPaymentByIdempotencyKey(ctx, idempotencyKey)
 		if err != nil {
 			zap.L().Error("Failed to check idempotency", zap.Error(err))
 			// 继续执行，不阻止请求
@@ -367,7 +372,7 @@ func CreateStripeWeChatPayment(ctx context.Context, c *app.RequestContext) {
 
 	// 先检查用户是否已经支付成功过（30天内有效）
 	if db.DB != nil {
-		userInfo, err := db.GetUserPaymentInfo(req.UserID)
+		userInfo, err := db.GetUserPaymentInfo(db.WithPrimary(ctx), req.UserID)
 		if err == nil && userInfo != nil && userInfo.HasPaid {
 			// 检查上次支付时间是否在30天内
 			if userInfo.LastPaymentAt != nil {
@@ -399,7 +404,7 @@ func CreateStripeWeChatPayment(ctx context.Context, c *app.RequestContext) {
 	cfg := conf.GetConf()
 	stripe.Key = cfg.Stripe.SecretKey
 
-	amount, currency, _ := getCurrentPricing()
+	amount, currency, _ := getCurrentPricing(ctx)
 
 	client := strings.ToLower(strings.TrimSpace(req.Client))
 	if client == "" {
@@ -449,7 +454,8 @@ func CreateStripeWeChatPayment(ctx context.Context, c *app.RequestContext) {
 			"description": req.Description,
 			"client":      client,
 		}
-		err = db.SavePaymentWithMetadata(
+		err = db.SavePaymentWithMetadata(ctx,
+			db.PaymentAccount{}, // 旧接口只使用默认账户的主密钥
 			intent.ID,
 			uuid.New().String(),
 			idempotencyKey, // 保存幂等性密钥
@@ -505,7 +511,7 @@ func CreateStripeAlipayPayment(ctx context.Context, c *app.RequestContext) {
 	// 幂等性检查：获取Idempotency Key
 	idempotencyKey := getIdempotencyKey(c)
 	if idempotencyKey != "" && db.DB != nil {
-		existingPayment, err := db.GetPaymentByIdempotencyKey(idempotencyKey)
+		existingPayment, err := db.GetPaymentByIdempotencyKey(ctx, idempotencyKey)
 		if err != nil {
 			zap.L().Error("Failed to check idempotency", zap.Error(err))
 			// 继续执行，不阻止请求
@@ -527,7 +533,7 @@ func CreateStripeAlipayPayment(ctx context.Context, c *app.RequestContext) {
 
 	// 先检查用户是否已经支付成功过（30天内有效）
 	if db.DB != nil {
-		userInfo, err := db.GetUserPaymentInfo(req.UserID)
+		userInfo, err := db.GetUserPaymentInfo(db.WithPrimary(ctx), req.UserID)
 		if err == nil && userInfo != nil && userInfo.HasPaid {
 			// 检查上次支付时间是否在30天内
 			if userInfo.LastPaymentAt != nil {
@@ -559,7 +565,7 @@ func CreateStripeAlipayPayment(ctx context.Context, c *app.RequestContext) {
 	cfg := conf.GetConf()
 	stripe.Key = cfg.Stripe.SecretKey
 
-	amount, currency, _ := getCurrentPricing()
+	amount, currency, _ := getCurrentPricing(ctx)
 
 	params := &stripe.PaymentIntentParams{
 		Amount:             stripe.Int64(amount),
@@ -596,7 +602,8 @@ func CreateStripeAlipayPayment(ctx context.Context, c *app.RequestContext) {
 			"user_id":     req.UserID,
 			"description": req.Description,
 		}
-		err = db.SavePaymentWithMetadata(
+		err = db.SavePaymentWithMetadata(ctx,
+			db.PaymentAccount{}, // 旧接口只使用默认账户的主密钥
 			intent.ID,
 			uuid.New().String(),
 			idempotencyKey, // 保存幂等性密钥
@@ -705,16 +712,17 @@ func StripeWebhook(ctx context.Context, c *app.RequestContext) {
 		} else {
 			// 更新数据库中的支付状态
 			if db.DB != nil {
+				ctx := paymentTenantContext(ctx, pi.ID)
 				// 更新支付历史状态
-				if err := db.UpdatePaymentStatus(pi.ID, string(pi.Status)); err != nil {
+				if err := db.UpdatePaymentStatus(ctx, pi.ID, string(pi.Status)); err != nil {
 					zap.L().Warn("Failed to update payment status", zap.Error(err))
 				}
 
 				// 获取用户ID（从 metadata 中）
 				userID := pi.Metadata["user_id"]
 				if userID != "" {
-					// 更新用户支付信息
-					if err := db.UpdateUserPaymentInfo(userID, pi.Amount); err != nil {
+					// 更新用户支付信息（旧接口只使用默认账户的主密钥，没有测试模式请求）
+					if err := db.UpdateUserPaymentInfo(ctx, userID, pi.Amount); err != nil {
 						zap.L().Warn("Failed to update user payment info", zap.Error(err))
 					}
 				}
@@ -727,7 +735,7 @@ func StripeWebhook(ctx context.Context, c *app.RequestContext) {
 		// 解析 PaymentIntent 并更新状态
 		var pi stripe.PaymentIntent
 		if err := json.Unmarshal(event.Data.Raw, &pi); err == nil && db.DB != nil {
-			db.UpdatePaymentStatus(pi.ID, string(pi.Status))
+			db.UpdatePaymentStatus(paymentTenantContext(ctx, pi.ID), pi.ID, string(pi.Status))
 		}
 
 	case "payment_intent.canceled":
@@ -736,7 +744,7 @@ func StripeWebhook(ctx context.Context, c *app.RequestContext) {
 		// 解析 PaymentIntent 并更新状态
 		var pi stripe.PaymentIntent
 		if err := json.Unmarshal(event.Data.Raw, &pi); err == nil && db.DB != nil {
-			db.UpdatePaymentStatus(pi.ID, string(pi.Status))
+			db.UpdatePaymentStatus(paymentTenantContext(ctx, pi.ID), pi.ID, string(pi.Status))
 		}
 
 	default:
@@ -753,9 +761,18 @@ type RefundRequest struct {
 	Reason          string `json:"reason,omitempty"`  // 可选：退款原因（duplicate, fraudulent, requested_by_customer）
 }
 
+// paymentTenantContext Webhook 没有请求租户，按支付记录所属租户设置 context（找不到记录时保持不变）
+func paymentTenantContext(ctx context.Context, paymentIntentID string) context.Context {
+	payment, err := db.GetPaymentByIntentIDAnyTenant(ctx, paymentIntentID)
+	if err != nil || payment == nil {
+		return ctx
+	}
+	return tenant.WithID(ctx, payment.TenantID)
+}
+
 // GetPricing 向前端返回当前应支付的币种与价格
 func GetPricing(ctx context.Context, c *app.RequestContext) {
-	amount, currency, label := getCurrentPricing()
+	amount, currency, label := getCurrentPricing(ctx)
 	c.JSON(consts.StatusOK, PricingResponse{
 		Amount:   amount,
 		Currency: currency,
@@ -816,7 +833,7 @@ func UpdatePaymentConfig(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	err := db.UpdatePaymentConfig(req.Currency, req.Amount, req.Description)
+	err := db.UpdatePaymentConfig(ctx, req.Currency, req.Amount, req.Description)
 	if err != nil {
 		zap.L().Error("Failed to update payment config", zap.Error(err))
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": "Failed to update payment config"})
@@ -824,7 +841,7 @@ func UpdatePaymentConfig(ctx context.Context, c *app.RequestContext) {
 	}
 
 	// 返回更新后的配置
-	config, err := db.GetPaymentConfig(req.Currency)
+	config, err := db.GetPaymentConfig(ctx, req.Currency)
 	if err != nil {
 		zap.L().Warn("Failed to get updated config", zap.Error(err))
 		// 即使获取失败，也返回成功（因为更新已经成功）
@@ -861,7 +878,7 @@ func GetPaymentConfig(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	config, err := db.GetPaymentConfig(currency)
+	config, err := db.GetPaymentConfig(ctx, currency)
 	if err != nil {
 		zap.L().Error("Failed to get payment config", zap.Error(err))
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": "Failed to get payment config"})
@@ -899,7 +916,7 @@ func GetUserPaymentInfo(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	info, err := db.GetUserPaymentInfo(userID)
+	info, err := db.GetUserPaymentInfo(ctx, userID)
 	if err != nil {
 		zap.L().Error("Failed to get user payment info", zap.Error(err))
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": "Failed to get user payment info"})
@@ -952,7 +969,7 @@ func UpdatePaymentStatusFromFrontend(ctx context.Context, c *app.RequestContext)
 	// 更新数据库
 	if db.DB != nil {
 		// 更新支付历史状态
-		if err := db.UpdatePaymentStatus(req.PaymentIntentID, actualStatus); err != nil {
+		if err := db.UpdatePaymentStatus(ctx, req.PaymentIntentID, actualStatus); err != nil {
 			zap.L().Warn("Failed to update payment status", zap.Error(err))
 		}
 
@@ -960,7 +977,7 @@ func UpdatePaymentStatusFromFrontend(ctx context.Context, c *app.RequestContext)
 		if actualStatus == "succeeded" {
 			userID := intent.Metadata["user_id"]
 			if userID != "" {
-				if err := db.UpdateUserPaymentInfo(userID, intent.Amount); err != nil {
+				if err := db.UpdateUserPaymentInfo(ctx, userID, intent.Amount); err != nil {
 					zap.L().Warn("Failed to update user payment info", zap.Error(err))
 				}
 			}
@@ -1002,7 +1019,7 @@ func GetUserPaymentHistory(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	history, err := db.GetPaymentHistory(userID, limit)
+	history, err := db.GetPaymentHistory(ctx, userID, limit)
 	if err != nil {
 		zap.L().Error("Failed to get payment history", zap.Error(err))
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": "Failed to get payment history"})
@@ -1161,7 +1178,7 @@ func GetPaymentStatus(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	payment, err := db.GetPaymentByPaymentID(paymentID)
+	payment, err := db.GetPaymentByPaymentID(ctx, paymentID)
 	if err != nil {
 		zap.L().Warn("Failed to get payment from database", zap.Error(err), zap.String("payment_id", paymentID))
 	} else if payment != nil {
@@ -0,0 +1,106 @@
+package receipt
+
+import (
+	"bytes"
+	"html/template"
+	"regexp"
+)
+
+// defaultBrandColor 未配置主题色时使用
+const defaultBrandColor = "#635bff"
+
+var brandColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+var htmlTemplate = template.Must(template.New("receipt").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Receipt {{.R.Number}}</title>
+<style>
+  body { font-family: -apple-system, "Helvetica Neue", Arial, sans-serif; color: #1a1a1a; background: #f6f8fa; margin: 0; padding: 32px 16px; }
+  .receipt { max-width: 640px; margin: 0 auto; background: #fff; border-radius: 8px; overflow: hidden; box-shadow: 0 1px 3px rgba(0,0,0,.1); }
+  .header { background: {{.Color}}; color: #fff; padding: 24px 32px; }
+  .header img { max-height: 40px; display: block; margin-bottom: 12px; }
+  .header h1 { margin: 0; font-size: 22px; }
+  .section { padding: 16px 32px; border-bottom: 1px solid #eee; }
+  .muted { color: #6b7280; font-size: 13px; }
+  table { width: 100%; border-collapse: collapse; }
+  td { padding: 6px 0; font-size: 14px; }
+  td.amount { text-align: right; white-space: nowrap; }
+  tr.total td { font-weight: 600; border-top: 1px solid #eee; }
+</style>
+</head>
+<body>
+<div class="receipt">
+  <div class="header">
+    {{if .R.LogoURL}}<img src="{{.R.LogoURL}}" alt="{{.R.Company.Name}}">{{end}}
+    <h1>Receipt from {{.R.Company.Name}}</h1>
+    <div>Receipt #{{.R.Number}}</div>
+  </div>
+  <div class="section">
+    <table>
+      <tr><td class="muted">Date</td><td class="amount">{{.R.IssuedAt.Format "2006-01-02 15:04 MST"}}</td></tr>
+      <tr><td class="muted">Payment ID</td><td class="amount">{{.R.PaymentID}}</td></tr>
+      {{if .R.PaymentMethod}}<tr><td class="muted">Payment method</td><td class="amount">{{.R.PaymentMethod}}</td></tr>{{end}}
+      <tr><td class="muted">Customer</td><td class="amount">{{.R.UserID}}</td></tr>
+    </table>
+  </div>
+  <div class="section">
+    {{if .R.Description}}<p>{{.R.Description}}</p>{{end}}
+    <table>
+      {{range .Lines}}<tr{{if .Total}} class="total"{{end}}><td>{{.Label}}</td><td class="amount">{{.Amount}}</td></tr>
+      {{end}}
+    </table>
+  </div>
+  {{if .Refunds}}<div class="section">
+    <table>
+      {{range .Refunds}}<tr><td class="muted">Refund {{.ID}} ({{.Status}}) {{.Refunded.Format "2006-01-02"}}</td><td class="amount">-{{.Amount}}</td></tr>
+      {{end}}
+    </table>
+  </div>{{end}}
+  <div class="section muted">
+    {{.R.Company.Name}}{{range .R.Company.Address}}<br>{{.}}{{end}}
+    {{if .R.Company.TaxID}}<br>Tax ID: {{.R.Company.TaxID}}{{end}}
+    {{if .R.Company.Email}}<br>{{.R.Company.Email}}{{end}}{{if .R.Company.Phone}} · {{.R.Company.Phone}}{{end}}
+    {{if .R.Company.Website}}<br>{{.R.Company.Website}}{{end}}
+  </div>
+</div>
+</body>
+</html>
+`))
+
+// htmlRefund 退款行（金额已格式化）
+type htmlRefund struct {
+	Refund
+	Amount string
+}
+
+// RenderHTML 渲染 HTML 收据
+func RenderHTML(r *Receipt) ([]byte, error) {
+	color := r.BrandColor
+	if !brandColorPattern.MatchString(color) {
+		color = defaultBrandColor
+	}
+
+	refunds := make([]htmlRefund, 0, len(r.Refunds))
+	for _, refund := range r.Refunds {
+		refunds = append(refunds, htmlRefund{Refund: refund, Amount: FormatAmount(refund.Amount, r.Currency)})
+	}
+
+	var buf bytes.Buffer
+	err := htmlTemplate.Execute(&buf, struct {
+		R       *Receipt
+		Color   template.CSS
+		Lines   []Line
+		Refunds []htmlRefund
+	}{
+		R:       r,
+		Color:   template.CSS(color),
+		Lines:   r.Lines(),
+		Refunds: refunds,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,232 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A4 页面尺寸和边距（单位：pt）
+const (
+	pageWidth    = 595.0
+	pageHeight   = 842.0
+	pageMargin   = 50.0
+	pageBottom   = 60.0
+	lineHeight   = 16.0
+	headerHeight = 90.0
+)
+
+// pdfWriter 生成只包含文本、线条和色块的简单 PDF（内置 Helvetica 字体，WinAnsi 编码）
+// 字体不支持的字符（如中文）显示为 ?
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+func newPDFWriter() *pdfWriter {
+	w := &pdfWriter{}
+	w.newPage()
+	return w
+}
+
+func (w *pdfWriter) page() *bytes.Buffer {
+	return w.pages[len(w.pages)-1]
+}
+
+func (w *pdfWriter) newPage() {
+	w.pages = append(w.pages, &bytes.Buffer{})
+	w.y = pageHeight - pageMargin
+}
+
+// ensureSpace 当前页剩余空间不足时换页
+func (w *pdfWriter) ensureSpace(height float64) {
+	if w.y-height < pageBottom {
+		w.newPage()
+	}
+}
+
+func (w *pdfWriter) text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(w.page(), "BT /%s %s Tf %s %s Td (%s) Tj ET\n", font, num(size), num(x), num(y), pdfEscape(s))
+}
+
+// textRight 右对齐文本（按 Helvetica 平均字宽估算）
+func (w *pdfWriter) textRight(right, y, size float64, bold bool, s string) {
+	w.text(right-textWidth(s, size), y, size, bold, s)
+}
+
+func (w *pdfWriter) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(w.page(), "0.85 0.85 0.85 RG 0.5 w %s %s m %s %s l S\n", num(x1), num(y1), num(x2), num(y2))
+}
+
+func (w *pdfWriter) fillRect(x, y, width, height float64, rgb [3]float64) {
+	fmt.Fprintf(w.page(), "%s %s %s rg %s %s %s %s re f\n",
+		num(rgb[0]), num(rgb[1]), num(rgb[2]), num(x), num(y), num(width), num(height))
+}
+
+func (w *pdfWriter) setFill(rgb [3]float64) {
+	fmt.Fprintf(w.page(), "%s %s %s rg\n", num(rgb[0]), num(rgb[1]), num(rgb[2]))
+}
+
+// row 一行左侧标签、右侧金额
+func (w *pdfWriter) row(label, value string, bold bool) {
+	w.ensureSpace(lineHeight)
+	w.y -= lineHeight
+	w.text(pageMargin, w.y, 10, bold, label)
+	w.textRight(pageWidth-pageMargin, w.y, 10, bold, value)
+}
+
+// bytes 输出 PDF 文件：Catalog、Pages、两种字体，以及每页的 Page 和内容流
+func (w *pdfWriter) bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	writeObj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+
+	// 对象编号：1 Catalog, 2 Pages, 3/4 字体, 之后每页两个对象（Page, Contents）
+	kids := make([]string, len(w.pages))
+	for i := range w.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+i*2)
+	}
+	writeObj("<< /Type /Catalog /Pages 2 0 R >>")
+	writeObj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(w.pages)))
+	writeObj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	writeObj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, content := range w.pages {
+		writeObj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			num(pageWidth), num(pageHeight), 6+i*2))
+		writeObj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// RenderPDF 渲染 PDF 收据
+func RenderPDF(r *Receipt) ([]byte, error) {
+	w := newPDFWriter()
+	brand := parseColor(r.BrandColor)
+	black := [3]float64{0.1, 0.1, 0.1}
+	muted := [3]float64{0.42, 0.45, 0.5}
+
+	// 页眉色块
+	w.fillRect(0, pageHeight-headerHeight, pageWidth, headerHeight, brand)
+	w.setFill([3]float64{1, 1, 1})
+	w.text(pageMargin, pageHeight-45, 20, true, "Receipt from "+r.Company.Name)
+	w.text(pageMargin, pageHeight-68, 11, false, "Receipt #"+r.Number)
+	w.setFill(black)
+	w.y = pageHeight - headerHeight - 20
+
+	w.row("Date", r.IssuedAt.Format("2006-01-02 15:04 MST"), false)
+	w.row("Payment ID", r.PaymentID, false)
+	if r.PaymentMethod != "" {
+		w.row("Payment method", r.PaymentMethod, false)
+	}
+	w.row("Customer", r.UserID, false)
+
+	w.y -= lineHeight / 2
+	w.line(pageMargin, w.y, pageWidth-pageMargin, w.y)
+	if r.Description != "" {
+		w.y -= lineHeight
+		w.text(pageMargin, w.y, 11, true, r.Description)
+	}
+	for _, line := range r.Lines() {
+		w.row(line.Label, line.Amount, line.Total)
+	}
+
+	if len(r.Refunds) > 0 {
+		w.y -= lineHeight / 2
+		w.line(pageMargin, w.y, pageWidth-pageMargin, w.y)
+		for _, refund := range r.Refunds {
+			label := fmt.Sprintf("Refund %s (%s) %s", refund.ID, refund.Status, refund.Refunded.Format("2006-01-02"))
+			w.row(label, "-"+FormatAmount(refund.Amount, r.Currency), false)
+		}
+	}
+
+	// 公司信息
+	footer := []string{r.Company.Name}
+	footer = append(footer, r.Company.Address...)
+	if r.Company.TaxID != "" {
+		footer = append(footer, "Tax ID: "+r.Company.TaxID)
+	}
+	contact := strings.TrimSpace(strings.Join(nonEmpty(r.Company.Email, r.Company.Phone, r.Company.Website), "  "))
+	if contact != "" {
+		footer = append(footer, contact)
+	}
+	w.y -= lineHeight / 2
+	w.ensureSpace(lineHeight * float64(len(footer)+1))
+	w.line(pageMargin, w.y, pageWidth-pageMargin, w.y)
+	w.setFill(muted)
+	for _, text := range footer {
+		w.y -= lineHeight
+		w.text(pageMargin, w.y, 9, false, text)
+	}
+
+	return w.bytes(), nil
+}
+
+// parseColor 解析 #rrggbb 主题色
+func parseColor(hex string) [3]float64 {
+	if !brandColorPattern.MatchString(hex) {
+		hex = defaultBrandColor
+	}
+	var rgb [3]float64
+	for i := 0; i < 3; i++ {
+		v, _ := strconv.ParseUint(hex[1+i*2:3+i*2], 16, 8)
+		rgb[i] = float64(v) / 255
+	}
+	return rgb
+}
+
+// pdfEscape 转义 PDF 字符串并转换为 WinAnsi（Latin-1 以外的字符替换为 ?）
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20:
+			b.WriteByte(' ')
+		case r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// textWidth 估算 Helvetica 文本宽度（平均字宽约 0.55em）
+func textWidth(s string, size float64) float64 {
+	return float64(len([]rune(s))) * size * 0.55
+}
+
+func num(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
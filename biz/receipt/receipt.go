@@ -0,0 +1,132 @@
+package receipt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// zeroDecimalCurrencies 没有小数位的币种（金额即为最小货币单位）
+var zeroDecimalCurrencies = map[string]bool{
+	"jpy": true,
+	"krw": true,
+	"vnd": true,
+	"clp": true,
+}
+
+// Company 收据上的公司信息
+type Company struct {
+	Name    string
+	Address []string
+	Email   string
+	Phone   string
+	TaxID   string
+	Website string
+}
+
+// Refund 收据上展示的退款
+type Refund struct {
+	ID       string
+	Amount   int64
+	Status   string
+	Reason   string
+	Refunded time.Time
+}
+
+// Receipt 一笔成功支付的收据内容，金额均为最小货币单位
+type Receipt struct {
+	Number          string
+	IssuedAt        time.Time
+	Company         Company
+	BrandColor      string
+	LogoURL         string
+	UserID          string
+	PaymentID       string
+	PaymentIntentID string
+	PaymentMethod   string
+	Description     string
+	Currency        string
+	Amount          int64 // 实收金额（含税）
+	OriginalAmount  int64 // 使用优惠码时的原价，未使用时为 0
+	DiscountAmount  int64
+	PromoCode       string
+	TaxName         string
+	TaxRateBps      int
+	TaxAmount       int64
+	Refunds         []Refund
+}
+
+// Line 收据金额明细中的一行
+type Line struct {
+	Label  string
+	Amount string
+	Total  bool
+}
+
+// RefundedAmount 已成功退款的总额
+func (r *Receipt) RefundedAmount() int64 {
+	var total int64
+	for _, refund := range r.Refunds {
+		if refund.Status == "succeeded" {
+			total += refund.Amount
+		}
+	}
+	return total
+}
+
+// Lines 金额明细：原价/折扣、不含税金额、税额、实收、退款和净额（HTML 与 PDF 共用）
+func (r *Receipt) Lines() []Line {
+	var lines []Line
+	if r.DiscountAmount > 0 {
+		lines = append(lines, Line{Label: "Original price", Amount: FormatAmount(r.OriginalAmount, r.Currency)})
+		label := "Discount"
+		if r.PromoCode != "" {
+			label = fmt.Sprintf("Discount (%s)", r.PromoCode)
+		}
+		lines = append(lines, Line{Label: label, Amount: "-" + FormatAmount(r.DiscountAmount, r.Currency)})
+	}
+	if r.TaxRateBps > 0 {
+		lines = append(lines,
+			Line{Label: "Subtotal", Amount: FormatAmount(r.Amount-r.TaxAmount, r.Currency)},
+			Line{Label: fmt.Sprintf("%s (%s, included)", r.taxName(), FormatRate(r.TaxRateBps)), Amount: FormatAmount(r.TaxAmount, r.Currency)},
+		)
+	}
+	lines = append(lines, Line{Label: "Amount paid", Amount: FormatAmount(r.Amount, r.Currency), Total: true})
+
+	if refunded := r.RefundedAmount(); refunded > 0 {
+		lines = append(lines,
+			Line{Label: "Refunded", Amount: "-" + FormatAmount(refunded, r.Currency)},
+			Line{Label: "Net amount", Amount: FormatAmount(r.Amount-refunded, r.Currency), Total: true},
+		)
+	}
+	return lines
+}
+
+func (r *Receipt) taxName() string {
+	if r.TaxName == "" {
+		return "Tax"
+	}
+	return r.TaxName
+}
+
+// FormatAmount 格式化金额，如 HKD 59.00、JPY 500
+func FormatAmount(amount int64, currency string) string {
+	code := strings.ToUpper(currency)
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if zeroDecimalCurrencies[strings.ToLower(currency)] {
+		return fmt.Sprintf("%s %s%d", code, sign, amount)
+	}
+	return fmt.Sprintf("%s %s%d.%02d", code, sign, amount/100, amount%100)
+}
+
+// FormatRate 格式化万分比税率，如 1300 -> 13%、825 -> 8.25%
+func FormatRate(bps int) string {
+	if bps%100 == 0 {
+		return fmt.Sprintf("%d%%", bps/100)
+	}
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%d.%02d", bps/100, bps%100), "0"), ".") + "%"
+}
@@ -0,0 +1,116 @@
+package receipt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFormatAmount 测试金额格式化
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   int64
+		currency string
+		want     string
+	}{
+		{"两位小数币种", 5900, "hkd", "HKD 59.00"},
+		{"不足一元", 5, "usd", "USD 0.05"},
+		{"零小数币种", 500, "jpy", "JPY 500"},
+		{"负数", -1250, "eur", "EUR -12.50"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatAmount(tt.amount, tt.currency); got != tt.want {
+				t.Errorf("FormatAmount() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLines 测试收据金额明细
+func TestLines(t *testing.T) {
+	r := &Receipt{
+		Currency:       "hkd",
+		Amount:         4720,
+		OriginalAmount: 5900,
+		DiscountAmount: 1180,
+		PromoCode:      "SPRING20",
+		TaxName:        "VAT",
+		TaxRateBps:     1300,
+		TaxAmount:      543,
+		Refunds: []Refund{
+			{ID: "re_1", Amount: 1000, Status: "succeeded"},
+			{ID: "re_2", Amount: 500, Status: "failed"},
+		},
+	}
+
+	var labels []string
+	for _, line := range r.Lines() {
+		labels = append(labels, line.Label+"="+line.Amount)
+	}
+	want := []string{
+		"Original price=HKD 59.00",
+		"Discount (SPRING20)=-HKD 11.80",
+		"Subtotal=HKD 41.77",
+		"VAT (13%, included)=HKD 5.43",
+		"Amount paid=HKD 47.20",
+		"Refunded=-HKD 10.00",
+		"Net amount=HKD 37.20",
+	}
+	if strings.Join(labels, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Lines() =\n%s\nwant\n%s", strings.Join(labels, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestRender 测试 HTML 与 PDF 渲染
+func TestRender(t *testing.T) {
+	r := &Receipt{
+		Number:      "R-00000001",
+		IssuedAt:    time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC),
+		Company:     Company{Name: "Acme <Ltd>", Address: []string{"1 Queen's Road"}},
+		BrandColor:  "red;}</style>",
+		UserID:      "user_1",
+		PaymentID:   "pay_1",
+		Description: "会员 (Premium)",
+		Currency:    "usd",
+		Amount:      5900,
+	}
+
+	html, err := RenderHTML(r)
+	if err != nil {
+		t.Fatalf("RenderHTML() error = %v", err)
+	}
+	if !bytes.Contains(html, []byte("Acme &lt;Ltd&gt;")) || !bytes.Contains(html, []byte(defaultBrandColor)) {
+		t.Errorf("RenderHTML() did not escape input or fall back to the default brand color")
+	}
+
+	pdf, err := RenderPDF(r)
+	if err != nil {
+		t.Fatalf("RenderPDF() error = %v", err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Errorf("RenderPDF() output is not a PDF file")
+	}
+	if !bytes.Contains(pdf, []byte(`(?? \(Premium\)) Tj`)) {
+		t.Errorf("RenderPDF() did not escape description")
+	}
+}
+
+// TestRenderPDFPages 测试退款较多时分页
+func TestRenderPDFPages(t *testing.T) {
+	r := &Receipt{Number: "R-00000002", Currency: "usd", Amount: 100000}
+	for i := 0; i < 50; i++ {
+		r.Refunds = append(r.Refunds, Refund{ID: "re", Amount: 100, Status: "succeeded"})
+	}
+
+	pdf, err := RenderPDF(r)
+	if err != nil {
+		t.Fatalf("RenderPDF() error = %v", err)
+	}
+	if !bytes.Contains(pdf, []byte("/Count 2")) {
+		t.Errorf("RenderPDF() expected 2 pages")
+	}
+}
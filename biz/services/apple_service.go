@@ -0,0 +1,116 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"stripe-pay/biz/models"
+	"stripe-pay/conf"
+	"stripe-pay/tenant"
+
+	"go.uber.org/zap"
+)
+
+// appleSandboxReceiptStatus 生产环境收到沙盒收据时返回的状态码
+const appleSandboxReceiptStatus = 21007
+
+// errAppleUnavailable Apple 返回 5xx
+var errAppleUnavailable = errors.New("apple verifyReceipt unavailable")
+
+// ErrAppleBundleMismatch 收据不属于当前租户配置的 Apple bundle
+var ErrAppleBundleMismatch = errors.New("apple receipt belongs to another bundle")
+
+// VerifyAppleReceipt 校验 Apple 内购收据：先请求生产环境，返回 21007 时改用沙盒环境
+// 请求带超时、对网络错误和 5xx 重试（verifyReceipt 是幂等的），连续失败后熔断；
+// 租户配置了 apple_bundle_id 时，有效收据必须属于该 bundle，否则返回 ErrAppleBundleMismatch
+func (s *PaymentService) VerifyAppleReceipt(ctx context.Context, receiptData string) (*models.AppleVerifyResponse, error) {
+	initUpstreams(s.cfg)
+
+	// 每次读取当前配置，Apple 地址和共享密钥修改后重新加载即可生效
+	apple := conf.GetConf().Apple
+	body, err := json.Marshal(map[string]interface{}{
+		"receipt-data": receiptData,
+		"password":     apple.SharedSecret,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := postAppleReceipt(ctx, apple.ProductionURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify receipt with Apple: %w", err)
+	}
+	if resp.Status == appleSandboxReceiptStatus {
+		zap.L().Info("Sandbox receipt, retrying against Apple sandbox")
+		resp, err = postAppleReceipt(ctx, apple.SandboxURL, body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify receipt with Apple sandbox: %w", err)
+		}
+	}
+
+	if bundleID := tenant.FromContext(ctx).AppleBundleID; bundleID != "" && resp.Status == 0 {
+		if got := appleReceiptBundleID(resp); got != bundleID {
+			zap.L().Warn("Apple receipt bundle does not match tenant",
+				zap.String("tenant_id", tenant.ID(ctx)),
+				zap.String("bundle_id", got),
+				zap.String("expected_bundle_id", bundleID))
+			return nil, fmt.Errorf("%w: %s", ErrAppleBundleMismatch, got)
+		}
+	}
+	return resp, nil
+}
+
+// appleReceiptBundleID 收据中的 bundle_id（没有时为空）
+func appleReceiptBundleID(resp *models.AppleVerifyResponse) string {
+	receipt, ok := resp.Receipt.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	bundleID, _ := receipt["bundle_id"].(string)
+	return bundleID
+}
+
+// postAppleReceipt 请求 verifyReceipt 接口（带熔断和重试）
+func postAppleReceipt(ctx context.Context, url string, body []byte) (*models.AppleVerifyResponse, error) {
+	var result models.AppleVerifyResponse
+	err := appleRetry.Do(ctx, func() error {
+		if err := appleBreaker.Allow(); err != nil {
+			return err
+		}
+		err := doAppleRequest(ctx, url, body, &result)
+		appleBreaker.Done(err == nil || !isRetryableHTTPError(err))
+		return err
+	}, isRetryableHTTPError)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// doAppleRequest 发送一次 verifyReceipt 请求并解析响应
+func doAppleRequest(ctx context.Context, url string, body []byte, result *models.AppleVerifyResponse) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := appleHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("%w: HTTP %d", errAppleUnavailable, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse Apple response: %w", err)
+	}
+	return nil
+}
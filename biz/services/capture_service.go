@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"stripe-pay/biz"
+	"stripe-pay/biz/models"
+	"stripe-pay/common"
+	"stripe-pay/db"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"go.uber.org/zap"
+)
+
+// 手动扣款默认参数（配置未设置时使用）
+const (
+	DefaultCaptureExpiryInterval = time.Hour
+	defaultCaptureBatchSize      = 100
+	defaultCaptureWarnBefore     = 24 * time.Hour
+	defaultCaptureCancelBefore   = 2 * time.Hour
+	// stripeAuthorizationWindow Stripe 卡授权的默认有效期（无法从 Charge 读取 capture_before 时使用）
+	stripeAuthorizationWindow = 7 * 24 * time.Hour
+)
+
+// PaymentStateError PaymentIntent 当前状态不允许该操作
+type PaymentStateError struct {
+	PaymentIntentID string
+	Status          string
+	Action          string
+}
+
+func (e *PaymentStateError) Error() string {
+	return fmt.Sprintf("cannot %s payment intent %s in status %s", e.Action, e.PaymentIntentID, e.Status)
+}
+
+// CapturePayment 扣款（全部或部分）已授权的 PaymentIntent
+func (s *PaymentService) CapturePayment(ctx context.Context, req *models.CaptureRequest, idempotencyKey string) (*stripe.PaymentIntent, error) {
+	if err := biz.ValidatePaymentIntentID(req.PaymentIntentID); err != nil {
+		return nil, fmt.Errorf("invalid payment_intent_id: %w", err)
+	}
+
+	sc, pi, err := s.getCapturableIntent(ctx, req.PaymentIntentID, "capture")
+	if err != nil {
+		return nil, err
+	}
+
+	params := &stripe.PaymentIntentCaptureParams{}
+	params.Context = ctx
+	if req.Amount > 0 {
+		if req.Amount > pi.AmountCapturable {
+			return nil, &biz.ValidationError{
+				Field:   "amount",
+				Message: fmt.Sprintf("amount must not exceed the authorized amount %d", pi.AmountCapturable),
+			}
+		}
+		params.AmountToCapture = stripe.Int64(req.Amount)
+	}
+	if idempotencyKey != "" {
+		params.IdempotencyKey = stripe.String("capture:" + idempotencyKey)
+	}
+
+	captured, err := sc.API.PaymentIntents.Capture(pi.ID, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture payment intent: %w", err)
+	}
+
+	// 部分扣款：记录实际扣款金额（剩余授权由 Stripe 自动释放）
+	if db.DB != nil && captured.AmountReceived > 0 && captured.AmountReceived != captured.Amount {
+		if err := db.UpdatePaymentAmount(ctx, captured.ID, captured.AmountReceived); err != nil {
+			zap.L().Warn("Failed to record captured amount", zap.Error(err), zap.String("payment_intent_id", captured.ID))
+		}
+	}
+	if err := s.syncPaymentIntentStatus(ctx, captured); err != nil {
+		zap.L().Warn("Failed to sync captured payment intent", zap.Error(err), zap.String("payment_intent_id", captured.ID))
+	}
+
+	zap.L().Info("Payment captured",
+		zap.String("payment_intent_id", captured.ID),
+		zap.Int64("amount_received", captured.AmountReceived),
+		zap.Int64("authorized_amount", captured.Amount))
+	return captured, nil
+}
+
+// CancelAuthorization 取消已授权未扣款的 PaymentIntent，释放持卡人额度
+func (s *PaymentService) CancelAuthorization(ctx context.Context, req *models.CancelAuthorizationRequest) (*stripe.PaymentIntent, error) {
+	if err := biz.ValidatePaymentIntentID(req.PaymentIntentID); err != nil {
+		return nil, fmt.Errorf("invalid payment_intent_id: %w", err)
+	}
+	if err := biz.ValidateRefundReason(req.Reason); err != nil {
+		return nil, fmt.Errorf("invalid reason: %w", err)
+	}
+
+	sc, pi, err := s.getCapturableIntent(ctx, req.PaymentIntentID, "cancel authorization of")
+	if err != nil {
+		return nil, err
+	}
+
+	reason := req.Reason
+	if reason == "" {
+		reason = string(stripe.PaymentIntentCancellationReasonRequestedByCustomer)
+	}
+	return s.cancelAuthorization(ctx, sc, pi.ID, reason)
+}
+
+// getCapturableIntent 从所属账户获取 PaymentIntent 并确认处于已授权待扣款状态
+func (s *PaymentService) getCapturableIntent(ctx context.Context, paymentIntentID, action string) (*stripeClient, *stripe.PaymentIntent, error) {
+	sc, err := stripeForIntent(ctx, paymentIntentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	params := &stripe.PaymentIntentParams{}
+	params.Context = ctx
+	pi, err := sc.API.PaymentIntents.Get(paymentIntentID, params)
+	if err != nil {
+		if stripeErr, ok := err.(*stripe.Error); ok && stripeErr.Code == stripe.ErrorCodeResourceMissing {
+			return nil, nil, ErrPaymentNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to get payment intent: %w", err)
+	}
+	if pi.Status != stripe.PaymentIntentStatusRequiresCapture {
+		return nil, nil, &PaymentStateError{PaymentIntentID: pi.ID, Status: string(pi.Status), Action: action}
+	}
+	return sc, pi, nil
+}
+
+// cancelAuthorization 在 Stripe 取消授权并同步状态（取消后释放优惠码预留）
+func (s *PaymentService) cancelAuthorization(ctx context.Context, sc *stripeClient, paymentIntentID, reason string) (*stripe.PaymentIntent, error) {
+	params := &stripe.PaymentIntentCancelParams{
+		CancellationReason: stripe.String(reason),
+	}
+	params.Context = ctx
+	pi, err := sc.API.PaymentIntents.Cancel(paymentIntentID, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel authorization: %w", err)
+	}
+
+	if err := s.syncPaymentIntentStatus(ctx, pi); err != nil {
+		zap.L().Warn("Failed to sync canceled authorization", zap.Error(err), zap.String("payment_intent_id", pi.ID))
+	}
+
+	zap.L().Info("Authorization canceled",
+		zap.String("payment_intent_id", pi.ID),
+		zap.String("reason", reason))
+	return pi, nil
+}
+
+// authorizationDeadline 授权失效时间：优先使用卡授权的 capture_before，否则按创建时间加 7 天估算
+func authorizationDeadline(pi *stripe.PaymentIntent) time.Time {
+	if pi.LatestCharge != nil && pi.LatestCharge.PaymentMethodDetails != nil &&
+		pi.LatestCharge.PaymentMethodDetails.Card != nil && pi.LatestCharge.PaymentMethodDetails.Card.CaptureBefore > 0 {
+		return time.Unix(pi.LatestCharge.PaymentMethodDetails.Card.CaptureBefore, 0)
+	}
+	return time.Unix(pi.Created, 0).Add(stripeAuthorizationWindow)
+}
+
+// getCaptureExpirySettings 读取授权到期检查参数
+func (s *PaymentService) getCaptureExpirySettings() (warnBefore, cancelBefore time.Duration, batchSize int) {
+	cfg := s.cfg.Capture
+
+	warnBefore = defaultCaptureWarnBefore
+	if cfg.WarnBefore > 0 {
+		warnBefore = time.Duration(cfg.WarnBefore) * time.Second
+	}
+	cancelBefore = defaultCaptureCancelBefore
+	if cfg.CancelBefore > 0 {
+		cancelBefore = time.Duration(cfg.CancelBefore) * time.Second
+	}
+	batchSize = cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultCaptureBatchSize
+	}
+	return warnBefore, cancelBefore, batchSize
+}
+
+// CheckExpiringAuthorizations 检查即将失效的未扣款授权：进入告警窗口的记录告警，
+// 开启 auto_cancel 时取消进入取消窗口的授权，返回取消的数量。每次运行分页处理所有授权，失败的记录退避后再重试
+func (s *PaymentService) CheckExpiringAuthorizations(ctx context.Context) (int, error) {
+	if db.DB == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	warnBefore, cancelBefore, batchSize := s.getCaptureExpirySettings()
+	now := time.Now()
+
+	// 创建时间早于 (7 天 - 告警窗口) 的授权才可能进入告警窗口；capture_before 可能更早，多留一天余量
+	createdBefore := now.Add(-(stripeAuthorizationWindow - warnBefore - 24*time.Hour))
+
+	expiring, canceled := 0, 0
+	err := sweepPayments(ctx, db.SweepJobAuthorizationExpiry, batchSize,
+		func(after *db.PaymentCursor, limit int) ([]db.PaymentHistory, error) {
+			return db.ListAuthorizedPayments(ctx, createdBefore, after, limit)
+		},
+		func(payment *db.PaymentHistory) error {
+			sc, err := stripeForPayment(payment)
+			if err != nil {
+				return err
+			}
+			params := &stripe.PaymentIntentParams{}
+			params.Context = ctx
+			params.AddExpand("latest_charge")
+			pi, err := sc.API.PaymentIntents.Get(payment.PaymentIntentID, params)
+			if err != nil {
+				return fmt.Errorf("failed to get payment intent %s: %w", payment.PaymentIntentID, err)
+			}
+			if pi.Status != stripe.PaymentIntentStatusRequiresCapture {
+				return s.syncPaymentIntentStatus(ctx, pi)
+			}
+
+			deadline := authorizationDeadline(pi)
+			remaining := deadline.Sub(now)
+			if remaining > warnBefore {
+				return nil
+			}
+
+			if s.cfg.Capture.AutoCancel && remaining <= cancelBefore {
+				if _, err := s.cancelAuthorization(ctx, sc, pi.ID, string(stripe.PaymentIntentCancellationReasonAbandoned)); err != nil {
+					return err
+				}
+				common.RecordAuthorizationAutoCanceled()
+				canceled++
+				return nil
+			}
+
+			expiring++
+			zap.L().Warn("Authorization is about to expire without capture",
+				zap.String("payment_intent_id", pi.ID),
+				zap.String("user_id", payment.UserID),
+				zap.Int64("amount_capturable", pi.AmountCapturable),
+				zap.Time("capture_before", deadline),
+				zap.Duration("remaining", remaining))
+			return nil
+		})
+
+	common.RecordAuthorizationsExpiring(expiring)
+	if canceled > 0 {
+		zap.L().Info("Expiring authorizations canceled", zap.Int("count", canceled))
+	}
+	return canceled, err
+}
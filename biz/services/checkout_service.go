@@ -0,0 +1,375 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"stripe-pay/biz"
+	"stripe-pay/biz/models"
+	"stripe-pay/db"
+	"stripe-pay/tenant"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v78"
+	"go.uber.org/zap"
+)
+
+// Checkout 相关常量
+const (
+	checkoutPaymentMethod = "checkout"
+	// metadataCheckoutPaymentID 写入 PaymentIntent metadata 的本地 payment_id，用于把 Checkout 占位记录关联到 PaymentIntent
+	metadataCheckoutPaymentID = "checkout_payment_id"
+	metadataCheckoutSessionID = "checkout_session_id"
+	checkoutSessionIDPrefix   = "cs_"
+	minCheckoutExpiresIn      = 30 * time.Minute
+	maxCheckoutExpiresIn      = 24 * time.Hour
+)
+
+// CreateCheckoutSession 按当前定价创建 Stripe Checkout Session（托管支付页面）
+// 创建时以 Session ID 作为 payment_intent_id 写入一条占位记录（状态 open），
+// 支付完成后由 Webhook 或对账关联到实际的 PaymentIntent
+func (s *PaymentService) CreateCheckoutSession(ctx context.Context, req *models.CreateCheckoutSessionRequest, idempotencyKey string) (*models.CheckoutSessionResponse, error) {
+	if err := biz.ValidateUserID(req.UserID); err != nil {
+		return nil, fmt.Errorf("invalid user_id: %w", err)
+	}
+	if err := biz.ValidateDescription(req.Description); err != nil {
+		return nil, fmt.Errorf("invalid description: %w", err)
+	}
+	if req.SuccessURL == "" || req.CancelURL == "" {
+		return nil, fmt.Errorf("success_url and cancel_url are required")
+	}
+	if err := biz.ValidateURL(req.SuccessURL); err != nil {
+		return nil, fmt.Errorf("invalid success_url: %w", err)
+	}
+	if err := biz.ValidateURL(req.CancelURL); err != nil {
+		return nil, fmt.Errorf("invalid cancel_url: %w", err)
+	}
+
+	if existing, err := s.existingCheckoutSession(ctx, idempotencyKey); err != nil || existing != nil {
+		return existing, err
+	}
+
+	validity, err := s.CheckUserPaymentValidity(ctx, req.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check user payment validity: %w", err)
+	}
+	if validity.Valid {
+		return nil, &AlreadyPaidError{
+			UserInfo:      validity.UserInfo,
+			DaysRemaining: validity.DaysRemaining,
+		}
+	}
+
+	pricing, err := s.GetCurrentPricing(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pricing: %w", err)
+	}
+
+	if err := s.checkFraud(ctx, &FraudCheck{
+		UserID:        req.UserID,
+		IP:            req.ClientIP,
+		PaymentMethod: checkoutPaymentMethod,
+		Amount:        pricing.Amount,
+		Currency:      pricing.Currency,
+	}); err != nil {
+		return nil, err
+	}
+
+	sc, err := stripeFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	customerID, _ := s.resolvePaymentCustomer(ctx, req.UserID, "")
+
+	paymentID := uuid.New().String()
+	productName := s.cfg.Checkout.ProductName
+	if productName == "" {
+		productName = req.Description
+	}
+	if productName == "" {
+		productName = "Payment"
+	}
+
+	params := &stripe.CheckoutSessionParams{
+		Mode:              stripe.String(string(stripe.CheckoutSessionModePayment)),
+		SuccessURL:        stripe.String(req.SuccessURL),
+		CancelURL:         stripe.String(req.CancelURL),
+		ClientReferenceID: stripe.String(req.UserID),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+					Currency:   stripe.String(pricing.Currency),
+					UnitAmount: stripe.Int64(pricing.Amount),
+					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+						Name: stripe.String(productName),
+					},
+				},
+				Quantity: stripe.Int64(1),
+			},
+		},
+		Metadata: map[string]string{
+			"user_id":    req.UserID,
+			"payment_id": paymentID,
+		},
+		PaymentIntentData: &stripe.CheckoutSessionPaymentIntentDataParams{
+			Metadata: map[string]string{
+				"user_id":                 req.UserID,
+				"description":             req.Description,
+				metadataCheckoutPaymentID: paymentID,
+			},
+		},
+	}
+	if req.Description != "" {
+		params.PaymentIntentData.Description = stripe.String(req.Description)
+	}
+	if len(s.cfg.Checkout.PaymentMethodTypes) > 0 {
+		params.PaymentMethodTypes = stripe.StringSlice(s.cfg.Checkout.PaymentMethodTypes)
+	}
+	if expiresIn := time.Duration(s.cfg.Checkout.ExpiresIn) * time.Second; expiresIn > 0 {
+		if expiresIn < minCheckoutExpiresIn {
+			expiresIn = minCheckoutExpiresIn
+		}
+		if expiresIn > maxCheckoutExpiresIn {
+			expiresIn = maxCheckoutExpiresIn
+		}
+		params.ExpiresAt = stripe.Int64(time.Now().Add(expiresIn).Unix())
+	}
+	if customerID != "" {
+		params.Customer = stripe.String(customerID)
+	}
+	if idempotencyKey != "" {
+		params.IdempotencyKey = stripe.String("checkout:" + s.stripeIdempotencyKey(ctx, idempotencyKey))
+	}
+	params.Context = ctx
+
+	session, err := sc.API.CheckoutSessions.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkout session: %w", err)
+	}
+
+	if db.DB != nil {
+		metadata := map[string]string{
+			"user_id":                 req.UserID,
+			"description":             req.Description,
+			metadataCheckoutSessionID: session.ID,
+		}
+		err = db.SavePaymentWithMetadata(ctx,
+			sc.newPaymentAccount(ctx),
+			session.ID,
+			paymentID,
+			idempotencyKey,
+			req.UserID,
+			pricing.Amount,
+			pricing.Currency,
+			string(session.Status),
+			checkoutPaymentMethod,
+			req.Description,
+			metadata,
+		)
+		if err != nil {
+			if dupErr, ok := err.(*db.DuplicateIdempotencyKeyError); ok {
+				// 并发请求使用同一幂等键：Stripe 返回同一个 Session，直接返回已保存的记录
+				if existing, existingErr := s.existingCheckoutSession(ctx, dupErr.Key); existingErr == nil && existing != nil {
+					return existing, nil
+				}
+			}
+			zap.L().Warn("Failed to save checkout session to database", zap.Error(err))
+		}
+	}
+
+	zap.L().Info("Checkout session created",
+		zap.String("checkout_session_id", session.ID),
+		zap.String("payment_id", paymentID),
+		zap.String("user_id", req.UserID))
+	return checkoutSessionResponse(session, paymentID), nil
+}
+
+// existingCheckoutSession 幂等键已对应 Checkout Session 时返回该 Session；已过期的 Session 释放幂等键后返回 nil
+func (s *PaymentService) existingCheckoutSession(ctx context.Context, idempotencyKey string) (*models.CheckoutSessionResponse, error) {
+	if idempotencyKey == "" || db.DB == nil {
+		return nil, nil
+	}
+
+	existing, err := db.GetPaymentByIdempotencyKey(ctx, idempotencyKey)
+	if err != nil || existing == nil {
+		return nil, nil
+	}
+	if existing.PaymentMethod != checkoutPaymentMethod {
+		return nil, fmt.Errorf("idempotency key already used for a %s payment", existing.PaymentMethod)
+	}
+
+	sessionID := existing.PaymentIntentID
+	if !strings.HasPrefix(sessionID, checkoutSessionIDPrefix) {
+		sessionID = checkoutSessionIDFromMetadata(existing.Metadata)
+	}
+	if sessionID == "" {
+		return nil, nil
+	}
+
+	sc, err := stripeForPayment(existing)
+	if err != nil {
+		return nil, err
+	}
+	params := &stripe.CheckoutSessionParams{}
+	params.Context = ctx
+	session, err := sc.API.CheckoutSessions.Get(sessionID, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checkout session: %w", err)
+	}
+
+	if session.Status == stripe.CheckoutSessionStatusExpired {
+		if err := s.expireCheckoutSession(ctx, session); err != nil {
+			return nil, err
+		}
+		if err := db.ReleaseIdempotencyKey(ctx, existing.PaymentIntentID); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	return checkoutSessionResponse(session, existing.PaymentID), nil
+}
+
+// handleCheckoutSessionEvent 处理 checkout.session.completed / checkout.session.expired 事件
+func (s *PaymentService) handleCheckoutSessionEvent(ctx context.Context, session *stripe.CheckoutSession) error {
+	if db.DB == nil {
+		return nil
+	}
+
+	switch session.Status {
+	case stripe.CheckoutSessionStatusComplete:
+		if session.PaymentIntent == nil || session.PaymentIntent.ID == "" {
+			zap.L().Info("Completed checkout session has no payment intent", zap.String("checkout_session_id", session.ID))
+			return nil
+		}
+		// 事件中的 PaymentIntent 只有 ID，从事件所属账户取最新状态后按 PaymentIntent 事件相同的路径同步
+		sc, err := stripeFor(ctx)
+		if err != nil {
+			return err
+		}
+		params := &stripe.PaymentIntentParams{}
+		params.Context = ctx
+		pi, err := sc.API.PaymentIntents.Get(session.PaymentIntent.ID, params)
+		if err != nil {
+			return fmt.Errorf("failed to get payment intent %s: %w", session.PaymentIntent.ID, err)
+		}
+		if pi.Metadata[metadataCheckoutPaymentID] == "" && session.Metadata["payment_id"] != "" {
+			if _, err := db.AttachCheckoutPaymentIntent(ctx, session.Metadata["payment_id"], pi.ID); err != nil {
+				return err
+			}
+		}
+		return s.syncPaymentIntentStatus(ctx, pi)
+
+	case stripe.CheckoutSessionStatusExpired:
+		return s.expireCheckoutSession(ctx, session)
+	}
+	return nil
+}
+
+// expireCheckoutSession Session 过期且未关联 PaymentIntent 时将占位记录标记为 canceled
+// 已关联 PaymentIntent 的记录由 PaymentIntent 事件更新
+func (s *PaymentService) expireCheckoutSession(ctx context.Context, session *stripe.CheckoutSession) error {
+	existing, err := db.GetPaymentByIntentIDAnyTenant(ctx, session.ID)
+	if err != nil || existing == nil {
+		return err
+	}
+	ctx = tenant.WithID(ctx, existing.TenantID)
+	if existing.Status == string(stripe.PaymentIntentStatusCanceled) {
+		return nil
+	}
+
+	zap.L().Info("Checkout session expired", zap.String("checkout_session_id", session.ID))
+	return db.UpdatePaymentStatus(ctx, session.ID, string(stripe.PaymentIntentStatusCanceled))
+}
+
+// attachCheckoutPaymentIntent PaymentIntent 来自 Checkout Session 时，把占位记录关联到该 PaymentIntent
+// PaymentIntent 事件可能先于 checkout.session.completed 到达，两条路径都会调用
+func (s *PaymentService) attachCheckoutPaymentIntent(ctx context.Context, pi *stripe.PaymentIntent) error {
+	paymentID := pi.Metadata[metadataCheckoutPaymentID]
+	if paymentID == "" {
+		return nil
+	}
+	_, err := db.AttachCheckoutPaymentIntent(ctx, paymentID, pi.ID)
+	return err
+}
+
+// reconcileCheckoutSession 对账：核对仍为占位状态的 Checkout 记录
+func (s *PaymentService) reconcileCheckoutSession(ctx context.Context, local *db.PaymentHistory, opts ReconcileOptions, report *ReconcileReport) error {
+	sc, err := stripeForPayment(local)
+	if err != nil {
+		return err
+	}
+	params := &stripe.CheckoutSessionParams{}
+	params.Context = ctx
+	session, err := sc.API.CheckoutSessions.Get(local.PaymentIntentID, params)
+	if err != nil {
+		return err
+	}
+
+	switch session.Status {
+	case stripe.CheckoutSessionStatusComplete:
+		if session.PaymentIntent == nil {
+			return nil
+		}
+		piParams := &stripe.PaymentIntentParams{}
+		piParams.Context = ctx
+		pi, err := sc.API.PaymentIntents.Get(session.PaymentIntent.ID, piParams)
+		if err != nil {
+			return err
+		}
+		s.reconcileIntent(ctx, "stale_scan", local, pi, opts, report)
+
+	case stripe.CheckoutSessionStatusExpired:
+		report.Scanned++
+		report.Mismatches++
+		item := ReconcileItem{
+			PaymentIntentID: local.PaymentIntentID,
+			PaymentID:       local.PaymentID,
+			Source:          "stale_scan",
+			LocalStatus:     local.Status,
+			StripeStatus:    string(session.Status),
+		}
+		if !opts.DryRun {
+			if err := s.expireCheckoutSession(ctx, session); err != nil {
+				item.Error = err.Error()
+				report.Errors++
+			} else {
+				item.Corrected = true
+				report.Corrected++
+			}
+		}
+		s.appendReconcileItem(report, item)
+
+	default:
+		report.Scanned++
+		if !opts.DryRun {
+			db.TouchPaymentHistory(ctx, local.PaymentIntentID)
+		}
+	}
+	return nil
+}
+
+// checkoutSessionIDFromMetadata 从 payment_history.metadata 读取 Checkout Session ID
+func checkoutSessionIDFromMetadata(metadata string) string {
+	if metadata == "" {
+		return ""
+	}
+	var m map[string]string
+	if err := json.Unmarshal([]byte(metadata), &m); err != nil {
+		return ""
+	}
+	return m[metadataCheckoutSessionID]
+}
+
+// checkoutSessionResponse 构建 Checkout Session 响应
+func checkoutSessionResponse(session *stripe.CheckoutSession, paymentID string) *models.CheckoutSessionResponse {
+	return &models.CheckoutSessionResponse{
+		CheckoutSessionID: session.ID,
+		URL:               session.URL,
+		PaymentID:         paymentID,
+		Status:            string(session.Status),
+		ExpiresAt:         session.ExpiresAt,
+	}
+}
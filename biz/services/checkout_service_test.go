@@ -0,0 +1,25 @@
+package services
+
+import "testing"
+
+// TestCheckoutSessionIDFromMetadata 测试从支付记录 metadata 读取 Checkout Session ID
+func TestCheckoutSessionIDFromMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+		want     string
+	}{
+		{"包含 Session ID", `{"user_id":"user_1","checkout_session_id":"cs_test_123"}`, "cs_test_123"},
+		{"不包含 Session ID", `{"user_id":"user_1"}`, ""},
+		{"空 metadata", "", ""},
+		{"非法 JSON", "not-json", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkoutSessionIDFromMetadata(tt.metadata); got != tt.want {
+				t.Errorf("checkoutSessionIDFromMetadata() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
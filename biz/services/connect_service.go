@@ -0,0 +1,318 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"stripe-pay/biz"
+	"stripe-pay/conf"
+	"stripe-pay/db"
+	"stripe-pay/tenant"
+
+	"github.com/stripe/stripe-go/v78"
+	"go.uber.org/zap"
+)
+
+// ErrConnectDisabled 未启用 Stripe Connect
+var ErrConnectDisabled = errors.New("stripe connect is not enabled")
+
+// 写入 PaymentIntent 和 payment_history.metadata 的分账字段
+const (
+	metadataCreatorID            = "creator_id"
+	metadataConnectedAccount     = "connected_account"
+	metadataApplicationFeeAmount = "application_fee_amount"
+)
+
+// ConnectAccountUnavailableError 创作者没有可以收款的连接账户
+type ConnectAccountUnavailableError struct {
+	CreatorID string
+	Reason    string
+}
+
+func (e *ConnectAccountUnavailableError) Error() string {
+	return fmt.Sprintf("creator %s cannot receive payments: %s", e.CreatorID, e.Reason)
+}
+
+// ConnectOnboardingResult 连接账户引导链接
+type ConnectOnboardingResult struct {
+	AccountID string `json:"account_id"`
+	Type      string `json:"type"` // account_onboarding 或 account_update
+	URL       string `json:"url"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// connectTransfer 分账参数：支付成功后扣除平台抽成，余额转给创作者的连接账户
+type connectTransfer struct {
+	CreatorID   string
+	Destination string // 连接账户ID（acct_...）
+	Fee         int64  // 平台抽成（最小货币单位）
+}
+
+// connectFeeRule 币种对应的抽成规则（未单独配置时使用 connect.fee）
+func connectFeeRule(cfg *conf.Config, currency string) conf.ConnectFeeRule {
+	if rule, ok := cfg.Connect.CurrencyFee[strings.ToLower(currency)]; ok {
+		return rule
+	}
+	return cfg.Connect.Fee
+}
+
+// applicationFee 按抽成规则计算平台抽成：百分比部分四舍五入到最小货币单位，结果不超过支付金额
+func applicationFee(rule conf.ConnectFeeRule, amount int64) int64 {
+	fee := int64(math.Round(float64(amount)*rule.Percent/100)) + rule.Fixed
+	if fee < rule.Min {
+		fee = rule.Min
+	}
+	if rule.Max > 0 && fee > rule.Max {
+		fee = rule.Max
+	}
+	if fee > amount {
+		fee = amount
+	}
+	return fee
+}
+
+// resolveConnectTransfer 查找创作者在当前 Stripe 账户和模式下的连接账户并计算平台抽成，
+// 未指定创作者时返回 nil（不分账）
+func (s *PaymentService) resolveConnectTransfer(ctx context.Context, sc *stripeClient, creatorID string, amount int64, currency string) (*connectTransfer, error) {
+	if creatorID == "" {
+		return nil, nil
+	}
+	cfg := conf.GetConf()
+	if !cfg.Connect.Enabled {
+		return nil, ErrConnectDisabled
+	}
+	if err := biz.ValidateUserID(creatorID); err != nil {
+		return nil, &biz.ValidationError{Field: "creator_id", Message: "invalid creator_id"}
+	}
+	if db.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	account, err := db.GetConnectedAccount(ctx, creatorID, sc.paymentAccount())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connected account: %w", err)
+	}
+	if account == nil {
+		return nil, &ConnectAccountUnavailableError{CreatorID: creatorID, Reason: "no connected account"}
+	}
+	if !account.ChargesEnabled {
+		reason := "onboarding not complete"
+		if account.DisabledReason != "" {
+			reason = "account restricted (" + account.DisabledReason + ")"
+		}
+		return nil, &ConnectAccountUnavailableError{CreatorID: creatorID, Reason: reason}
+	}
+
+	return &connectTransfer{
+		CreatorID:   creatorID,
+		Destination: account.AccountID,
+		Fee:         applicationFee(connectFeeRule(cfg, currency), amount),
+	}, nil
+}
+
+// applyConnectTransfer 设置 PaymentIntent 的转账目标和平台抽成
+func applyConnectTransfer(transfer *connectTransfer, params *stripe.PaymentIntentParams) {
+	if transfer == nil {
+		return
+	}
+	params.TransferData = &stripe.PaymentIntentTransferDataParams{
+		Destination: stripe.String(transfer.Destination),
+	}
+	params.ApplicationFeeAmount = stripe.Int64(transfer.Fee)
+	addConnectMetadata(transfer, params.Metadata)
+}
+
+// addConnectMetadata 写入分账信息（创作者、连接账户、平台抽成）
+func addConnectMetadata(transfer *connectTransfer, metadata map[string]string) {
+	if transfer == nil {
+		return
+	}
+	metadata[metadataCreatorID] = transfer.CreatorID
+	metadata[metadataConnectedAccount] = transfer.Destination
+	metadata[metadataApplicationFeeAmount] = strconv.FormatInt(transfer.Fee, 10)
+}
+
+// isConnectPayment 支付记录是否为分账支付（退款时需要同时撤回转账和平台抽成）
+func isConnectPayment(ph *db.PaymentHistory) bool {
+	if ph == nil || ph.Metadata == "" {
+		return false
+	}
+	var metadata map[string]string
+	return json.Unmarshal([]byte(ph.Metadata), &metadata) == nil && metadata[metadataConnectedAccount] != ""
+}
+
+// GetConnectedAccount 查询创作者在当前 Stripe 账户和模式下的连接账户（不存在时返回 nil）
+func (s *PaymentService) GetConnectedAccount(ctx context.Context, userID string) (*db.ConnectedAccount, error) {
+	if !conf.GetConf().Connect.Enabled {
+		return nil, ErrConnectDisabled
+	}
+	if db.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	sc, err := stripeFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetConnectedAccount(ctx, userID, sc.paymentAccount())
+}
+
+// CreateConnectOnboardingLink 为创作者创建连接账户链接（首次调用时创建连接账户）。
+// 资料未提交前为 account_onboarding，提交后为 account_update（修改已提交的资料）。
+// 跳转地址只使用 connect.return_url 和 connect.refresh_url，不接受请求传入，避免链接被引导到第三方页面。
+// 链接只能使用一次且很快过期，过期后跳转到 refresh_url，由前端重新调用本接口
+func (s *PaymentService) CreateConnectOnboardingLink(ctx context.Context, userID string) (*ConnectOnboardingResult, error) {
+	cfg := conf.GetConf()
+	if !cfg.Connect.Enabled {
+		return nil, ErrConnectDisabled
+	}
+	if err := biz.ValidateUserID(userID); err != nil {
+		return nil, err
+	}
+	if cfg.Connect.ReturnURL == "" || cfg.Connect.RefreshURL == "" {
+		return nil, fmt.Errorf("connect.return_url and connect.refresh_url are not configured")
+	}
+
+	account, sc, err := s.getOrCreateConnectedAccount(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	linkType := connectLinkType(account)
+	link, err := sc.API.AccountLinks.New(&stripe.AccountLinkParams{
+		Account:    stripe.String(account.AccountID),
+		RefreshURL: stripe.String(cfg.Connect.RefreshURL),
+		ReturnURL:  stripe.String(cfg.Connect.ReturnURL),
+		Type:       stripe.String(linkType),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create account link: %w", err)
+	}
+
+	return &ConnectOnboardingResult{
+		AccountID: account.AccountID,
+		Type:      linkType,
+		URL:       link.URL,
+		ExpiresAt: link.ExpiresAt,
+	}, nil
+}
+
+// connectLinkType 资料提交前使用引导链接，提交后只允许修改资料
+func connectLinkType(account *db.ConnectedAccount) string {
+	if account.DetailsSubmitted {
+		return string(stripe.AccountLinkTypeAccountUpdate)
+	}
+	return string(stripe.AccountLinkTypeAccountOnboarding)
+}
+
+// getOrCreateConnectedAccount 获取创作者的连接账户，不存在时在当前 Stripe 账户下创建（懒创建，幂等）
+// Stripe 请求使用按租户和 user_id 生成的幂等键，并发或保存失败后重试都会得到同一个连接账户
+func (s *PaymentService) getOrCreateConnectedAccount(ctx context.Context, userID string) (*db.ConnectedAccount, *stripeClient, error) {
+	if db.DB == nil {
+		return nil, nil, fmt.Errorf("database not available")
+	}
+	sc, err := stripeFor(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	existing, err := db.GetConnectedAccount(ctx, userID, sc.paymentAccount())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get connected account: %w", err)
+	}
+	if existing != nil {
+		return existing, sc, nil
+	}
+
+	cfg := conf.GetConf()
+	accountType := cfg.Connect.AccountType
+	if accountType == "" {
+		accountType = string(stripe.AccountTypeExpress)
+	}
+	params := &stripe.AccountParams{
+		Type: stripe.String(accountType),
+		Metadata: map[string]string{
+			"user_id":   userID,
+			"tenant_id": tenant.ID(ctx),
+		},
+	}
+	if cfg.Connect.Country != "" {
+		params.Country = stripe.String(cfg.Connect.Country)
+	}
+	if accountType == string(stripe.AccountTypeExpress) {
+		// 分账收款需要 transfers 能力
+		params.Capabilities = &stripe.AccountCapabilitiesParams{
+			CardPayments: &stripe.AccountCapabilitiesCardPaymentsParams{Requested: stripe.Bool(true)},
+			Transfers:    &stripe.AccountCapabilitiesTransfersParams{Requested: stripe.Bool(true)},
+		}
+	}
+	params.IdempotencyKey = stripe.String(tenant.Key(ctx, "connect-account:"+userID))
+
+	acct, err := sc.API.Accounts.New(params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create connected account: %w", err)
+	}
+
+	status := connectedAccountStatus(acct)
+	saved, err := db.SaveConnectedAccount(ctx, &db.ConnectedAccount{
+		UserID:           userID,
+		AccountID:        acct.ID,
+		AccountType:      accountType,
+		ChargesEnabled:   status.ChargesEnabled,
+		PayoutsEnabled:   status.PayoutsEnabled,
+		DetailsSubmitted: status.DetailsSubmitted,
+		DisabledReason:   status.DisabledReason,
+	}, sc.paymentAccount())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to save connected account: %w", err)
+	}
+	if saved == nil {
+		return nil, nil, fmt.Errorf("connected account %s not found after saving", acct.ID)
+	}
+
+	zap.L().Info("Stripe connected account ready",
+		zap.String("user_id", userID),
+		zap.String("stripe_account", sc.Account),
+		zap.String("account_id", saved.AccountID))
+	return saved, sc, nil
+}
+
+// connectedAccountStatus 从 Stripe Account 对象提取能力状态
+func connectedAccountStatus(acct *stripe.Account) db.ConnectedAccountStatus {
+	status := db.ConnectedAccountStatus{
+		ChargesEnabled:   acct.ChargesEnabled,
+		PayoutsEnabled:   acct.PayoutsEnabled,
+		DetailsSubmitted: acct.DetailsSubmitted,
+	}
+	if acct.Requirements != nil {
+		status.DisabledReason = string(acct.Requirements.DisabledReason)
+	}
+	return status
+}
+
+// syncConnectedAccount 将 account.updated 事件中的能力状态写入数据库（本服务未创建的账户忽略）
+func (s *PaymentService) syncConnectedAccount(ctx context.Context, acct *stripe.Account) error {
+	if db.DB == nil {
+		return nil
+	}
+
+	status := connectedAccountStatus(acct)
+	found, err := db.UpdateConnectedAccountStatus(ctx, acct.ID, status)
+	if err != nil {
+		return err
+	}
+	if !found {
+		zap.L().Info("Ignoring account.updated for unknown connected account", zap.String("account_id", acct.ID))
+		return nil
+	}
+
+	zap.L().Info("Connected account updated",
+		zap.String("account_id", acct.ID),
+		zap.Bool("charges_enabled", status.ChargesEnabled),
+		zap.Bool("payouts_enabled", status.PayoutsEnabled),
+		zap.Bool("details_submitted", status.DetailsSubmitted),
+		zap.String("disabled_reason", status.DisabledReason))
+	return nil
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"stripe-pay/conf"
+	"stripe-pay/db"
+	"testing"
+)
+
+// TestApplicationFee 测试平台抽成计算
+func TestApplicationFee(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   conf.ConnectFeeRule
+		amount int64
+		want   int64
+	}{
+		{"未配置抽成", conf.ConnectFeeRule{}, 1000, 0},
+		{"按百分比", conf.ConnectFeeRule{Percent: 10}, 1000, 100},
+		{"百分比四舍五入", conf.ConnectFeeRule{Percent: 2.9}, 1050, 30},
+		{"百分比加固定金额", conf.ConnectFeeRule{Percent: 5, Fixed: 30}, 1000, 80},
+		{"不低于最低抽成", conf.ConnectFeeRule{Percent: 1, Min: 50}, 1000, 50},
+		{"不高于最高抽成", conf.ConnectFeeRule{Percent: 20, Max: 150}, 1000, 150},
+		{"不超过支付金额", conf.ConnectFeeRule{Fixed: 500}, 300, 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := applicationFee(tt.rule, tt.amount); got != tt.want {
+				t.Errorf("applicationFee() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestConnectFeeRule 测试按币种选择抽成规则
+func TestConnectFeeRule(t *testing.T) {
+	cfg := &conf.Config{}
+	cfg.Connect.Fee = conf.ConnectFeeRule{Percent: 10}
+	cfg.Connect.CurrencyFee = map[string]conf.ConnectFeeRule{"usd": {Percent: 8, Fixed: 30}}
+
+	tests := []struct {
+		name     string
+		currency string
+		want     conf.ConnectFeeRule
+	}{
+		{"币种单独配置", "usd", conf.ConnectFeeRule{Percent: 8, Fixed: 30}},
+		{"币种大小写不敏感", "USD", conf.ConnectFeeRule{Percent: 8, Fixed: 30}},
+		{"未单独配置的币种", "hkd", conf.ConnectFeeRule{Percent: 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := connectFeeRule(cfg, tt.currency); got != tt.want {
+				t.Errorf("connectFeeRule() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestIsConnectPayment 测试从支付记录 metadata 判断是否为分账支付
+func TestIsConnectPayment(t *testing.T) {
+	tests := []struct {
+		name    string
+		payment *db.PaymentHistory
+		want    bool
+	}{
+		{"分账支付", &db.PaymentHistory{Metadata: `{"user_id":"user_1","connected_account":"acct_123"}`}, true},
+		{"普通支付", &db.PaymentHistory{Metadata: `{"user_id":"user_1"}`}, false},
+		{"空 metadata", &db.PaymentHistory{}, false},
+		{"非法 JSON", &db.PaymentHistory{Metadata: "not-json"}, false},
+		{"没有支付记录", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConnectPayment(tt.payment); got != tt.want {
+				t.Errorf("isConnectPayment() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
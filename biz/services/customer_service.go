@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"stripe-pay/biz"
+	"stripe-pay/db"
+	"stripe-pay/tenant"
+
+	"github.com/stripe/stripe-go/v78"
+	"go.uber.org/zap"
+)
+
+// SavedPaymentMethod 已保存的支付方式（卡）
+type SavedPaymentMethod struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Brand    string `json:"brand,omitempty"`
+	Last4    string `json:"last4,omitempty"`
+	ExpMonth int64  `json:"exp_month,omitempty"`
+	ExpYear  int64  `json:"exp_year,omitempty"`
+	Created  int64  `json:"created"`
+}
+
+// SetupIntentResult SetupIntent 创建结果
+type SetupIntentResult struct {
+	ClientSecret  string `json:"client_secret"`
+	SetupIntentID string `json:"setup_intent_id"`
+	CustomerID    string `json:"customer_id"`
+}
+
+// PaymentMethodOwnershipError 支付方式不属于该用户
+type PaymentMethodOwnershipError struct {
+	PaymentMethodID string
+}
+
+func (e *PaymentMethodOwnershipError) Error() string {
+	return fmt.Sprintf("payment method %s does not belong to this user", e.PaymentMethodID)
+}
+
+// getOrCreateCustomer 获取用户的 Stripe Customer，不存在时创建（懒创建，幂等）
+// Stripe 请求使用按租户和 user_id 生成的幂等键，并发或保存失败后重试都会得到同一个 Customer；
+// 共用同一 Stripe 账户的租户即使 user_id 相同也不会拿到对方的 Customer
+func (s *PaymentService) getOrCreateCustomer(ctx context.Context, userID string) (string, error) {
+	if db.DB == nil {
+		return "", fmt.Errorf("database not available")
+	}
+
+	sc, existing, err := s.userCustomer(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		return existing.StripeCustomerID, nil
+	}
+
+	params := &stripe.CustomerParams{
+		Metadata: map[string]string{
+			"user_id":   userID,
+			"tenant_id": tenant.ID(ctx),
+		},
+	}
+	params.IdempotencyKey = stripe.String(tenant.Key(ctx, "customer:"+userID))
+
+	c, err := sc.API.Customers.New(params)
+	if err != nil {
+		return "", fmt.Errorf("failed to create stripe customer: %w", err)
+	}
+
+	saved, err := db.SaveStripeCustomer(ctx, userID, c.ID, sc.paymentAccount())
+	if err != nil {
+		return "", fmt.Errorf("failed to save stripe customer: %w", err)
+	}
+	if saved == nil {
+		return c.ID, nil
+	}
+
+	zap.L().Info("Stripe customer ready",
+		zap.String("user_id", userID),
+		zap.String("stripe_account", sc.Account),
+		zap.String("stripe_customer_id", saved.StripeCustomerID))
+	return saved.StripeCustomerID, nil
+}
+
+// userCustomer 当前请求选择的 Stripe 客户端，以及用户在该账户和模式下的 Customer（不存在时为 nil）
+func (s *PaymentService) userCustomer(ctx context.Context, userID string) (*stripeClient, *db.StripeCustomer, error) {
+	sc, err := stripeFor(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	existing, err := db.GetStripeCustomer(ctx, userID, sc.paymentAccount(), sc.legacy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get stripe customer: %w", err)
+	}
+	return sc, existing, nil
+}
+
+// resolvePaymentCustomer 确定卡支付关联的 Customer：使用已保存的支付方式时校验归属，
+// 否则懒创建 Customer（创建失败不阻止支付，按匿名支付继续）
+func (s *PaymentService) resolvePaymentCustomer(ctx context.Context, userID, paymentMethodID string) (string, error) {
+	if paymentMethodID != "" {
+		return s.verifyPaymentMethodOwner(ctx, userID, paymentMethodID)
+	}
+	if db.DB == nil {
+		return "", nil
+	}
+
+	customerID, err := s.getOrCreateCustomer(ctx, userID)
+	if err != nil {
+		zap.L().Warn("Failed to get or create stripe customer, continuing without customer",
+			zap.Error(err), zap.String("user_id", userID))
+		return "", nil
+	}
+	return customerID, nil
+}
+
+// CreateSetupIntent 为用户创建 SetupIntent，用于保存卡片以便后续支付
+func (s *PaymentService) CreateSetupIntent(ctx context.Context, userID string) (*SetupIntentResult, error) {
+	if err := biz.ValidateUserID(userID); err != nil {
+		return nil, fmt.Errorf("invalid user_id: %w", err)
+	}
+
+	customerID, err := s.getOrCreateCustomer(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sc, err := stripeFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	si, err := sc.API.SetupIntents.New(&stripe.SetupIntentParams{
+		Customer:           stripe.String(customerID),
+		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
+		Usage:              stripe.String(string(stripe.SetupIntentUsageOnSession)),
+		Metadata: map[string]string{
+			"user_id": userID,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create setup intent: %w", err)
+	}
+
+	return &SetupIntentResult{
+		ClientSecret:  si.ClientSecret,
+		SetupIntentID: si.ID,
+		CustomerID:    customerID,
+	}, nil
+}
+
+// ListSavedPaymentMethods 列出用户已保存的卡（没有 Customer 时返回空列表）
+func (s *PaymentService) ListSavedPaymentMethods(ctx context.Context, userID string) ([]SavedPaymentMethod, error) {
+	if db.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	sc, existing, err := s.userCustomer(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	methods := []SavedPaymentMethod{}
+	if existing == nil {
+		return methods, nil
+	}
+
+	iter := sc.API.PaymentMethods.List(&stripe.PaymentMethodListParams{
+		Customer: stripe.String(existing.StripeCustomerID),
+		Type:     stripe.String(string(stripe.PaymentMethodTypeCard)),
+	})
+	for iter.Next() {
+		methods = append(methods, toSavedPaymentMethod(iter.PaymentMethod()))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list payment methods: %w", err)
+	}
+	return methods, nil
+}
+
+// DeleteSavedPaymentMethod 删除（解绑）用户已保存的支付方式
+func (s *PaymentService) DeleteSavedPaymentMethod(ctx context.Context, userID, paymentMethodID string) error {
+	customerID, err := s.verifyPaymentMethodOwner(ctx, userID, paymentMethodID)
+	if err != nil {
+		return err
+	}
+
+	sc, err := stripeFor(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := sc.API.PaymentMethods.Detach(paymentMethodID, nil); err != nil {
+		return fmt.Errorf("failed to detach payment method: %w", err)
+	}
+
+	zap.L().Info("Saved payment method detached",
+		zap.String("user_id", userID),
+		zap.String("stripe_customer_id", customerID),
+		zap.String("payment_method_id", paymentMethodID))
+	return nil
+}
+
+// verifyPaymentMethodOwner 校验支付方式已绑定到该用户的 Customer，返回 Customer ID
+func (s *PaymentService) verifyPaymentMethodOwner(ctx context.Context, userID, paymentMethodID string) (string, error) {
+	if err := biz.ValidatePaymentMethodID(paymentMethodID); err != nil {
+		return "", fmt.Errorf("invalid payment_method: %w", err)
+	}
+	if db.DB == nil {
+		return "", fmt.Errorf("database not available")
+	}
+
+	sc, existing, err := s.userCustomer(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if existing == nil {
+		return "", &PaymentMethodOwnershipError{PaymentMethodID: paymentMethodID}
+	}
+
+	pm, err := sc.API.PaymentMethods.Get(paymentMethodID, nil)
+	if err != nil {
+		if stripeErr, ok := err.(*stripe.Error); ok && stripeErr.Code == stripe.ErrorCodeResourceMissing {
+			return "", &PaymentMethodOwnershipError{PaymentMethodID: paymentMethodID}
+		}
+		return "", fmt.Errorf("failed to get payment method: %w", err)
+	}
+	if pm.Customer == nil || pm.Customer.ID != existing.StripeCustomerID {
+		return "", &PaymentMethodOwnershipError{PaymentMethodID: paymentMethodID}
+	}
+	return existing.StripeCustomerID, nil
+}
+
+// toSavedPaymentMethod 转换为对外返回的支付方式（只包含展示所需字段）
+func toSavedPaymentMethod(pm *stripe.PaymentMethod) SavedPaymentMethod {
+	saved := SavedPaymentMethod{
+		ID:      pm.ID,
+		Type:    string(pm.Type),
+		Created: pm.Created,
+	}
+	if pm.Card != nil {
+		saved.Brand = string(pm.Card.Brand)
+		saved.Last4 = pm.Card.Last4
+		saved.ExpMonth = pm.Card.ExpMonth
+		saved.ExpYear = pm.Card.ExpYear
+	}
+	return saved
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"stripe-pay/common"
+	"stripe-pay/db"
+	"stripe-pay/tenant"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"go.uber.org/zap"
+)
+
+// 过期取消默认参数（配置未设置时使用）
+const (
+	DefaultPaymentExpiryInterval   = time.Minute
+	defaultPaymentExpiryBatchSize  = 100
+	defaultPaymentExpiryTTLSeconds = 30 * 60
+	defaultPromoReservationTTL     = 24 * time.Hour
+)
+
+// defaultPaymentExpiryMethods 未配置 ttl 时默认启用过期取消的支付方式（扫码类支付用户经常不扫码直接离开）
+var defaultPaymentExpiryMethods = []string{"wechat_pay", "alipay"}
+
+// cancelableStatuses 可以在 Stripe 取消的 PaymentIntent 状态
+var cancelableStatuses = []string{
+	"requires_payment_method",
+	"requires_confirmation",
+	"requires_action",
+}
+
+// getPaymentTTLs 读取各支付方式的未支付过期时间（未启用时返回 nil）
+func (s *PaymentService) getPaymentTTLs() map[string]time.Duration {
+	cfg := s.cfg.PaymentExpiry
+	if !cfg.Enabled {
+		return nil
+	}
+
+	ttls := make(map[string]time.Duration)
+	for method, seconds := range cfg.TTL {
+		if seconds > 0 {
+			ttls[method] = time.Duration(seconds) * time.Second
+		}
+	}
+	if len(cfg.TTL) == 0 {
+		for _, method := range defaultPaymentExpiryMethods {
+			ttls[method] = defaultPaymentExpiryTTLSeconds * time.Second
+		}
+	}
+	return ttls
+}
+
+// getPromoReservationTTL 读取优惠码预留的最长保留时间（未启用过期取消时返回 0）
+func (s *PaymentService) getPromoReservationTTL() time.Duration {
+	cfg := s.cfg.PaymentExpiry
+	if !cfg.Enabled {
+		return 0
+	}
+	if cfg.PromoReservationTTL > 0 {
+		return time.Duration(cfg.PromoReservationTTL) * time.Second
+	}
+	return defaultPromoReservationTTL
+}
+
+// isPaymentExpired 判断支付记录是否已超过所属支付方式的过期时间且仍未支付
+func (s *PaymentService) isPaymentExpired(payment *db.PaymentHistory, now time.Time) bool {
+	ttl, ok := s.getPaymentTTLs()[payment.PaymentMethod]
+	if !ok {
+		return false
+	}
+	if !isCancelableStatus(payment.Status) {
+		return false
+	}
+	return now.Sub(payment.CreatedAt) > ttl
+}
+
+// isCancelableStatus 判断状态是否可以取消
+func isCancelableStatus(status string) bool {
+	for _, s := range cancelableStatuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpireAbandonedPayments 取消超过过期时间仍未支付的 PaymentIntent，返回取消的数量。
+// 每次运行分页处理所有到期记录，取消失败的记录退避后再重试
+func (s *PaymentService) ExpireAbandonedPayments(ctx context.Context) (int, error) {
+	if db.DB == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	batchSize := s.cfg.PaymentExpiry.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultPaymentExpiryBatchSize
+	}
+
+	expired := 0
+	var errs []error
+	for method, ttl := range s.getPaymentTTLs() {
+		createdBefore := time.Now().Add(-ttl)
+		err := sweepPayments(ctx, db.SweepJobPaymentExpiry, batchSize,
+			func(after *db.PaymentCursor, limit int) ([]db.PaymentHistory, error) {
+				return db.ListExpirablePayments(ctx, method, cancelableStatuses, createdBefore, after, limit)
+			},
+			func(payment *db.PaymentHistory) error {
+				ok, err := s.expirePayment(ctx, payment)
+				if ok {
+					expired++
+				}
+				return err
+			})
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if ctx.Err() != nil {
+			return expired, errors.Join(errs...)
+		}
+	}
+
+	released, err := s.expirePromoReservations(ctx, batchSize)
+	expired += released
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if expired > 0 {
+		zap.L().Info("Abandoned payments expired", zap.Int("count", expired))
+	}
+	return expired, errors.Join(errs...)
+}
+
+// expirePromoReservations 释放超过保留时间的优惠码预留：仍可取消的支付（任意支付方式，如放弃的银行卡支付）在 Stripe 取消，
+// 同步取消状态时释放预留；预留后没有创建 PaymentIntent 的记录直接释放。返回取消的支付数
+func (s *PaymentService) expirePromoReservations(ctx context.Context, batchSize int) (int, error) {
+	ttl := s.getPromoReservationTTL()
+	if ttl <= 0 {
+		return 0, nil
+	}
+	reservedBefore := time.Now().Add(-ttl)
+
+	expired := 0
+	err := sweepPayments(ctx, db.SweepJobPromoReservation, batchSize,
+		func(after *db.PaymentCursor, limit int) ([]db.PaymentHistory, error) {
+			return db.ListPromoReservedPayments(ctx, cancelableStatuses, reservedBefore, after, limit)
+		},
+		func(payment *db.PaymentHistory) error {
+			ok, err := s.expirePayment(ctx, payment)
+			if ok {
+				expired++
+			}
+			return err
+		})
+	if ctx.Err() != nil {
+		return expired, err
+	}
+
+	for {
+		released, releaseErr := db.ReleaseOrphanPromoRedemptions(ctx, reservedBefore, batchSize)
+		if releaseErr != nil {
+			return expired, errors.Join(err, releaseErr)
+		}
+		if released > 0 {
+			zap.L().Info("Orphan promo reservations released", zap.Int("count", released))
+		}
+		if released < batchSize || ctx.Err() != nil {
+			break
+		}
+	}
+	return expired, err
+}
+
+// expirePayment 在 Stripe 取消 PaymentIntent，将记录标记为 canceled 并释放幂等键
+// PaymentIntent 已不可取消（例如用户刚好完成支付）时按 Stripe 最新状态同步，返回 false
+func (s *PaymentService) expirePayment(ctx context.Context, payment *db.PaymentHistory) (bool, error) {
+	// 幂等键和缓存按记录所属租户隔离
+	ctx = tenant.WithID(ctx, payment.TenantID)
+	sc, err := stripeForPayment(payment)
+	if err != nil {
+		return false, err
+	}
+
+	params := &stripe.PaymentIntentCancelParams{
+		CancellationReason: stripe.String(string(stripe.PaymentIntentCancellationReasonAbandoned)),
+	}
+	params.Context = ctx
+	pi, err := sc.API.PaymentIntents.Cancel(payment.PaymentIntentID, params)
+	if err != nil {
+		getParams := &stripe.PaymentIntentParams{}
+		getParams.Context = ctx
+		latest, getErr := sc.API.PaymentIntents.Get(payment.PaymentIntentID, getParams)
+		if getErr != nil {
+			zap.L().Warn("Failed to cancel expired payment intent",
+				zap.Error(err),
+				zap.String("payment_intent_id", payment.PaymentIntentID))
+			return false, fmt.Errorf("failed to cancel payment intent %s: %w", payment.PaymentIntentID, err)
+		}
+		// 仍可取消说明取消请求本身失败（网络错误、Stripe 5xx 等），返回错误由扫描记录失败并退避重试
+		if isCancelableStatus(string(latest.Status)) {
+			zap.L().Warn("Failed to cancel expired payment intent",
+				zap.Error(err),
+				zap.String("payment_intent_id", payment.PaymentIntentID),
+				zap.String("status", string(latest.Status)))
+			return false, fmt.Errorf("failed to cancel payment intent %s: %w", payment.PaymentIntentID, err)
+		}
+		pi = latest
+	}
+
+	if pi.Status != stripe.PaymentIntentStatusCanceled {
+		zap.L().Info("Expired payment intent is no longer cancelable, syncing status",
+			zap.String("payment_intent_id", pi.ID),
+			zap.String("status", string(pi.Status)))
+		return false, s.syncPaymentIntentStatus(ctx, pi)
+	}
+
+	if err := s.syncPaymentIntentStatus(ctx, pi); err != nil {
+		return false, err
+	}
+	// 幂等中间件保存的响应已在同步取消状态时删除
+	if err := db.ReleaseIdempotencyKey(ctx, pi.ID); err != nil {
+		return true, err
+	}
+
+	common.RecordPaymentExpired(payment.PaymentMethod)
+	zap.L().Info("Expired payment intent canceled",
+		zap.String("payment_intent_id", pi.ID),
+		zap.String("payment_method", payment.PaymentMethod),
+		zap.String("user_id", payment.UserID),
+		zap.Time("created_at", payment.CreatedAt))
+	return true, nil
+}
+
+// stripeIdempotencyKey 生成传给 Stripe 的幂等键
+// Stripe 会在 24 小时内对同一幂等键返回相同结果，幂等键被释放过时追加重试序号，保证重新发起支付能创建新的 PaymentIntent
+func (s *PaymentService) stripeIdempotencyKey(ctx context.Context, idempotencyKey string) string {
+	if idempotencyKey == "" || db.DB == nil {
+		return idempotencyKey
+	}
+
+	released, err := db.CountReleasedIdempotencyKey(ctx, idempotencyKey)
+	if err != nil || released == 0 {
+		return idempotencyKey
+	}
+	return fmt.Sprintf("%s#retry-%d", idempotencyKey, released)
+}
@@ -0,0 +1,401 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"stripe-pay/biz"
+	"stripe-pay/biz/models"
+	"stripe-pay/common"
+	"stripe-pay/db"
+	"stripe-pay/tenant"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"go.uber.org/zap"
+)
+
+// defaultFraudRulesCacheTTL 风控规则默认缓存时间
+const defaultFraudRulesCacheTTL = 30 * time.Second
+
+// velocityWindow 每日次数限制的统计窗口（滚动 24 小时）
+const velocityWindow = 24 * time.Hour
+
+// fraudPaymentMethods 风控规则可限定的支付方式
+var fraudPaymentMethods = map[string]bool{
+	"card":                true,
+	"wechat_pay":          true,
+	"alipay":              true,
+	checkoutPaymentMethod: true,
+}
+
+// FraudCheck 创建支付前的风控检查参数
+type FraudCheck struct {
+	UserID          string
+	IP              string
+	Email           string // 为空时从通知设置中读取
+	PaymentMethodID string // 已保存的支付方式（pm_xxx），用于获取卡指纹
+	CardFingerprint string
+	PaymentMethod   string // card, wechat_pay, alipay, checkout
+	Amount          int64
+	Currency        string
+}
+
+// FraudBlockedError 支付被风控规则拒绝（不向客户端暴露命中的规则）
+type FraudBlockedError struct {
+	UserID string
+	RuleID int64
+}
+
+func (e *FraudBlockedError) Error() string {
+	return "payment declined by risk checks"
+}
+
+// fraudResult 风控规则评估结果
+type fraudResult struct {
+	Decision string
+	Rule     *db.FraudRule // 未命中规则时为 nil
+	Reason   string
+}
+
+// fraudRulesEntry 一个租户启用规则的缓存
+type fraudRulesEntry struct {
+	rules    []db.FraudRule
+	loadedAt time.Time
+}
+
+// fraudRulesCache 启用规则的进程内缓存（按租户）
+var fraudRulesCache struct {
+	sync.Mutex
+	tenants map[string]fraudRulesEntry
+}
+
+// invalidateFraudRules 使租户的规则缓存失效（管理接口修改规则后调用）
+func invalidateFraudRules(tenantID string) {
+	fraudRulesCache.Lock()
+	delete(fraudRulesCache.tenants, tenantID)
+	fraudRulesCache.Unlock()
+}
+
+// activeFraudRules 读取当前租户启用的规则（带缓存）
+func (s *PaymentService) activeFraudRules(ctx context.Context) ([]db.FraudRule, error) {
+	ttl := defaultFraudRulesCacheTTL
+	if s.cfg.Fraud.CacheTTL > 0 {
+		ttl = time.Duration(s.cfg.Fraud.CacheTTL) * time.Second
+	}
+
+	tenantID := tenant.ID(ctx)
+	fraudRulesCache.Lock()
+	defer fraudRulesCache.Unlock()
+	if entry, ok := fraudRulesCache.tenants[tenantID]; ok && time.Since(entry.loadedAt) < ttl {
+		return entry.rules, nil
+	}
+
+	rules, err := db.ListFraudRules(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	if fraudRulesCache.tenants == nil {
+		fraudRulesCache.tenants = map[string]fraudRulesEntry{}
+	}
+	fraudRulesCache.tenants[tenantID] = fraudRulesEntry{rules: rules, loadedAt: time.Now()}
+	return rules, nil
+}
+
+// checkFraud 在创建 PaymentIntent 前执行风控规则并记录决策
+// 命中 block 规则时返回 *FraudBlockedError；review 规则只记录，不拦截
+func (s *PaymentService) checkFraud(ctx context.Context, check *FraudCheck) error {
+	if !s.cfg.Fraud.Enabled || db.DB == nil {
+		return nil
+	}
+	failClosed := s.cfg.Fraud.FailClosed
+
+	rules, err := s.activeFraudRules(ctx)
+	if err != nil {
+		zap.L().Error("Service: Failed to load fraud rules", zap.Error(err), zap.Bool("fail_closed", failClosed))
+		if failClosed {
+			return &FraudBlockedError{UserID: check.UserID}
+		}
+		return nil
+	}
+
+	s.enrichFraudCheck(ctx, rules, check)
+
+	countPayments := func(paymentMethod string) (int, error) {
+		return db.CountUserPaymentsSince(ctx, check.UserID, paymentMethod, time.Now().Add(-velocityWindow))
+	}
+	result, err := evaluateFraudRules(rules, check, countPayments)
+	if err != nil {
+		zap.L().Error("Service: Failed to evaluate fraud rules", zap.Error(err), zap.Bool("fail_closed", failClosed))
+		if failClosed {
+			result = &fraudResult{Decision: db.FraudDecisionBlock, Reason: "rule evaluation failed"}
+		}
+	}
+
+	decision := &db.FraudDecision{
+		UserID:        check.UserID,
+		IP:            check.IP,
+		PaymentMethod: check.PaymentMethod,
+		Amount:        check.Amount,
+		Currency:      check.Currency,
+		Decision:      result.Decision,
+		Reason:        result.Reason,
+	}
+	ruleType := ""
+	if result.Rule != nil {
+		decision.RuleID = result.Rule.ID
+		ruleType = result.Rule.RuleType
+	}
+	if err := db.SaveFraudDecision(ctx, decision); err != nil {
+		zap.L().Warn("Service: Failed to record fraud decision", zap.Error(err), zap.String("user_id", check.UserID))
+	}
+	common.RecordFraudDecision(check.PaymentMethod, result.Decision, ruleType)
+
+	switch result.Decision {
+	case db.FraudDecisionBlock:
+		zap.L().Warn("Service: Payment blocked by fraud rules",
+			zap.String("user_id", check.UserID),
+			zap.String("ip", check.IP),
+			zap.Int64("rule_id", decision.RuleID),
+			zap.String("reason", result.Reason))
+		return &FraudBlockedError{UserID: check.UserID, RuleID: decision.RuleID}
+	case db.FraudDecisionReview:
+		zap.L().Info("Service: Payment flagged for review",
+			zap.String("user_id", check.UserID),
+			zap.Int64("rule_id", decision.RuleID),
+			zap.String("reason", result.Reason))
+	}
+	return nil
+}
+
+// enrichFraudCheck 按需补充邮箱和卡指纹（只在存在对应规则时查询）
+func (s *PaymentService) enrichFraudCheck(ctx context.Context, rules []db.FraudRule, check *FraudCheck) {
+	if check.Email == "" && hasFraudRuleType(rules, db.FraudRuleBlockEmail) {
+		if settings, err := db.GetNotificationSettings(ctx, check.UserID); err == nil && settings != nil {
+			check.Email = settings.Email
+		}
+	}
+
+	if check.CardFingerprint == "" && check.PaymentMethodID != "" && hasFraudRuleType(rules, db.FraudRuleBlockCardFingerprint) {
+		sc, err := stripeFor(ctx)
+		var pm *stripe.PaymentMethod
+		if err == nil {
+			pm, err = sc.API.PaymentMethods.Get(check.PaymentMethodID, nil)
+		}
+		if err != nil {
+			zap.L().Warn("Service: Failed to get payment method for fraud check", zap.Error(err),
+				zap.String("payment_method", check.PaymentMethodID))
+			return
+		}
+		if pm.Card != nil {
+			check.CardFingerprint = pm.Card.Fingerprint
+		}
+	}
+}
+
+// hasFraudRuleType 是否存在指定类型的规则
+func hasFraudRuleType(rules []db.FraudRule, ruleType string) bool {
+	for _, rule := range rules {
+		if rule.RuleType == ruleType {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateFraudRules 评估风控规则：命中任一 block 规则即拒绝，否则命中 review 规则时标记待审核
+// countPayments 返回用户在统计窗口内的支付数（paymentMethod 为空时统计所有支付方式）
+func evaluateFraudRules(rules []db.FraudRule, check *FraudCheck, countPayments func(paymentMethod string) (int, error)) (*fraudResult, error) {
+	result := &fraudResult{Decision: db.FraudDecisionAllow}
+	var firstErr error
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.PaymentMethod != "" && rule.PaymentMethod != check.PaymentMethod {
+			continue
+		}
+
+		reason, matched, err := matchFraudRule(rule, check, countPayments)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		if rule.Action == db.FraudActionBlock {
+			return &fraudResult{Decision: db.FraudDecisionBlock, Rule: rule, Reason: reason}, nil
+		}
+		if result.Decision == db.FraudDecisionAllow {
+			result = &fraudResult{Decision: db.FraudDecisionReview, Rule: rule, Reason: reason}
+		}
+	}
+	return result, firstErr
+}
+
+// matchFraudRule 判断单条规则是否命中，返回命中原因
+func matchFraudRule(rule *db.FraudRule, check *FraudCheck, countPayments func(paymentMethod string) (int, error)) (string, bool, error) {
+	switch rule.RuleType {
+	case db.FraudRuleBlockUser:
+		return "blocked user", check.UserID != "" && check.UserID == rule.Value, nil
+	case db.FraudRuleBlockIP:
+		ip := net.ParseIP(check.IP)
+		return "blocked ip", ip != nil && ip.Equal(net.ParseIP(rule.Value)), nil
+	case db.FraudRuleBlockCIDR:
+		ip := net.ParseIP(check.IP)
+		_, network, err := net.ParseCIDR(rule.Value)
+		return "blocked ip range " + rule.Value, ip != nil && err == nil && network.Contains(ip), nil
+	case db.FraudRuleBlockEmail:
+		email := strings.ToLower(strings.TrimSpace(check.Email))
+		if email == "" {
+			return "", false, nil
+		}
+		if strings.HasPrefix(rule.Value, "@") {
+			return "blocked email domain", strings.HasSuffix(email, rule.Value), nil
+		}
+		return "blocked email", email == rule.Value, nil
+	case db.FraudRuleBlockCardFingerprint:
+		return "blocked card", check.CardFingerprint != "" && check.CardFingerprint == rule.Value, nil
+	case db.FraudRuleMaxAmount:
+		if rule.Currency != "" && !strings.EqualFold(rule.Currency, check.Currency) {
+			return "", false, nil
+		}
+		return fmt.Sprintf("amount %d exceeds %d", check.Amount, rule.Threshold), check.Amount > rule.Threshold, nil
+	case db.FraudRuleVelocityUserDaily:
+		count, err := countPayments(rule.PaymentMethod)
+		if err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf("%d payments in 24h (limit %d)", count, rule.Threshold), int64(count) >= rule.Threshold, nil
+	}
+	return "", false, nil
+}
+
+// CreateFraudRule 创建风控规则（管理接口）
+func (s *PaymentService) CreateFraudRule(ctx context.Context, req *models.CreateFraudRuleRequest) (*db.FraudRule, error) {
+	if db.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	rule, err := buildFraudRule(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.CreateFraudRule(ctx, rule); err != nil {
+		return nil, err
+	}
+	invalidateFraudRules(tenant.ID(ctx))
+	return db.GetFraudRule(ctx, rule.ID)
+}
+
+// DeactivateFraudRule 停用风控规则（管理接口），返回是否找到
+func (s *PaymentService) DeactivateFraudRule(ctx context.Context, id int64) (bool, error) {
+	if db.DB == nil {
+		return false, fmt.Errorf("database not available")
+	}
+	found, err := db.SetFraudRuleActive(ctx, id, false)
+	if err != nil {
+		return false, err
+	}
+	invalidateFraudRules(tenant.ID(ctx))
+	return found, nil
+}
+
+// buildFraudRule 校验创建请求并构建规则，参数非法时返回 *biz.ValidationError
+func buildFraudRule(req *models.CreateFraudRuleRequest) (*db.FraudRule, error) {
+	rule := &db.FraudRule{
+		RuleType:      strings.ToLower(strings.TrimSpace(req.RuleType)),
+		Value:         strings.TrimSpace(req.Value),
+		Threshold:     req.Threshold,
+		Currency:      strings.ToLower(strings.TrimSpace(req.Currency)),
+		PaymentMethod: strings.ToLower(strings.TrimSpace(req.PaymentMethod)),
+		Action:        strings.ToLower(strings.TrimSpace(req.Action)),
+		Active:        true,
+		Description:   req.Description,
+	}
+
+	if rule.Action == "" {
+		rule.Action = db.FraudActionBlock
+	}
+	if rule.Action != db.FraudActionBlock && rule.Action != db.FraudActionReview {
+		return nil, &biz.ValidationError{Field: "action", Message: "action must be block or review"}
+	}
+	if rule.PaymentMethod != "" && !fraudPaymentMethods[rule.PaymentMethod] {
+		return nil, &biz.ValidationError{Field: "payment_method", Message: "payment_method must be card, wechat_pay, alipay or checkout"}
+	}
+	if err := biz.ValidateCurrency(rule.Currency); err != nil {
+		return nil, err
+	}
+	if err := biz.ValidateDescription(req.Description); err != nil {
+		return nil, err
+	}
+	if len(rule.Value) > 255 {
+		return nil, &biz.ValidationError{Field: "value", Message: "value must not exceed 255 characters"}
+	}
+
+	switch rule.RuleType {
+	case db.FraudRuleBlockUser:
+		if rule.Value == "" {
+			return nil, &biz.ValidationError{Field: "value", Message: "value is required"}
+		}
+		if err := biz.ValidateUserID(rule.Value); err != nil {
+			return nil, err
+		}
+	case db.FraudRuleBlockIP:
+		ip := net.ParseIP(rule.Value)
+		if ip == nil {
+			return nil, &biz.ValidationError{Field: "value", Message: "value must be an IP address"}
+		}
+		rule.Value = ip.String()
+	case db.FraudRuleBlockCIDR:
+		_, network, err := net.ParseCIDR(rule.Value)
+		if err != nil {
+			return nil, &biz.ValidationError{Field: "value", Message: "value must be a CIDR range, e.g. 203.0.113.0/24"}
+		}
+		rule.Value = network.String()
+	case db.FraudRuleBlockEmail:
+		rule.Value = strings.ToLower(rule.Value)
+		if strings.HasPrefix(rule.Value, "@") {
+			if len(rule.Value) < 2 || strings.Count(rule.Value, "@") != 1 {
+				return nil, &biz.ValidationError{Field: "value", Message: "value must be an email address or @domain"}
+			}
+		} else if err := biz.ValidateEmail(rule.Value); err != nil || rule.Value == "" {
+			return nil, &biz.ValidationError{Field: "value", Message: "value must be an email address or @domain"}
+		}
+	case db.FraudRuleBlockCardFingerprint:
+		if rule.Value == "" {
+			return nil, &biz.ValidationError{Field: "value", Message: "value is required"}
+		}
+	case db.FraudRuleVelocityUserDaily, db.FraudRuleMaxAmount:
+		if rule.Value != "" {
+			return nil, &biz.ValidationError{Field: "value", Message: "value must be empty for " + rule.RuleType}
+		}
+		if rule.Threshold <= 0 {
+			return nil, &biz.ValidationError{Field: "threshold", Message: "threshold must be positive"}
+		}
+	default:
+		return nil, &biz.ValidationError{Field: "rule_type", Message: "unsupported rule_type: " + rule.RuleType}
+	}
+
+	if rule.RuleType != db.FraudRuleVelocityUserDaily && rule.RuleType != db.FraudRuleMaxAmount && rule.Threshold != 0 {
+		return nil, &biz.ValidationError{Field: "threshold", Message: "threshold is only used by velocity_user_daily and max_amount"}
+	}
+	if rule.Currency != "" && rule.RuleType != db.FraudRuleMaxAmount {
+		return nil, &biz.ValidationError{Field: "currency", Message: "currency is only used by max_amount"}
+	}
+
+	if req.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			return nil, &biz.ValidationError{Field: "expires_at", Message: "expires_at must be RFC3339"}
+		}
+		rule.ExpiresAt = &expiresAt
+	}
+
+	return rule, nil
+}
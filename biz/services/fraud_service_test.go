@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+	"stripe-pay/biz/models"
+	"stripe-pay/db"
+	"testing"
+)
+
+// TestEvaluateFraudRules 测试风控规则评估
+func TestEvaluateFraudRules(t *testing.T) {
+	check := &FraudCheck{
+		UserID:          "user_1",
+		IP:              "203.0.113.7",
+		Email:           "Buyer@Example.com",
+		CardFingerprint: "fp_123",
+		PaymentMethod:   "wechat_pay",
+		Amount:          5000,
+		Currency:        "hkd",
+	}
+	count := func(string) (int, error) { return 3, nil }
+
+	tests := []struct {
+		name         string
+		rules        []db.FraudRule
+		count        func(string) (int, error)
+		wantDecision string
+		wantRuleID   int64
+		wantErr      bool
+	}{
+		{"无规则放行", nil, count, db.FraudDecisionAllow, 0, false},
+		{"用户黑名单", []db.FraudRule{{ID: 1, RuleType: db.FraudRuleBlockUser, Value: "user_1", Action: db.FraudActionBlock}}, count, db.FraudDecisionBlock, 1, false},
+		{"IP 黑名单", []db.FraudRule{{ID: 2, RuleType: db.FraudRuleBlockIP, Value: "203.0.113.7", Action: db.FraudActionBlock}}, count, db.FraudDecisionBlock, 2, false},
+		{"网段黑名单", []db.FraudRule{{ID: 3, RuleType: db.FraudRuleBlockCIDR, Value: "203.0.113.0/24", Action: db.FraudActionBlock}}, count, db.FraudDecisionBlock, 3, false},
+		{"网段未命中", []db.FraudRule{{ID: 3, RuleType: db.FraudRuleBlockCIDR, Value: "198.51.100.0/24", Action: db.FraudActionBlock}}, count, db.FraudDecisionAllow, 0, false},
+		{"邮箱域名黑名单", []db.FraudRule{{ID: 4, RuleType: db.FraudRuleBlockEmail, Value: "@example.com", Action: db.FraudActionBlock}}, count, db.FraudDecisionBlock, 4, false},
+		{"卡指纹黑名单", []db.FraudRule{{ID: 5, RuleType: db.FraudRuleBlockCardFingerprint, Value: "fp_123", Action: db.FraudActionBlock}}, count, db.FraudDecisionBlock, 5, false},
+		{"超过金额上限", []db.FraudRule{{ID: 6, RuleType: db.FraudRuleMaxAmount, Threshold: 4999, Action: db.FraudActionBlock}}, count, db.FraudDecisionBlock, 6, false},
+		{"金额上限币种不匹配", []db.FraudRule{{ID: 6, RuleType: db.FraudRuleMaxAmount, Threshold: 4999, Currency: "usd", Action: db.FraudActionBlock}}, count, db.FraudDecisionAllow, 0, false},
+		{"达到每日次数上限", []db.FraudRule{{ID: 7, RuleType: db.FraudRuleVelocityUserDaily, Threshold: 3, Action: db.FraudActionBlock}}, count, db.FraudDecisionBlock, 7, false},
+		{"未达每日次数上限", []db.FraudRule{{ID: 7, RuleType: db.FraudRuleVelocityUserDaily, Threshold: 4, Action: db.FraudActionBlock}}, count, db.FraudDecisionAllow, 0, false},
+		{"限定其他支付方式", []db.FraudRule{{ID: 8, RuleType: db.FraudRuleBlockUser, Value: "user_1", PaymentMethod: "card", Action: db.FraudActionBlock}}, count, db.FraudDecisionAllow, 0, false},
+		{
+			name: "review 规则之后仍检查 block 规则",
+			rules: []db.FraudRule{
+				{ID: 9, RuleType: db.FraudRuleMaxAmount, Threshold: 100, Action: db.FraudActionReview},
+				{ID: 10, RuleType: db.FraudRuleBlockIP, Value: "203.0.113.7", Action: db.FraudActionBlock},
+			},
+			count: count, wantDecision: db.FraudDecisionBlock, wantRuleID: 10,
+		},
+		{"只命中 review 规则", []db.FraudRule{{ID: 9, RuleType: db.FraudRuleMaxAmount, Threshold: 100, Action: db.FraudActionReview}}, count, db.FraudDecisionReview, 9, false},
+		{
+			name:  "计数失败返回错误并放行",
+			rules: []db.FraudRule{{ID: 7, RuleType: db.FraudRuleVelocityUserDaily, Threshold: 1, Action: db.FraudActionBlock}},
+			count: func(string) (int, error) { return 0, errors.New("db down") }, wantDecision: db.FraudDecisionAllow, wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := evaluateFraudRules(tt.rules, check, tt.count)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("evaluateFraudRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result.Decision != tt.wantDecision {
+				t.Errorf("Decision = %s, want %s", result.Decision, tt.wantDecision)
+			}
+			var ruleID int64
+			if result.Rule != nil {
+				ruleID = result.Rule.ID
+			}
+			if ruleID != tt.wantRuleID {
+				t.Errorf("RuleID = %d, want %d", ruleID, tt.wantRuleID)
+			}
+		})
+	}
+}
+
+// TestBuildFraudRule 测试风控规则创建参数校验
+func TestBuildFraudRule(t *testing.T) {
+	tests := []struct {
+		name      string
+		req       models.CreateFraudRuleRequest
+		wantValue string
+		wantErr   bool
+	}{
+		{"IP 规范化", models.CreateFraudRuleRequest{RuleType: "block_ip", Value: " 203.0.113.7 "}, "203.0.113.7", false},
+		{"网段规范化", models.CreateFraudRuleRequest{RuleType: "block_cidr", Value: "203.0.113.9/24"}, "203.0.113.0/24", false},
+		{"邮箱转小写", models.CreateFraudRuleRequest{RuleType: "block_email", Value: "Bad@Example.com"}, "bad@example.com", false},
+		{"邮箱域名", models.CreateFraudRuleRequest{RuleType: "block_email", Value: "@example.com"}, "@example.com", false},
+		{"次数限制", models.CreateFraudRuleRequest{RuleType: "velocity_user_daily", Threshold: 5, PaymentMethod: "wechat_pay"}, "", false},
+		{"非法 IP", models.CreateFraudRuleRequest{RuleType: "block_ip", Value: "not-an-ip"}, "", true},
+		{"非法网段", models.CreateFraudRuleRequest{RuleType: "block_cidr", Value: "203.0.113.0"}, "", true},
+		{"缺少阈值", models.CreateFraudRuleRequest{RuleType: "max_amount"}, "", true},
+		{"阈值规则不接受 value", models.CreateFraudRuleRequest{RuleType: "max_amount", Value: "1", Threshold: 100}, "", true},
+		{"黑名单规则不接受阈值", models.CreateFraudRuleRequest{RuleType: "block_user", Value: "user_1", Threshold: 1}, "", true},
+		{"未知规则类型", models.CreateFraudRuleRequest{RuleType: "block_country", Value: "XX"}, "", true},
+		{"未知动作", models.CreateFraudRuleRequest{RuleType: "block_user", Value: "user_1", Action: "allow"}, "", true},
+		{"未知支付方式", models.CreateFraudRuleRequest{RuleType: "block_user", Value: "user_1", PaymentMethod: "paypal"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := buildFraudRule(&tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildFraudRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if rule.Value != tt.wantValue {
+				t.Errorf("Value = %q, want %q", rule.Value, tt.wantValue)
+			}
+			if rule.Action != db.FraudActionBlock || !rule.Active {
+				t.Errorf("Action = %s, Active = %v, want block and active", rule.Action, rule.Active)
+			}
+		})
+	}
+}
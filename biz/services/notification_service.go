@@ -0,0 +1,332 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"stripe-pay/biz"
+	"stripe-pay/biz/models"
+	"stripe-pay/biz/notify"
+	"stripe-pay/biz/receipt"
+	"stripe-pay/common"
+	"stripe-pay/db"
+	"stripe-pay/tenant"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"go.uber.org/zap"
+)
+
+// 通知默认参数（配置未设置时使用）
+const (
+	DefaultNotificationInterval    = 10 * time.Minute
+	defaultNotificationMaxAttempts = 5
+	notificationSendTimeout        = 30 * time.Second
+	notificationBatchSize          = 200
+	// notificationStaleAfter pending 超过该时间视为发送中断（进程退出），由重试任务补发
+	notificationStaleAfter   = 10 * time.Minute
+	notificationChannelEmail = "email"
+)
+
+// enqueueNotification 为用户写入一条待发送通知（按 dedupeKey 去重）
+// 收件人优先使用用户设置的邮箱，否则使用 fallbackEmail；用户退订或没有邮箱时返回 nil
+func (s *PaymentService) enqueueNotification(ctx context.Context, kind, userID, dedupeKey, fallbackEmail string, data *notify.Data) (*db.Notification, error) {
+	if s.notifier == nil || db.DB == nil || userID == "" {
+		return nil, nil
+	}
+
+	settings, err := db.GetNotificationSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	recipient, locale := fallbackEmail, ""
+	if settings != nil {
+		if settings.OptedOut {
+			return nil, nil
+		}
+		if settings.Email != "" {
+			recipient = settings.Email
+		}
+		locale = settings.Locale
+	}
+	if recipient == "" {
+		zap.L().Debug("No email for user, skipping notification", zap.String("user_id", userID), zap.String("kind", kind))
+		return nil, nil
+	}
+	if locale = notify.NormalizeLocale(locale); locale == "" {
+		if locale = notify.NormalizeLocale(s.cfg.Notifications.DefaultLocale); locale == "" {
+			locale = notify.DefaultLocale
+		}
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	n := &db.Notification{
+		DedupeKey: dedupeKey,
+		UserID:    userID,
+		Kind:      kind,
+		Channel:   notificationChannelEmail,
+		Recipient: recipient,
+		Locale:    locale,
+		Payload:   string(payload),
+	}
+	reserved, err := db.ReserveNotification(ctx, n)
+	if err != nil || !reserved {
+		return nil, err
+	}
+	return n, nil
+}
+
+// notifyAsync 写入通知并在后台发送（Webhook 路径使用，不阻塞事件处理；失败由重试任务补发）
+func (s *PaymentService) notifyAsync(ctx context.Context, kind, userID, dedupeKey, fallbackEmail string, data *notify.Data) {
+	n, err := s.enqueueNotification(ctx, kind, userID, dedupeKey, fallbackEmail, data)
+	if err != nil {
+		zap.L().Warn("Failed to enqueue notification", zap.Error(err), zap.String("dedupe_key", dedupeKey))
+		return
+	}
+	if n == nil {
+		return
+	}
+	go s.deliverNotification(context.WithoutCancel(ctx), n, data)
+}
+
+// deliverNotification 渲染并发送通知，记录发送结果
+func (s *PaymentService) deliverNotification(ctx context.Context, n *db.Notification, data *notify.Data) error {
+	ctx, cancel := context.WithTimeout(ctx, notificationSendTimeout)
+	defer cancel()
+
+	content, err := notify.Render(n.Kind, n.Locale, data)
+	if err == nil {
+		err = s.notifier.Send(ctx, &notify.Message{
+			From:    s.cfg.Notifications.From,
+			To:      n.Recipient,
+			Subject: content.Subject,
+			Text:    content.Text,
+			HTML:    content.HTML,
+		})
+	}
+	if err != nil {
+		common.RecordNotification(n.Kind, "failed")
+		zap.L().Warn("Failed to send notification", zap.Error(err),
+			zap.Int64("notification_id", n.ID),
+			zap.String("kind", n.Kind),
+			zap.String("user_id", n.UserID))
+		if markErr := db.MarkNotificationFailed(ctx, n.ID, err.Error()); markErr != nil {
+			zap.L().Error("Failed to record notification failure", zap.Error(markErr), zap.Int64("notification_id", n.ID))
+		}
+		return err
+	}
+
+	common.RecordNotification(n.Kind, "sent")
+	zap.L().Info("Notification sent",
+		zap.Int64("notification_id", n.ID),
+		zap.String("kind", n.Kind),
+		zap.String("user_id", n.UserID))
+	return db.MarkNotificationSent(ctx, n.ID)
+}
+
+// notifyPaymentSucceeded 支付成功通知（按 PaymentIntent 去重）
+func (s *PaymentService) notifyPaymentSucceeded(ctx context.Context, pi *stripe.PaymentIntent) {
+	if s.notifier == nil {
+		return
+	}
+	payment, err := db.GetPaymentByIntentIDAnyTenant(ctx, pi.ID)
+	if err != nil || payment == nil {
+		return
+	}
+	ctx = tenant.WithID(ctx, payment.TenantID)
+
+	data := &notify.Data{
+		UserID:      payment.UserID,
+		PaymentID:   payment.PaymentID,
+		Description: payment.Description,
+		Amount:      receipt.FormatAmount(payment.Amount, payment.Currency),
+		ReceiptURL:  s.receiptURL(payment),
+	}
+	s.notifyAsync(ctx, notify.KindPaymentSucceeded, payment.UserID, notify.KindPaymentSucceeded+":"+pi.ID, pi.ReceiptEmail, data)
+}
+
+// notifyRefundIssued 退款成功通知（按 Refund 去重，ctx 需为支付所属租户）
+func (s *PaymentService) notifyRefundIssued(ctx context.Context, payment *db.PaymentHistory, r *stripe.Refund) {
+	if s.notifier == nil || r.Status != stripe.RefundStatusSucceeded {
+		return
+	}
+
+	data := &notify.Data{
+		UserID:       payment.UserID,
+		PaymentID:    payment.PaymentID,
+		Description:  payment.Description,
+		Amount:       receipt.FormatAmount(payment.Amount, payment.Currency),
+		RefundAmount: receipt.FormatAmount(r.Amount, string(r.Currency)),
+	}
+	s.notifyAsync(ctx, notify.KindRefundIssued, payment.UserID, notify.KindRefundIssued+":"+r.ID, "", data)
+}
+
+// receiptURL 收据链接（未配置 public_base_url 时为空）
+func (s *PaymentService) receiptURL(payment *db.PaymentHistory) string {
+	base := strings.TrimRight(s.cfg.Notifications.PublicBaseURL, "/")
+	if base == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/api/v1/payment/%s/receipt?user_id=%s", base, url.PathEscape(payment.PaymentID), url.QueryEscape(payment.UserID))
+}
+
+// SendExpiryReminders 给权益在 expiry_reminder_days 天内到期的用户发送提醒（每个到期日只提醒一次），返回发送数量
+func (s *PaymentService) SendExpiryReminders(ctx context.Context) (int, error) {
+	days := s.cfg.Notifications.ExpiryReminderDays
+	if s.notifier == nil || db.DB == nil || days <= 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	period := EntitlementDays * 24 * time.Hour
+	from := now.Add(-period)
+	to := from.Add(time.Duration(days) * 24 * time.Hour)
+
+	sent := 0
+	var errs []error
+	for offset := 0; ; offset += notificationBatchSize {
+		users, err := db.ListUsersLastPaidBetween(ctx, from, to, notificationBatchSize, offset)
+		if err != nil {
+			return sent, err
+		}
+
+		for _, user := range users {
+			if err := ctx.Err(); err != nil {
+				return sent, err
+			}
+			// 按用户所属租户查询有效期和通知设置
+			userID := user.UserID
+			tctx := tenant.WithID(ctx, user.TenantID)
+			// 以实时计算的有效期为准（与支付校验一致）
+			validity, err := s.CheckUserPaymentValidity(tctx, userID)
+			if err != nil || !validity.Valid || validity.UserInfo.LastPaymentAt == nil {
+				continue
+			}
+			expiresAt := validity.UserInfo.LastPaymentAt.Add(period)
+			data := &notify.Data{
+				UserID:        userID,
+				DaysRemaining: remainingDays(expiresAt, now),
+				ExpiresAt:     expiresAt.Format("2006-01-02"),
+			}
+
+			dedupeKey := tenant.Key(tctx, fmt.Sprintf("%s:%s:%s", notify.KindEntitlementExpiring, userID, expiresAt.Format("20060102")))
+			n, err := s.enqueueNotification(tctx, notify.KindEntitlementExpiring, userID, dedupeKey, "", data)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if n == nil {
+				continue
+			}
+			if err := s.deliverNotification(tctx, n, data); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			sent++
+		}
+
+		if len(users) < notificationBatchSize {
+			break
+		}
+	}
+
+	if sent > 0 {
+		zap.L().Info("Expiry reminders sent", zap.Int("count", sent))
+	}
+	return sent, errors.Join(errs...)
+}
+
+// RetryFailedNotifications 重新发送失败或中断的通知（按保存的 payload 重新渲染），返回成功数量
+func (s *PaymentService) RetryFailedNotifications(ctx context.Context) (int, error) {
+	if s.notifier == nil || db.DB == nil {
+		return 0, nil
+	}
+
+	maxAttempts := s.cfg.Notifications.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultNotificationMaxAttempts
+	}
+	notifications, err := db.ListRetryableNotifications(ctx, maxAttempts, time.Now().Add(-notificationStaleAfter), notificationBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	var errs []error
+	for i := range notifications {
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+		n := &notifications[i]
+		var data notify.Data
+		if err := json.Unmarshal([]byte(n.Payload), &data); err != nil {
+			errs = append(errs, fmt.Errorf("invalid payload for notification %d: %w", n.ID, err))
+			continue
+		}
+		if err := s.deliverNotification(ctx, n, &data); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sent++
+	}
+	return sent, errors.Join(errs...)
+}
+
+// GetNotificationSettings 查询用户通知设置（未设置时返回默认值）
+func (s *PaymentService) GetNotificationSettings(ctx context.Context, userID string) (*db.NotificationSettings, error) {
+	if db.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	settings, err := db.GetNotificationSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		settings = &db.NotificationSettings{UserID: userID}
+	}
+	return settings, nil
+}
+
+// UpdateNotificationSettings 更新用户通知设置，参数非法时返回 *biz.ValidationError
+func (s *PaymentService) UpdateNotificationSettings(ctx context.Context, userID string, req *models.UpdateNotificationSettingsRequest) (*db.NotificationSettings, error) {
+	if db.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	email := strings.TrimSpace(req.Email)
+	if err := biz.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+	locale := ""
+	if req.Locale != "" {
+		if locale = notify.NormalizeLocale(req.Locale); locale == "" {
+			return nil, &biz.ValidationError{Field: "locale", Message: "unsupported locale"}
+		}
+	}
+
+	if err := db.SaveNotificationSettings(ctx, &db.NotificationSettings{
+		UserID:   userID,
+		Email:    email,
+		Locale:   locale,
+		OptedOut: req.OptedOut,
+	}); err != nil {
+		return nil, err
+	}
+	return db.GetNotificationSettings(ctx, userID)
+}
+
+// remainingDays 距离到期的天数（向上取整，至少 1 天）
+func remainingDays(expiresAt, now time.Time) int {
+	days := int(math.Ceil(expiresAt.Sub(now).Hours() / 24))
+	if days < 1 {
+		return 1
+	}
+	return days
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"stripe-pay/biz"
+	"stripe-pay/db"
+	"time"
+)
+
+// PaymentSearchParams 支付记录查询参数（原始字符串，来自 query string）
+// start/end 支持 YYYY-MM-DD（end 包含当天）或 RFC3339（end 不包含）
+type PaymentSearchParams struct {
+	TenantID      string // 为空时不按租户过滤（仅管理端）
+	UserID        string
+	Status        string // 逗号分隔
+	PaymentMethod string
+	Currency      string
+	Start         string
+	End           string
+	Cursor        string
+	Limit         string
+}
+
+// SearchPayments 分页查询支付记录（用户支付历史和管理端搜索共用）
+func (s *PaymentService) SearchPayments(ctx context.Context, params PaymentSearchParams) (*db.PaymentPage, error) {
+	if db.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	query, err := BuildPaymentQuery(params, time.Local)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.SearchPayments(ctx, query)
+}
+
+// BuildPaymentQuery 校验查询参数并构建 db.PaymentQuery，参数非法时返回 *biz.ValidationError
+func BuildPaymentQuery(params PaymentSearchParams, loc *time.Location) (db.PaymentQuery, error) {
+	query := db.PaymentQuery{
+		TenantID:      params.TenantID,
+		UserID:        strings.TrimSpace(params.UserID),
+		PaymentMethod: strings.TrimSpace(params.PaymentMethod),
+		Currency:      strings.ToLower(strings.TrimSpace(params.Currency)),
+	}
+
+	if query.UserID != "" {
+		if err := biz.ValidateUserID(query.UserID); err != nil {
+			return query, err
+		}
+	}
+
+	if params.Status != "" {
+		seen := make(map[string]bool)
+		for _, status := range strings.Split(params.Status, ",") {
+			status = strings.ToLower(strings.TrimSpace(status))
+			if status == "" || seen[status] {
+				continue
+			}
+			if err := biz.ValidatePaymentStatus(status); err != nil {
+				return query, err
+			}
+			seen[status] = true
+			query.Statuses = append(query.Statuses, status)
+		}
+	}
+
+	if err := biz.ValidateCurrency(query.Currency); err != nil {
+		return query, err
+	}
+
+	if params.Start != "" {
+		start, _, err := parseSearchTime(params.Start, loc)
+		if err != nil {
+			return query, &biz.ValidationError{Field: "start", Message: err.Error()}
+		}
+		query.CreatedFrom = &start
+	}
+	if params.End != "" {
+		end, dateOnly, err := parseSearchTime(params.End, loc)
+		if err != nil {
+			return query, &biz.ValidationError{Field: "end", Message: err.Error()}
+		}
+		if dateOnly {
+			end = end.AddDate(0, 0, 1)
+		}
+		query.CreatedTo = &end
+	}
+	if query.CreatedFrom != nil && query.CreatedTo != nil && !query.CreatedFrom.Before(*query.CreatedTo) {
+		return query, &biz.ValidationError{Field: "start", Message: "start must be before end"}
+	}
+
+	if params.Cursor != "" {
+		cursor, err := db.DecodePaymentCursor(params.Cursor)
+		if err != nil {
+			return query, &biz.ValidationError{Field: "cursor", Message: err.Error()}
+		}
+		query.Cursor = cursor
+	}
+
+	if params.Limit != "" {
+		limit, err := strconv.Atoi(params.Limit)
+		if err != nil || limit <= 0 {
+			return query, &biz.ValidationError{Field: "limit", Message: "limit must be a positive integer"}
+		}
+		query.Limit = limit
+	}
+	query.Limit = db.ClampPaymentPageSize(query.Limit)
+
+	return query, nil
+}
+
+// parseSearchTime 解析查询时间，返回是否为纯日期格式
+func parseSearchTime(value string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation(reportDateLayout, value, loc); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid time format, expected YYYY-MM-DD or RFC3339")
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"stripe-pay/db"
+	"testing"
+	"time"
+)
+
+// TestBuildPaymentQuery 测试支付记录查询参数解析
+func TestBuildPaymentQuery(t *testing.T) {
+	cursor := db.EncodePaymentCursor(db.PaymentCursor{CreatedAt: time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC), ID: 42})
+
+	tests := []struct {
+		name      string
+		params    PaymentSearchParams
+		wantLimit int
+		wantEnd   string
+		wantErr   bool
+	}{
+		{"默认分页大小", PaymentSearchParams{}, db.DefaultPaymentPageSize, "", false},
+		{"超过上限截断", PaymentSearchParams{Limit: "1000"}, db.MaxPaymentPageSize, "", false},
+		{"纯日期结束时间包含当天", PaymentSearchParams{Start: "2024-01-01", End: "2024-01-31"}, db.DefaultPaymentPageSize, "2024-02-01", false},
+		{"多个状态和游标", PaymentSearchParams{Status: "succeeded, canceled", Currency: "USD", Cursor: cursor}, db.DefaultPaymentPageSize, "", false},
+		{"非法状态", PaymentSearchParams{Status: "succeeded,bogus"}, 0, "", true},
+		{"非法币种", PaymentSearchParams{Currency: "xyz"}, 0, "", true},
+		{"非法分页大小", PaymentSearchParams{Limit: "-1"}, 0, "", true},
+		{"非法游标", PaymentSearchParams{Cursor: "not-a-cursor"}, 0, "", true},
+		{"开始时间晚于结束时间", PaymentSearchParams{Start: "2024-02-01", End: "2024-01-01"}, 0, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := BuildPaymentQuery(tt.params, time.UTC)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BuildPaymentQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if query.Limit != tt.wantLimit {
+				t.Errorf("limit = %d, want %d", query.Limit, tt.wantLimit)
+			}
+			if tt.wantEnd != "" && (query.CreatedTo == nil || query.CreatedTo.Format(reportDateLayout) != tt.wantEnd) {
+				t.Errorf("end = %v, want %s", query.CreatedTo, tt.wantEnd)
+			}
+		})
+	}
+}
+
+// TestPaymentCursorRoundTrip 测试游标编码和解析
+func TestPaymentCursorRoundTrip(t *testing.T) {
+	want := db.PaymentCursor{CreatedAt: time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC), ID: 42}
+	got, err := db.DecodePaymentCursor(db.EncodePaymentCursor(want))
+	if err != nil {
+		t.Fatalf("DecodePaymentCursor() error = %v", err)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID {
+		t.Errorf("cursor = %+v, want %+v", got, want)
+	}
+}
@@ -8,25 +8,36 @@ import (
 	"strings"
 	"stripe-pay/biz"
 	"stripe-pay/biz/models"
+	"stripe-pay/biz/notify"
 	"stripe-pay/conf"
 	"stripe-pay/db"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/stripe/stripe-go/v78"
-	"github.com/stripe/stripe-go/v78/paymentintent"
 	"go.uber.org/zap"
 )
 
+// EntitlementDays 一次支付成功后的权益有效天数
+const EntitlementDays = 30
+
 // PaymentService 支付服务
 type PaymentService struct {
-	cfg *conf.Config
+	cfg      *conf.Config
+	notifier notify.Notifier // 未启用通知时为 nil
 }
 
 // NewPaymentService 创建支付服务
 func NewPaymentService() *PaymentService {
+	cfg := conf.GetConf()
+	initUpstreams(cfg)
+	notifier, err := notify.New(cfg)
+	if err != nil {
+		zap.L().Error("Invalid notifications config, notifications disabled", zap.Error(err))
+	}
 	return &PaymentService{
-		cfg: conf.GetConf(),
+		cfg:      cfg,
+		notifier: notifier,
 	}
 }
 
@@ -37,13 +48,16 @@ type PricingInfo struct {
 	Label    string
 }
 
-// GetCurrentPricing 获取当前定价信息
-func (s *PaymentService) GetCurrentPricing() (*PricingInfo, error) {
+// GetCurrentPricing 获取当前租户的定价信息（按租户的定价币种）
+func (s *PaymentService) GetCurrentPricing(ctx context.Context) (*PricingInfo, error) {
 	// 从数据库读取配置
 	if db.DB != nil {
-		config, err := db.GetPaymentConfig("hkd")
+		config, err := db.GetPaymentConfig(ctx, "")
 		if err == nil && config != nil {
 			label := "HK$" + formatAmount(config.Amount)
+			if config.Currency != "hkd" {
+				label = strings.ToUpper(config.Currency) + " " + formatAmount(config.Amount)
+			}
 			return &PricingInfo{
 				Amount:   config.Amount,
 				Currency: config.Currency,
@@ -80,13 +94,14 @@ func formatFloat(f float64) string {
 	return strconv.FormatFloat(f, 'f', 2, 64)
 }
 
-// CheckUserPaymentValidity 检查用户支付有效性（30天内有效）
-func (s *PaymentService) CheckUserPaymentValidity(userID string) (*UserPaymentValidity, error) {
+// CheckUserPaymentValidity 检查当前租户的用户支付有效性（30天内有效）
+func (s *PaymentService) CheckUserPaymentValidity(ctx context.Context, userID string) (*UserPaymentValidity, error) {
 	if db.DB == nil {
 		return &UserPaymentValidity{Valid: false}, nil
 	}
 
-	userInfo, err := db.GetUserPaymentInfo(userID)
+	// 重复支付检查必须读取主库，刚成功的支付可能还未同步到从库
+	userInfo, err := db.GetUserPaymentInfo(db.WithPrimary(ctx), userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user payment info: %w", err)
 	}
@@ -95,16 +110,16 @@ func (s *PaymentService) CheckUserPaymentValidity(userID string) (*UserPaymentVa
 		return &UserPaymentValidity{Valid: false}, nil
 	}
 
-	// 检查上次支付时间是否在30天内
+	// 检查上次支付时间是否在权益有效期内
 	if userInfo.LastPaymentAt == nil {
 		return &UserPaymentValidity{Valid: false}, nil
 	}
 
 	daysSinceLastPayment := time.Since(*userInfo.LastPaymentAt).Hours() / 24
-	if daysSinceLastPayment <= 30 {
+	if daysSinceLastPayment <= EntitlementDays {
 		return &UserPaymentValidity{
 			Valid:         true,
-			DaysRemaining: int(30 - daysSinceLastPayment),
+			DaysRemaining: int(EntitlementDays - daysSinceLastPayment),
 			UserInfo:      userInfo,
 		}, nil
 	}
@@ -128,7 +143,7 @@ func (s *PaymentService) CheckIdempotency(ctx context.Context, idempotencyKey st
 	}
 
 	zap.L().Debug("Service: Querying database for existing payment", zap.String("idempotency_key", idempotencyKey))
-	existingPayment, err := db.GetPaymentByIdempotencyKey(idempotencyKey)
+	existingPayment, err := db.GetPaymentByIdempotencyKey(ctx, idempotencyKey)
 	if err != nil {
 		zap.L().Error("Service: Failed to check idempotency", zap.Error(err), zap.String("idempotency_key", idempotencyKey))
 		return nil, nil // 继续执行，不阻止请求
@@ -143,10 +158,18 @@ func (s *PaymentService) CheckIdempotency(ctx context.Context, idempotencyKey st
 		zap.String("payment_id", existingPayment.PaymentID),
 		zap.String("payment_intent_id", existingPayment.PaymentIntentID))
 
+	// 已取消或已过期未支付的记录不再返回旧的 client_secret，释放幂等键后重新发起支付
+	if s.releaseAbandonedPayment(ctx, existingPayment) {
+		return nil, nil
+	}
+
 	// 找到已存在的支付记录，从Stripe获取最新的PaymentIntent信息
 	zap.L().Debug("Service: Fetching latest PaymentIntent from Stripe", zap.String("payment_intent_id", existingPayment.PaymentIntentID))
-	stripe.Key = s.cfg.Stripe.SecretKey
-	intent, err := paymentintent.Get(existingPayment.PaymentIntentID, nil)
+	sc, err := stripeForPayment(existingPayment)
+	var intent *stripe.PaymentIntent
+	if err == nil {
+		intent, err = sc.API.PaymentIntents.Get(existingPayment.PaymentIntentID, nil)
+	}
 	if err != nil {
 		zap.L().Warn("Service: Failed to get payment intent from Stripe, returning cached data", zap.Error(err))
 		// 如果获取失败，返回数据库中的信息（client_secret为空）
@@ -161,6 +184,16 @@ func (s *PaymentService) CheckIdempotency(ctx context.Context, idempotencyKey st
 		zap.String("payment_intent_id", intent.ID),
 		zap.String("status", string(intent.Status)))
 
+	if intent.Status == stripe.PaymentIntentStatusCanceled {
+		zap.L().Info("Service: Existing PaymentIntent was canceled, releasing idempotency key",
+			zap.String("payment_intent_id", intent.ID))
+		if err := s.syncPaymentIntentStatus(ctx, intent); err == nil {
+			if err := db.ReleaseIdempotencyKey(ctx, intent.ID); err == nil {
+				return nil, nil
+			}
+		}
+	}
+
 	// 成功获取，返回完整的支付信息
 	return &models.PaymentResponse{
 		ClientSecret:    intent.ClientSecret,
@@ -169,6 +202,29 @@ func (s *PaymentService) CheckIdempotency(ctx context.Context, idempotencyKey st
 	}, nil
 }
 
+// releaseAbandonedPayment 已取消或超过过期时间的支付记录：取消 PaymentIntent 并释放幂等键，返回是否已释放
+func (s *PaymentService) releaseAbandonedPayment(ctx context.Context, payment *db.PaymentHistory) bool {
+	if payment.Status == string(stripe.PaymentIntentStatusCanceled) {
+		if err := db.ReleaseIdempotencyKey(ctx, payment.PaymentIntentID); err != nil {
+			return false
+		}
+		zap.L().Info("Service: Existing payment was canceled, idempotency key released",
+			zap.String("payment_intent_id", payment.PaymentIntentID))
+		return true
+	}
+
+	if !s.isPaymentExpired(payment, time.Now()) {
+		return false
+	}
+
+	expired, err := s.expirePayment(ctx, payment)
+	if err != nil {
+		zap.L().Warn("Service: Failed to expire abandoned payment", zap.Error(err),
+			zap.String("payment_intent_id", payment.PaymentIntentID))
+	}
+	return expired && err == nil
+}
+
 // CreateStripePayment 创建Stripe支付
 func (s *PaymentService) CreateStripePayment(ctx context.Context, req *models.CreatePaymentRequest, idempotencyKey string) (*models.PaymentResponse, error) {
 	zap.L().Info("Service: CreateStripePayment started",
@@ -192,10 +248,15 @@ func (s *PaymentService) CreateStripePayment(ctx context.Context, req *models.Cr
 		zap.L().Warn("Service: Invalid description", zap.Error(err))
 		return nil, fmt.Errorf("invalid description: %w", err)
 	}
+	if err := biz.ValidateCaptureMethod(req.CaptureMethod); err != nil {
+		zap.L().Warn("Service: Invalid capture_method", zap.Error(err))
+		return nil, fmt.Errorf("invalid capture_method: %w", err)
+	}
+	manualCapture := strings.EqualFold(strings.TrimSpace(req.CaptureMethod), string(stripe.PaymentIntentCaptureMethodManual))
 
 	// 检查用户支付有效性
 	zap.L().Debug("Service: Checking user payment validity", zap.String("user_id", req.UserID))
-	validity, err := s.CheckUserPaymentValidity(req.UserID)
+	validity, err := s.CheckUserPaymentValidity(ctx, req.UserID)
 	if err != nil {
 		zap.L().Error("Service: Failed to check user payment validity", zap.Error(err))
 		return nil, fmt.Errorf("failed to check user payment validity: %w", err)
@@ -213,7 +274,7 @@ func (s *PaymentService) CreateStripePayment(ctx context.Context, req *models.Cr
 
 	// 获取定价信息
 	zap.L().Debug("Service: Getting current pricing")
-	pricing, err := s.GetCurrentPricing()
+	pricing, err := s.GetCurrentPricing(ctx)
 	if err != nil {
 		zap.L().Error("Service: Failed to get pricing", zap.Error(err))
 		return nil, fmt.Errorf("failed to get pricing: %w", err)
@@ -222,17 +283,55 @@ func (s *PaymentService) CreateStripePayment(ctx context.Context, req *models.Cr
 		zap.Int64("amount", pricing.Amount),
 		zap.String("currency", pricing.Currency))
 
-	// 设置Stripe密钥
-	zap.L().Debug("Service: Setting Stripe API key")
-	stripe.Key = s.cfg.Stripe.SecretKey
+	// 关联 Stripe Customer（首次支付时创建），使用已保存的支付方式时校验归属
+	customerID, err := s.resolvePaymentCustomer(ctx, req.UserID, req.PaymentMethod)
+	if err != nil {
+		zap.L().Warn("Service: Failed to resolve payment customer", zap.Error(err))
+		return nil, err
+	}
+
+	// 风控检查
+	if err := s.checkFraud(ctx, &FraudCheck{
+		UserID:          req.UserID,
+		IP:              req.ClientIP,
+		PaymentMethodID: req.PaymentMethod,
+		PaymentMethod:   "card",
+		Amount:          pricing.Amount,
+		Currency:        pricing.Currency,
+	}); err != nil {
+		return nil, err
+	}
+
+	// 预留优惠码
+	redemption, err := s.reservePromoCode(ctx, req.PromoCode, req.UserID, pricing)
+	if err != nil {
+		zap.L().Warn("Service: Failed to apply promo code", zap.Error(err), zap.String("promo_code", req.PromoCode))
+		return nil, err
+	}
+	amount := discountedAmount(redemption, pricing.Amount)
+
+	// 选择 Stripe 账户（租户、地区或测试模式）
+	sc, err := stripeFor(ctx)
+	if err != nil {
+		s.releasePromoReservation(ctx, redemption)
+		return nil, err
+	}
+	zap.L().Debug("Service: Using Stripe account", zap.String("stripe_account", sc.Account), zap.Bool("livemode", sc.Livemode))
+
+	// 指定创作者时分账给其连接账户
+	transfer, err := s.resolveConnectTransfer(ctx, sc, req.CreatorID, amount, pricing.Currency)
+	if err != nil {
+		s.releasePromoReservation(ctx, redemption)
+		return nil, err
+	}
 
 	// 创建 Payment Intent
 	zap.L().Info("Service: Creating Stripe PaymentIntent",
-		zap.Int64("amount", pricing.Amount),
+		zap.Int64("amount", amount),
 		zap.String("currency", pricing.Currency),
 		zap.String("idempotency_key", idempotencyKey))
 	params := &stripe.PaymentIntentParams{
-		Amount:   stripe.Int64(pricing.Amount),
+		Amount:   stripe.Int64(amount),
 		Currency: stripe.String(pricing.Currency),
 		Metadata: map[string]string{
 			"user_id":     req.UserID,
@@ -243,17 +342,31 @@ func (s *PaymentService) CreateStripePayment(ctx context.Context, req *models.Cr
 			Enabled: stripe.Bool(true),
 		},
 	}
+	addPromoMetadata(redemption, params.Metadata)
+	applyConnectTransfer(transfer, params)
+	if customerID != "" {
+		params.Customer = stripe.String(customerID)
+	}
+	if req.PaymentMethod != "" {
+		params.PaymentMethod = stripe.String(req.PaymentMethod)
+	}
+	if manualCapture {
+		params.CaptureMethod = stripe.String(string(stripe.PaymentIntentCaptureMethodManual))
+		params.Metadata["capture_method"] = string(stripe.PaymentIntentCaptureMethodManual)
+	}
 
 	// 如果提供了Idempotency Key，传递给Stripe
 	if idempotencyKey != "" {
-		params.IdempotencyKey = stripe.String(idempotencyKey)
+		params.IdempotencyKey = stripe.String(s.stripeIdempotencyKey(ctx, idempotencyKey))
 	}
 
-	intent, err := paymentintent.New(params)
+	intent, err := sc.API.PaymentIntents.New(params)
 	if err != nil {
 		zap.L().Error("Service: Failed to create Stripe PaymentIntent", zap.Error(err))
+		s.releasePromoReservation(ctx, redemption)
 		return nil, fmt.Errorf("failed to create payment intent: %w", err)
 	}
+	s.attachPromoReservation(ctx, redemption, intent.ID)
 	zap.L().Info("Service: Stripe PaymentIntent created",
 		zap.String("payment_intent_id", intent.ID),
 		zap.String("status", string(intent.Status)))
@@ -271,8 +384,14 @@ func (s *PaymentService) CreateStripePayment(ctx context.Context, req *models.Cr
 			"user_id":     req.UserID,
 			"description": req.Description,
 		}
+		addPromoMetadata(redemption, metadata)
+		addConnectMetadata(transfer, metadata)
+		if manualCapture {
+			metadata["capture_method"] = string(stripe.PaymentIntentCaptureMethodManual)
+		}
 
-		err = db.SavePaymentWithMetadata(
+		err = db.SavePaymentWithMetadata(ctx,
+			sc.newPaymentAccount(ctx),
 			intent.ID,
 			paymentID,
 			idempotencyKey,
@@ -291,10 +410,10 @@ func (s *PaymentService) CreateStripePayment(ctx context.Context, req *models.Cr
 				zap.L().Info("Service: Concurrent request with same idempotency_key detected",
 					zap.String("idempotency_key", dupErr.Key))
 				// 查询已存在的支付记录并返回
-				existingPayment, queryErr := db.GetPaymentByIdempotencyKey(dupErr.Key)
+				existingPayment, queryErr := db.GetPaymentByIdempotencyKey(ctx, dupErr.Key)
 				if queryErr == nil && existingPayment != nil {
 					// 从Stripe获取最新的PaymentIntent信息
-					intent, getErr := paymentintent.Get(existingPayment.PaymentIntentID, nil)
+					intent, getErr := sc.API.PaymentIntents.Get(existingPayment.PaymentIntentID, nil)
 					if getErr == nil {
 						zap.L().Info("Service: Returning existing payment from concurrent request",
 							zap.String("payment_id", existingPayment.PaymentID))
@@ -351,7 +470,7 @@ func (s *PaymentService) CreateWeChatPayment(ctx context.Context, req *models.Cr
 	}
 
 	// 检查用户支付有效性
-	validity, err := s.CheckUserPaymentValidity(req.UserID)
+	validity, err := s.CheckUserPaymentValidity(ctx, req.UserID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check user payment validity: %w", err)
 	}
@@ -365,13 +484,42 @@ func (s *PaymentService) CreateWeChatPayment(ctx context.Context, req *models.Cr
 	}
 
 	// 获取定价信息
-	pricing, err := s.GetCurrentPricing()
+	pricing, err := s.GetCurrentPricing(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pricing: %w", err)
 	}
 
-	// 设置Stripe密钥
-	stripe.Key = s.cfg.Stripe.SecretKey
+	// 风控检查
+	if err := s.checkFraud(ctx, &FraudCheck{
+		UserID:        req.UserID,
+		IP:            req.ClientIP,
+		PaymentMethod: "wechat_pay",
+		Amount:        pricing.Amount,
+		Currency:      pricing.Currency,
+	}); err != nil {
+		return nil, err
+	}
+
+	// 预留优惠码
+	redemption, err := s.reservePromoCode(ctx, req.PromoCode, req.UserID, pricing)
+	if err != nil {
+		return nil, err
+	}
+
+	// 选择 Stripe 账户（租户、地区或测试模式）
+	sc, err := stripeFor(ctx)
+	if err != nil {
+		s.releasePromoReservation(ctx, redemption)
+		return nil, err
+	}
+	amount := discountedAmount(redemption, pricing.Amount)
+
+	// 指定创作者时分账给其连接账户
+	transfer, err := s.resolveConnectTransfer(ctx, sc, req.CreatorID, amount, pricing.Currency)
+	if err != nil {
+		s.releasePromoReservation(ctx, redemption)
+		return nil, err
+	}
 
 	client := strings.ToLower(strings.TrimSpace(req.Client))
 	if client == "" {
@@ -379,7 +527,7 @@ func (s *PaymentService) CreateWeChatPayment(ctx context.Context, req *models.Cr
 	}
 
 	params := &stripe.PaymentIntentParams{
-		Amount:             stripe.Int64(pricing.Amount),
+		Amount:             stripe.Int64(amount),
 		Currency:           stripe.String(pricing.Currency),
 		PaymentMethodTypes: stripe.StringSlice([]string{"wechat_pay"}),
 		Metadata: map[string]string{
@@ -392,19 +540,23 @@ func (s *PaymentService) CreateWeChatPayment(ctx context.Context, req *models.Cr
 			},
 		},
 	}
+	addPromoMetadata(redemption, params.Metadata)
+	applyConnectTransfer(transfer, params)
 
 	if req.ReturnURL != "" {
 		params.ReturnURL = stripe.String(req.ReturnURL)
 	}
 
 	if idempotencyKey != "" {
-		params.IdempotencyKey = stripe.String(idempotencyKey)
+		params.IdempotencyKey = stripe.String(s.stripeIdempotencyKey(ctx, idempotencyKey))
 	}
 
-	intent, err := paymentintent.New(params)
+	intent, err := sc.API.PaymentIntents.New(params)
 	if err != nil {
+		s.releasePromoReservation(ctx, redemption)
 		return nil, fmt.Errorf("failed to create wechat payment intent: %w", err)
 	}
+	s.attachPromoReservation(ctx, redemption, intent.ID)
 
 	// 保存到数据库
 	if db.DB != nil {
@@ -413,7 +565,10 @@ func (s *PaymentService) CreateWeChatPayment(ctx context.Context, req *models.Cr
 			"description": req.Description,
 			"client":      client,
 		}
-		err = db.SavePaymentWithMetadata(
+		addPromoMetadata(redemption, metadata)
+		addConnectMetadata(transfer, metadata)
+		err = db.SavePaymentWithMetadata(ctx,
+			sc.newPaymentAccount(ctx),
 			intent.ID,
 			uuid.New().String(),
 			idempotencyKey,
@@ -427,9 +582,9 @@ func (s *PaymentService) CreateWeChatPayment(ctx context.Context, req *models.Cr
 		)
 		if err != nil {
 			if dupErr, ok := err.(*db.DuplicateIdempotencyKeyError); ok {
-				existingPayment, queryErr := db.GetPaymentByIdempotencyKey(dupErr.Key)
+				existingPayment, queryErr := db.GetPaymentByIdempotencyKey(ctx, dupErr.Key)
 				if queryErr == nil && existingPayment != nil {
-					intent, getErr := paymentintent.Get(existingPayment.PaymentIntentID, nil)
+					intent, getErr := sc.API.PaymentIntents.Get(existingPayment.PaymentIntentID, nil)
 					if getErr == nil {
 						return map[string]interface{}{
 							"client_secret":     intent.ClientSecret,
@@ -466,7 +621,7 @@ func (s *PaymentService) CreateAlipayPayment(ctx context.Context, req *models.Cr
 	}
 
 	// 检查用户支付有效性
-	validity, err := s.CheckUserPaymentValidity(req.UserID)
+	validity, err := s.CheckUserPaymentValidity(ctx, req.UserID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check user payment validity: %w", err)
 	}
@@ -480,16 +635,45 @@ func (s *PaymentService) CreateAlipayPayment(ctx context.Context, req *models.Cr
 	}
 
 	// 获取定价信息
-	pricing, err := s.GetCurrentPricing()
+	pricing, err := s.GetCurrentPricing(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pricing: %w", err)
 	}
 
-	// 设置Stripe密钥
-	stripe.Key = s.cfg.Stripe.SecretKey
+	// 风控检查
+	if err := s.checkFraud(ctx, &FraudCheck{
+		UserID:        req.UserID,
+		IP:            req.ClientIP,
+		PaymentMethod: "alipay",
+		Amount:        pricing.Amount,
+		Currency:      pricing.Currency,
+	}); err != nil {
+		return nil, err
+	}
+
+	// 预留优惠码
+	redemption, err := s.reservePromoCode(ctx, req.PromoCode, req.UserID, pricing)
+	if err != nil {
+		return nil, err
+	}
+
+	// 选择 Stripe 账户（租户、地区或测试模式）
+	sc, err := stripeFor(ctx)
+	if err != nil {
+		s.releasePromoReservation(ctx, redemption)
+		return nil, err
+	}
+	amount := discountedAmount(redemption, pricing.Amount)
+
+	// 指定创作者时分账给其连接账户
+	transfer, err := s.resolveConnectTransfer(ctx, sc, req.CreatorID, amount, pricing.Currency)
+	if err != nil {
+		s.releasePromoReservation(ctx, redemption)
+		return nil, err
+	}
 
 	params := &stripe.PaymentIntentParams{
-		Amount:             stripe.Int64(pricing.Amount),
+		Amount:             stripe.Int64(amount),
 		Currency:           stripe.String(pricing.Currency),
 		PaymentMethodTypes: stripe.StringSlice([]string{"alipay"}),
 		Metadata: map[string]string{
@@ -497,15 +681,19 @@ func (s *PaymentService) CreateAlipayPayment(ctx context.Context, req *models.Cr
 			"description": req.Description,
 		},
 	}
+	addPromoMetadata(redemption, params.Metadata)
+	applyConnectTransfer(transfer, params)
 
 	if idempotencyKey != "" {
-		params.IdempotencyKey = stripe.String(idempotencyKey)
+		params.IdempotencyKey = stripe.String(s.stripeIdempotencyKey(ctx, idempotencyKey))
 	}
 
-	intent, err := paymentintent.New(params)
+	intent, err := sc.API.PaymentIntents.New(params)
 	if err != nil {
+		s.releasePromoReservation(ctx, redemption)
 		return nil, fmt.Errorf("failed to create alipay payment intent: %w", err)
 	}
+	s.attachPromoReservation(ctx, redemption, intent.ID)
 
 	// 保存到数据库
 	if db.DB != nil {
@@ -513,7 +701,10 @@ func (s *PaymentService) CreateAlipayPayment(ctx context.Context, req *models.Cr
 			"user_id":     req.UserID,
 			"description": req.Description,
 		}
-		err = db.SavePaymentWithMetadata(
+		addPromoMetadata(redemption, metadata)
+		addConnectMetadata(transfer, metadata)
+		err = db.SavePaymentWithMetadata(ctx,
+			sc.newPaymentAccount(ctx),
 			intent.ID,
 			uuid.New().String(),
 			idempotencyKey,
@@ -527,9 +718,9 @@ func (s *PaymentService) CreateAlipayPayment(ctx context.Context, req *models.Cr
 		)
 		if err != nil {
 			if dupErr, ok := err.(*db.DuplicateIdempotencyKeyError); ok {
-				existingPayment, queryErr := db.GetPaymentByIdempotencyKey(dupErr.Key)
+				existingPayment, queryErr := db.GetPaymentByIdempotencyKey(ctx, dupErr.Key)
 				if queryErr == nil && existingPayment != nil {
-					intent, getErr := paymentintent.Get(existingPayment.PaymentIntentID, nil)
+					intent, getErr := sc.API.PaymentIntents.Get(existingPayment.PaymentIntentID, nil)
 					if getErr == nil {
 						return map[string]interface{}{
 							"client_secret":     intent.ClientSecret,
@@ -554,16 +745,73 @@ func (s *PaymentService) CreateAlipayPayment(ctx context.Context, req *models.Cr
 	}, nil
 }
 
-// GetPaymentIntent 从Stripe获取PaymentIntent
-func (s *PaymentService) GetPaymentIntent(paymentIntentID string) (*stripe.PaymentIntent, error) {
-	stripe.Key = s.cfg.Stripe.SecretKey
-	intent, err := paymentintent.Get(paymentIntentID, nil)
+// GetPaymentIntent 从 PaymentIntent 所属的 Stripe 账户获取 PaymentIntent
+func (s *PaymentService) GetPaymentIntent(ctx context.Context, paymentIntentID string) (*stripe.PaymentIntent, error) {
+	sc, err := stripeForIntent(ctx, paymentIntentID)
+	if err != nil {
+		return nil, err
+	}
+	intent, err := sc.API.PaymentIntents.Get(paymentIntentID, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get payment intent: %w", err)
 	}
 	return intent, nil
 }
 
+// RefundPayment 发起退款（全额或部分）
+func (s *PaymentService) RefundPayment(ctx context.Context, req *models.RefundRequest) (*stripe.Refund, error) {
+	if err := biz.ValidatePaymentIntentID(req.PaymentIntentID); err != nil {
+		return nil, err
+	}
+	if req.Amount > 0 {
+		if err := biz.ValidateAmount(req.Amount); err != nil {
+			return nil, err
+		}
+	}
+	if err := biz.ValidateRefundReason(req.Reason); err != nil {
+		return nil, err
+	}
+
+	sc, err := stripeForIntent(ctx, req.PaymentIntentID)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(req.PaymentIntentID),
+	}
+	if req.Amount > 0 {
+		params.Amount = stripe.Int64(req.Amount)
+	}
+	if req.Reason != "" {
+		params.Reason = stripe.String(req.Reason)
+	}
+	// 分账支付退款时按比例撤回转给创作者的金额和平台抽成，否则退款全部由平台承担
+	if db.DB != nil {
+		if ph, err := db.GetPaymentByIntentID(ctx, req.PaymentIntentID); err == nil && isConnectPayment(ph) {
+			params.ReverseTransfer = stripe.Bool(true)
+			params.RefundApplicationFee = stripe.Bool(true)
+		}
+	}
+
+	result, err := sc.API.Refunds.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refund: %w", err)
+	}
+
+	zap.L().Info("Refund created",
+		zap.String("refund_id", result.ID),
+		zap.String("payment_intent_id", req.PaymentIntentID),
+		zap.Int64("amount", result.Amount),
+		zap.String("status", string(result.Status)))
+
+	// 保存退款记录（失败不影响退款结果，refund.* Webhook 会再次写入）
+	if err := s.recordRefund(ctx, result); err != nil {
+		zap.L().Warn("Failed to record refund", zap.Error(err), zap.String("refund_id", result.ID))
+	}
+	return result, nil
+}
+
 // ValidatePaymentRequest 验证支付请求
 func (s *PaymentService) ValidatePaymentRequest(req *models.CreatePaymentRequest) error {
 	if err := biz.ValidateUserID(req.UserID); err != nil {
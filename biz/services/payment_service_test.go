@@ -3,7 +3,12 @@ package services
 import (
 	"context"
 	"stripe-pay/biz/models"
+	"stripe-pay/conf"
+	"stripe-pay/db"
 	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
 )
 
 // TestGetCurrentPricing 测试获取定价信息
@@ -14,7 +19,7 @@ func TestGetCurrentPricing(t *testing.T) {
 
 	service := NewPaymentService()
 
-	pricing, err := service.GetCurrentPricing()
+	pricing, err := service.GetCurrentPricing(context.Background())
 	if err != nil {
 		t.Fatalf("GetCurrentPricing() failed: %v", err)
 	}
@@ -50,7 +55,7 @@ func TestCheckUserPaymentValidity_NoPayment(t *testing.T) {
 	service := NewPaymentService()
 
 	// 测试不存在的用户（数据库可能不可用，但应该返回Valid=false）
-	validity, err := service.CheckUserPaymentValidity("non_existent_user")
+	validity, err := service.CheckUserPaymentValidity(context.Background(), "non_existent_user")
 	if err != nil {
 		// 如果数据库不可用，这是预期的
 		t.Logf("Database not available (expected in test): %v", err)
@@ -74,7 +79,7 @@ func TestCheckUserPaymentValidity_ExpiredPayment(t *testing.T) {
 	service := NewPaymentService()
 
 	// 这个测试需要数据库支持，如果数据库不可用则跳过
-	validity, err := service.CheckUserPaymentValidity("test_user_expired")
+	validity, err := service.CheckUserPaymentValidity(context.Background(), "test_user_expired")
 	if err != nil {
 		t.Logf("Skipping test - database not available: %v", err)
 		return
@@ -314,7 +319,7 @@ func TestGetPaymentIntent(t *testing.T) {
 
 	// 这个测试需要有效的PaymentIntent ID和Stripe API密钥
 	// 如果没有配置，则跳过
-	_, err := service.GetPaymentIntent("pi_test_12345")
+	_, err := service.GetPaymentIntent(context.Background(), "pi_test_12345")
 	if err != nil {
 		// Stripe API错误是预期的（如果没有配置密钥或无效ID）
 		t.Logf("Skipping test - Stripe API not configured or invalid ID (expected in test): %v", err)
@@ -331,7 +336,7 @@ func BenchmarkGetCurrentPricing(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := service.GetCurrentPricing()
+		_, err := service.GetCurrentPricing(context.Background())
 		if err != nil {
 			b.Fatalf("GetCurrentPricing() failed: %v", err)
 		}
@@ -348,3 +353,101 @@ func BenchmarkCheckIdempotency(b *testing.B) {
 		_, _ = service.CheckIdempotency(ctx, "test_key")
 	}
 }
+
+// TestGetReconcileSettings 测试对账配置默认值
+func TestGetReconcileSettings(t *testing.T) {
+	service := &PaymentService{cfg: &conf.Config{}}
+
+	settings := service.getReconcileSettings()
+	if settings.staleAfter != defaultReconcileStaleAfter {
+		t.Errorf("staleAfter = %v, want %v", settings.staleAfter, defaultReconcileStaleAfter)
+	}
+	if settings.batchSize != defaultReconcileBatchSize {
+		t.Errorf("batchSize = %d, want %d", settings.batchSize, defaultReconcileBatchSize)
+	}
+
+	service.cfg.Reconciliation.StaleAfter = 60
+	service.cfg.Reconciliation.BatchSize = 10
+	settings = service.getReconcileSettings()
+	if settings.staleAfter != time.Minute {
+		t.Errorf("staleAfter = %v, want %v", settings.staleAfter, time.Minute)
+	}
+	if settings.batchSize != 10 {
+		t.Errorf("batchSize = %d, want 10", settings.batchSize)
+	}
+}
+
+// TestIsPaymentExpired 测试未支付记录过期判断
+func TestIsPaymentExpired(t *testing.T) {
+	service := &PaymentService{cfg: &conf.Config{}}
+	service.cfg.PaymentExpiry.Enabled = true
+	service.cfg.PaymentExpiry.TTL = map[string]int{"wechat_pay": 600}
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		payment db.PaymentHistory
+		want    bool
+	}{
+		{"微信支付超时未支付", db.PaymentHistory{PaymentMethod: "wechat_pay", Status: "requires_action", CreatedAt: now.Add(-11 * time.Minute)}, true},
+		{"微信支付未超时", db.PaymentHistory{PaymentMethod: "wechat_pay", Status: "requires_action", CreatedAt: now.Add(-5 * time.Minute)}, false},
+		{"已支付成功", db.PaymentHistory{PaymentMethod: "wechat_pay", Status: "succeeded", CreatedAt: now.Add(-time.Hour)}, false},
+		{"处理中不可取消", db.PaymentHistory{PaymentMethod: "wechat_pay", Status: "processing", CreatedAt: now.Add(-time.Hour)}, false},
+		{"未配置过期时间的支付方式", db.PaymentHistory{PaymentMethod: "alipay", Status: "requires_action", CreatedAt: now.Add(-time.Hour)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := service.isPaymentExpired(&tt.payment, now); got != tt.want {
+				t.Errorf("isPaymentExpired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	service.cfg.PaymentExpiry.Enabled = false
+	expired := db.PaymentHistory{PaymentMethod: "wechat_pay", Status: "requires_action", CreatedAt: now.Add(-time.Hour)}
+	if service.isPaymentExpired(&expired, now) {
+		t.Error("expected no expiry when payment_expiry is disabled")
+	}
+}
+
+// TestGetPromoReservationTTL 测试优惠码预留保留时间配置
+func TestGetPromoReservationTTL(t *testing.T) {
+	service := &PaymentService{cfg: &conf.Config{}}
+	if ttl := service.getPromoReservationTTL(); ttl != 0 {
+		t.Errorf("disabled ttl = %v, want 0", ttl)
+	}
+
+	service.cfg.PaymentExpiry.Enabled = true
+	if ttl := service.getPromoReservationTTL(); ttl != defaultPromoReservationTTL {
+		t.Errorf("default ttl = %v, want %v", ttl, defaultPromoReservationTTL)
+	}
+
+	service.cfg.PaymentExpiry.PromoReservationTTL = 3600
+	if ttl := service.getPromoReservationTTL(); ttl != time.Hour {
+		t.Errorf("configured ttl = %v, want %v", ttl, time.Hour)
+	}
+}
+
+// TestAuthorizationDeadline 测试授权失效时间计算
+func TestAuthorizationDeadline(t *testing.T) {
+	created := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	captureBefore := time.Date(2024, 3, 7, 20, 0, 0, 0, time.UTC)
+
+	withCharge := &stripe.PaymentIntent{
+		Created: created.Unix(),
+		LatestCharge: &stripe.Charge{
+			PaymentMethodDetails: &stripe.ChargePaymentMethodDetails{
+				Card: &stripe.ChargePaymentMethodDetailsCard{CaptureBefore: captureBefore.Unix()},
+			},
+		},
+	}
+	if got := authorizationDeadline(withCharge); !got.Equal(captureBefore) {
+		t.Errorf("authorizationDeadline() = %v, want capture_before %v", got, captureBefore)
+	}
+
+	withoutCharge := &stripe.PaymentIntent{Created: created.Unix()}
+	if got, want := authorizationDeadline(withoutCharge), created.Add(7*24*time.Hour); !got.Equal(want) {
+		t.Errorf("authorizationDeadline() = %v, want %v", got, want)
+	}
+}
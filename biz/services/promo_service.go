@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"stripe-pay/biz"
+	"stripe-pay/biz/models"
+	"stripe-pay/db"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 写入 PaymentIntent 和 payment_history.metadata 的优惠信息字段
+const (
+	metadataPromoCode      = "promo_code"
+	metadataDiscountAmount = "discount_amount"
+	metadataOriginalAmount = "original_amount"
+)
+
+// reservePromoCode 校验并预留优惠码，返回预留记录（未提供优惠码时返回 nil）
+// 折扣后的金额必须不低于最小金额
+func (s *PaymentService) reservePromoCode(ctx context.Context, code, userID string, pricing *PricingInfo) (*db.PromoRedemption, error) {
+	code = db.NormalizePromoCode(code)
+	if code == "" {
+		return nil, nil
+	}
+	if err := biz.ValidatePromoCode(code); err != nil {
+		return nil, fmt.Errorf("invalid promo_code: %w", err)
+	}
+	if db.DB == nil {
+		return nil, fmt.Errorf("promo codes are unavailable: database not available")
+	}
+
+	redemption, err := db.ReservePromoCode(ctx, code, userID, pricing.Amount, pricing.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply promo code: %w", err)
+	}
+
+	if pricing.Amount-redemption.DiscountAmount < biz.MinAmount {
+		s.releasePromoReservation(ctx, redemption)
+		return nil, fmt.Errorf("failed to apply promo code: %w",
+			&db.PromoCodeUnavailableError{Code: code, Reason: "discount exceeds payment amount"})
+	}
+
+	return redemption, nil
+}
+
+// attachPromoReservation 将预留记录关联到新创建的 PaymentIntent
+// 同一幂等请求并发创建时 Stripe 返回同一个 PaymentIntent，多出的预留记录直接释放
+func (s *PaymentService) attachPromoReservation(ctx context.Context, redemption *db.PromoRedemption, paymentIntentID string) {
+	if redemption == nil {
+		return
+	}
+
+	err := db.AttachPromoRedemption(ctx, redemption.ID, paymentIntentID)
+	if errors.Is(err, db.ErrPromoRedemptionAttached) {
+		zap.L().Info("Promo redemption already attached to payment intent, releasing duplicate reservation",
+			zap.String("payment_intent_id", paymentIntentID),
+			zap.Int64("redemption_id", redemption.ID))
+		s.releasePromoReservation(ctx, redemption)
+		return
+	}
+	if err != nil {
+		zap.L().Warn("Failed to attach promo redemption", zap.Error(err),
+			zap.String("payment_intent_id", paymentIntentID),
+			zap.Int64("redemption_id", redemption.ID))
+	}
+}
+
+// releasePromoReservation 释放预留记录（PaymentIntent 创建失败时调用）
+func (s *PaymentService) releasePromoReservation(ctx context.Context, redemption *db.PromoRedemption) {
+	if redemption == nil {
+		return
+	}
+	if err := db.ReleasePromoRedemption(context.WithoutCancel(ctx), redemption.ID); err != nil {
+		zap.L().Error("Failed to release promo reservation", zap.Error(err), zap.Int64("redemption_id", redemption.ID))
+	}
+}
+
+// syncPromoRedemption 按 PaymentIntent 最终状态更新优惠码使用记录：成功标记为已使用，取消释放次数
+func (s *PaymentService) syncPromoRedemption(ctx context.Context, paymentIntentID, status string, metadata map[string]string) error {
+	if metadata[metadataPromoCode] == "" {
+		return nil
+	}
+
+	switch status {
+	case "succeeded":
+		return db.MarkPromoRedeemed(ctx, paymentIntentID)
+	case "canceled":
+		return db.ReleasePromoRedemptionByIntent(ctx, paymentIntentID)
+	}
+	return nil
+}
+
+// discountedAmount 计算折扣后的金额（未使用优惠码时返回原价）
+func discountedAmount(redemption *db.PromoRedemption, amount int64) int64 {
+	if redemption == nil {
+		return amount
+	}
+	return amount - redemption.DiscountAmount
+}
+
+// addPromoMetadata 写入优惠信息（优惠码、折扣金额、原价）
+func addPromoMetadata(redemption *db.PromoRedemption, metadata map[string]string) {
+	if redemption == nil {
+		return
+	}
+	metadata[metadataPromoCode] = redemption.Code
+	metadata[metadataDiscountAmount] = strconv.FormatInt(redemption.DiscountAmount, 10)
+	metadata[metadataOriginalAmount] = strconv.FormatInt(redemption.OriginalAmount, 10)
+}
+
+// CreatePromoCode 创建优惠码（管理接口）
+func (s *PaymentService) CreatePromoCode(ctx context.Context, req *models.CreatePromoCodeRequest) (*db.PromoCode, error) {
+	if db.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	promo, err := buildPromoCode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := db.GetPromoCode(ctx, promo.Code)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("promo code %s already exists", promo.Code)
+	}
+
+	if err := db.CreatePromoCode(ctx, promo); err != nil {
+		return nil, err
+	}
+	return db.GetPromoCode(ctx, promo.Code)
+}
+
+// buildPromoCode 校验创建请求并构建优惠码，参数非法时返回 *biz.ValidationError
+func buildPromoCode(req *models.CreatePromoCodeRequest) (*db.PromoCode, error) {
+	promo := &db.PromoCode{
+		Code:           db.NormalizePromoCode(req.Code),
+		Currency:       strings.ToLower(strings.TrimSpace(req.Currency)),
+		MaxRedemptions: req.MaxRedemptions,
+		PerUserLimit:   1,
+		Active:         true,
+		Description:    req.Description,
+	}
+
+	if promo.Code == "" {
+		return nil, &biz.ValidationError{Field: "code", Message: "code is required"}
+	}
+	if err := biz.ValidatePromoCode(promo.Code); err != nil {
+		return nil, err
+	}
+	if err := biz.ValidateCurrency(promo.Currency); err != nil {
+		return nil, err
+	}
+	if err := biz.ValidateDescription(req.Description); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case req.PercentOff != 0 && req.AmountOff != 0:
+		return nil, &biz.ValidationError{Field: "percent_off", Message: "only one of percent_off and amount_off can be set"}
+	case req.PercentOff != 0:
+		if req.PercentOff < 1 || req.PercentOff > 100 {
+			return nil, &biz.ValidationError{Field: "percent_off", Message: "percent_off must be between 1 and 100"}
+		}
+		promo.DiscountType = db.PromoDiscountPercent
+		promo.PercentOff = req.PercentOff
+	case req.AmountOff != 0:
+		if req.AmountOff < biz.MinAmount || req.AmountOff > biz.MaxAmount {
+			return nil, &biz.ValidationError{Field: "amount_off", Message: fmt.Sprintf("amount_off must be between %d and %d", biz.MinAmount, biz.MaxAmount)}
+		}
+		if promo.Currency == "" {
+			return nil, &biz.ValidationError{Field: "currency", Message: "currency is required for amount_off"}
+		}
+		promo.DiscountType = db.PromoDiscountAmount
+		promo.AmountOff = req.AmountOff
+	default:
+		return nil, &biz.ValidationError{Field: "percent_off", Message: "one of percent_off and amount_off is required"}
+	}
+
+	if req.MaxRedemptions < 0 {
+		return nil, &biz.ValidationError{Field: "max_redemptions", Message: "max_redemptions must not be negative"}
+	}
+	if req.PerUserLimit != nil {
+		if *req.PerUserLimit < 0 {
+			return nil, &biz.ValidationError{Field: "per_user_limit", Message: "per_user_limit must not be negative"}
+		}
+		promo.PerUserLimit = *req.PerUserLimit
+	}
+
+	if req.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			return nil, &biz.ValidationError{Field: "expires_at", Message: "expires_at must be RFC3339"}
+		}
+		promo.ExpiresAt = &expiresAt
+	}
+
+	return promo, nil
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"stripe-pay/biz"
+	"stripe-pay/biz/receipt"
+	"stripe-pay/common"
+	"stripe-pay/db"
+	"stripe-pay/tenant"
+
+	"go.uber.org/zap"
+)
+
+// 收据格式
+const (
+	ReceiptFormatHTML = "html"
+	ReceiptFormatPDF  = "pdf"
+)
+
+// RenderedReceipt 渲染后的收据
+type RenderedReceipt struct {
+	Number      string
+	ContentType string
+	Body        []byte
+}
+
+// receiptSettings 开具和渲染收据使用的设置
+type receiptSettings struct {
+	NumberPrefix string
+	BrandColor   string
+	LogoURL      string
+	TaxName      string
+	TaxRate      float64
+	Company      receipt.Company
+}
+
+// receiptSettingsFor 当前租户的收据设置（租户未设置的项使用全局 receipt 配置）
+func (s *PaymentService) receiptSettingsFor(ctx context.Context) receiptSettings {
+	cfg := s.cfg.Receipt
+	settings := receiptSettings{
+		NumberPrefix: cfg.NumberPrefix,
+		BrandColor:   cfg.BrandColor,
+		LogoURL:      cfg.LogoURL,
+		TaxName:      cfg.TaxName,
+		TaxRate:      cfg.TaxRate,
+		Company: receipt.Company{
+			Name:    cfg.Company.Name,
+			Address: cfg.Company.Address,
+			Email:   cfg.Company.Email,
+			Phone:   cfg.Company.Phone,
+			TaxID:   cfg.Company.TaxID,
+			Website: cfg.Company.Website,
+		},
+	}
+	if db.DB == nil {
+		return settings
+	}
+
+	// Webhook 和后台任务的 context 只有租户标识，从租户缓存读取完整配置
+	t, err := common.LookupTenant(ctx, tenant.ID(ctx))
+	if err != nil {
+		zap.L().Warn("Failed to load tenant receipt settings, using global settings", zap.Error(err), zap.String("tenant_id", tenant.ID(ctx)))
+		return settings
+	}
+	if t == nil {
+		return settings
+	}
+	return mergeReceiptSettings(settings, t.Receipt)
+}
+
+// mergeReceiptSettings 用租户设置覆盖全局设置；租户设置了公司名称时整体使用租户的公司信息
+func mergeReceiptSettings(settings receiptSettings, r *tenant.Receipt) receiptSettings {
+	if r == nil {
+		return settings
+	}
+	if r.NumberPrefix != "" {
+		settings.NumberPrefix = r.NumberPrefix
+	}
+	if r.BrandColor != "" {
+		settings.BrandColor = r.BrandColor
+	}
+	if r.LogoURL != "" {
+		settings.LogoURL = r.LogoURL
+	}
+	if r.TaxName != "" {
+		settings.TaxName = r.TaxName
+	}
+	if r.TaxRate != nil {
+		settings.TaxRate = *r.TaxRate
+	}
+	if r.Company != nil && r.Company.Name != "" {
+		settings.Company = receipt.Company{
+			Name:    r.Company.Name,
+			Address: r.Company.Address,
+			Email:   r.Company.Email,
+			Phone:   r.Company.Phone,
+			TaxID:   r.Company.TaxID,
+			Website: r.Company.Website,
+		}
+	}
+	return settings
+}
+
+// issueReceiptForIntent 支付成功后开具收据（按 payment_intent_id 幂等，金额取支付记录中的实收金额）
+func (s *PaymentService) issueReceiptForIntent(ctx context.Context, paymentIntentID string) (*db.PaymentReceipt, error) {
+	payment, err := db.GetPaymentByIntentID(ctx, paymentIntentID)
+	if err != nil || payment == nil {
+		return nil, err
+	}
+	return s.issueReceipt(ctx, payment)
+}
+
+// issueReceipt 按租户当前的税率和编号前缀开具收据（已开具的收据保持不变）
+func (s *PaymentService) issueReceipt(ctx context.Context, payment *db.PaymentHistory) (*db.PaymentReceipt, error) {
+	settings := s.receiptSettingsFor(ctx)
+	taxRateBps := int(math.Round(settings.TaxRate * 100))
+	return db.IssueReceipt(ctx, &db.PaymentReceipt{
+		PaymentIntentID: payment.PaymentIntentID,
+		PaymentID:       payment.PaymentID,
+		UserID:          payment.UserID,
+		Amount:          payment.Amount,
+		Currency:        payment.Currency,
+		TaxRateBps:      taxRateBps,
+		TaxAmount:       db.InclusiveTax(payment.Amount, taxRateBps),
+	}, settings.NumberPrefix)
+}
+
+// RenderReceipt 渲染用户某笔成功支付的收据（id 可以是 payment_id 或 payment_intent_id）
+// 支付不存在或不属于该用户时返回 ErrPaymentNotFound，未支付成功时返回 *PaymentStateError
+func (s *PaymentService) RenderReceipt(ctx context.Context, id, userID, format string) (*RenderedReceipt, error) {
+	if err := biz.ValidateUserID(userID); err != nil {
+		return nil, fmt.Errorf("invalid user_id: %w", err)
+	}
+	if format != ReceiptFormatHTML && format != ReceiptFormatPDF {
+		return nil, &biz.ValidationError{Field: "format", Message: "format must be html or pdf"}
+	}
+	if db.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	var payment *db.PaymentHistory
+	var err error
+	if strings.HasPrefix(id, "pi_") {
+		payment, err = db.GetPaymentByIntentID(ctx, id)
+	} else {
+		payment, err = db.GetPaymentByPaymentID(ctx, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+	// 不属于该用户（或其他租户）的支付按不存在处理，避免泄露支付是否存在
+	if payment == nil || payment.UserID != userID || payment.TenantID != tenant.ID(ctx) {
+		return nil, ErrPaymentNotFound
+	}
+	if payment.Status != "succeeded" {
+		return nil, &PaymentStateError{PaymentIntentID: payment.PaymentIntentID, Status: payment.Status, Action: "issue a receipt for"}
+	}
+
+	issued, err := db.GetReceiptByIntentID(ctx, payment.PaymentIntentID)
+	if err != nil {
+		return nil, err
+	}
+	if issued == nil {
+		// Webhook 开具失败或早于收据功能的支付：查看时补开
+		if issued, err = s.issueReceipt(ctx, payment); err != nil {
+			return nil, fmt.Errorf("failed to issue receipt: %w", err)
+		}
+	}
+
+	refunds, err := db.GetRefundsByIntentID(ctx, payment.PaymentIntentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refunds: %w", err)
+	}
+
+	r := buildReceipt(s.receiptSettingsFor(ctx), payment, issued, refunds)
+	rendered := &RenderedReceipt{Number: r.Number}
+	if format == ReceiptFormatPDF {
+		rendered.ContentType = "application/pdf"
+		rendered.Body, err = receipt.RenderPDF(r)
+	} else {
+		rendered.ContentType = "text/html; charset=utf-8"
+		rendered.Body, err = receipt.RenderHTML(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to render receipt: %w", err)
+	}
+	return rendered, nil
+}
+
+// buildReceipt 组装收据内容（公司信息和样式取租户当前的设置，金额和税额取开具时的记录）
+func buildReceipt(settings receiptSettings, payment *db.PaymentHistory, issued *db.PaymentReceipt, refunds []db.PaymentRefund) *receipt.Receipt {
+	r := &receipt.Receipt{
+		Number:          issued.ReceiptNumber,
+		IssuedAt:        issued.IssuedAt,
+		Company:         settings.Company,
+		BrandColor:      settings.BrandColor,
+		LogoURL:         settings.LogoURL,
+		UserID:          payment.UserID,
+		PaymentID:       payment.PaymentID,
+		PaymentIntentID: payment.PaymentIntentID,
+		PaymentMethod:   payment.PaymentMethod,
+		Description:     payment.Description,
+		Currency:        issued.Currency,
+		Amount:          issued.Amount,
+		TaxName:         settings.TaxName,
+		TaxRateBps:      issued.TaxRateBps,
+		TaxAmount:       issued.TaxAmount,
+	}
+	if r.Company.Name == "" {
+		r.Company.Name = "Payment"
+	}
+
+	var metadata map[string]string
+	if payment.Metadata != "" && json.Unmarshal([]byte(payment.Metadata), &metadata) == nil && metadata[metadataPromoCode] != "" {
+		r.PromoCode = metadata[metadataPromoCode]
+		r.DiscountAmount, _ = strconv.ParseInt(metadata[metadataDiscountAmount], 10, 64)
+		r.OriginalAmount, _ = strconv.ParseInt(metadata[metadataOriginalAmount], 10, 64)
+	}
+
+	for _, refund := range refunds {
+		r.Refunds = append(r.Refunds, receipt.Refund{
+			ID:       refund.RefundID,
+			Amount:   refund.Amount,
+			Status:   refund.Status,
+			Reason:   refund.Reason,
+			Refunded: refund.CreatedAt,
+		})
+	}
+	return r
+}
@@ -0,0 +1,492 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"stripe-pay/common"
+	"stripe-pay/db"
+	"stripe-pay/tenant"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"go.uber.org/zap"
+)
+
+// 对账默认参数（配置未设置时使用）
+const (
+	DefaultReconcileInterval      = 5 * time.Minute
+	defaultReconcileStaleAfter    = 15 * time.Minute
+	defaultReconcileMaxAge        = 7 * 24 * time.Hour
+	defaultReconcileBatchSize     = 100
+	defaultReconcileEventLookback = 24 * time.Hour
+	maxReconcileReportItems       = 500
+)
+
+// reconcilableStatuses 需要对账的非终态状态
+var reconcilableStatuses = []string{
+	"requires_payment_method",
+	"requires_confirmation",
+	"requires_action",
+	"processing",
+	"open", // Checkout Session 占位记录
+}
+
+// reconcileEventTypes 对账时拉取的 Stripe 事件类型（与 Webhook 处理的事件一致）
+var reconcileEventTypes = []string{
+	"payment_intent.succeeded",
+	"payment_intent.payment_failed",
+	"payment_intent.canceled",
+}
+
+// ReconcileOptions 对账参数
+type ReconcileOptions struct {
+	TriggerSource string // scheduler, admin
+	DryRun        bool   // 只检查不修正
+}
+
+// ReconcileItem 一条状态不一致或核对失败的记录
+type ReconcileItem struct {
+	PaymentIntentID string `json:"payment_intent_id"`
+	PaymentID       string `json:"payment_id,omitempty"`
+	Source          string `json:"source"` // stale_scan, event
+	LocalStatus     string `json:"local_status,omitempty"`
+	StripeStatus    string `json:"stripe_status,omitempty"`
+	Corrected       bool   `json:"corrected"`
+	Error           string `json:"error,omitempty"`
+}
+
+// ReconcileReport 对账报告
+type ReconcileReport struct {
+	RunID           int64           `json:"run_id"`
+	TenantID        string          `json:"tenant_id"`
+	TriggerSource   string          `json:"trigger_source"`
+	DryRun          bool            `json:"dry_run"`
+	Scanned         int             `json:"scanned"`
+	EventsScanned   int             `json:"events_scanned"`
+	Mismatches      int             `json:"mismatches"`
+	Corrected       int             `json:"corrected"`
+	Errors          int             `json:"errors"`
+	EventCheckpoint int64           `json:"event_checkpoint"`
+	StartedAt       time.Time       `json:"started_at"`
+	FinishedAt      time.Time       `json:"finished_at"`
+	Items           []ReconcileItem `json:"items"`
+}
+
+// reconcileSettings 对账配置（已填充默认值）
+type reconcileSettings struct {
+	staleAfter    time.Duration
+	maxAge        time.Duration
+	batchSize     int
+	useEvents     bool
+	eventLookback time.Duration
+}
+
+// getReconcileSettings 读取对账配置，未设置的项使用默认值
+func (s *PaymentService) getReconcileSettings() reconcileSettings {
+	cfg := s.cfg.Reconciliation
+	settings := reconcileSettings{
+		staleAfter:    defaultReconcileStaleAfter,
+		maxAge:        defaultReconcileMaxAge,
+		batchSize:     defaultReconcileBatchSize,
+		useEvents:     cfg.UseEvents,
+		eventLookback: defaultReconcileEventLookback,
+	}
+	if cfg.StaleAfter > 0 {
+		settings.staleAfter = time.Duration(cfg.StaleAfter) * time.Second
+	}
+	if cfg.MaxAge > 0 {
+		settings.maxAge = time.Duration(cfg.MaxAge) * time.Second
+	}
+	if cfg.BatchSize > 0 {
+		settings.batchSize = cfg.BatchSize
+	}
+	if cfg.EventLookback > 0 {
+		settings.eventLookback = time.Duration(cfg.EventLookback) * time.Second
+	}
+	return settings
+}
+
+// tenantReconcileRun 一个租户的一次对账运行
+type tenantReconcileRun struct {
+	ctx    context.Context // 带租户的 context
+	report *ReconcileReport
+	seen   map[string]bool // 同一个 PaymentIntent 在一次运行中只核对一次
+	err    error
+}
+
+// newTenantReconcileRun 为 context 中的租户开始一次对账运行
+func newTenantReconcileRun(ctx context.Context, opts ReconcileOptions) *tenantReconcileRun {
+	return &tenantReconcileRun{
+		ctx: ctx,
+		report: &ReconcileReport{
+			TenantID:      tenant.ID(ctx),
+			TriggerSource: opts.TriggerSource,
+			DryRun:        opts.DryRun,
+			StartedAt:     time.Now(),
+		},
+		seen: make(map[string]bool),
+	}
+}
+
+// ReconcileAllTenants 对每个启用的租户执行对账（后台任务使用），单个租户失败不影响其他租户。
+// Stripe 事件不区分租户，每个账户只拉取一次，按记录所属租户分发核对
+func (s *PaymentService) ReconcileAllTenants(ctx context.Context, opts ReconcileOptions) error {
+	if db.DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	tenants, err := db.ListTenants(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	settings := s.getReconcileSettings()
+	runs := make(map[string]*tenantReconcileRun, len(tenants))
+	for _, t := range tenants {
+		runs[t.ID] = newTenantReconcileRun(tenant.WithID(ctx, t.ID), opts)
+	}
+	s.reconcileEvents(ctx, settings, opts, runs)
+
+	var errs []error
+	for _, t := range tenants {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.finishReconcileRun(settings, opts, runs[t.ID]); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", t.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReconcilePayments 对比 context 中租户的 payment_history 与 Stripe 中的 PaymentIntent 状态，并通过 Webhook 相同的更新路径修正不一致
+// 1. 可选：从该租户上次的检查点开始拉取 Stripe 事件，按每个 PaymentIntent 的最新事件核对（其他租户的记录跳过）
+// 2. 扫描超过阈值未更新的非终态记录，逐条从 Stripe 获取 PaymentIntent 核对
+func (s *PaymentService) ReconcilePayments(ctx context.Context, opts ReconcileOptions) (*ReconcileReport, error) {
+	if db.DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	settings := s.getReconcileSettings()
+	run := newTenantReconcileRun(ctx, opts)
+	s.reconcileEvents(ctx, settings, opts, map[string]*tenantReconcileRun{run.report.TenantID: run})
+	err := s.finishReconcileRun(settings, opts, run)
+	return run.report, err
+}
+
+// reconcileEvents 启用事件核对时拉取事件并分发到各租户的运行，拉取失败时计入每个租户的报告
+func (s *PaymentService) reconcileEvents(ctx context.Context, settings reconcileSettings, opts ReconcileOptions, runs map[string]*tenantReconcileRun) {
+	if !settings.useEvents || len(runs) == 0 {
+		return
+	}
+	if err := s.reconcileFromEvents(ctx, settings, opts, runs); err != nil {
+		zap.L().Warn("Reconciliation: failed to list Stripe events", zap.Error(err))
+		for _, run := range runs {
+			run.report.Errors++
+			run.err = err
+		}
+	}
+}
+
+// finishReconcileRun 扫描租户的超时记录，保存报告并记录指标，返回本次运行的错误
+func (s *PaymentService) finishReconcileRun(settings reconcileSettings, opts ReconcileOptions, run *tenantReconcileRun) error {
+	ctx, report := run.ctx, run.report
+	if err := s.reconcileStalePayments(ctx, settings, opts, report, run.seen); err != nil {
+		zap.L().Warn("Reconciliation: failed to scan stale payments", zap.Error(err))
+		report.Errors++
+		run.err = err
+	}
+
+	report.FinishedAt = time.Now()
+
+	if err := s.saveReconcileReport(ctx, report); err != nil {
+		zap.L().Warn("Reconciliation: failed to save report", zap.Error(err))
+	}
+
+	status := "success"
+	if run.err != nil {
+		status = "error"
+	}
+	common.RecordReconciliationRun(status, report.Mismatches)
+
+	zap.L().Info("Reconciliation completed",
+		zap.Int64("run_id", report.RunID),
+		zap.String("tenant_id", report.TenantID),
+		zap.String("trigger", report.TriggerSource),
+		zap.Bool("dry_run", report.DryRun),
+		zap.Int("scanned", report.Scanned),
+		zap.Int("events_scanned", report.EventsScanned),
+		zap.Int("mismatches", report.Mismatches),
+		zap.Int("corrected", report.Corrected),
+		zap.Int("errors", report.Errors),
+		zap.Duration("duration", report.FinishedAt.Sub(report.StartedAt)))
+
+	return run.err
+}
+
+// reconcileFromEvents 从各租户检查点中最早的一个开始，拉取每个 Stripe 账户（包括测试模式密钥）的 PaymentIntent 事件，
+// 按记录所属租户核对；所有账户都完整读取后才推进各租户的检查点
+func (s *PaymentService) reconcileFromEvents(ctx context.Context, settings reconcileSettings, opts ReconcileOptions, runs map[string]*tenantReconcileRun) error {
+	defaultCheckpoint := time.Now().Add(-settings.eventLookback).Unix()
+	checkpoint := int64(0)
+	for _, run := range runs {
+		tenantCheckpoint, err := db.GetLastEventCheckpoint(run.ctx)
+		if err != nil {
+			return err
+		}
+		if tenantCheckpoint == 0 {
+			tenantCheckpoint = defaultCheckpoint
+		}
+		run.report.EventCheckpoint = tenantCheckpoint
+		if checkpoint == 0 || tenantCheckpoint < checkpoint {
+			checkpoint = tenantCheckpoint
+		}
+	}
+
+	latest := checkpoint
+	var errs []error
+	for _, sc := range stripeAccounts().clients() {
+		accountLatest, err := s.reconcileAccountEvents(ctx, sc, checkpoint, opts, runs)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("stripe account %s (livemode=%t): %w", sc.Account, sc.Livemode, err))
+			continue
+		}
+		if accountLatest > latest {
+			latest = accountLatest
+		}
+	}
+	if len(errs) > 0 {
+		// 未完整读取时不推进检查点，下次从原检查点重新拉取
+		return errors.Join(errs...)
+	}
+
+	for _, run := range runs {
+		if latest > run.report.EventCheckpoint {
+			run.report.EventCheckpoint = latest
+		}
+	}
+	return nil
+}
+
+// reconcileAccountEvents 拉取一个账户从检查点开始的事件，按记录所属租户核对，返回最新事件的创建时间。
+// 报告的 events_scanned 只计入属于该租户的事件
+func (s *PaymentService) reconcileAccountEvents(ctx context.Context, sc *stripeClient, checkpoint int64, opts ReconcileOptions, runs map[string]*tenantReconcileRun) (int64, error) {
+	params := &stripe.EventListParams{
+		CreatedRange: &stripe.RangeQueryParams{GreaterThanOrEqual: checkpoint},
+		Types:        stripe.StringSlice(reconcileEventTypes),
+	}
+	params.Context = ctx
+
+	// 事件按创建时间倒序返回，每个 PaymentIntent 只取最新的事件
+	latest := checkpoint
+	iter := sc.API.Events.List(params)
+	for iter.Next() {
+		evt := iter.Event()
+		if evt.Created > latest {
+			latest = evt.Created
+		}
+
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(evt.Data.Raw, &pi); err != nil {
+			s.addEventReconcileError(runs, ReconcileItem{Source: "event"}, fmt.Errorf("failed to parse event %s: %w", evt.ID, err))
+			continue
+		}
+
+		local, err := db.GetPaymentByIntentIDAnyTenant(ctx, pi.ID)
+		if err != nil {
+			s.addEventReconcileError(runs, ReconcileItem{PaymentIntentID: pi.ID, Source: "event"}, err)
+			continue
+		}
+		if local == nil {
+			// 不是本服务创建的 PaymentIntent
+			continue
+		}
+		run, ok := runs[local.TenantID]
+		if !ok {
+			// 属于未参与本次运行的租户
+			continue
+		}
+		run.report.EventsScanned++
+		if run.seen[pi.ID] {
+			continue
+		}
+		run.seen[pi.ID] = true
+		s.reconcileIntent(run.ctx, "event", local, &pi, opts, run.report)
+	}
+	return latest, iter.Err()
+}
+
+// reconcileStalePayments 扫描超过阈值未更新的非终态记录并逐条核对。
+// 按 (created_at, id) 游标翻页处理完所有记录；核对失败的记录（如所属账户已从配置中删除）按失败次数退避，
+// 不会一直排在最前面挤占其他记录
+func (s *PaymentService) reconcileStalePayments(ctx context.Context, settings reconcileSettings, opts ReconcileOptions, report *ReconcileReport, seen map[string]bool) error {
+	now := time.Now()
+	updatedBefore, createdAfter := now.Add(-settings.staleAfter), now.Add(-settings.maxAge)
+
+	var listErr error
+	err := sweepPayments(ctx, db.SweepJobReconcile, settings.batchSize,
+		func(after *db.PaymentCursor, limit int) ([]db.PaymentHistory, error) {
+			payments, err := db.ListStalePayments(ctx, reconcilableStatuses, updatedBefore, createdAfter, after, limit)
+			listErr = err
+			return payments, err
+		},
+		func(local *db.PaymentHistory) error {
+			if seen[local.PaymentIntentID] {
+				return nil
+			}
+			seen[local.PaymentIntentID] = true
+
+			if err := s.reconcileStalePayment(ctx, local, opts, report); err != nil {
+				s.addReconcileError(report, ReconcileItem{
+					PaymentIntentID: local.PaymentIntentID,
+					PaymentID:       local.PaymentID,
+					Source:          "stale_scan",
+					LocalStatus:     local.Status,
+				}, err)
+				return err
+			}
+			return nil
+		})
+	switch {
+	case listErr != nil:
+		return listErr
+	case ctx.Err() != nil:
+		return ctx.Err()
+	}
+	// 其余是单条记录的核对失败，已计入报告并按失败次数退避，不作为本次扫描的错误
+	if err != nil {
+		zap.L().Debug("Reconciliation: some stale payments failed to reconcile", zap.Error(err))
+	}
+	return nil
+}
+
+// reconcileStalePayment 从 Stripe 获取一条记录的最新状态并核对，状态一致时刷新 updated_at
+func (s *PaymentService) reconcileStalePayment(ctx context.Context, local *db.PaymentHistory, opts ReconcileOptions, report *ReconcileReport) error {
+	if strings.HasPrefix(local.PaymentIntentID, checkoutSessionIDPrefix) {
+		return s.reconcileCheckoutSession(ctx, local, opts, report)
+	}
+
+	sc, err := stripeForPayment(local)
+	if err != nil {
+		return err
+	}
+	params := &stripe.PaymentIntentParams{}
+	params.Context = ctx
+	pi, err := sc.API.PaymentIntents.Get(local.PaymentIntentID, params)
+	if err != nil {
+		return err
+	}
+
+	if !s.reconcileIntent(ctx, "stale_scan", local, pi, opts, report) && !opts.DryRun {
+		db.TouchPaymentHistory(ctx, local.PaymentIntentID)
+	}
+	return nil
+}
+
+// reconcileIntent 核对一条记录，状态不一致时按 Webhook 相同路径修正，返回是否不一致
+func (s *PaymentService) reconcileIntent(ctx context.Context, source string, local *db.PaymentHistory, pi *stripe.PaymentIntent, opts ReconcileOptions, report *ReconcileReport) bool {
+	report.Scanned++
+
+	stripeStatus := string(pi.Status)
+	if local.Status == stripeStatus {
+		return false
+	}
+
+	report.Mismatches++
+	common.RecordReconciliationMismatch(source)
+
+	item := ReconcileItem{
+		PaymentIntentID: local.PaymentIntentID,
+		PaymentID:       local.PaymentID,
+		Source:          source,
+		LocalStatus:     local.Status,
+		StripeStatus:    stripeStatus,
+	}
+
+	zap.L().Warn("Reconciliation: payment status mismatch",
+		zap.String("payment_intent_id", local.PaymentIntentID),
+		zap.String("source", source),
+		zap.String("local_status", local.Status),
+		zap.String("stripe_status", stripeStatus),
+		zap.Bool("dry_run", opts.DryRun))
+
+	if !opts.DryRun {
+		if err := s.syncPaymentIntentStatus(ctx, pi); err != nil {
+			item.Error = err.Error()
+			report.Errors++
+		} else {
+			item.Corrected = true
+			report.Corrected++
+		}
+	}
+
+	s.appendReconcileItem(report, item)
+	return true
+}
+
+// addReconcileError 记录核对失败
+func (s *PaymentService) addReconcileError(report *ReconcileReport, item ReconcileItem, err error) {
+	zap.L().Warn("Reconciliation: failed to check payment",
+		zap.String("payment_intent_id", item.PaymentIntentID),
+		zap.String("source", item.Source),
+		zap.Error(err))
+	report.Errors++
+	item.Error = err.Error()
+	s.appendReconcileItem(report, item)
+}
+
+// addEventReconcileError 记录无法确定所属租户的事件核对失败（计入本次运行所有租户的报告）
+func (s *PaymentService) addEventReconcileError(runs map[string]*tenantReconcileRun, item ReconcileItem, err error) {
+	zap.L().Warn("Reconciliation: failed to check event",
+		zap.String("payment_intent_id", item.PaymentIntentID),
+		zap.Error(err))
+	item.Error = err.Error()
+	for _, run := range runs {
+		run.report.Errors++
+		s.appendReconcileItem(run.report, item)
+	}
+}
+
+// appendReconcileItem 追加报告明细（超过上限后只计数，避免报告过大）
+func (s *PaymentService) appendReconcileItem(report *ReconcileReport, item ReconcileItem) {
+	if len(report.Items) < maxReconcileReportItems {
+		report.Items = append(report.Items, item)
+	}
+}
+
+// saveReconcileReport 保存对账报告到 reconciliation_runs 表
+func (s *PaymentService) saveReconcileReport(ctx context.Context, report *ReconcileReport) error {
+	details := ""
+	if len(report.Items) > 0 {
+		bytes, err := json.Marshal(report.Items)
+		if err == nil {
+			details = string(bytes)
+		}
+	}
+
+	finishedAt := report.FinishedAt
+	run := &db.ReconciliationRun{
+		TriggerSource:   report.TriggerSource,
+		DryRun:          report.DryRun,
+		ScannedCount:    report.Scanned,
+		EventsScanned:   report.EventsScanned,
+		MismatchCount:   report.Mismatches,
+		CorrectedCount:  report.Corrected,
+		ErrorCount:      report.Errors,
+		EventCheckpoint: report.EventCheckpoint,
+		Details:         details,
+		StartedAt:       report.StartedAt,
+		FinishedAt:      &finishedAt,
+	}
+
+	// 运行可能因 ctx 超时结束，保存报告使用独立的上下文
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := db.SaveReconciliationRun(saveCtx, run); err != nil {
+		return err
+	}
+	report.RunID = run.ID
+	return nil
+}
@@ -0,0 +1,312 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"stripe-pay/biz"
+	"stripe-pay/conf"
+	"stripe-pay/db"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 报表默认参数
+const (
+	defaultReportDays         = 30
+	maxReportDays             = 366
+	DefaultRollupInterval     = 10 * time.Minute
+	defaultRollupDays         = 3
+	reportSourceLive          = "live"
+	reportSourceRollup        = "rollup"
+	reportDateLayout          = "2006-01-02"
+	reportRatePrecisionFactor = 10000
+)
+
+// ReportService 报表服务
+type ReportService struct {
+	cfg *conf.Config
+}
+
+// NewReportService 创建报表服务
+func NewReportService() *ReportService {
+	return &ReportService{
+		cfg: conf.GetConf(),
+	}
+}
+
+// ReportQuery 报表查询参数（日期为 YYYY-MM-DD，end 包含当天）
+type ReportQuery struct {
+	Start         string
+	End           string
+	GroupBy       string // 逗号分隔：day, currency, method
+	Currency      string
+	PaymentMethod string
+	Source        string // live, rollup（为空时按配置）
+}
+
+// Report 报表结果
+type Report struct {
+	Name    string                   `json:"report"`
+	Start   string                   `json:"start"`
+	End     string                   `json:"end"`
+	GroupBy []string                 `json:"group_by"`
+	Source  string                   `json:"source"`
+	Columns []string                 `json:"columns"`
+	Rows    []map[string]interface{} `json:"rows"`
+}
+
+// reportDefinition 报表定义：默认分组和指标列
+type reportDefinition struct {
+	defaultGroupBy []string
+	amounts        bool // 含金额列（不同币种的金额不能相加，始终按币种分组）
+	columns        []string
+	values         func(row *db.ReportRow) map[string]interface{}
+}
+
+// reportDefinitions 支持的报表
+var reportDefinitions = map[string]reportDefinition{
+	// 每日收入：成功支付金额、退款金额、净收入
+	"revenue": {
+		defaultGroupBy: []string{db.ReportDimDay, db.ReportDimCurrency},
+		amounts:        true,
+		columns:        []string{"payment_count", "gross_amount", "refund_amount", "net_amount"},
+		values: func(row *db.ReportRow) map[string]interface{} {
+			return map[string]interface{}{
+				"payment_count": row.SucceededCount,
+				"gross_amount":  row.SucceededAmount,
+				"refund_amount": row.RefundAmount,
+				"net_amount":    row.SucceededAmount - row.RefundAmount,
+			}
+		},
+	},
+	// 支付成功率（按支付方式）
+	"success-rate": {
+		defaultGroupBy: []string{db.ReportDimMethod},
+		columns:        []string{"attempts", "succeeded_count", "canceled_count", "success_rate"},
+		values: func(row *db.ReportRow) map[string]interface{} {
+			return map[string]interface{}{
+				"attempts":        row.Attempts,
+				"succeeded_count": row.SucceededCount,
+				"canceled_count":  row.CanceledCount,
+				"success_rate":    ratio(row.SucceededCount, row.Attempts),
+			}
+		},
+	},
+	// 退款率（按笔数和金额）
+	"refund-rate": {
+		defaultGroupBy: []string{db.ReportDimCurrency},
+		amounts:        true,
+		columns:        []string{"succeeded_count", "refunded_payments", "refund_rate", "gross_amount", "refund_amount", "refund_amount_rate"},
+		values: func(row *db.ReportRow) map[string]interface{} {
+			return map[string]interface{}{
+				"succeeded_count":    row.SucceededCount,
+				"refunded_payments":  row.RefundedPayments,
+				"refund_rate":        ratio(row.RefundedPayments, row.SucceededCount),
+				"gross_amount":       row.SucceededAmount,
+				"refund_amount":      row.RefundAmount,
+				"refund_amount_rate": ratio(row.RefundAmount, row.SucceededAmount),
+			}
+		},
+	},
+	// 新用户与老用户
+	"payers": {
+		defaultGroupBy: []string{db.ReportDimDay},
+		columns:        []string{"new_payers", "returning_payers", "total_payers"},
+		values: func(row *db.ReportRow) map[string]interface{} {
+			return map[string]interface{}{
+				"new_payers":       row.NewPayers,
+				"returning_payers": row.ReturningPayers,
+				"total_payers":     row.NewPayers + row.ReturningPayers,
+			}
+		},
+	},
+}
+
+// IsValidReport 判断报表名称是否存在
+func IsValidReport(name string) bool {
+	_, ok := reportDefinitions[name]
+	return ok
+}
+
+// GetReport 生成报表
+func (s *ReportService) GetReport(ctx context.Context, name string, q ReportQuery) (*Report, error) {
+	def, ok := reportDefinitions[name]
+	if !ok {
+		return nil, &biz.ValidationError{Field: "report", Message: "unknown report: " + name}
+	}
+	if db.DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	filter, err := buildReportFilter(q, def.defaultGroupBy, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if def.amounts {
+		filter.GroupBy = withCurrencyDim(filter.GroupBy)
+	}
+
+	source := q.Source
+	if source == "" {
+		source = reportSourceLive
+		if s.cfg.Analytics.UseRollup {
+			source = reportSourceRollup
+		}
+	}
+
+	var rows []db.ReportRow
+	switch source {
+	case reportSourceLive:
+		rows, err = db.QueryReport(ctx, filter)
+	case reportSourceRollup:
+		rows, err = db.QueryReportRollup(ctx, filter)
+	default:
+		return nil, &biz.ValidationError{Field: "source", Message: fmt.Sprintf("invalid source: %s (expected live or rollup)", source)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query report: %w", err)
+	}
+
+	report := &Report{
+		Name:    name,
+		Start:   filter.Start.Format(reportDateLayout),
+		End:     filter.End.AddDate(0, 0, -1).Format(reportDateLayout),
+		GroupBy: filter.GroupBy,
+		Source:  source,
+		Rows:    make([]map[string]interface{}, 0, len(rows)),
+	}
+	report.Columns = append(dimensionColumns(filter.GroupBy), def.columns...)
+
+	for i := range rows {
+		values := def.values(&rows[i])
+		for _, dim := range filter.GroupBy {
+			switch dim {
+			case db.ReportDimDay:
+				values["day"] = rows[i].Day
+			case db.ReportDimCurrency:
+				values["currency"] = rows[i].Currency
+			case db.ReportDimMethod:
+				values["payment_method"] = rows[i].PaymentMethod
+			}
+		}
+		report.Rows = append(report.Rows, values)
+	}
+
+	return report, nil
+}
+
+// RefreshDailyRollup 重新计算最近几天的每日统计汇总
+func (s *ReportService) RefreshDailyRollup(ctx context.Context) error {
+	if db.DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	days := s.cfg.Analytics.RollupDays
+	if days <= 0 {
+		days = defaultRollupDays
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	from := today.AddDate(0, 0, -(days - 1))
+	to := today.AddDate(0, 0, 1)
+
+	affected, err := db.RefreshDailyStats(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	zap.L().Info("Daily stats rollup refreshed",
+		zap.String("from", from.Format(reportDateLayout)),
+		zap.String("to", today.Format(reportDateLayout)),
+		zap.Int64("rows_affected", affected))
+	return nil
+}
+
+// buildReportFilter 解析日期范围和分组参数
+func buildReportFilter(q ReportQuery, defaultGroupBy []string, now time.Time) (db.ReportFilter, error) {
+	filter := db.ReportFilter{
+		Currency:      strings.ToLower(strings.TrimSpace(q.Currency)),
+		PaymentMethod: strings.TrimSpace(q.PaymentMethod),
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	end := today
+	if q.End != "" {
+		parsed, err := time.ParseInLocation(reportDateLayout, q.End, now.Location())
+		if err != nil {
+			return filter, &biz.ValidationError{Field: "end", Message: "invalid end date format, expected YYYY-MM-DD"}
+		}
+		end = parsed
+	}
+	start := end.AddDate(0, 0, -(defaultReportDays - 1))
+	if q.Start != "" {
+		parsed, err := time.ParseInLocation(reportDateLayout, q.Start, now.Location())
+		if err != nil {
+			return filter, &biz.ValidationError{Field: "start", Message: "invalid start date format, expected YYYY-MM-DD"}
+		}
+		start = parsed
+	}
+	if start.After(end) {
+		return filter, &biz.ValidationError{Field: "start", Message: "invalid date range: start is after end"}
+	}
+	if end.Sub(start) >= maxReportDays*24*time.Hour {
+		return filter, &biz.ValidationError{Field: "end", Message: fmt.Sprintf("invalid date range: at most %d days allowed", maxReportDays)}
+	}
+	filter.Start = start
+	filter.End = end.AddDate(0, 0, 1)
+
+	filter.GroupBy = defaultGroupBy
+	if q.GroupBy != "" {
+		filter.GroupBy = nil
+		seen := make(map[string]bool)
+		for _, dim := range strings.Split(q.GroupBy, ",") {
+			dim = strings.TrimSpace(dim)
+			if dim == "" || dim == "none" || seen[dim] {
+				continue
+			}
+			if !db.IsValidReportDim(dim) {
+				return filter, &biz.ValidationError{Field: "group_by", Message: fmt.Sprintf("invalid group_by dimension: %s (expected day, currency or method)", dim)}
+			}
+			seen[dim] = true
+			filter.GroupBy = append(filter.GroupBy, dim)
+		}
+	}
+
+	return filter, nil
+}
+
+// withCurrencyDim 含金额的报表追加币种分组（已按币种分组时原样返回）
+func withCurrencyDim(groupBy []string) []string {
+	for _, dim := range groupBy {
+		if dim == db.ReportDimCurrency {
+			return groupBy
+		}
+	}
+	result := make([]string, 0, len(groupBy)+1)
+	return append(append(result, groupBy...), db.ReportDimCurrency)
+}
+
+// dimensionColumns 分组维度对应的输出列名
+func dimensionColumns(groupBy []string) []string {
+	columns := make([]string, 0, len(groupBy))
+	for _, dim := range groupBy {
+		if dim == db.ReportDimMethod {
+			columns = append(columns, "payment_method")
+		} else {
+			columns = append(columns, dim)
+		}
+	}
+	return columns
+}
+
+// ratio 计算比率（保留 4 位小数，分母为 0 时返回 0）
+func ratio(numerator, denominator int64) float64 {
+	if denominator == 0 {
+		return 0
+	}
+	return math.Round(float64(numerator)/float64(denominator)*reportRatePrecisionFactor) / reportRatePrecisionFactor
+}
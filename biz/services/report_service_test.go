@@ -0,0 +1,99 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestBuildReportFilter 测试报表日期范围和分组参数解析
+func TestBuildReportFilter(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		name        string
+		query       ReportQuery
+		wantStart   string
+		wantEnd     string
+		wantGroupBy []string
+		wantErr     bool
+	}{
+		{
+			name:        "默认最近30天",
+			query:       ReportQuery{},
+			wantStart:   "2024-02-15",
+			wantEnd:     "2024-03-16",
+			wantGroupBy: []string{"day"},
+		},
+		{
+			name:        "指定日期范围和分组",
+			query:       ReportQuery{Start: "2024-01-01", End: "2024-01-31", GroupBy: "currency, method,currency"},
+			wantStart:   "2024-01-01",
+			wantEnd:     "2024-02-01",
+			wantGroupBy: []string{"currency", "method"},
+		},
+		{
+			name:        "不分组",
+			query:       ReportQuery{Start: "2024-01-01", End: "2024-01-01", GroupBy: "none"},
+			wantStart:   "2024-01-01",
+			wantEnd:     "2024-01-02",
+			wantGroupBy: nil,
+		},
+		{"日期格式错误", ReportQuery{Start: "2024/01/01"}, "", "", nil, true},
+		{"开始日期晚于结束日期", ReportQuery{Start: "2024-02-01", End: "2024-01-01"}, "", "", nil, true},
+		{"范围超过上限", ReportQuery{Start: "2022-01-01", End: "2024-01-01"}, "", "", nil, true},
+		{"非法分组维度", ReportQuery{GroupBy: "user_id"}, "", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := buildReportFilter(tt.query, []string{"day"}, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildReportFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := filter.Start.Format(reportDateLayout); got != tt.wantStart {
+				t.Errorf("start = %s, want %s", got, tt.wantStart)
+			}
+			if got := filter.End.Format(reportDateLayout); got != tt.wantEnd {
+				t.Errorf("end = %s, want %s", got, tt.wantEnd)
+			}
+			if !reflect.DeepEqual(filter.GroupBy, tt.wantGroupBy) {
+				t.Errorf("group_by = %v, want %v", filter.GroupBy, tt.wantGroupBy)
+			}
+		})
+	}
+}
+
+// TestWithCurrencyDim 测试含金额报表的币种分组
+func TestWithCurrencyDim(t *testing.T) {
+	tests := []struct {
+		name    string
+		groupBy []string
+		want    []string
+	}{
+		{"不分组", nil, []string{"currency"}},
+		{"按天分组", []string{"day"}, []string{"day", "currency"}},
+		{"已按币种分组", []string{"currency", "method"}, []string{"currency", "method"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withCurrencyDim(tt.groupBy); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withCurrencyDim(%v) = %v, want %v", tt.groupBy, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRatio 测试比率计算
+func TestRatio(t *testing.T) {
+	if got := ratio(1, 3); got != 0.3333 {
+		t.Errorf("ratio(1, 3) = %v, want 0.3333", got)
+	}
+	if got := ratio(5, 0); got != 0 {
+		t.Errorf("ratio(5, 0) = %v, want 0", got)
+	}
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"stripe-pay/cache"
+	"stripe-pay/common"
+	"stripe-pay/conf"
+	"stripe-pay/tenant"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v78"
+	"go.uber.org/zap"
+)
+
+// 支付状态查询结果（指标标签）
+const (
+	StatusLookupHit       = "hit"       // 使用 Stripe 状态缓存
+	StatusLookupMiss      = "miss"      // 查询了 Stripe
+	StatusLookupCoalesced = "coalesced" // 复用了进程内或其他实例正在进行的查询
+)
+
+// 默认查询合并参数
+const (
+	defaultMinRevalidateInterval = 2 * time.Second
+	defaultStatusFetchLockTTL    = 5 * time.Second
+	statusFetchPollInterval      = 50 * time.Millisecond
+)
+
+// intentCall 进程内正在进行的 PaymentIntent 查询
+type intentCall struct {
+	done      chan struct{}
+	intent    *stripe.PaymentIntent
+	coalesced bool
+	err       error
+}
+
+// intentFlightGroup 按 PaymentIntent 合并进程内的并发查询（singleflight）
+type intentFlightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*intentCall
+}
+
+var intentFlights = &intentFlightGroup{calls: make(map[string]*intentCall)}
+
+// do 同一 key 同时只执行一次 fn，其他调用方等待并共享结果；返回的 coalesced 表示结果来自其他调用
+func (g *intentFlightGroup) do(key string, fn func() (*stripe.PaymentIntent, bool, error)) (*stripe.PaymentIntent, bool, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.intent, true, call.err
+	}
+	call := &intentCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.intent, call.coalesced, call.err = fn()
+	return call.intent, call.coalesced, call.err
+}
+
+// statusLookupKey 跨实例合并查询和后台刷新标记的 Redis 键（缓存层再加租户前缀）：
+// 同一 PaymentIntent 在不同账户选择（地区、账户、测试模式）下分别查询，等待方不会拿到未经 stripeForIntent 校验的结果
+func statusLookupKey(ctx context.Context, paymentIntentID string) string {
+	sel := common.StripeSelectionFrom(ctx)
+	return fmt.Sprintf("%s|%s|%t|%s", sel.Region, sel.Account, sel.TestMode, paymentIntentID)
+}
+
+// intentFlightKey 进程内合并查询的 key：租户加 statusLookupKey
+func intentFlightKey(ctx context.Context, paymentIntentID string) string {
+	return tenant.ID(ctx) + "|" + statusLookupKey(ctx, paymentIntentID)
+}
+
+// statusLookupSettings 合并配置和默认值
+func statusLookupSettings(cfg *conf.Config) (minRevalidate, lockTTL time.Duration) {
+	minRevalidate, lockTTL = defaultMinRevalidateInterval, defaultStatusFetchLockTTL
+	if cfg.PaymentStatus.MinRevalidateInterval > 0 {
+		minRevalidate = time.Duration(cfg.PaymentStatus.MinRevalidateInterval) * time.Second
+	}
+	if cfg.PaymentStatus.FetchLockTTL > 0 {
+		lockTTL = time.Duration(cfg.PaymentStatus.FetchLockTTL) * time.Second
+	}
+	return minRevalidate, lockTTL
+}
+
+// LookupPaymentIntent 查询 PaymentIntent 的最新状态，同一 PaymentIntent 的并发查询（包括跨实例）只请求 Stripe 一次。
+// 复用其他实例的结果时只包含 ID、状态、金额和币种。
+// 查询不随发起请求取消，避免发起方断开连接时等待同一结果的其他请求一起失败
+func (s *PaymentService) LookupPaymentIntent(ctx context.Context, paymentIntentID string) (*stripe.PaymentIntent, error) {
+	intent, coalesced, err := intentFlights.do(intentFlightKey(ctx, paymentIntentID), func() (*stripe.PaymentIntent, bool, error) {
+		return s.fetchPaymentIntentShared(context.WithoutCancel(ctx), paymentIntentID)
+	})
+	if coalesced {
+		common.RecordPaymentStatusLookup(StatusLookupCoalesced)
+	} else {
+		common.RecordPaymentStatusLookup(StatusLookupMiss)
+	}
+	return intent, err
+}
+
+// fetchPaymentIntentShared 通过 Redis 锁合并跨实例查询：持锁实例查询 Stripe 并发布结果，其他实例等待结果。
+// 持锁实例在锁超时内未发布结果时自行查询
+func (s *PaymentService) fetchPaymentIntentShared(ctx context.Context, paymentIntentID string) (*stripe.PaymentIntent, bool, error) {
+	if !cache.IsAvailable() {
+		intent, err := s.GetPaymentIntent(ctx, paymentIntentID)
+		return intent, false, err
+	}
+
+	_, lockTTL := statusLookupSettings(s.cfg)
+	lookupKey := statusLookupKey(ctx, paymentIntentID)
+	token := uuid.New().String()
+	deadline := time.Now().Add(lockTTL)
+	for {
+		locked, err := cache.TryLockStatusFetch(ctx, lookupKey, token, lockTTL)
+		if err != nil {
+			break
+		}
+		if locked {
+			defer cache.UnlockStatusFetch(context.WithoutCancel(ctx), lookupKey, token)
+			intent, err := s.GetPaymentIntent(ctx, paymentIntentID)
+			if err == nil {
+				cache.SetStatusFetchResult(context.WithoutCancel(ctx), lookupKey, statusCacheData(intent), lockTTL)
+			}
+			return intent, false, err
+		}
+
+		if data, err := cache.GetStatusFetchResult(ctx, lookupKey); err == nil && data != nil {
+			return intentFromStatusCache(data), true, nil
+		}
+		if time.Now().Add(statusFetchPollInterval).After(deadline) {
+			zap.L().Debug("Timed out waiting for Stripe status fetch on another instance",
+				zap.String("payment_intent_id", paymentIntentID))
+			break
+		}
+
+		timer := time.NewTimer(statusFetchPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, false, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	intent, err := s.GetPaymentIntent(ctx, paymentIntentID)
+	return intent, false, err
+}
+
+// ShouldRevalidate 是否在后台刷新 PaymentIntent 状态：同一 PaymentIntent 在最小间隔内只刷新一次（跨实例）
+func (s *PaymentService) ShouldRevalidate(ctx context.Context, paymentIntentID string) bool {
+	minRevalidate, _ := statusLookupSettings(s.cfg)
+	marked, err := cache.MarkRevalidation(ctx, statusLookupKey(ctx, paymentIntentID), minRevalidate)
+	if err != nil {
+		// Redis 不可用时仍由进程内合并保护 Stripe
+		return true
+	}
+	return marked
+}
+
+// statusCacheData 转换为状态缓存数据
+func statusCacheData(intent *stripe.PaymentIntent) *cache.StripeStatusCacheData {
+	return &cache.StripeStatusCacheData{
+		PaymentIntentID: intent.ID,
+		Status:          string(intent.Status),
+		Amount:          intent.Amount,
+		Currency:        string(intent.Currency),
+		CachedAt:        time.Now().Format(time.RFC3339),
+	}
+}
+
+// intentFromStatusCache 从状态缓存数据还原 PaymentIntent（只包含状态查询需要的字段）
+func intentFromStatusCache(data *cache.StripeStatusCacheData) *stripe.PaymentIntent {
+	return &stripe.PaymentIntent{
+		ID:       data.PaymentIntentID,
+		Status:   stripe.PaymentIntentStatus(data.Status),
+		Amount:   data.Amount,
+		Currency: stripe.Currency(data.Currency),
+	}
+}
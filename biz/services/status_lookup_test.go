@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"stripe-pay/common"
+	"stripe-pay/tenant"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+)
+
+// TestIntentFlightGroup 测试同一 PaymentIntent 的并发查询只执行一次
+func TestIntentFlightGroup(t *testing.T) {
+	g := &intentFlightGroup{calls: make(map[string]*intentCall)}
+	release := make(chan struct{})
+	var calls int32
+	fn := func() (*stripe.PaymentIntent, bool, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &stripe.PaymentIntent{ID: "pi_123", Status: stripe.PaymentIntentStatusProcessing}, false, nil
+	}
+
+	const callers = 5
+	var wg, started sync.WaitGroup
+	var coalesced int32
+	call := func() {
+		defer wg.Done()
+		started.Done()
+		intent, shared, err := g.do("pi_123", fn)
+		if err != nil || intent.ID != "pi_123" {
+			t.Errorf("do() = %v, %v, want pi_123", intent, err)
+		}
+		if shared {
+			atomic.AddInt32(&coalesced, 1)
+		}
+	}
+
+	// 第一个调用方开始查询后，其他调用方加入等待
+	wg.Add(callers)
+	started.Add(callers)
+	go call()
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i < callers; i++ {
+		go call()
+	}
+	started.Wait()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 || coalesced != callers-1 {
+		t.Errorf("fn called %d times with %d coalesced, want 1 call and %d coalesced", calls, coalesced, callers-1)
+	}
+
+	// 查询结束后不再复用结果，错误同样返回给调用方
+	wantErr := errors.New("stripe down")
+	_, shared, err := g.do("pi_123", func() (*stripe.PaymentIntent, bool, error) { return nil, false, wantErr })
+	if err != wantErr || shared {
+		t.Errorf("do() after completion = %v, shared %v, want %v, not shared", err, shared, wantErr)
+	}
+	if len(g.calls) != 0 {
+		t.Errorf("calls not cleaned up: %d left", len(g.calls))
+	}
+}
+
+// TestIntentFlightKey 测试合并查询按租户和账户选择区分
+func TestIntentFlightKey(t *testing.T) {
+	base := context.Background()
+	key := intentFlightKey(base, "pi_123")
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+	}{
+		{"不同租户", tenant.WithID(base, "acme")},
+		{"不同地区", common.WithStripeSelection(base, common.StripeSelection{Region: "HK"})},
+		{"不同账户", common.WithStripeSelection(base, common.StripeSelection{Account: "acme-hk"})},
+		{"测试模式", common.WithStripeSelection(base, common.StripeSelection{TestMode: true})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := intentFlightKey(tt.ctx, "pi_123"); got == key {
+				t.Errorf("intentFlightKey() = %q, want different from default %q", got, key)
+			}
+		})
+	}
+
+	if got := intentFlightKey(base, "pi_123"); got != key {
+		t.Errorf("intentFlightKey() = %q, want stable %q", got, key)
+	}
+}
+
+// TestStatusLookupKey 测试跨实例合并查询的 Redis 键按账户选择区分
+func TestStatusLookupKey(t *testing.T) {
+	base := context.Background()
+	key := statusLookupKey(base, "pi_123")
+
+	selections := []common.StripeSelection{
+		{Region: "HK"},
+		{Account: "acme-hk"},
+		{TestMode: true},
+	}
+	for _, sel := range selections {
+		if got := statusLookupKey(common.WithStripeSelection(base, sel), "pi_123"); got == key {
+			t.Errorf("statusLookupKey(%+v) = %q, want different from default %q", sel, got, key)
+		}
+	}
+}
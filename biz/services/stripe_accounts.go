@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"stripe-pay/common"
+	"stripe-pay/conf"
+	"stripe-pay/db"
+	"stripe-pay/tenant"
+	"sync/atomic"
+
+	"github.com/stripe/stripe-go/v78/client"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrStripeAccountNotFound 记录或请求中的 Stripe 账户不在当前配置中
+	ErrStripeAccountNotFound = errors.New("stripe account not configured")
+	// ErrStripeTestModeUnavailable 账户没有配置测试模式密钥
+	ErrStripeTestModeUnavailable = errors.New("stripe test mode not configured for account")
+)
+
+// stripeClient 一个 Stripe 账户在某个模式下的客户端
+type stripeClient struct {
+	API      *client.API
+	Account  string // 账户标识
+	Livemode bool   // 密钥是否为生产模式
+	legacy   bool   // 默认账户的主密钥：迁移前未记录账户和模式的数据都属于它
+}
+
+// paymentAccount 写入 payment_history 的账户和模式
+func (sc *stripeClient) paymentAccount() db.PaymentAccount {
+	livemode := sc.Livemode
+	return db.PaymentAccount{StripeAccount: sc.Account, Livemode: &livemode}
+}
+
+// newPaymentAccount 新建支付记录的账户和模式：请求通过 X-Stripe-Mode 选择测试模式时标记为测试支付（不计入权益和报表）。
+// 不能只看 Livemode：主密钥为测试密钥的部署（如预发环境），普通支付同样是 livemode=false
+func (sc *stripeClient) newPaymentAccount(ctx context.Context) db.PaymentAccount {
+	account := sc.paymentAccount()
+	account.TestMode = common.StripeSelectionFrom(ctx).TestMode
+	return account
+}
+
+// stripeAccountEntry 一个账户的配置和客户端
+type stripeAccountEntry struct {
+	config  conf.StripeAccount
+	primary *stripeClient // 主密钥
+	test    *stripeClient // 测试模式（主密钥本身是测试密钥时与 primary 相同；未配置时为 nil）
+}
+
+// stripeRegistry 按配置快照构建的账户集合，配置重新加载后重建
+type stripeRegistry struct {
+	cfg      *conf.Config
+	accounts map[string]*stripeAccountEntry
+	ids      []string // 配置顺序，default 在最前
+}
+
+var currentStripeRegistry atomic.Pointer[stripeRegistry]
+
+// stripeAccounts 返回当前配置对应的账户集合（密钥轮换或增删账户后重新加载配置即可生效）
+func stripeAccounts() *stripeRegistry {
+	cfg := conf.GetConf()
+	if r := currentStripeRegistry.Load(); r != nil && r.cfg == cfg {
+		return r
+	}
+	// client.API 使用创建时的全局后端，先配置好超时、重试和熔断
+	initUpstreams(cfg)
+	r := newStripeRegistry(cfg)
+	currentStripeRegistry.Store(r)
+	return r
+}
+
+// isLiveStripeKey 是否为生产模式密钥
+func isLiveStripeKey(key string) bool {
+	return strings.HasPrefix(key, "sk_live_") || strings.HasPrefix(key, "rk_live_")
+}
+
+func newStripeClient(account, key string) *stripeClient {
+	api := &client.API{}
+	api.Init(key, nil)
+	return &stripeClient{API: api, Account: account, Livemode: isLiveStripeKey(key)}
+}
+
+func newStripeRegistry(cfg *conf.Config) *stripeRegistry {
+	r := &stripeRegistry{cfg: cfg, accounts: map[string]*stripeAccountEntry{}}
+	add := func(id string, account conf.StripeAccount) {
+		account.ID = id
+		entry := &stripeAccountEntry{config: account, primary: newStripeClient(id, account.SecretKey)}
+		switch {
+		case account.TestSecretKey != "":
+			entry.test = newStripeClient(id, account.TestSecretKey)
+		case !entry.primary.Livemode:
+			entry.test = entry.primary
+		}
+		r.accounts[id] = entry
+		r.ids = append(r.ids, id)
+	}
+
+	add(db.DefaultStripeAccount, cfg.Stripe.StripeAccount)
+	r.accounts[db.DefaultStripeAccount].primary.legacy = true
+	for _, account := range cfg.Stripe.Accounts {
+		add(account.ID, account)
+	}
+	return r
+}
+
+// account 按标识查找账户（空标识为默认账户）
+func (r *stripeRegistry) account(id string) (*stripeAccountEntry, error) {
+	if id == "" {
+		id = db.DefaultStripeAccount
+	}
+	entry, ok := r.accounts[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrStripeAccountNotFound, id)
+	}
+	return entry, nil
+}
+
+// resolve 按请求选择账户：租户配置的账户优先，其次按租户和地区匹配，都不匹配时使用默认账户
+func (r *stripeRegistry) resolve(sel common.StripeSelection) (*stripeAccountEntry, error) {
+	if sel.Account != "" {
+		// 租户指定的账户不在配置中时报错，不退回默认账户（避免款项进入其他账户）
+		return r.account(sel.Account)
+	}
+	if sel.Tenant != "" {
+		for _, id := range r.ids {
+			if containsFold(r.accounts[id].config.Tenants, sel.Tenant) {
+				return r.accounts[id], nil
+			}
+		}
+	}
+	if sel.Region != "" {
+		for _, id := range r.ids {
+			if containsFold(r.accounts[id].config.Regions, sel.Region) {
+				return r.accounts[id], nil
+			}
+		}
+	}
+	return r.accounts[db.DefaultStripeAccount], nil
+}
+
+// selectClient 新建支付等操作使用的客户端
+func (r *stripeRegistry) selectClient(sel common.StripeSelection) (*stripeClient, error) {
+	entry, err := r.resolve(sel)
+	if err != nil {
+		return nil, err
+	}
+	if !sel.TestMode {
+		return entry.primary, nil
+	}
+	if entry.test == nil {
+		return nil, fmt.Errorf("%w: %s", ErrStripeTestModeUnavailable, entry.config.ID)
+	}
+	return entry.test, nil
+}
+
+// selectionMismatch 已有记录与请求的选择不一致的原因（一致时为空）：
+// 记录的模式必须与请求选中的客户端一致（主密钥为测试密钥的账户，普通请求即为测试模式）；
+// 租户指定或按租户匹配到账户时，记录必须属于该账户
+func (r *stripeRegistry) selectionMismatch(sel common.StripeSelection, ph *db.PaymentHistory) string {
+	if ph.Livemode != nil {
+		sc, err := r.selectClient(sel)
+		if err != nil {
+			return err.Error()
+		}
+		if *ph.Livemode != sc.Livemode {
+			return "livemode mismatch"
+		}
+	}
+	pinned := sel.Account
+	if pinned == "" && sel.Tenant != "" {
+		for _, id := range r.ids {
+			if containsFold(r.accounts[id].config.Tenants, sel.Tenant) {
+				pinned = id
+				break
+			}
+		}
+	}
+	account := ph.StripeAccount
+	if account == "" {
+		account = db.DefaultStripeAccount
+	}
+	if pinned != "" && pinned != account {
+		return "stripe account mismatch"
+	}
+	return ""
+}
+
+// clientFor 已有记录使用的客户端：按记录的账户和模式选择；模式未知（迁移前的记录）时使用主密钥
+func (r *stripeRegistry) clientFor(account string, livemode *bool) (*stripeClient, error) {
+	entry, err := r.account(account)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case livemode == nil || *livemode == entry.primary.Livemode:
+		return entry.primary, nil
+	case !*livemode && entry.test != nil:
+		return entry.test, nil
+	}
+	return nil, fmt.Errorf("%w: %s has no key for livemode=%t", ErrStripeAccountNotFound, entry.config.ID, *livemode)
+}
+
+// clients 所有不同的客户端（对账时逐个拉取事件）
+func (r *stripeRegistry) clients() []*stripeClient {
+	var clients []*stripeClient
+	for _, id := range r.ids {
+		entry := r.accounts[id]
+		clients = append(clients, entry.primary)
+		if entry.test != nil && entry.test != entry.primary {
+			clients = append(clients, entry.test)
+		}
+	}
+	return clients
+}
+
+func containsFold(values []string, target string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, target) {
+			return true
+		}
+	}
+	return false
+}
+
+type stripeClientKey struct{}
+
+// withStripeClient 固定后续操作使用的客户端（Webhook 和事件重放按事件所属账户处理）
+func withStripeClient(ctx context.Context, sc *stripeClient) context.Context {
+	return context.WithValue(ctx, stripeClientKey{}, sc)
+}
+
+// stripeFor 当前请求使用的 Stripe 客户端：已固定客户端时直接使用，
+// 否则按地区、租户和测试模式选择（由 common.StripeAccountMiddleware 解析）
+func stripeFor(ctx context.Context) (*stripeClient, error) {
+	if sc, ok := ctx.Value(stripeClientKey{}).(*stripeClient); ok {
+		return sc, nil
+	}
+	return stripeAccounts().selectClient(common.StripeSelectionFrom(ctx))
+}
+
+// stripeForPayment 已有支付记录所属账户的客户端
+func stripeForPayment(ph *db.PaymentHistory) (*stripeClient, error) {
+	if ph == nil {
+		return stripeAccounts().clientFor("", nil)
+	}
+	return stripeAccounts().clientFor(ph.StripeAccount, ph.Livemode)
+}
+
+// stripeForIntent 按 PaymentIntent ID 查找当前租户记录所属账户的客户端；本地没有记录时使用当前请求选择的账户。
+// 记录属于其他租户，或与请求的模式、租户指定的账户不一致时返回 ErrPaymentNotFound（不能凭 pi_ ID 操作其他租户或账户的支付）
+func stripeForIntent(ctx context.Context, paymentIntentID string) (*stripeClient, error) {
+	if db.DB == nil {
+		return stripeFor(ctx)
+	}
+
+	ph, err := db.GetPaymentByIntentID(ctx, paymentIntentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+	if ph == nil {
+		other, err := db.GetPaymentByIntentIDAnyTenant(ctx, paymentIntentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get payment: %w", err)
+		}
+		if other != nil {
+			zap.L().Warn("Payment intent belongs to another tenant",
+				zap.String("payment_intent_id", paymentIntentID),
+				zap.String("tenant_id", tenant.ID(ctx)))
+			return nil, ErrPaymentNotFound
+		}
+		return stripeFor(ctx)
+	}
+
+	if reason := stripeAccounts().selectionMismatch(common.StripeSelectionFrom(ctx), ph); reason != "" {
+		zap.L().Warn("Payment intent does not match the request's stripe account",
+			zap.String("payment_intent_id", paymentIntentID),
+			zap.String("reason", reason))
+		return nil, ErrPaymentNotFound
+	}
+	return stripeForPayment(ph)
+}
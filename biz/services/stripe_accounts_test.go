@@ -0,0 +1,141 @@
+package services
+
+import (
+	"errors"
+	"stripe-pay/common"
+	"stripe-pay/conf"
+	"stripe-pay/db"
+	"testing"
+)
+
+func newTestStripeRegistry() *stripeRegistry {
+	cfg := &conf.Config{}
+	cfg.Stripe.SecretKey = "sk_live_default"
+	cfg.Stripe.TestSecretKey = "sk_test_default"
+	cfg.Stripe.Accounts = []conf.StripeAccount{
+		{ID: "hk", SecretKey: "sk_live_hk", Regions: []string{"HK", "MO"}},
+		{ID: "acme", SecretKey: "sk_test_acme", Tenants: []string{"acme"}, Regions: []string{"US"}},
+	}
+	return newStripeRegistry(cfg)
+}
+
+// TestSelectStripeClient 测试按租户、地区和测试模式选择账户
+func TestSelectStripeClient(t *testing.T) {
+	r := newTestStripeRegistry()
+
+	tests := []struct {
+		name         string
+		sel          common.StripeSelection
+		wantAccount  string
+		wantLivemode bool
+		wantErr      error
+	}{
+		{"默认账户", common.StripeSelection{}, "default", true, nil},
+		{"按地区", common.StripeSelection{Region: "hk"}, "hk", true, nil},
+		{"未配置的地区", common.StripeSelection{Region: "JP"}, "default", true, nil},
+		{"租户优先于地区", common.StripeSelection{Region: "HK", Tenant: "acme"}, "acme", false, nil},
+		{"默认账户测试模式", common.StripeSelection{TestMode: true}, "default", false, nil},
+		{"主密钥为测试密钥", common.StripeSelection{Tenant: "acme", TestMode: true}, "acme", false, nil},
+		{"未配置测试密钥", common.StripeSelection{Region: "HK", TestMode: true}, "", false, ErrStripeTestModeUnavailable},
+		{"租户指定账户", common.StripeSelection{Region: "US", Tenant: "acme", Account: "hk"}, "hk", true, nil},
+		{"租户指定的账户未配置", common.StripeSelection{Account: "jp"}, "", false, ErrStripeAccountNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := r.selectClient(tt.sel)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("selectClient() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if sc.Account != tt.wantAccount || sc.Livemode != tt.wantLivemode {
+				t.Errorf("selectClient() = %s (livemode=%t), want %s (livemode=%t)", sc.Account, sc.Livemode, tt.wantAccount, tt.wantLivemode)
+			}
+		})
+	}
+}
+
+// TestStripeClientFor 测试已有记录按账户和模式选择客户端
+func TestStripeClientFor(t *testing.T) {
+	r := newTestStripeRegistry()
+	live, test := true, false
+
+	tests := []struct {
+		name         string
+		account      string
+		livemode     *bool
+		wantAccount  string
+		wantLivemode bool
+		wantLegacy   bool
+		wantErr      bool
+	}{
+		{"迁移前的记录", "", nil, "default", true, true, false},
+		{"默认账户生产模式", "default", &live, "default", true, true, false},
+		{"默认账户测试模式", "default", &test, "default", false, false, false},
+		{"其他账户", "hk", &live, "hk", true, false, false},
+		{"没有测试密钥", "hk", &test, "", false, false, true},
+		{"只有测试密钥", "acme", &live, "", false, false, true},
+		{"账户已从配置中删除", "jp", nil, "", false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := r.clientFor(tt.account, tt.livemode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("clientFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if sc.Account != tt.wantAccount || sc.Livemode != tt.wantLivemode || sc.legacy != tt.wantLegacy {
+				t.Errorf("clientFor() = %s (livemode=%t, legacy=%t), want %s (livemode=%t, legacy=%t)",
+					sc.Account, sc.Livemode, sc.legacy, tt.wantAccount, tt.wantLivemode, tt.wantLegacy)
+			}
+		})
+	}
+}
+
+// TestStripeSelectionMismatch 测试按 pi_ ID 操作已有支付时校验请求的模式和账户
+func TestStripeSelectionMismatch(t *testing.T) {
+	r := newTestStripeRegistry()
+	live, test := true, false
+
+	tests := []struct {
+		name    string
+		sel     common.StripeSelection
+		payment db.PaymentHistory
+		want    bool
+	}{
+		{"生产模式支付", common.StripeSelection{}, db.PaymentHistory{StripeAccount: "default", Livemode: &live}, true},
+		{"模式未知的旧记录", common.StripeSelection{TestMode: true}, db.PaymentHistory{}, true},
+		{"测试模式请求操作生产模式支付", common.StripeSelection{TestMode: true}, db.PaymentHistory{Livemode: &live}, false},
+		{"生产模式请求操作测试模式支付", common.StripeSelection{}, db.PaymentHistory{Livemode: &test}, false},
+		{"未指定账户的租户可以操作按地区选择的账户", common.StripeSelection{Tenant: "other"}, db.PaymentHistory{StripeAccount: "hk", Livemode: &live}, true},
+		{"租户匹配的账户", common.StripeSelection{Tenant: "acme", TestMode: true}, db.PaymentHistory{StripeAccount: "acme", Livemode: &test}, true},
+		{"主密钥为测试密钥的账户", common.StripeSelection{Tenant: "acme"}, db.PaymentHistory{StripeAccount: "acme", Livemode: &test}, true},
+		{"主密钥为测试密钥的账户不能操作生产模式支付", common.StripeSelection{Tenant: "acme"}, db.PaymentHistory{StripeAccount: "acme", Livemode: &live}, false},
+		{"测试模式未配置", common.StripeSelection{Region: "HK", TestMode: true}, db.PaymentHistory{StripeAccount: "hk", Livemode: &test}, false},
+		{"租户匹配到其他账户", common.StripeSelection{Tenant: "acme"}, db.PaymentHistory{StripeAccount: "hk", Livemode: &live}, false},
+		{"租户指定账户", common.StripeSelection{Account: "hk"}, db.PaymentHistory{StripeAccount: "hk", Livemode: &live}, true},
+		{"租户指定其他账户", common.StripeSelection{Account: "hk"}, db.PaymentHistory{Livemode: &live}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := r.selectionMismatch(tt.sel, &tt.payment)
+			if (reason == "") != tt.want {
+				t.Errorf("selectionMismatch() = %q, want match %v", reason, tt.want)
+			}
+		})
+	}
+
+	// 默认密钥为测试密钥的部署（如预发环境），普通请求可以操作自己的测试模式支付
+	cfg := &conf.Config{}
+	cfg.Stripe.SecretKey = "sk_test_staging"
+	staging := newStripeRegistry(cfg)
+	if reason := staging.selectionMismatch(common.StripeSelection{}, &db.PaymentHistory{StripeAccount: "default", Livemode: &test}); reason != "" {
+		t.Errorf("selectionMismatch() with test primary key = %q, want match", reason)
+	}
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"stripe-pay/db"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 扫描任务中处理失败的记录的重试退避：1 分钟起每次翻倍，最长 1 小时
+const (
+	sweepRetryBaseDelay = time.Minute
+	sweepRetryMaxDelay  = time.Hour
+)
+
+// sweepPayments 按 (created_at, id) 游标分页扫描 list 返回的支付记录并逐条调用 handle，一次运行处理完所有到期记录。
+// 失败的记录写入 sweep_failures 并按失败次数退避，退避期间 list 不再返回，不会让后面的记录一直排不上；成功后清除失败记录
+func sweepPayments(ctx context.Context, job string, batchSize int,
+	list func(after *db.PaymentCursor, limit int) ([]db.PaymentHistory, error),
+	handle func(payment *db.PaymentHistory) error) error {
+	var errs []error
+	var after *db.PaymentCursor
+	for {
+		payments, err := list(after, batchSize)
+		if err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+
+		for i := range payments {
+			if err := ctx.Err(); err != nil {
+				return errors.Join(append(errs, err)...)
+			}
+			payment := &payments[i]
+			if err := handle(payment); err != nil {
+				errs = append(errs, err)
+				if recordErr := db.RecordSweepFailure(ctx, job, payment.ID, err, sweepRetryBaseDelay, sweepRetryMaxDelay); recordErr != nil {
+					errs = append(errs, recordErr)
+				}
+				continue
+			}
+			_ = db.ClearSweepFailure(ctx, job, payment.ID)
+		}
+
+		if len(payments) < batchSize {
+			break
+		}
+		last := payments[len(payments)-1]
+		after = &db.PaymentCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+
+	if len(errs) > 0 {
+		zap.L().Warn("Sweep finished with failures", zap.String("job", job), zap.Int("failed", len(errs)))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"stripe-pay/biz"
+	"stripe-pay/biz/models"
+	"stripe-pay/db"
+	"stripe-pay/tenant"
+)
+
+// ErrTenantNotFound 租户不存在
+var ErrTenantNotFound = errors.New("tenant not found")
+
+// tenantIDPattern 租户标识：小写字母、数字、- 和 _（会出现在缓存键和日志中）
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// CreateTenant 创建租户并生成 API Key（明文只在返回值中出现一次，数据库只保存哈希）
+func (s *PaymentService) CreateTenant(ctx context.Context, req *models.CreateTenantRequest) (*tenant.Tenant, string, error) {
+	if db.DB == nil {
+		return nil, "", fmt.Errorf("database not available")
+	}
+
+	t := &tenant.Tenant{
+		ID:            strings.TrimSpace(req.ID),
+		Name:          strings.TrimSpace(req.Name),
+		Hosts:         req.Hosts,
+		StripeAccount: strings.TrimSpace(req.StripeAccount),
+		AppleBundleID: strings.TrimSpace(req.AppleBundleID),
+		Currency:      req.Currency,
+		Receipt:       req.Receipt,
+		Active:        true,
+	}
+	if !tenantIDPattern.MatchString(t.ID) {
+		return nil, "", &biz.ValidationError{Field: "id", Message: "id must be 1-64 lowercase letters, digits, '-' or '_'"}
+	}
+	if err := s.validateTenant(ctx, t); err != nil {
+		return nil, "", err
+	}
+
+	existing, err := db.GetTenant(ctx, t.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	if existing != nil {
+		return nil, "", fmt.Errorf("tenant %s already exists", t.ID)
+	}
+
+	key, hash, err := tenant.NewAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	t.APIKeyHash = hash
+	if err := db.CreateTenant(ctx, t); err != nil {
+		return nil, "", err
+	}
+
+	created, err := db.GetTenant(ctx, t.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	return created, key, nil
+}
+
+// UpdateTenant 更新租户配置（未提供的字段保持不变）
+func (s *PaymentService) UpdateTenant(ctx context.Context, id string, req *models.UpdateTenantRequest) (*tenant.Tenant, error) {
+	if db.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	t, err := db.GetTenant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrTenantNotFound
+	}
+
+	if req.Name != nil {
+		t.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Hosts != nil {
+		t.Hosts = *req.Hosts
+	}
+	if req.StripeAccount != nil {
+		t.StripeAccount = strings.TrimSpace(*req.StripeAccount)
+	}
+	if req.AppleBundleID != nil {
+		t.AppleBundleID = strings.TrimSpace(*req.AppleBundleID)
+	}
+	if req.Currency != nil {
+		t.Currency = *req.Currency
+	}
+	if req.Receipt != nil {
+		t.Receipt = req.Receipt
+	}
+	if req.Active != nil {
+		t.Active = *req.Active
+	}
+	if err := s.validateTenant(ctx, t); err != nil {
+		return nil, err
+	}
+
+	if _, err := db.UpdateTenant(ctx, t); err != nil {
+		return nil, err
+	}
+	return db.GetTenant(ctx, id)
+}
+
+// RotateTenantAPIKey 为租户生成新的 API Key，旧 Key 立即失效
+func (s *PaymentService) RotateTenantAPIKey(ctx context.Context, id string) (string, error) {
+	if db.DB == nil {
+		return "", fmt.Errorf("database not available")
+	}
+
+	key, hash, err := tenant.NewAPIKey()
+	if err != nil {
+		return "", err
+	}
+	found, err := db.SetTenantAPIKeyHash(ctx, id, hash)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", ErrTenantNotFound
+	}
+	return key, nil
+}
+
+// validateTenant 校验租户配置并规范化 Host 和币种，参数非法时返回 *biz.ValidationError
+func (s *PaymentService) validateTenant(ctx context.Context, t *tenant.Tenant) error {
+	if t.Name == "" {
+		return &biz.ValidationError{Field: "name", Message: "name is required"}
+	}
+	if t.ID == tenant.DefaultID && !t.Active {
+		return &biz.ValidationError{Field: "active", Message: "the default tenant cannot be deactivated"}
+	}
+
+	t.Currency = strings.ToLower(strings.TrimSpace(t.Currency))
+	if t.Currency == "" {
+		t.Currency = tenant.DefaultCurrency
+	}
+	if err := biz.ValidateCurrency(t.Currency); err != nil {
+		return err
+	}
+
+	if r := t.Receipt; r != nil {
+		if r.TaxRate != nil && (*r.TaxRate < 0 || *r.TaxRate >= 100) {
+			return &biz.ValidationError{Field: "receipt.tax_rate", Message: "receipt.tax_rate must be between 0 and 100"}
+		}
+		if r.Company != nil && strings.TrimSpace(r.Company.Name) == "" {
+			return &biz.ValidationError{Field: "receipt.company.name", Message: "receipt.company.name is required when company is set"}
+		}
+	}
+
+	if t.StripeAccount != "" {
+		if _, err := stripeAccounts().account(t.StripeAccount); err != nil {
+			return &biz.ValidationError{Field: "stripe_account", Message: "stripe_account is not configured: " + t.StripeAccount}
+		}
+	}
+
+	hosts, err := normalizeTenantHosts(t.Hosts)
+	if err != nil {
+		return err
+	}
+	t.Hosts = hosts
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	// 同一个 Host 只能属于一个租户，否则无法识别请求所属租户
+	others, err := db.ListTenants(ctx, false)
+	if err != nil {
+		return err
+	}
+	return checkTenantHostConflicts(t, others)
+}
+
+// normalizeTenantHosts 去掉端口、转为小写并去重
+func normalizeTenantHosts(hosts []string) ([]string, error) {
+	var normalized []string
+	seen := map[string]bool{}
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" || strings.ContainsAny(host, "/ ") {
+			return nil, &biz.ValidationError{Field: "hosts", Message: "invalid host: " + host}
+		}
+		if !seen[host] {
+			seen[host] = true
+			normalized = append(normalized, host)
+		}
+	}
+	return normalized, nil
+}
+
+// checkTenantHostConflicts 检查 Host 是否已被其他租户使用
+func checkTenantHostConflicts(t *tenant.Tenant, others []tenant.Tenant) error {
+	for _, other := range others {
+		if other.ID == t.ID {
+			continue
+		}
+		for _, host := range other.Hosts {
+			for _, h := range t.Hosts {
+				if strings.EqualFold(host, h) {
+					return &biz.ValidationError{Field: "hosts", Message: fmt.Sprintf("host %s is already used by tenant %s", h, other.ID)}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"reflect"
+	"stripe-pay/biz/receipt"
+	"stripe-pay/tenant"
+	"testing"
+)
+
+// TestNormalizeTenantHosts 测试租户 Host 规范化
+func TestNormalizeTenantHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		hosts   []string
+		want    []string
+		wantErr bool
+	}{
+		{"未配置", nil, nil, false},
+		{"去掉端口并转小写", []string{" Pay.Acme.Example:443 "}, []string{"pay.acme.example"}, false},
+		{"去重", []string{"a.example", "A.example"}, []string{"a.example"}, false},
+		{"包含路径", []string{"a.example/pay"}, nil, true},
+		{"空 Host", []string{" "}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeTenantHosts(tt.hosts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeTenantHosts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeTenantHosts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCheckTenantHostConflicts 测试同一 Host 不能属于多个租户
+func TestCheckTenantHostConflicts(t *testing.T) {
+	others := []tenant.Tenant{
+		{ID: "acme", Hosts: []string{"pay.acme.example"}},
+		{ID: "beta", Hosts: []string{"pay.beta.example"}},
+	}
+
+	tests := []struct {
+		name    string
+		tenant  *tenant.Tenant
+		wantErr bool
+	}{
+		{"没有冲突", &tenant.Tenant{ID: "gamma", Hosts: []string{"pay.gamma.example"}}, false},
+		{"更新自己的 Host", &tenant.Tenant{ID: "acme", Hosts: []string{"pay.acme.example"}}, false},
+		{"与其他租户冲突", &tenant.Tenant{ID: "gamma", Hosts: []string{"pay.beta.example"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkTenantHostConflicts(tt.tenant, others); (err != nil) != tt.wantErr {
+				t.Errorf("checkTenantHostConflicts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestMergeReceiptSettings 测试租户收据设置覆盖全局设置
+func TestMergeReceiptSettings(t *testing.T) {
+	global := receiptSettings{
+		NumberPrefix: "R-",
+		TaxName:      "VAT",
+		TaxRate:      13,
+		Company:      receipt.Company{Name: "Platform Ltd", TaxID: "P-001"},
+	}
+	zero := 0.0
+
+	tests := []struct {
+		name    string
+		receipt *tenant.Receipt
+		want    receiptSettings
+	}{
+		{"未设置", nil, global},
+		{"覆盖编号前缀", &tenant.Receipt{NumberPrefix: "ACME-"},
+			receiptSettings{NumberPrefix: "ACME-", TaxName: "VAT", TaxRate: 13, Company: global.Company}},
+		{"税率设为 0", &tenant.Receipt{TaxRate: &zero},
+			receiptSettings{NumberPrefix: "R-", TaxName: "VAT", Company: global.Company}},
+		{"整体使用租户的公司信息", &tenant.Receipt{Company: &tenant.ReceiptCompany{Name: "Acme Inc"}},
+			receiptSettings{NumberPrefix: "R-", TaxName: "VAT", TaxRate: 13, Company: receipt.Company{Name: "Acme Inc"}}},
+		{"公司名称为空时不覆盖", &tenant.Receipt{Company: &tenant.ReceiptCompany{TaxID: "A-002"}}, global},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeReceiptSettings(global, tt.receipt); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeReceiptSettings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
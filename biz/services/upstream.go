@@ -0,0 +1,142 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"stripe-pay/common"
+	"stripe-pay/conf"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/form"
+	"go.uber.org/zap"
+)
+
+// 外部服务名（熔断器和指标标签）
+const (
+	UpstreamStripe = "stripe"
+	UpstreamApple  = "apple"
+)
+
+// 默认超时、重试和熔断参数
+var (
+	defaultStripeUpstream = conf.UpstreamConfig{Timeout: 20, MaxRetries: 2, FailureThreshold: 5, OpenTimeout: 30}
+	defaultAppleUpstream  = conf.UpstreamConfig{Timeout: 10, MaxRetries: 2, FailureThreshold: 5, OpenTimeout: 30}
+)
+
+var (
+	upstreamOnce    sync.Once
+	appleBreaker    *common.CircuitBreaker
+	appleHTTPClient *http.Client
+	appleRetry      common.RetryPolicy
+)
+
+// upstreamSettings 合并配置和默认值
+func upstreamSettings(cfg, defaults conf.UpstreamConfig) conf.UpstreamConfig {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaults.Timeout
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaults.MaxRetries
+	} else if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaults.FailureThreshold
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = defaults.OpenTimeout
+	}
+	return cfg
+}
+
+// breakerSettings 转换为熔断器参数
+func breakerSettings(u conf.UpstreamConfig) common.BreakerSettings {
+	return common.BreakerSettings{
+		FailureThreshold: u.FailureThreshold,
+		OpenTimeout:      time.Duration(u.OpenTimeout) * time.Second,
+	}
+}
+
+// initUpstreams 配置 Stripe 后端（超时、重试、熔断）和 Apple HTTP 客户端，进程内只执行一次
+func initUpstreams(cfg *conf.Config) {
+	upstreamOnce.Do(func() {
+		stripeCfg := upstreamSettings(cfg.Upstreams.Stripe, defaultStripeUpstream)
+		// stripe-go 对网络错误、409、429 和 5xx 做带抖动的指数退避重试，POST 请求自动带幂等键，重试是安全的
+		backend := stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+			HTTPClient:        &http.Client{Timeout: time.Duration(stripeCfg.Timeout) * time.Second},
+			MaxNetworkRetries: stripe.Int64(int64(stripeCfg.MaxRetries)),
+		})
+		stripe.SetBackend(stripe.APIBackend, &breakerBackend{
+			Backend: backend,
+			breaker: common.NewCircuitBreaker(UpstreamStripe, breakerSettings(stripeCfg)),
+		})
+
+		appleCfg := upstreamSettings(cfg.Upstreams.Apple, defaultAppleUpstream)
+		appleHTTPClient = &http.Client{Timeout: time.Duration(appleCfg.Timeout) * time.Second}
+		appleBreaker = common.NewCircuitBreaker(UpstreamApple, breakerSettings(appleCfg))
+		appleRetry = common.RetryPolicy{MaxRetries: appleCfg.MaxRetries, BaseDelay: 200 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+		zap.L().Info("Upstream clients configured",
+			zap.Int("stripe_timeout", stripeCfg.Timeout),
+			zap.Int("stripe_max_retries", stripeCfg.MaxRetries),
+			zap.Int("apple_timeout", appleCfg.Timeout),
+			zap.Int("apple_max_retries", appleCfg.MaxRetries))
+	})
+}
+
+// breakerBackend 在 Stripe 后端外层加熔断：熔断中直接返回 *common.CircuitOpenError，不发起请求
+type breakerBackend struct {
+	stripe.Backend
+	breaker *common.CircuitBreaker
+}
+
+func (b *breakerBackend) Call(method, path, key string, params stripe.ParamsContainer, v stripe.LastResponseSetter) error {
+	return b.do(func() error { return b.Backend.Call(method, path, key, params, v) })
+}
+
+func (b *breakerBackend) CallStreaming(method, path, key string, params stripe.ParamsContainer, v stripe.StreamingLastResponseSetter) error {
+	return b.do(func() error { return b.Backend.CallStreaming(method, path, key, params, v) })
+}
+
+func (b *breakerBackend) CallRaw(method, path, key string, body *form.Values, params *stripe.Params, v stripe.LastResponseSetter) error {
+	return b.do(func() error { return b.Backend.CallRaw(method, path, key, body, params, v) })
+}
+
+func (b *breakerBackend) CallMultipart(method, path, key, boundary string, body *bytes.Buffer, params *stripe.Params, v stripe.LastResponseSetter) error {
+	return b.do(func() error { return b.Backend.CallMultipart(method, path, key, boundary, body, params, v) })
+}
+
+func (b *breakerBackend) do(call func() error) error {
+	if err := b.breaker.Allow(); err != nil {
+		return err
+	}
+	err := call()
+	b.breaker.Done(!isStripeUpstreamFailure(err))
+	return err
+}
+
+// isStripeUpstreamFailure 是否计为 Stripe 故障：网络错误、超时和 5xx。4xx（参数错误、卡被拒等）说明 Stripe 正常
+func isStripeUpstreamFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) {
+		return stripeErr.HTTPStatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// isRetryableHTTPError 网络错误（包括超时）和 5xx 可重试；客户端取消的请求不重试
+func isRetryableHTTPError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, errAppleUnavailable)
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"stripe-pay/cache"
+	"stripe-pay/db"
+	"stripe-pay/tenant"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"go.uber.org/zap"
+)
+
+// HandleStripeEvent 处理 Stripe 事件（Webhook 接收和 admin 重放共用同一处理路径），
+// 处理过程中的 Stripe 请求使用事件所属账户和模式的客户端
+func (s *PaymentService) HandleStripeEvent(ctx context.Context, account string, evt *stripe.Event) error {
+	livemode := evt.Livemode
+	sc, err := stripeAccounts().clientFor(account, &livemode)
+	if err != nil {
+		return err
+	}
+	ctx = withStripeClient(ctx, sc)
+
+	switch evt.Type {
+	case "payment_intent.succeeded",
+		"payment_intent.payment_failed",
+		"payment_intent.canceled",
+		"payment_intent.amount_capturable_updated":
+		zap.L().Info("Processing payment intent event",
+			zap.String("event_id", evt.ID),
+			zap.String("type", string(evt.Type)))
+
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(evt.Data.Raw, &pi); err != nil {
+			zap.L().Error("Failed to parse payment intent", zap.Error(err), zap.String("event_id", evt.ID))
+			return fmt.Errorf("failed to parse payment intent: %w", err)
+		}
+		if err := s.syncPaymentIntentStatus(ctx, &pi); err != nil {
+			return fmt.Errorf("failed to sync payment intent %s: %w", pi.ID, err)
+		}
+
+	case "checkout.session.completed",
+		"checkout.session.async_payment_succeeded",
+		"checkout.session.async_payment_failed",
+		"checkout.session.expired":
+		var session stripe.CheckoutSession
+		if err := json.Unmarshal(evt.Data.Raw, &session); err != nil {
+			zap.L().Error("Failed to parse checkout session", zap.Error(err), zap.String("event_id", evt.ID))
+			return fmt.Errorf("failed to parse checkout session: %w", err)
+		}
+		if err := s.handleCheckoutSessionEvent(ctx, &session); err != nil {
+			return fmt.Errorf("failed to sync checkout session %s: %w", session.ID, err)
+		}
+
+	case "refund.created",
+		"refund.updated",
+		"charge.refund.updated":
+		var r stripe.Refund
+		if err := json.Unmarshal(evt.Data.Raw, &r); err != nil {
+			zap.L().Error("Failed to parse refund", zap.Error(err), zap.String("event_id", evt.ID))
+			return fmt.Errorf("failed to parse refund: %w", err)
+		}
+		if err := s.recordRefund(ctx, &r); err != nil {
+			return fmt.Errorf("failed to record refund %s: %w", r.ID, err)
+		}
+
+	case "account.updated":
+		// Connect 事件：连接账户完成引导或能力变化
+		var acct stripe.Account
+		if err := json.Unmarshal(evt.Data.Raw, &acct); err != nil {
+			zap.L().Error("Failed to parse account", zap.Error(err), zap.String("event_id", evt.ID))
+			return fmt.Errorf("failed to parse account: %w", err)
+		}
+		if err := s.syncConnectedAccount(ctx, &acct); err != nil {
+			return fmt.Errorf("failed to sync connected account %s: %w", acct.ID, err)
+		}
+
+	default:
+		zap.L().Info("Unhandled event type", zap.String("type", string(evt.Type)))
+	}
+
+	return nil
+}
+
+// ReplayStripeEvent 从指定账户重新拉取事件并按 Webhook 路径重新处理（testMode 时使用账户的测试模式密钥）
+func (s *PaymentService) ReplayStripeEvent(ctx context.Context, account string, testMode bool, eventID string) (*stripe.Event, error) {
+	var livemode *bool
+	if testMode {
+		livemode = new(bool)
+	}
+	sc, err := stripeAccounts().clientFor(account, livemode)
+	if err != nil {
+		return nil, err
+	}
+	evt, err := sc.API.Events.Get(eventID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event from Stripe: %w", err)
+	}
+
+	zap.L().Info("Replaying Stripe event",
+		zap.String("event_id", evt.ID),
+		zap.String("type", string(evt.Type)),
+		zap.String("stripe_account", sc.Account))
+	if err := s.HandleStripeEvent(ctx, sc.Account, evt); err != nil {
+		return evt, err
+	}
+	return evt, nil
+}
+
+// SyncPaymentIntent 从 Stripe 读取 PaymentIntent（按请求的租户和账户校验归属）并按 Webhook 相同路径同步状态（前端支付完成后调用）。
+// 读取失败时返回 nil PaymentIntent；同步失败时同时返回 PaymentIntent 和错误
+func (s *PaymentService) SyncPaymentIntent(ctx context.Context, paymentIntentID string) (*stripe.PaymentIntent, error) {
+	intent, err := s.GetPaymentIntent(ctx, paymentIntentID)
+	if err != nil {
+		return nil, err
+	}
+	return intent, s.syncPaymentIntentStatus(ctx, intent)
+}
+
+// syncPaymentIntentStatus 将 PaymentIntent 的最新状态写入数据库并刷新缓存（Webhook、事件重放、对账和前端更新状态共用）
+// 重复处理同一个已成功的 PaymentIntent 时不会重复累计用户支付统计（按 entitlement_granted_at 去重）
+func (s *PaymentService) syncPaymentIntentStatus(ctx context.Context, pi *stripe.PaymentIntent) error {
+	if db.DB == nil {
+		return nil
+	}
+
+	// 来自 Checkout Session 的 PaymentIntent：先把占位记录关联到该 PaymentIntent
+	if err := s.attachCheckoutPaymentIntent(ctx, pi); err != nil {
+		return err
+	}
+
+	status := string(pi.Status)
+
+	idempotencyKey := ""
+	testMode := false
+	if existing, err := db.GetPaymentByIntentIDAnyTenant(ctx, pi.ID); err == nil && existing != nil {
+		idempotencyKey = existing.IdempotencyKey
+		testMode = existing.TestMode
+		// Webhook 和对账请求没有租户，按记录所属租户更新用户统计、发送通知和刷新缓存
+		ctx = tenant.WithID(ctx, existing.TenantID)
+	}
+
+	// Update payment history status
+	if err := db.UpdatePaymentStatus(ctx, pi.ID, status); err != nil {
+		zap.L().Warn("Failed to update payment status", zap.Error(err))
+		return err
+	}
+	if cache.IsAvailable() {
+		go func() {
+			// Accuracy first: final status deletes cache, forcing the next query to Stripe
+			if cache.IsFinalStatus(status) {
+				zap.L().Info("Final status in webhook, deleting cache for accuracy",
+					zap.String("payment_intent_id", pi.ID),
+					zap.String("status", status))
+				cache.DeleteStripeStatus(context.WithoutCancel(ctx), pi.ID)
+				cache.DeletePayment(context.WithoutCancel(ctx), pi.ID)
+				return
+			}
+
+			// Intermediate status: update cache
+			stripeStatusData := &cache.StripeStatusCacheData{
+				PaymentIntentID: pi.ID,
+				Status:          status,
+				Amount:          pi.Amount,
+				Currency:        string(pi.Currency),
+				CachedAt:        time.Now().Format(time.RFC3339),
+			}
+			cache.SetStripeStatus(context.WithoutCancel(ctx), pi.ID, stripeStatusData, cache.GetStripeStatusTTL(status))
+		}()
+	}
+
+	// 已取消的支付：删除幂等中间件保存的创建响应，同一幂等键重试时不再重放失效的 client_secret
+	// （Webhook、取消授权、过期扫描和重试时发现已取消都经过这里）
+	if status == string(stripe.PaymentIntentStatusCanceled) {
+		_ = cache.DeleteIdempotencyRecord(ctx, idempotencyKey)
+	}
+
+	if err := s.syncPromoRedemption(ctx, pi.ID, status, pi.Metadata); err != nil {
+		zap.L().Warn("Failed to sync promo redemption", zap.Error(err), zap.String("payment_intent_id", pi.ID))
+		return err
+	}
+
+	if status != "succeeded" {
+		return nil
+	}
+
+	// 开具收据（幂等，失败不影响状态同步，查看收据时会补开）
+	if _, err := s.issueReceiptForIntent(ctx, pi.ID); err != nil {
+		zap.L().Warn("Failed to issue receipt", zap.Error(err), zap.String("payment_intent_id", pi.ID))
+	}
+	s.notifyPaymentSucceeded(ctx, pi)
+
+	// 通过 X-Stripe-Mode 请求的测试支付不计入用户权益（主密钥为测试密钥的部署仍正常授予）
+	if testMode {
+		zap.L().Info("Test mode payment succeeded, skipping user payment info update",
+			zap.String("payment_intent_id", pi.ID))
+		return nil
+	}
+
+	// Get user ID (from metadata) and update user payment information
+	userID := pi.Metadata["user_id"]
+	if userID == "" {
+		return nil
+	}
+	// 部分扣款时按实际扣款金额累计
+	amount := pi.Amount
+	if pi.AmountReceived > 0 {
+		amount = pi.AmountReceived
+	}
+	// 状态可能已由轮询或后台刷新写为 succeeded，按 PaymentIntent 的发放记录去重
+	granted, err := db.GrantPaymentEntitlement(ctx, pi.ID, userID, amount)
+	if err != nil {
+		zap.L().Warn("Failed to update user payment info", zap.Error(err))
+		return err
+	}
+	if !granted {
+		zap.L().Info("User payment info already updated for payment intent, skipping",
+			zap.String("payment_intent_id", pi.ID))
+		return nil
+	}
+	if cache.IsAvailable() {
+		go func() {
+			cache.InvalidateUserPaymentCache(context.WithoutCancel(ctx), userID)
+		}()
+	}
+	return nil
+}
+
+// recordRefund 保存退款记录（退款接口和 Webhook 共用，按 refund_id 幂等）。
+// Webhook 没有请求租户，按支付记录所属租户记录退款和发送通知
+func (s *PaymentService) recordRefund(ctx context.Context, r *stripe.Refund) error {
+	if db.DB == nil || r.PaymentIntent == nil {
+		return nil
+	}
+
+	payment, err := db.GetPaymentByIntentIDAnyTenant(ctx, r.PaymentIntent.ID)
+	if err != nil {
+		return err
+	}
+	if payment != nil {
+		ctx = tenant.WithID(ctx, payment.TenantID)
+	}
+
+	err = db.SaveRefund(ctx, &db.PaymentRefund{
+		RefundID:        r.ID,
+		PaymentIntentID: r.PaymentIntent.ID,
+		Amount:          r.Amount,
+		Currency:        string(r.Currency),
+		Status:          string(r.Status),
+		Reason:          string(r.Reason),
+	})
+	if err != nil {
+		return err
+	}
+
+	if payment != nil {
+		s.notifyRefundIssued(ctx, payment, r)
+	}
+	return nil
+}
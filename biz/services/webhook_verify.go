@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"stripe-pay/cache"
+	"stripe-pay/common"
+	"stripe-pay/conf"
+	"stripe-pay/db"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/webhook"
+	"go.uber.org/zap"
+)
+
+// Webhook 签名校验结果（指标 result 标签）
+const (
+	WebhookSignatureValid   = "valid"
+	WebhookSignatureInvalid = "invalid"
+	WebhookSignatureTooOld  = "too_old"
+)
+
+// webhookSecretNone 没有密钥匹配时的指标标签
+const webhookSecretNone = "none"
+
+// ErrWebhookSecretNotConfigured 没有可用的 Webhook 签名密钥
+var ErrWebhookSecretNotConfigured = errors.New("webhook secret not configured")
+
+// ErrNotConnectEvent Connect 端点收到的事件不属于连接账户
+var ErrNotConnectEvent = errors.New("event is not from a connected account")
+
+// WebhookEndpoint Stripe 后台的 Webhook 端点类型，两种端点的签名密钥不同
+type WebhookEndpoint string
+
+const (
+	WebhookEndpointAccount WebhookEndpoint = "account" // 平台账户自身的事件（/stripe/webhook）
+	WebhookEndpointConnect WebhookEndpoint = "connect" // 连接账户的事件（/stripe/connect-webhook），如 account.updated
+)
+
+// namedWebhookSecret 带名称的签名密钥（名称用于指标和日志，不包含密钥内容）
+type namedWebhookSecret struct {
+	name   string
+	secret string
+}
+
+// activeWebhookSecrets 按尝试顺序返回账户未过期的签名密钥：先 webhook_secret，再 webhook_secrets，最后 test_webhook_secret
+func activeWebhookSecrets(account conf.StripeAccount, now time.Time) []namedWebhookSecret {
+	var secrets []namedWebhookSecret
+	if account.WebhookSecret != "" {
+		secrets = append(secrets, namedWebhookSecret{name: "webhook_secret", secret: account.WebhookSecret})
+	}
+	for i, s := range account.WebhookSecrets {
+		if s.Secret == "" || (!s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt)) {
+			continue
+		}
+		name := s.Name
+		if name == "" {
+			name = fmt.Sprintf("secret_%d", i+1)
+		}
+		secrets = append(secrets, namedWebhookSecret{name: name, secret: s.Secret})
+	}
+	if account.TestWebhookSecret != "" {
+		secrets = append(secrets, namedWebhookSecret{name: "test_webhook_secret", secret: account.TestWebhookSecret})
+	}
+	return secrets
+}
+
+// activeConnectWebhookSecrets 按尝试顺序返回 Connect 端点的签名密钥：先 connect_webhook_secret，再 test_connect_webhook_secret
+func activeConnectWebhookSecrets(account conf.StripeAccount) []namedWebhookSecret {
+	var secrets []namedWebhookSecret
+	if account.ConnectWebhookSecret != "" {
+		secrets = append(secrets, namedWebhookSecret{name: "connect_webhook_secret", secret: account.ConnectWebhookSecret})
+	}
+	if account.TestConnectWebhookSecret != "" {
+		secrets = append(secrets, namedWebhookSecret{name: "test_connect_webhook_secret", secret: account.TestConnectWebhookSecret})
+	}
+	return secrets
+}
+
+// webhookAccount 查找账户配置（default 为 stripe 下的默认账户）
+func webhookAccount(cfg *conf.Config, id string) (conf.StripeAccount, error) {
+	if id == db.DefaultStripeAccount {
+		return cfg.Stripe.StripeAccount, nil
+	}
+	for _, account := range cfg.Stripe.Accounts {
+		if account.ID == id {
+			return account, nil
+		}
+	}
+	return conf.StripeAccount{}, fmt.Errorf("%w: %s", ErrStripeAccountNotFound, id)
+}
+
+// webhookTolerance 签名时间戳允许的最大偏差
+func webhookTolerance(cfg *conf.Config) time.Duration {
+	if cfg.Stripe.WebhookTolerance > 0 {
+		return time.Duration(cfg.Stripe.WebhookTolerance) * time.Second
+	}
+	return webhook.DefaultTolerance
+}
+
+// minWebhookReplayTTL 事件登记的最短保留时间，同时挡住 Stripe 对已成功处理事件的重复投递
+const minWebhookReplayTTL = 24 * time.Hour
+
+// webhookReplayTTL 事件登记的保留时间：不短于签名时间窗口，窗口内重放的请求都能识别
+func webhookReplayTTL(cfg *conf.Config) time.Duration {
+	return max(webhookTolerance(cfg), minWebhookReplayTTL)
+}
+
+// ClaimStripeEvent 在处理签名有效的事件前按账户和事件 ID 登记（Redis SETNX），已登记过的事件返回 false，不应再次处理。
+// 返回的 release 在处理失败时调用，删除登记后 Stripe 重新发送时可以再次处理。
+// Redis 不可用时放行（事件处理按状态幂等），只记录告警
+func ClaimStripeEvent(ctx context.Context, account string, event *stripe.Event) (bool, func()) {
+	release := func() { _ = cache.UnmarkStripeEvent(ctx, account, event.ID) }
+	if !cache.IsAvailable() {
+		zap.L().Warn("Redis not available, Stripe event replay check skipped",
+			zap.String("event_id", event.ID),
+			zap.String("stripe_account", account))
+		return true, func() {}
+	}
+	claimed, err := cache.MarkStripeEvent(ctx, account, event.ID, webhookReplayTTL(conf.GetConf()))
+	if err != nil {
+		return true, release
+	}
+	return claimed, release
+}
+
+// ConstructStripeEvent 校验 Webhook 签名并解析事件：按顺序尝试账户在该类型端点下所有未过期的密钥，
+// 轮换期间新旧密钥签名的事件都能通过；返回匹配的密钥名称。
+// Connect 端点只接受 connect_webhook_secret 签名且带 account 字段的事件
+func ConstructStripeEvent(account string, endpoint WebhookEndpoint, payload []byte, signature string) (stripe.Event, string, error) {
+	return constructStripeEvent(conf.GetConf(), account, endpoint, payload, signature, time.Now())
+}
+
+func constructStripeEvent(cfg *conf.Config, accountID string, endpoint WebhookEndpoint, payload []byte, signature string, now time.Time) (stripe.Event, string, error) {
+	account, err := webhookAccount(cfg, accountID)
+	if err != nil {
+		return stripe.Event{}, "", err
+	}
+	secrets := activeWebhookSecrets(account, now)
+	if endpoint == WebhookEndpointConnect {
+		secrets = activeConnectWebhookSecrets(account)
+	}
+	if len(secrets) == 0 {
+		return stripe.Event{}, "", ErrWebhookSecretNotConfigured
+	}
+
+	options := webhook.ConstructEventOptions{Tolerance: webhookTolerance(cfg)}
+	var lastErr error
+	for _, s := range secrets {
+		// 先只校验签名，避免 API 版本等解析错误被当作签名不匹配而继续尝试下一个密钥
+		err := webhook.ValidatePayloadWithTolerance(payload, signature, s.secret, options.Tolerance)
+		switch {
+		case err == nil:
+			common.RecordWebhookSignature(accountID, s.name, WebhookSignatureValid)
+			event, err := webhook.ConstructEventWithOptions(payload, signature, s.secret, options)
+			if err == nil && endpoint == WebhookEndpointConnect && event.Account == "" {
+				return stripe.Event{}, "", ErrNotConnectEvent
+			}
+			return event, s.name, err
+		case errors.Is(err, webhook.ErrTooOld):
+			// 时间戳检查在签名比对之前，换一个密钥结果相同
+			common.RecordWebhookSignature(accountID, webhookSecretNone, WebhookSignatureTooOld)
+			return stripe.Event{}, "", err
+		case errors.Is(err, webhook.ErrNoValidSignature):
+			lastErr = err
+		default:
+			// 签名头格式错误，与密钥无关
+			common.RecordWebhookSignature(accountID, webhookSecretNone, WebhookSignatureInvalid)
+			return stripe.Event{}, "", err
+		}
+	}
+
+	common.RecordWebhookSignature(accountID, webhookSecretNone, WebhookSignatureInvalid)
+	zap.L().Warn("Stripe webhook signature matched no active secret",
+		zap.String("stripe_account", accountID),
+		zap.Int("secrets_tried", len(secrets)))
+	return stripe.Event{}, "", lastErr
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"stripe-pay/conf"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/webhook"
+)
+
+// TestConstructStripeEvent 测试多个签名密钥轮换时的校验
+func TestConstructStripeEvent(t *testing.T) {
+	now := time.Now()
+	payload := []byte(fmt.Sprintf(`{"id":"evt_123","object":"event","api_version":%q,"type":"payment_intent.succeeded"}`, stripe.APIVersion))
+	connectPayload := []byte(fmt.Sprintf(`{"id":"evt_123","object":"event","api_version":%q,"type":"account.updated","account":"acct_123"}`, stripe.APIVersion))
+	signPayload := func(payload []byte, secret string, at time.Time) string {
+		return webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{Payload: payload, Secret: secret, Timestamp: at}).Header
+	}
+	sign := func(secret string, at time.Time) string { return signPayload(payload, secret, at) }
+	signConnect := func(secret string) string { return signPayload(connectPayload, secret, now) }
+
+	newConfig := func() *conf.Config {
+		cfg := &conf.Config{}
+		cfg.Stripe.WebhookSecret = "whsec_new"
+		cfg.Stripe.WebhookSecrets = []conf.WebhookSecret{
+			{Name: "previous", Secret: "whsec_old", ExpiresAt: now.Add(time.Hour)},
+			{Secret: "whsec_expired", ExpiresAt: now.Add(-time.Minute)},
+			{Secret: "whsec_other"},
+		}
+		cfg.Stripe.TestWebhookSecret = "whsec_test"
+		cfg.Stripe.ConnectWebhookSecret = "whsec_connect"
+		cfg.Stripe.Accounts = []conf.StripeAccount{{ID: "hk", SecretKey: "sk_live_hk", WebhookSecret: "whsec_hk"}}
+		return cfg
+	}
+
+	tests := []struct {
+		name       string
+		account    string
+		endpoint   WebhookEndpoint
+		payload    []byte
+		setup      func(cfg *conf.Config)
+		signature  string
+		wantSecret string
+		wantErr    error
+	}{
+		{"当前密钥", "default", WebhookEndpointAccount, payload, nil, sign("whsec_new", now), "webhook_secret", nil},
+		{"轮换中的旧密钥", "default", WebhookEndpointAccount, payload, nil, sign("whsec_old", now), "previous", nil},
+		{"未命名的密钥", "default", WebhookEndpointAccount, payload, nil, sign("whsec_other", now), "secret_3", nil},
+		{"测试模式密钥", "default", WebhookEndpointAccount, payload, nil, sign("whsec_test", now), "test_webhook_secret", nil},
+		{"已过期的密钥", "default", WebhookEndpointAccount, payload, nil, sign("whsec_expired", now), "", webhook.ErrNoValidSignature},
+		{"未知密钥", "default", WebhookEndpointAccount, payload, nil, sign("whsec_unknown", now), "", webhook.ErrNoValidSignature},
+		{"超过时间窗口", "default", WebhookEndpointAccount, payload, nil, sign("whsec_new", now.Add(-10*time.Minute)), "", webhook.ErrTooOld},
+		{"放宽时间窗口", "default", WebhookEndpointAccount, payload, func(cfg *conf.Config) { cfg.Stripe.WebhookTolerance = 900 }, sign("whsec_new", now.Add(-10*time.Minute)), "webhook_secret", nil},
+		{"未配置密钥", "default", WebhookEndpointAccount, payload, func(cfg *conf.Config) {
+			cfg.Stripe.WebhookSecret = ""
+			cfg.Stripe.WebhookSecrets = nil
+			cfg.Stripe.TestWebhookSecret = ""
+		}, sign("whsec_new", now), "", ErrWebhookSecretNotConfigured},
+		{"其他账户的密钥", "hk", WebhookEndpointAccount, payload, nil, sign("whsec_hk", now), "webhook_secret", nil},
+		{"其他账户不接受默认账户的密钥", "hk", WebhookEndpointAccount, payload, nil, sign("whsec_new", now), "", webhook.ErrNoValidSignature},
+		{"未配置的账户", "jp", WebhookEndpointAccount, payload, nil, sign("whsec_new", now), "", ErrStripeAccountNotFound},
+		{"Connect 端点", "default", WebhookEndpointConnect, connectPayload, nil, signConnect("whsec_connect"), "connect_webhook_secret", nil},
+		{"Connect 端点不接受账户端点的密钥", "default", WebhookEndpointConnect, connectPayload, nil, signConnect("whsec_new"), "", webhook.ErrNoValidSignature},
+		{"账户端点不接受 Connect 密钥", "default", WebhookEndpointAccount, connectPayload, nil, signConnect("whsec_connect"), "", webhook.ErrNoValidSignature},
+		{"Connect 端点的事件缺少 account", "default", WebhookEndpointConnect, payload, nil, signPayload(payload, "whsec_connect", now), "", ErrNotConnectEvent},
+		{"未配置 Connect 密钥", "hk", WebhookEndpointConnect, connectPayload, nil, signConnect("whsec_connect"), "", ErrWebhookSecretNotConfigured},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newConfig()
+			if tt.setup != nil {
+				tt.setup(cfg)
+			}
+			event, secret, err := constructStripeEvent(cfg, tt.account, tt.endpoint, tt.payload, tt.signature, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("constructStripeEvent() error = %v, want %v", err, tt.wantErr)
+			}
+			if secret != tt.wantSecret {
+				t.Errorf("constructStripeEvent() secret = %q, want %q", secret, tt.wantSecret)
+			}
+			if tt.wantErr == nil && event.ID != "evt_123" {
+				t.Errorf("constructStripeEvent() event = %q, want evt_123", event.ID)
+			}
+		})
+	}
+}
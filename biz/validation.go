@@ -2,6 +2,7 @@ package biz
 
 import (
 	"fmt"
+	"net/mail"
 	"net/url"
 	"regexp"
 	"strings"
@@ -14,6 +15,8 @@ const (
 	MinUserIDLength      = 1
 	MaxDescriptionLength = 500
 	MaxURLLength         = 2048
+	MaxPromoCodeLength   = 64
+	MaxEmailLength       = 254
 	MinAmount            = 1        // 最小金额：1分
 	MaxAmount            = 10000000 // 最大金额：100000元（10000000分）
 )
@@ -45,17 +48,30 @@ var (
 
 	// 允许的支付状态
 	allowedPaymentStatuses = map[string]bool{
-		"succeeded":  true,
-		"failed":     true,
-		"canceled":   true,
-		"pending":    true,
-		"processing": true,
+		"succeeded":        true,
+		"failed":           true,
+		"canceled":         true,
+		"pending":          true,
+		"processing":       true,
+		"requires_capture": true,
+	}
+
+	// 允许的扣款方式
+	allowedCaptureMethods = map[string]bool{
+		"automatic": true,
+		"manual":    true,
 	}
 
 	// user_id格式：允许字母、数字、下划线、连字符、点号，以及中文字符（简体/繁体）
 	// \p{Han} 匹配所有汉字（包括简体中文和繁体中文）
 	userIDPattern = regexp.MustCompile(`^[\p{L}\p{N}._-]+$`)
 
+	// 优惠码格式：字母、数字、下划线、连字符
+	promoCodePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+	// Stripe PaymentMethod ID格式：pm_开头
+	stripePaymentMethodPattern = regexp.MustCompile(`^pm_[a-zA-Z0-9]{1,64}$`)
+
 	// Stripe PaymentIntent ID格式：pi_开头，后跟24个字符
 	stripePaymentIntentPattern = regexp.MustCompile(`^pi_[a-zA-Z0-9]{24}$`)
 )
@@ -237,6 +253,38 @@ func ValidatePaymentIntentID(paymentIntentID string) error {
 	return nil
 }
 
+// ValidatePaymentMethodID 验证Stripe PaymentMethod ID格式
+func ValidatePaymentMethodID(paymentMethodID string) error {
+	if paymentMethodID == "" {
+		return &ValidationError{Field: "payment_method", Message: "payment_method is required"}
+	}
+
+	if !stripePaymentMethodPattern.MatchString(paymentMethodID) {
+		return &ValidationError{
+			Field:   "payment_method",
+			Message: "invalid payment_method format (must start with 'pm_')",
+		}
+	}
+
+	return nil
+}
+
+// ValidateCaptureMethod 验证扣款方式（白名单）
+func ValidateCaptureMethod(captureMethod string) error {
+	if captureMethod == "" {
+		return nil // 扣款方式是可选的，默认 automatic
+	}
+
+	if !allowedCaptureMethods[strings.ToLower(strings.TrimSpace(captureMethod))] {
+		return &ValidationError{
+			Field:   "capture_method",
+			Message: "capture_method must be one of: automatic, manual",
+		}
+	}
+
+	return nil
+}
+
 // ValidateRefundReason 验证退款原因（白名单）
 func ValidateRefundReason(reason string) error {
 	if reason == "" {
@@ -271,6 +319,53 @@ func ValidatePaymentStatus(status string) error {
 	return nil
 }
 
+// ValidatePromoCode 验证优惠码格式
+func ValidatePromoCode(code string) error {
+	if code == "" {
+		return nil // 优惠码是可选的
+	}
+
+	if len(code) > MaxPromoCodeLength {
+		return &ValidationError{
+			Field:   "promo_code",
+			Message: fmt.Sprintf("promo_code length must not exceed %d characters", MaxPromoCodeLength),
+		}
+	}
+
+	if !promoCodePattern.MatchString(code) {
+		return &ValidationError{
+			Field:   "promo_code",
+			Message: "promo_code can only contain letters, numbers, underscores, and hyphens",
+		}
+	}
+
+	return nil
+}
+
+// ValidateEmail 验证邮箱地址（只接受纯地址，不含显示名）
+func ValidateEmail(email string) error {
+	if email == "" {
+		return nil // 邮箱是可选的
+	}
+
+	if len(email) > MaxEmailLength {
+		return &ValidationError{
+			Field:   "email",
+			Message: fmt.Sprintf("email length must not exceed %d characters", MaxEmailLength),
+		}
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return &ValidationError{
+			Field:   "email",
+			Message: "invalid email format",
+		}
+	}
+
+	return nil
+}
+
 // ValidateReceiptData 验证Apple收据数据
 func ValidateReceiptData(receiptData string) error {
 	if receiptData == "" {
@@ -196,6 +196,53 @@ func TestValidatePaymentIntentID(t *testing.T) {
 	}
 }
 
+// TestValidatePaymentMethodID 测试PaymentMethod ID验证
+func TestValidatePaymentMethodID(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		wantErr bool
+	}{
+		{"有效ID", "pm_1PqRsT2eZvKYlo2C", false},
+		{"空ID", "", true},
+		{"PaymentIntent ID", "pi_3SSrQY6xpYAaGcYp0CHAb3nG", true},
+		{"包含非法字符", "pm_123;drop", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePaymentMethodID(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePaymentMethodID(%q) error = %v, wantErr %v", tt.id, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestValidateEmail 测试邮箱验证
+func TestValidateEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		email   string
+		wantErr bool
+	}{
+		{"有效邮箱", "user@example.com", false},
+		{"空邮箱（可选）", "", false},
+		{"缺少域名", "user@", true},
+		{"包含显示名", "User <user@example.com>", true},
+		{"包含换行", "user@example.com\r\nBcc: x@example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateEmail(tt.email)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateEmail(%q) error = %v, wantErr %v", tt.email, err, tt.wantErr)
+			}
+		})
+	}
+}
+
 // TestValidateRefundReason 测试退款原因验证
 func TestValidateRefundReason(t *testing.T) {
 	tests := []struct {
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"stripe-pay/tenant"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// IdempotencyKeyPrefix 幂等键缓存前缀
+const IdempotencyKeyPrefix = "idempotency:"
+
+// 幂等记录状态
+const (
+	IdempotencyInFlight  = "in_flight" // 首个请求处理中
+	IdempotencyCompleted = "completed" // 已保存响应
+)
+
+// IdempotencyRecord 幂等键对应的请求指纹和响应
+type IdempotencyRecord struct {
+	State       string `json:"state"`
+	Fingerprint string `json:"fingerprint"`
+	Token       string `json:"token,omitempty"` // 持有锁的请求标识，完成和释放时校验
+	StatusCode  int    `json:"status_code,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// compareAndSetScript 值未被其他请求替换时才覆盖（ARGV[2] 为空时删除）。
+// 脚本只访问 KEYS[1]，cluster 模式下按该键路由到所在节点；新增脚本如需多个键，键名要用相同的 {hash tag}
+var compareAndSetScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if ARGV[2] == "" then
+	return redis.call("DEL", KEYS[1])
+end
+redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+return 1
+`)
+
+// IdempotencyLock 已获取的幂等键锁
+type IdempotencyLock struct {
+	key    string
+	locked string // 加锁时写入的值
+}
+
+// AcquireIdempotencyKey 尝试锁定幂等键；键已存在时返回已有记录（处理中或已完成）
+func AcquireIdempotencyKey(ctx context.Context, key, fingerprint, token string, lockTTL time.Duration) (*IdempotencyLock, *IdempotencyRecord, error) {
+	if !IsAvailable() {
+		return nil, nil, fmt.Errorf("redis not available")
+	}
+
+	record := IdempotencyRecord{
+		State:       IdempotencyInFlight,
+		Fingerprint: fingerprint,
+		Token:       token,
+		CreatedAt:   time.Now().Format(time.RFC3339),
+	}
+	val, err := json.Marshal(record)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+
+	redisKey := tenant.Key(ctx, IdempotencyKeyPrefix+key)
+	acquired, err := client.SetNX(ctx, redisKey, val, lockTTL).Result()
+	if err != nil {
+		zap.L().Warn("Failed to lock idempotency key", zap.Error(err))
+		return nil, nil, err
+	}
+	if acquired {
+		return &IdempotencyLock{key: redisKey, locked: string(val)}, nil, nil
+	}
+
+	existing, err := client.Get(ctx, redisKey).Result()
+	if err == redis.Nil {
+		// 锁在 SETNX 和 GET 之间过期，按处理中返回，由客户端重试
+		return nil, &IdempotencyRecord{State: IdempotencyInFlight, Fingerprint: fingerprint}, nil
+	}
+	if err != nil {
+		zap.L().Warn("Failed to get idempotency record", zap.Error(err))
+		return nil, nil, err
+	}
+
+	var existingRecord IdempotencyRecord
+	if err := json.Unmarshal([]byte(existing), &existingRecord); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal idempotency record: %w", err)
+	}
+	return nil, &existingRecord, nil
+}
+
+// Complete 保存响应并在 ttl 内重放；锁已过期并被其他请求获取时不覆盖
+func (l *IdempotencyLock) Complete(ctx context.Context, record *IdempotencyRecord, ttl time.Duration) error {
+	record.State = IdempotencyCompleted
+	record.Token = ""
+	val, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+
+	if err := compareAndSetScript.Run(ctx, client, []string{l.key}, l.locked, string(val), ttl.Milliseconds()).Err(); err != nil {
+		zap.L().Warn("Failed to save idempotent response", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// Release 释放锁（请求失败时调用，允许客户端用同一幂等键重试）
+func (l *IdempotencyLock) Release(ctx context.Context) error {
+	if err := compareAndSetScript.Run(ctx, client, []string{l.key}, l.locked, "", 0).Err(); err != nil {
+		zap.L().Warn("Failed to release idempotency key", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// DeleteIdempotencyRecord 删除幂等键保存的响应（支付被取消并释放幂等键后，同一幂等键可以重新发起支付）
+func DeleteIdempotencyRecord(ctx context.Context, key string) error {
+	if !IsAvailable() || key == "" {
+		return nil
+	}
+	if err := client.Del(ctx, tenant.Key(ctx, IdempotencyKeyPrefix+key)).Err(); err != nil {
+		zap.L().Warn("Failed to delete idempotency record", zap.Error(err))
+		return err
+	}
+	return nil
+}
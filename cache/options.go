@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"stripe-pay/conf"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis 部署模式
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// redisMode 配置的部署模式（为空时为 standalone）
+func redisMode(cfg *conf.Config) string {
+	mode := strings.ToLower(strings.TrimSpace(cfg.Redis.Mode))
+	if mode == "" {
+		return ModeStandalone
+	}
+	return mode
+}
+
+// redisAddrs 连接地址：优先使用 addresses，standalone 模式兼容 address + port
+func redisAddrs(cfg *conf.Config) []string {
+	var addrs []string
+	for _, addr := range cfg.Redis.Addresses {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 && cfg.Redis.Address != "" {
+		port := cfg.Redis.Port
+		if port == 0 {
+			port = 6379
+		}
+		addrs = []string{fmt.Sprintf("%s:%d", cfg.Redis.Address, port)}
+	}
+	return addrs
+}
+
+// universalOptions 根据部署模式生成客户端参数；未配置地址时返回 nil
+func universalOptions(cfg *conf.Config) (*redis.UniversalOptions, error) {
+	mode := redisMode(cfg)
+	addrs := redisAddrs(cfg)
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+
+	opts := &redis.UniversalOptions{
+		Addrs:        addrs,
+		Username:     cfg.Redis.Username,
+		Password:     cfg.Redis.Password,
+		DB:           cfg.Redis.DB,
+		DialTimeout:  time.Duration(cfg.Redis.DialTimeout) * time.Second,
+		ReadTimeout:  time.Duration(cfg.Redis.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.Redis.WriteTimeout) * time.Second,
+		PoolSize:     cfg.Redis.PoolSize,
+		MinIdleConns: cfg.Redis.MinIdleConns,
+		// 每次建立新连接时读取当前用户名和密码，密码轮换后重新加载配置即可生效
+		CredentialsProvider: func() (string, string) {
+			current := conf.GetConf()
+			return current.Redis.Username, current.Redis.Password
+		},
+	}
+
+	switch mode {
+	case ModeStandalone:
+		// 多个地址会被当作集群，standalone 只连接第一个
+		opts.Addrs = addrs[:1]
+	case ModeSentinel:
+		if cfg.Redis.MasterName == "" {
+			return nil, fmt.Errorf("redis master_name is required in sentinel mode")
+		}
+		opts.MasterName = cfg.Redis.MasterName
+		opts.SentinelPassword = cfg.Redis.SentinelPassword
+	case ModeCluster:
+		if cfg.Redis.DB != 0 {
+			return nil, fmt.Errorf("redis cluster only supports db 0")
+		}
+		opts.IsClusterMode = true
+	default:
+		return nil, fmt.Errorf("unsupported redis mode: %s", cfg.Redis.Mode)
+	}
+
+	if cfg.Redis.TLS.Enabled {
+		tlsConfig, err := redisTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+	return opts, nil
+}
+
+// redisTLSConfig 加载 CA 和客户端证书
+func redisTLSConfig(cfg *conf.Config) (*tls.Config, error) {
+	tlsCfg := cfg.Redis.TLS
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         tlsCfg.ServerName,
+		InsecureSkipVerify: tlsCfg.InsecureSkipVerify,
+	}
+
+	if tlsCfg.CAFile != "" {
+		pem, err := os.ReadFile(tlsCfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in redis CA file %s", tlsCfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if tlsCfg.CertFile != "" || tlsCfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"reflect"
+	"stripe-pay/conf"
+	"testing"
+)
+
+// TestUniversalOptions 测试各部署模式的客户端参数
+func TestUniversalOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(cfg *conf.Config)
+		wantNil     bool
+		wantErr     bool
+		wantAddrs   []string
+		wantMaster  string
+		wantCluster bool
+	}{
+		{"未配置地址", func(cfg *conf.Config) {}, true, false, nil, "", false},
+		{"单机兼容 address 和 port", func(cfg *conf.Config) {
+			cfg.Redis.Address, cfg.Redis.Port = "localhost", 6380
+		}, false, false, []string{"localhost:6380"}, "", false},
+		{"单机只使用第一个地址", func(cfg *conf.Config) {
+			cfg.Redis.Addresses = []string{"10.0.0.1:6379", "10.0.0.2:6379"}
+		}, false, false, []string{"10.0.0.1:6379"}, "", false},
+		{"sentinel 模式", func(cfg *conf.Config) {
+			cfg.Redis.Mode = "sentinel"
+			cfg.Redis.MasterName = "mymaster"
+			cfg.Redis.Addresses = []string{"s1:26379", " s2:26379 ", ""}
+		}, false, false, []string{"s1:26379", "s2:26379"}, "mymaster", false},
+		{"sentinel 缺少主节点名", func(cfg *conf.Config) {
+			cfg.Redis.Mode = "sentinel"
+			cfg.Redis.Addresses = []string{"s1:26379"}
+		}, false, true, nil, "", false},
+		{"cluster 模式", func(cfg *conf.Config) {
+			cfg.Redis.Mode = "Cluster"
+			cfg.Redis.Addresses = []string{"n1:7000"}
+		}, false, false, []string{"n1:7000"}, "", true},
+		{"cluster 不支持非 0 数据库", func(cfg *conf.Config) {
+			cfg.Redis.Mode = "cluster"
+			cfg.Redis.Addresses = []string{"n1:7000"}
+			cfg.Redis.DB = 1
+		}, false, true, nil, "", false},
+		{"不支持的模式", func(cfg *conf.Config) {
+			cfg.Redis.Mode = "replica"
+			cfg.Redis.Address = "localhost"
+		}, false, true, nil, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &conf.Config{}
+			tt.setup(cfg)
+			opts, err := universalOptions(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("universalOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (opts == nil) != tt.wantNil {
+				t.Fatalf("universalOptions() = %v, wantNil %v", opts, tt.wantNil)
+			}
+			if opts == nil {
+				return
+			}
+			if !reflect.DeepEqual(opts.Addrs, tt.wantAddrs) || opts.MasterName != tt.wantMaster || opts.IsClusterMode != tt.wantCluster {
+				t.Errorf("universalOptions() = addrs %v, master %q, cluster %v; want %v, %q, %v",
+					opts.Addrs, opts.MasterName, opts.IsClusterMode, tt.wantAddrs, tt.wantMaster, tt.wantCluster)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"stripe-pay/conf"
+	"stripe-pay/tenant"
 	"sync"
 	"time"
 
@@ -13,46 +14,46 @@ import (
 )
 
 var (
-	client     *redis.Client
+	client     redis.UniversalClient
 	clientOnce sync.Once
 )
 
-// Init 初始化 Redis 连接
+// Init 初始化 Redis 连接（standalone、sentinel 或 cluster）
 func Init() error {
 	var err error
 	clientOnce.Do(func() {
 		cfg := conf.GetConf()
 
+		opts, optsErr := universalOptions(cfg)
+		if optsErr != nil {
+			zap.L().Error("Invalid Redis configuration, caching disabled", zap.Error(optsErr))
+			err = optsErr
+			return
+		}
 		// 如果 Redis 未配置，跳过初始化
-		if cfg.Redis.Address == "" {
+		if opts == nil {
 			zap.L().Info("Redis not configured, caching disabled")
 			return
 		}
 
-		client = redis.NewClient(&redis.Options{
-			Addr:         fmt.Sprintf("%s:%d", cfg.Redis.Address, cfg.Redis.Port),
-			Password:     cfg.Redis.Password,
-			DB:           cfg.Redis.DB,
-			DialTimeout:  time.Duration(cfg.Redis.DialTimeout) * time.Second,
-			ReadTimeout:  time.Duration(cfg.Redis.ReadTimeout) * time.Second,
-			WriteTimeout: time.Duration(cfg.Redis.WriteTimeout) * time.Second,
-			PoolSize:     cfg.Redis.PoolSize,
-			MinIdleConns: cfg.Redis.MinIdleConns,
-		})
+		client = redis.NewUniversalClient(opts)
 
 		// 测试连接
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
 		if err = client.Ping(ctx).Err(); err != nil {
-			zap.L().Warn("Failed to connect to Redis, caching disabled", zap.Error(err))
+			zap.L().Warn("Failed to connect to Redis, caching disabled", zap.Error(err), zap.String("mode", redisMode(cfg)))
+			client.Close()
 			client = nil
 			return
 		}
 
 		zap.L().Info("Redis connected successfully",
-			zap.String("address", fmt.Sprintf("%s:%d", cfg.Redis.Address, cfg.Redis.Port)),
-			zap.Int("db", cfg.Redis.DB))
+			zap.String("mode", redisMode(cfg)),
+			zap.Strings("addresses", opts.Addrs),
+			zap.Bool("tls", opts.TLSConfig != nil),
+			zap.Int("db", opts.DB))
 	})
 	return err
 }
@@ -70,12 +71,12 @@ func IsAvailable() bool {
 	return client != nil
 }
 
-// GetClient 获取 Redis 客户端（用于高级操作）
-func GetClient() *redis.Client {
+// GetClient 获取 Redis 客户端（用于高级操作）。cluster 模式下多键命令和 Lua 脚本的键必须在同一个哈希槽
+func GetClient() redis.UniversalClient {
 	return client
 }
 
-// 缓存键前缀
+// 缓存键前缀（非默认租户的键再加上 tenant:<id>: 前缀，见 tenant.Key）
 const (
 	PaymentKeyPrefix        = "payment:"
 	PaymentIntentKeyPrefix  = "payment_intent:"
@@ -104,7 +105,7 @@ func GetPayment(ctx context.Context, paymentID string) (*PaymentCacheData, error
 		return nil, nil
 	}
 
-	key := PaymentKeyPrefix + paymentID
+	key := tenant.Key(ctx, PaymentKeyPrefix+paymentID)
 	val, err := client.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return nil, nil // 缓存未命中
@@ -130,7 +131,7 @@ func SetPayment(ctx context.Context, paymentID string, data *PaymentCacheData, t
 		return nil
 	}
 
-	key := PaymentKeyPrefix + paymentID
+	key := tenant.Key(ctx, PaymentKeyPrefix+paymentID)
 	val, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payment cache: %w", err)
@@ -151,7 +152,7 @@ func DeletePayment(ctx context.Context, paymentID string) error {
 		return nil
 	}
 
-	key := PaymentKeyPrefix + paymentID
+	key := tenant.Key(ctx, PaymentKeyPrefix+paymentID)
 	if err := client.Del(ctx, key).Err(); err != nil {
 		zap.L().Warn("Failed to delete payment cache", zap.Error(err), zap.String("payment_id", paymentID))
 		return err
@@ -167,7 +168,7 @@ func GetPaymentByIntentID(ctx context.Context, paymentIntentID string) (*Payment
 		return nil, nil
 	}
 
-	key := PaymentIntentKeyPrefix + paymentIntentID
+	key := tenant.Key(ctx, PaymentIntentKeyPrefix+paymentIntentID)
 	val, err := client.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return nil, nil
@@ -193,7 +194,7 @@ func SetPaymentByIntentID(ctx context.Context, paymentIntentID string, data *Pay
 		return nil
 	}
 
-	key := PaymentIntentKeyPrefix + paymentIntentID
+	key := tenant.Key(ctx, PaymentIntentKeyPrefix+paymentIntentID)
 	val, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payment cache: %w", err)
@@ -208,22 +209,42 @@ func SetPaymentByIntentID(ctx context.Context, paymentIntentID string, data *Pay
 	return nil
 }
 
+// DeletePaymentByIntentID 删除 payment_intent_id 维度的支付缓存
+func DeletePaymentByIntentID(ctx context.Context, paymentIntentID string) error {
+	if !IsAvailable() {
+		return nil
+	}
+
+	key := tenant.Key(ctx, PaymentIntentKeyPrefix+paymentIntentID)
+	if err := client.Del(ctx, key).Err(); err != nil {
+		zap.L().Warn("Failed to delete payment cache by intent_id", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
+		return err
+	}
+
+	zap.L().Debug("Payment cache deleted by intent_id", zap.String("payment_intent_id", paymentIntentID))
+	return nil
+}
+
 // InvalidateUserPaymentCache 使某个用户的支付缓存失效
 func InvalidateUserPaymentCache(ctx context.Context, userID string) error {
 	if !IsAvailable() {
 		return nil
 	}
 
-	// 使用模式匹配删除所有相关缓存
-	pattern := UserPaymentKeyPrefix + userID + ":*"
-	keys, err := client.Keys(ctx, pattern).Result()
+	// 使用 SCAN 匹配相关缓存（cluster 模式下遍历所有主节点），逐个删除避免跨哈希槽
+	pattern := tenant.Key(ctx, UserPaymentKeyPrefix+userID+":*")
+	keys, err := scanKeys(ctx, pattern)
 	if err != nil {
 		zap.L().Warn("Failed to get user payment cache keys", zap.Error(err), zap.String("user_id", userID))
 		return err
 	}
 
 	if len(keys) > 0 {
-		if err := client.Del(ctx, keys...).Err(); err != nil {
+		pipe := client.Pipeline()
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
 			zap.L().Warn("Failed to delete user payment cache", zap.Error(err), zap.String("user_id", userID))
 			return err
 		}
@@ -233,13 +254,44 @@ func InvalidateUserPaymentCache(ctx context.Context, userID string) error {
 	return nil
 }
 
+// scanKeys 返回匹配 pattern 的所有键；cluster 模式下在每个主节点上扫描
+func scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	scan := func(ctx context.Context, c redis.Cmdable) ([]string, error) {
+		var keys []string
+		iter := c.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		return keys, iter.Err()
+	}
+
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		return scan(ctx, client)
+	}
+
+	var mu sync.Mutex
+	var keys []string
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		nodeKeys, err := scan(ctx, node)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		keys = append(keys, nodeKeys...)
+		mu.Unlock()
+		return nil
+	})
+	return keys, err
+}
+
 // GetStripeStatus 从缓存获取 Stripe 状态
 func GetStripeStatus(ctx context.Context, paymentIntentID string) (*StripeStatusCacheData, error) {
 	if !IsAvailable() {
 		return nil, nil
 	}
 
-	key := StripeStatusKeyPrefix + paymentIntentID
+	key := tenant.Key(ctx, StripeStatusKeyPrefix+paymentIntentID)
 	val, err := client.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return nil, nil // 缓存未命中
@@ -265,7 +317,7 @@ func SetStripeStatus(ctx context.Context, paymentIntentID string, data *StripeSt
 		return nil
 	}
 
-	key := StripeStatusKeyPrefix + paymentIntentID
+	key := tenant.Key(ctx, StripeStatusKeyPrefix+paymentIntentID)
 	val, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal Stripe status cache: %w", err)
@@ -286,7 +338,7 @@ func DeleteStripeStatus(ctx context.Context, paymentIntentID string) error {
 		return nil
 	}
 
-	key := StripeStatusKeyPrefix + paymentIntentID
+	key := tenant.Key(ctx, StripeStatusKeyPrefix+paymentIntentID)
 	if err := client.Del(ctx, key).Err(); err != nil {
 		zap.L().Warn("Failed to delete Stripe status cache", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
 		return err
@@ -376,7 +428,7 @@ func RecordStatusChange(ctx context.Context, paymentIntentID, oldStatus, newStat
 		Source:          source,
 	}
 
-	key := StatusChangeEventPrefix + paymentIntentID
+	key := tenant.Key(ctx, StatusChangeEventPrefix+paymentIntentID)
 	val, err := json.Marshal(event)
 	if err != nil {
 		return fmt.Errorf("failed to marshal status change event: %w", err)
@@ -402,7 +454,7 @@ func GetStatusChangeEvent(ctx context.Context, paymentIntentID string) (*StatusC
 		return nil, nil
 	}
 
-	key := StatusChangeEventPrefix + paymentIntentID
+	key := tenant.Key(ctx, StatusChangeEventPrefix+paymentIntentID)
 	val, err := client.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return nil, nil // 没有状态变化事件
@@ -427,7 +479,7 @@ func ClearStatusChangeEvent(ctx context.Context, paymentIntentID string) error {
 		return nil
 	}
 
-	key := StatusChangeEventPrefix + paymentIntentID
+	key := tenant.Key(ctx, StatusChangeEventPrefix+paymentIntentID)
 	if err := client.Del(ctx, key).Err(); err != nil {
 		zap.L().Warn("Failed to clear status change event", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
 		return err
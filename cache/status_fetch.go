@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"stripe-pay/tenant"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Stripe 状态查询合并相关的缓存前缀（键为租户前缀加调用方生成的 lookupKey：PaymentIntent ID 和 Stripe 账户选择，
+// 不同账户或测试模式的请求不会共享查询结果）
+const (
+	StatusFetchLockPrefix   = "stripe_fetch_lock:"   // 跨实例查询锁，持有者负责查询 Stripe
+	StatusFetchResultPrefix = "stripe_fetch_result:" // 持锁实例发布的查询结果，等待中的实例直接使用
+	StatusRevalidatePrefix  = "stripe_revalidate:"   // 后台刷新最小间隔标记
+)
+
+// TryLockStatusFetch 尝试获取 PaymentIntent 的查询锁（按 lookupKey）；获取成功时清除上一轮的查询结果
+func TryLockStatusFetch(ctx context.Context, lookupKey, token string, ttl time.Duration) (bool, error) {
+	if !IsAvailable() {
+		return false, fmt.Errorf("redis not available")
+	}
+
+	acquired, err := client.SetNX(ctx, tenant.Key(ctx, StatusFetchLockPrefix+lookupKey), token, ttl).Result()
+	if err != nil {
+		zap.L().Debug("Failed to lock Stripe status fetch", zap.Error(err), zap.String("lookup_key", lookupKey))
+		return false, err
+	}
+	if acquired {
+		client.Del(ctx, tenant.Key(ctx, StatusFetchResultPrefix+lookupKey))
+	}
+	return acquired, nil
+}
+
+// UnlockStatusFetch 释放查询锁（锁已过期并被其他实例获取时不删除）
+func UnlockStatusFetch(ctx context.Context, lookupKey, token string) error {
+	if !IsAvailable() {
+		return nil
+	}
+	if err := compareAndSetScript.Run(ctx, client, []string{tenant.Key(ctx, StatusFetchLockPrefix+lookupKey)}, token, "", 0).Err(); err != nil {
+		zap.L().Debug("Failed to unlock Stripe status fetch", zap.Error(err), zap.String("lookup_key", lookupKey))
+		return err
+	}
+	return nil
+}
+
+// SetStatusFetchResult 发布查询结果（包括最终状态），供等待同一查询锁的实例使用
+func SetStatusFetchResult(ctx context.Context, lookupKey string, data *StripeStatusCacheData, ttl time.Duration) error {
+	if !IsAvailable() {
+		return nil
+	}
+
+	val, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal Stripe status fetch result: %w", err)
+	}
+	if err := client.Set(ctx, tenant.Key(ctx, StatusFetchResultPrefix+lookupKey), val, ttl).Err(); err != nil {
+		zap.L().Debug("Failed to publish Stripe status fetch result", zap.Error(err), zap.String("lookup_key", lookupKey))
+		return err
+	}
+	return nil
+}
+
+// GetStatusFetchResult 获取持锁实例发布的查询结果（未发布时返回 nil）
+func GetStatusFetchResult(ctx context.Context, lookupKey string) (*StripeStatusCacheData, error) {
+	if !IsAvailable() {
+		return nil, nil
+	}
+
+	val, err := client.Get(ctx, tenant.Key(ctx, StatusFetchResultPrefix+lookupKey)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data StripeStatusCacheData
+	if err := json.Unmarshal([]byte(val), &data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Stripe status fetch result: %w", err)
+	}
+	return &data, nil
+}
+
+// MarkRevalidation 标记 PaymentIntent 即将后台刷新（按 lookupKey）；interval 内已标记过时返回 false
+func MarkRevalidation(ctx context.Context, lookupKey string, interval time.Duration) (bool, error) {
+	if !IsAvailable() {
+		return false, fmt.Errorf("redis not available")
+	}
+
+	marked, err := client.SetNX(ctx, tenant.Key(ctx, StatusRevalidatePrefix+lookupKey), time.Now().Format(time.RFC3339), interval).Result()
+	if err != nil {
+		zap.L().Debug("Failed to mark Stripe status revalidation", zap.Error(err), zap.String("lookup_key", lookupKey))
+		return false, err
+	}
+	return marked, nil
+}
@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// StripeEventPrefix 已接收的 Stripe Webhook 事件（stripe_event:<账户>:<事件ID>），同一事件只处理一次。
+// 事件在租户识别之前校验，键不带租户前缀
+const StripeEventPrefix = "stripe_event:"
+
+func stripeEventKey(account, eventID string) string {
+	return StripeEventPrefix + account + ":" + eventID
+}
+
+// MarkStripeEvent 登记事件开始处理；ttl 内已登记过（重放或重复投递）时返回 false
+func MarkStripeEvent(ctx context.Context, account, eventID string, ttl time.Duration) (bool, error) {
+	if !IsAvailable() {
+		return false, fmt.Errorf("redis not available")
+	}
+
+	marked, err := client.SetNX(ctx, stripeEventKey(account, eventID), time.Now().Format(time.RFC3339), ttl).Result()
+	if err != nil {
+		zap.L().Warn("Failed to mark Stripe event", zap.Error(err), zap.String("event_id", eventID))
+		return false, err
+	}
+	return marked, nil
+}
+
+// UnmarkStripeEvent 删除事件登记（处理失败时调用，Stripe 重新发送时可以再次处理）
+func UnmarkStripeEvent(ctx context.Context, account, eventID string) error {
+	if !IsAvailable() {
+		return nil
+	}
+	if err := client.Del(ctx, stripeEventKey(account, eventID)).Err(); err != nil {
+		zap.L().Warn("Failed to unmark Stripe event", zap.Error(err), zap.String("event_id", eventID))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"stripe-pay/admin"
+	"stripe-pay/conf"
+	"stripe-pay/db"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runCommand 执行命令行子命令，返回进程退出码
+func runCommand(args []string) int {
+	switch args[0] {
+	case "migrate":
+		return runMigrateCommand(args[1:])
+	case "admin":
+		return admin.Run(args[1:])
+	case "secrets":
+		return runSecretsCommand(args[1:])
+	case "help", "-h", "--help":
+		printUsage()
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", args[0])
+		printUsage()
+		return 2
+	}
+}
+
+// printUsage 打印命令行用法
+func printUsage() {
+	fmt.Fprintln(os.Stderr, `Usage:
+  stripe-pay                       start the HTTP server
+  stripe-pay migrate up            apply all pending database migrations
+  stripe-pay migrate down [N]      roll back the last N migrations (default 1)
+  stripe-pay migrate status        show migration status
+  stripe-pay admin <command>       operator tools (run "stripe-pay admin help")
+  stripe-pay secrets genkey        print a new base64 key for the encrypted secrets file
+  stripe-pay secrets encrypt IN OUT
+                                   encrypt a YAML secrets file with SECRETS_KEY or SECRETS_KEY_FILE`)
+}
+
+// runMigrateCommand 处理 migrate 子命令
+func runMigrateCommand(args []string) int {
+	if len(args) == 0 {
+		printUsage()
+		return 2
+	}
+
+	if err := db.Open(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate up failed after %d migration(s): %v\n", applied, err)
+			return 1
+		}
+		fmt.Printf("applied %d migration(s)\n", applied)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				fmt.Fprintf(os.Stderr, "invalid step count: %s\n", args[1])
+				return 2
+			}
+			steps = n
+		}
+		rolledBack, err := db.MigrateDown(ctx, steps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate down failed after %d migration(s): %v\n", rolledBack, err)
+			return 1
+		}
+		fmt.Printf("rolled back %d migration(s)\n", rolledBack)
+
+	case "status":
+		statuses, err := db.GetMigrationStatus(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read migration status: %v\n", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range statuses {
+			state := "pending"
+			switch {
+			case s.Missing:
+				state = "applied (unknown to this binary)"
+			case s.ChecksumMismatch:
+				state = "applied (CHECKSUM MISMATCH)"
+			case s.Applied:
+				state = "applied"
+			}
+			appliedAt := "-"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+		}
+		w.Flush()
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command: %s\n\n", args[0])
+		printUsage()
+		return 2
+	}
+
+	return 0
+}
+
+// runSecretsCommand 处理 secrets 子命令（不需要加载配置，密钥内容不会输出）
+func runSecretsCommand(args []string) int {
+	if len(args) == 0 {
+		printUsage()
+		return 2
+	}
+
+	switch args[0] {
+	case "genkey":
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to generate key: %v\n", err)
+			return 1
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(key))
+
+	case "encrypt":
+		if len(args) != 3 {
+			printUsage()
+			return 2
+		}
+		encoded := os.Getenv("SECRETS_KEY")
+		if encoded == "" && os.Getenv("SECRETS_KEY_FILE") != "" {
+			data, err := os.ReadFile(os.Getenv("SECRETS_KEY_FILE"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to read key file: %v\n", err)
+				return 1
+			}
+			encoded = string(data)
+		}
+		key, err := conf.ParseSecretsKey(encoded)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "SECRETS_KEY or SECRETS_KEY_FILE: %v\n", err)
+			return 2
+		}
+
+		plaintext, err := os.ReadFile(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", args[1], err)
+			return 1
+		}
+		var secrets map[string]string
+		if err := yaml.Unmarshal(plaintext, &secrets); err != nil {
+			fmt.Fprintf(os.Stderr, "%s must be a YAML map of secret name to value\n", args[1])
+			return 1
+		}
+		encrypted, err := conf.EncryptSecrets(plaintext, key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to encrypt: %v\n", err)
+			return 1
+		}
+		if err := os.WriteFile(args[2], encrypted, 0o600); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", args[2], err)
+			return 1
+		}
+		fmt.Printf("encrypted %d secret(s) to %s\n", len(secrets), args[2])
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown secrets command: %s\n\n", args[0])
+		printUsage()
+		return 2
+	}
+
+	return 0
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"stripe-pay/conf"
+	"stripe-pay/tenant"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"go.uber.org/zap"
+)
+
+// AdminAuthMiddleware 管理接口鉴权中间件
+// 请求需携带 Authorization: Bearer <admin.api_key> 或 X-Admin-Key: <admin.api_key>；未配置密钥时拒绝所有请求
+func AdminAuthMiddleware() app.HandlerFunc {
+	return func(ctx context.Context, c *app.RequestContext) {
+		apiKey := conf.GetConf().Admin.APIKey
+		if apiKey == "" {
+			zap.L().Warn("Admin API request rejected: admin.api_key is not configured",
+				zap.String("path", string(c.Path())))
+			SendError(c, ErrForbidden.WithDetails("admin API is disabled"))
+			c.Abort()
+			return
+		}
+
+		provided := string(c.GetHeader("X-Admin-Key"))
+		if provided == "" {
+			auth := string(c.GetHeader("Authorization"))
+			if strings.HasPrefix(auth, "Bearer ") {
+				provided = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+			}
+		}
+
+		if provided == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(apiKey)) != 1 {
+			zap.L().Warn("Admin API request rejected: invalid credentials",
+				zap.String("path", string(c.Path())),
+				zap.String("ip", c.ClientIP()))
+			SendError(c, ErrUnauthorized.WithDetails("invalid admin credentials"))
+			c.Abort()
+			return
+		}
+
+		c.Next(ctx)
+	}
+}
+
+// UserTokenHeader 用户令牌请求头
+const UserTokenHeader = "X-User-Token"
+
+// SignUserToken 生成用户令牌：<过期时间戳>.<HMAC-SHA256(secret, "租户ID:用户ID:过期时间戳") 的十六进制>。
+// 令牌由租户后端在用户登录后签发，前端调用 /user/:user_id 下需要用户身份的接口时放在 X-User-Token 请求头
+func SignUserToken(secret, tenantID, userID string, expiresAt int64) string {
+	exp := strconv.FormatInt(expiresAt, 10)
+	return exp + "." + userTokenSignature(secret, tenantID, userID, exp)
+}
+
+func userTokenSignature(secret, tenantID, userID, exp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(tenantID + ":" + userID + ":" + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyUserToken 校验令牌属于该租户和用户、未过期，且有效期不超过 maxTTL
+func verifyUserToken(secret, token, tenantID, userID string, now time.Time, maxTTL time.Duration) error {
+	exp, signature, ok := strings.Cut(token, ".")
+	if !ok || exp == "" || signature == "" {
+		return errors.New("malformed user token")
+	}
+	expiresAt, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return errors.New("malformed user token")
+	}
+	want := userTokenSignature(secret, tenantID, userID, exp)
+	if subtle.ConstantTimeCompare([]byte(signature), []byte(want)) != 1 {
+		return errors.New("invalid user token")
+	}
+	if expiresAt <= now.Unix() {
+		return errors.New("user token expired")
+	}
+	if expiresAt > now.Add(maxTTL).Unix() {
+		return errors.New("user token lifetime exceeds user_auth.max_ttl")
+	}
+	return nil
+}
+
+// UserAuthMiddleware 用户接口鉴权中间件：X-User-Token 必须是当前租户为路径中 user_id 签发的令牌；
+// 未配置 user_auth.token_secret 时拒绝所有请求
+func UserAuthMiddleware() app.HandlerFunc {
+	return func(ctx context.Context, c *app.RequestContext) {
+		if !AuthenticateUser(ctx, c, c.Param("user_id")) {
+			c.Abort()
+			return
+		}
+		c.Next(ctx)
+	}
+}
+
+// AuthenticateUser 校验 X-User-Token 是当前租户为 userID 签发的令牌，失败时写入错误响应并返回 false。
+// user_id 不在路径中的接口（请求体或查询参数中的 user_id）在处理函数中调用
+func AuthenticateUser(ctx context.Context, c *app.RequestContext, userID string) bool {
+	cfg := conf.GetConf()
+	if cfg.UserAuth.TokenSecret == "" {
+		zap.L().Warn("User API request rejected: user_auth.token_secret is not configured",
+			zap.String("path", string(c.Path())))
+		SendError(c, ErrForbidden.WithDetails("user authentication is not configured"))
+		return false
+	}
+
+	token := strings.TrimSpace(string(c.GetHeader(UserTokenHeader)))
+	maxTTL := time.Duration(cfg.UserAuth.MaxTTL) * time.Second
+	if err := verifyUserToken(cfg.UserAuth.TokenSecret, token, tenant.ID(ctx), userID, time.Now(), maxTTL); err != nil {
+		zap.L().Warn("User API request rejected",
+			zap.String("path", string(c.Path())),
+			zap.String("user_id", userID),
+			zap.String("ip", c.ClientIP()),
+			zap.Error(err))
+		SendError(c, ErrUnauthorized.WithDetails(err.Error()))
+		return false
+	}
+	return true
+}
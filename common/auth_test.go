@@ -0,0 +1,38 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+// TestVerifyUserToken 测试用户令牌校验
+func TestVerifyUserToken(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	valid := SignUserToken("secret", "acme", "user_1", now.Add(10*time.Minute).Unix())
+
+	tests := []struct {
+		name     string
+		token    string
+		tenantID string
+		userID   string
+		wantErr  bool
+	}{
+		{"有效令牌", valid, "acme", "user_1", false},
+		{"其他用户", valid, "acme", "user_2", true},
+		{"其他租户", valid, "default", "user_1", true},
+		{"其他密钥签发", SignUserToken("other", "acme", "user_1", now.Add(10*time.Minute).Unix()), "acme", "user_1", true},
+		{"已过期", SignUserToken("secret", "acme", "user_1", now.Add(-time.Second).Unix()), "acme", "user_1", true},
+		{"有效期超过上限", SignUserToken("secret", "acme", "user_1", now.Add(2*time.Hour).Unix()), "acme", "user_1", true},
+		{"格式错误", "not-a-token", "acme", "user_1", true},
+		{"缺少令牌", "", "acme", "user_1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyUserToken("secret", tt.token, tt.tenantID, tt.userID, now, time.Hour)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyUserToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
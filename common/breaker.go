@@ -0,0 +1,216 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 熔断器状态
+const (
+	BreakerClosed   = "closed"    // 正常放行
+	BreakerOpen     = "open"      // 熔断中，直接拒绝
+	BreakerHalfOpen = "half_open" // 熔断到期，放行一个探测请求
+)
+
+// BreakerSettings 熔断器参数
+type BreakerSettings struct {
+	FailureThreshold int           // 连续失败多少次后熔断
+	OpenTimeout      time.Duration // 熔断持续时间，之后进入半开状态
+}
+
+// CircuitOpenError 熔断器打开时返回的错误
+type CircuitOpenError struct {
+	Upstream   string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s is temporarily unavailable (circuit open)", e.Upstream)
+}
+
+// IsCircuitOpen 判断错误是否由熔断引起
+func IsCircuitOpen(err error) bool {
+	var openErr *CircuitOpenError
+	return errors.As(err, &openErr)
+}
+
+// CircuitBreaker 连续失败计数熔断器
+type CircuitBreaker struct {
+	name     string
+	settings BreakerSettings
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool // 半开状态下是否已有探测请求
+	probeAt   time.Time
+	now       func() time.Time
+	onChanged func(name, state string)
+}
+
+var (
+	breakersMu sync.RWMutex
+	breakers   = make(map[string]*CircuitBreaker)
+)
+
+// NewCircuitBreaker 创建熔断器并注册（同名熔断器会被替换），状态变化同步到 Prometheus
+func NewCircuitBreaker(name string, settings BreakerSettings) *CircuitBreaker {
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = 5
+	}
+	if settings.OpenTimeout <= 0 {
+		settings.OpenTimeout = 30 * time.Second
+	}
+	b := &CircuitBreaker{
+		name:      name,
+		settings:  settings,
+		state:     BreakerClosed,
+		now:       time.Now,
+		onChanged: SetBreakerState,
+	}
+	b.onChanged(name, BreakerClosed)
+
+	breakersMu.Lock()
+	breakers[name] = b
+	breakersMu.Unlock()
+	return b
+}
+
+// Name 熔断器名称（上游服务名）
+func (b *CircuitBreaker) Name() string {
+	return b.name
+}
+
+// State 当前状态（熔断到期后返回 half_open）
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Allow 判断是否放行请求；熔断中返回 *CircuitOpenError。放行后必须调用 Done 报告结果
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		elapsed := b.now().Sub(b.openedAt)
+		if elapsed < b.settings.OpenTimeout {
+			RecordUpstreamRequest(b.name, "rejected")
+			return &CircuitOpenError{Upstream: b.name, RetryAfter: b.settings.OpenTimeout - elapsed}
+		}
+		b.setState(BreakerHalfOpen)
+		b.probing, b.probeAt = true, b.now()
+		return nil
+	case BreakerHalfOpen:
+		// 探测请求未报告结果（超过熔断时间）时允许新的探测
+		if b.probing && b.now().Sub(b.probeAt) < b.settings.OpenTimeout {
+			RecordUpstreamRequest(b.name, "rejected")
+			return &CircuitOpenError{Upstream: b.name, RetryAfter: time.Second}
+		}
+		b.probing, b.probeAt = true, b.now()
+		return nil
+	}
+	return nil
+}
+
+// Done 报告请求结果：成功时关闭熔断器，失败累计到阈值（或半开探测失败）时打开
+func (b *CircuitBreaker) Done(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		RecordUpstreamRequest(b.name, "success")
+		b.failures = 0
+		b.probing = false
+		if b.state != BreakerClosed {
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	RecordUpstreamRequest(b.name, "failure")
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.settings.FailureThreshold {
+		b.probing = false
+		b.openedAt = b.now()
+		if b.state != BreakerOpen {
+			b.setState(BreakerOpen)
+		}
+	}
+}
+
+// setState 切换状态（调用方持有锁）
+func (b *CircuitBreaker) setState(state string) {
+	b.state = state
+	if b.onChanged != nil {
+		b.onChanged(b.name, state)
+	}
+}
+
+// BreakerStates 所有熔断器的当前状态（上游服务名 -> 状态）
+func BreakerStates() map[string]string {
+	breakersMu.RLock()
+	list := make([]*CircuitBreaker, 0, len(breakers))
+	for _, b := range breakers {
+		list = append(list, b)
+	}
+	breakersMu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	states := make(map[string]string, len(list))
+	for _, b := range list {
+		states[b.name] = b.State()
+	}
+	return states
+}
+
+// RetryPolicy 重试策略（指数退避 + 全抖动），只用于幂等操作
+type RetryPolicy struct {
+	MaxRetries int           // 最大重试次数（不含首次请求）
+	BaseDelay  time.Duration // 首次重试的最大等待时间
+	MaxDelay   time.Duration // 单次等待上限
+}
+
+// Backoff 第 attempt 次重试（从 1 开始）前的等待时间，在 [0, min(MaxDelay, BaseDelay*2^(attempt-1))] 内随机
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay
+	for i := 1; i < attempt && ceiling < p.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	if p.MaxDelay > 0 && ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Do 执行 fn，retryable 返回 true 时按策略重试；熔断或 ctx 结束时立即返回
+func (p RetryPolicy) Do(ctx context.Context, fn func() error, retryable func(error) bool) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil || attempt >= p.MaxRetries || IsCircuitOpen(err) || !retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(p.Backoff(attempt + 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
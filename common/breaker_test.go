@@ -0,0 +1,103 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestCircuitBreaker 测试熔断器状态转换
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+	b := NewCircuitBreaker("test_upstream", BreakerSettings{FailureThreshold: 3, OpenTimeout: 30 * time.Second})
+	b.now = func() time.Time { return now }
+
+	// 未达到阈值时保持关闭，成功会清零失败计数
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("Allow() = %v, want nil", err)
+		}
+		b.Done(false)
+	}
+	b.Done(true)
+	for i := 0; i < 2; i++ {
+		b.Done(false)
+	}
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("State() = %s, want closed", got)
+	}
+
+	// 连续失败达到阈值后熔断
+	b.Done(false)
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("State() = %s, want open", got)
+	}
+	err := b.Allow()
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || openErr.RetryAfter != 30*time.Second {
+		t.Fatalf("Allow() = %v, want CircuitOpenError with RetryAfter 30s", err)
+	}
+
+	// 熔断到期后只放行一个探测请求，探测失败重新熔断
+	now = now.Add(31 * time.Second)
+	if got := b.State(); got != BreakerHalfOpen {
+		t.Fatalf("State() = %s, want half_open", got)
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe Allow() = %v, want nil", err)
+	}
+	if err := b.Allow(); !IsCircuitOpen(err) {
+		t.Fatalf("second Allow() during probe = %v, want circuit open", err)
+	}
+	b.Done(false)
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("State() after failed probe = %s, want open", got)
+	}
+
+	// 探测成功后关闭
+	now = now.Add(31 * time.Second)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe Allow() = %v, want nil", err)
+	}
+	b.Done(true)
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("State() after successful probe = %s, want closed", got)
+	}
+	if got := BreakerStates()["test_upstream"]; got != BreakerClosed {
+		t.Errorf("BreakerStates()[test_upstream] = %s, want closed", got)
+	}
+}
+
+// TestRetryPolicy 测试退避时间范围和重试次数
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 2, BaseDelay: 100 * time.Millisecond, MaxDelay: 250 * time.Millisecond}
+	for attempt, ceiling := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: 250 * time.Millisecond} {
+		for i := 0; i < 50; i++ {
+			if d := policy.Backoff(attempt); d < 0 || d > ceiling {
+				t.Fatalf("Backoff(%d) = %v, want within [0, %v]", attempt, d, ceiling)
+			}
+		}
+	}
+
+	fast := RetryPolicy{MaxRetries: 2}
+	retryable := func(err error) bool { return err.Error() != "fatal" }
+	tests := []struct {
+		name      string
+		err       error
+		wantCalls int
+	}{
+		{"可重试错误重试到上限", errors.New("retryable"), 3},
+		{"不可重试错误不重试", errors.New("fatal"), 1},
+		{"熔断不重试", &CircuitOpenError{Upstream: "apple"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := fast.Do(context.Background(), func() error { calls++; return tt.err }, retryable)
+			if err != tt.err || calls != tt.wantCalls {
+				t.Errorf("Do() = %v after %d calls, want %v after %d calls", err, calls, tt.err, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"stripe-pay/conf"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// 默认 CORS 设置（与原先硬编码的行为一致）
+var (
+	defaultCORSMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	defaultCORSHeaders = []string{"Content-Type", "Authorization", "Accept"}
+)
+
+const defaultCORSMaxAge = 43200 // 12 hours
+
+// CORSMiddleware 全局 CORS 处理：每次请求读取当前配置，修改 cors 配置后重新加载即可生效。
+// 必须放在最前面，确保所有响应都包含 CORS 头；OPTIONS 预检请求直接返回。
+func CORSMiddleware() app.HandlerFunc {
+	return func(ctx context.Context, c *app.RequestContext) {
+		cfg := conf.GetConf()
+		origin := string(c.Request.Header.Get("Origin"))
+
+		if allowOrigin := corsAllowOrigin(cfg.CORS.AllowOrigins, origin); allowOrigin != "" {
+			c.Header("Access-Control-Allow-Origin", allowOrigin)
+			if allowOrigin != "*" {
+				c.Header("Vary", "Origin")
+			}
+		}
+		c.Header("Access-Control-Allow-Methods", strings.Join(orDefault(cfg.CORS.AllowMethods, defaultCORSMethods), ", "))
+		c.Header("Access-Control-Allow-Headers", strings.Join(orDefault(cfg.CORS.AllowHeaders, defaultCORSHeaders), ", "))
+		c.Header("Access-Control-Expose-Headers", "Content-Length")
+		c.Header("Access-Control-Allow-Credentials", "false")
+		maxAge := cfg.CORS.MaxAge
+		if maxAge == 0 {
+			maxAge = defaultCORSMaxAge
+		}
+		c.Header("Access-Control-Max-Age", strconv.Itoa(maxAge))
+
+		if string(c.Request.Method()) == "OPTIONS" {
+			c.JSON(consts.StatusOK, utils.H{})
+			c.Abort()
+			return
+		}
+		c.Next(ctx)
+	}
+}
+
+// corsAllowOrigin 计算 Access-Control-Allow-Origin：
+// 未配置或包含 * 时回显请求的 Origin（没有 Origin 时为 *）；否则只回显列表中的来源，不匹配时返回空
+func corsAllowOrigin(allowed []string, origin string) string {
+	allowAll := len(allowed) == 0
+	for _, o := range allowed {
+		if o == "*" {
+			allowAll = true
+			break
+		}
+	}
+	if allowAll {
+		if origin != "" {
+			return origin
+		}
+		return "*"
+	}
+	for _, o := range allowed {
+		if strings.EqualFold(strings.TrimRight(o, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+func orDefault(values, fallback []string) []string {
+	if len(values) == 0 {
+		return fallback
+	}
+	return values
+}
@@ -0,0 +1,27 @@
+package common
+
+import "testing"
+
+// TestCORSAllowOrigin 测试允许的来源
+func TestCORSAllowOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    string
+	}{
+		{"未配置时回显来源", nil, "https://a.example.com", "https://a.example.com"},
+		{"未配置且没有来源", nil, "", "*"},
+		{"通配符", []string{"*"}, "https://b.example.com", "https://b.example.com"},
+		{"匹配列表", []string{"https://a.example.com/"}, "https://a.example.com", "https://a.example.com"},
+		{"不在列表中", []string{"https://a.example.com"}, "https://evil.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := corsAllowOrigin(tt.allowed, tt.origin); got != tt.want {
+				t.Errorf("corsAllowOrigin() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package common
+
+import (
+	"context"
+	"stripe-pay/db"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// isReadOnlyMethod 不修改数据的请求方法
+func isReadOnlyMethod(method string) bool {
+	switch method {
+	case consts.MethodGet, consts.MethodHead, consts.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// PrimaryReadsMiddleware 写请求（POST、PUT、DELETE 等）内的所有数据库读取固定到主库，保证读到本请求刚写入的数据；
+// 只读请求按 db 层的路由读取从库
+func PrimaryReadsMiddleware() app.HandlerFunc {
+	return func(ctx context.Context, c *app.RequestContext) {
+		if isReadOnlyMethod(string(c.Method())) {
+			c.Next(ctx)
+			return
+		}
+		c.Next(db.WithPrimary(ctx))
+	}
+}
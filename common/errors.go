@@ -47,6 +47,9 @@ var (
 	// 409 Conflict
 	ErrConflict = &APIError{Code: consts.StatusConflict, Message: "Resource conflict"}
 
+	// 422 Unprocessable Entity
+	ErrIdempotencyKeyReused = &APIError{Code: consts.StatusUnprocessableEntity, Message: "Idempotency key reused"}
+
 	// 429 Too Many Requests
 	ErrTooManyRequests = &APIError{Code: consts.StatusTooManyRequests, Message: "Too many requests"}
 
@@ -94,6 +97,11 @@ func WrapError(err error) *APIError {
 		return apiErr
 	}
 
+	// 外部服务熔断中
+	if IsCircuitOpen(err) {
+		return ErrServiceUnavailable.WithDetails(err.Error())
+	}
+
 	// 检查是否是已知的错误类型
 	errStr := strings.ToLower(err.Error())
 
@@ -0,0 +1,174 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"stripe-pay/cache"
+	"stripe-pay/conf"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// 幂等中间件默认参数
+const (
+	DefaultIdempotencyLockTTL     = time.Minute    // 首个请求处理超时后释放锁
+	DefaultIdempotencyResponseTTL = 24 * time.Hour // 保存响应的时间
+	MaxIdempotencyKeyLength       = 255
+	maxIdempotentResponseSize     = 1 << 20 // 超过 1MB 的响应不保存
+)
+
+// IdempotentReplayedHeader 重放已保存响应时设置的响应头
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// idempotencyKeyFromRequest 读取幂等键（Idempotency-Key 或 X-Idempotency-Key）
+func idempotencyKeyFromRequest(c *app.RequestContext) string {
+	if key := string(c.GetHeader("Idempotency-Key")); key != "" {
+		return key
+	}
+	return string(c.GetHeader("X-Idempotency-Key"))
+}
+
+// credentialHeaders 计入请求指纹的凭证请求头（管理员、租户 API Key 和用户令牌）。
+// 中间件在鉴权之前执行，没有有效凭证的请求不能重放其他调用方保存的响应
+var credentialHeaders = []string{"Authorization", "X-Admin-Key", APIKeyHeader, UserTokenHeader}
+
+// requestCredentials 拼接调用方凭证（header 读取请求头）
+func requestCredentials(header func(key string) []byte) string {
+	parts := make([]string, 0, len(credentialHeaders))
+	for _, name := range credentialHeaders {
+		parts = append(parts, string(header(name)))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// requestFingerprint 请求指纹（方法、路径和参数、调用方凭证、请求体）
+// 包含凭证，不同调用方使用同一幂等键时视为不同请求，不会重放其他调用方的响应
+func requestFingerprint(method, requestURI, credentials string, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{method, requestURI, credentials} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// shouldStoreIdempotentResponse 是否保存响应：5xx 和鉴权、冲突、限流等可重试的响应不保存
+func shouldStoreIdempotentResponse(statusCode, size int) bool {
+	if size > maxIdempotentResponseSize {
+		return false
+	}
+	switch statusCode {
+	case consts.StatusUnauthorized, consts.StatusConflict, consts.StatusTooManyRequests:
+		return false
+	}
+	return statusCode < consts.StatusInternalServerError
+}
+
+// idempotencyTTLs 锁和保存响应的时间（未配置时使用默认值）
+func idempotencyTTLs(cfg *conf.Config) (lockTTL, responseTTL time.Duration) {
+	lockTTL = DefaultIdempotencyLockTTL
+	if cfg.Idempotency.LockTTL > 0 {
+		lockTTL = time.Duration(cfg.Idempotency.LockTTL) * time.Second
+	}
+	responseTTL = DefaultIdempotencyResponseTTL
+	if cfg.Idempotency.ResponseTTL > 0 {
+		responseTTL = time.Duration(cfg.Idempotency.ResponseTTL) * time.Second
+	}
+	return lockTTL, responseTTL
+}
+
+// IdempotencyMiddleware 通用幂等中间件
+// 对携带 Idempotency-Key 的 POST/PUT 请求：在 Redis 中锁定幂等键，保存状态码和响应体，
+// 处理中的重复请求返回 409，已完成的请求重放保存的响应，同一幂等键配合不同请求体返回 422。
+// Redis 不可用时直接放行（创建支付接口仍有数据库幂等兜底）
+func IdempotencyMiddleware() app.HandlerFunc {
+	return func(ctx context.Context, c *app.RequestContext) {
+		method := string(c.Method())
+		if method != consts.MethodPost && method != consts.MethodPut {
+			c.Next(ctx)
+			return
+		}
+		key := idempotencyKeyFromRequest(c)
+		if key == "" {
+			c.Next(ctx)
+			return
+		}
+		if len(key) > MaxIdempotencyKeyLength {
+			SendError(c, ErrInvalidParameter.WithDetails(fmt.Sprintf("Idempotency-Key must not exceed %d characters", MaxIdempotencyKeyLength)))
+			c.Abort()
+			return
+		}
+		if !cache.IsAvailable() {
+			c.Next(ctx)
+			return
+		}
+
+		// 每个请求读取当前配置，重新加载配置后新的超时时间立即生效
+		lockTTL, responseTTL := idempotencyTTLs(conf.GetConf())
+		path := string(c.Path())
+		fingerprint := requestFingerprint(method, string(c.Request.RequestURI()), requestCredentials(c.GetHeader), c.Request.Body())
+		lock, existing, err := cache.AcquireIdempotencyKey(ctx, key, fingerprint, uuid.New().String(), lockTTL)
+		if err != nil {
+			zap.L().Warn("Idempotency check failed, processing request without it", zap.Error(err), zap.String("path", path))
+			c.Next(ctx)
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != fingerprint:
+				RecordIdempotentRequest("mismatch")
+				zap.L().Warn("Idempotency key reused with different request",
+					zap.String("idempotency_key", key), zap.String("path", path))
+				SendError(c, ErrIdempotencyKeyReused.WithDetails("Idempotency-Key was already used with a different request"))
+			case existing.State == cache.IdempotencyInFlight:
+				RecordIdempotentRequest("in_flight")
+				c.Header("Retry-After", "1")
+				SendError(c, ErrConflict.WithDetails("A request with this Idempotency-Key is still being processed"))
+			default:
+				RecordIdempotentRequest("replayed")
+				zap.L().Info("Replaying stored idempotent response",
+					zap.String("idempotency_key", key), zap.String("path", path), zap.Int("status", existing.StatusCode))
+				c.Header(IdempotentReplayedHeader, "true")
+				c.Data(existing.StatusCode, existing.ContentType, existing.Body)
+			}
+			c.Abort()
+			return
+		}
+
+		// 请求未完成（包括 panic）时释放锁，允许客户端重试
+		completed := false
+		defer func() {
+			if !completed {
+				_ = lock.Release(context.WithoutCancel(ctx))
+			}
+		}()
+
+		c.Next(ctx)
+
+		statusCode := c.Response.StatusCode()
+		body := c.Response.Body()
+		if !shouldStoreIdempotentResponse(statusCode, len(body)) {
+			return
+		}
+		record := &cache.IdempotencyRecord{
+			Fingerprint: fingerprint,
+			StatusCode:  statusCode,
+			ContentType: string(c.Response.Header.ContentType()),
+			Body:        append([]byte(nil), body...),
+			CreatedAt:   time.Now().Format(time.RFC3339),
+		}
+		if err := lock.Complete(context.WithoutCancel(ctx), record, responseTTL); err == nil {
+			completed = true
+			RecordIdempotentRequest("stored")
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"stripe-pay/conf"
+	"testing"
+	"time"
+)
+
+// TestShouldStoreIdempotentResponse 测试哪些响应会被保存用于重放
+func TestShouldStoreIdempotentResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		size       int
+		want       bool
+	}{
+		{"成功响应", 200, 100, true},
+		{"参数错误", 400, 100, true},
+		{"业务拒绝", 403, 100, true},
+		{"未鉴权可重试", 401, 100, false},
+		{"冲突可重试", 409, 100, false},
+		{"限流可重试", 429, 100, false},
+		{"服务端错误可重试", 500, 100, false},
+		{"响应过大", 200, maxIdempotentResponseSize + 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldStoreIdempotentResponse(tt.statusCode, tt.size); got != tt.want {
+				t.Errorf("shouldStoreIdempotentResponse(%d, %d) = %v, want %v", tt.statusCode, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRequestFingerprint 测试请求指纹区分方法、路径、凭证和请求体
+func TestRequestFingerprint(t *testing.T) {
+	base := requestFingerprint("POST", "/api/v1/stripe/refund", "", []byte(`{"amount":100}`))
+	if base != requestFingerprint("POST", "/api/v1/stripe/refund", "", []byte(`{"amount":100}`)) {
+		t.Error("same request should have the same fingerprint")
+	}
+
+	others := []string{
+		requestFingerprint("PUT", "/api/v1/stripe/refund", "", []byte(`{"amount":100}`)),
+		requestFingerprint("POST", "/api/v1/payment/config", "", []byte(`{"amount":100}`)),
+		requestFingerprint("POST", "/api/v1/stripe/refund", "Bearer admin", []byte(`{"amount":100}`)),
+		requestFingerprint("POST", "/api/v1/stripe/refund", "", []byte(`{"amount":200}`)),
+	}
+	for i, other := range others {
+		if other == base {
+			t.Errorf("case %d: different request should have a different fingerprint", i)
+		}
+	}
+}
+
+// TestRequestCredentials 测试用户令牌和租户 API Key 计入调用方凭证
+func TestRequestCredentials(t *testing.T) {
+	credentials := func(headers map[string]string) string {
+		return requestCredentials(func(key string) []byte { return []byte(headers[key]) })
+	}
+
+	base := credentials(nil)
+	for _, header := range []string{"Authorization", "X-Admin-Key", "X-API-Key", "X-User-Token"} {
+		if credentials(map[string]string{header: "secret"}) == base {
+			t.Errorf("%s should change the credentials", header)
+		}
+	}
+	if credentials(map[string]string{"Content-Type": "application/json"}) != base {
+		t.Error("non-credential headers should not change the credentials")
+	}
+}
+
+// TestIdempotencyTTLs 测试锁和响应保存时间的默认值和配置值
+func TestIdempotencyTTLs(t *testing.T) {
+	cfg := &conf.Config{}
+	lockTTL, responseTTL := idempotencyTTLs(cfg)
+	if lockTTL != DefaultIdempotencyLockTTL || responseTTL != DefaultIdempotencyResponseTTL {
+		t.Errorf("idempotencyTTLs() = %v, %v, want defaults", lockTTL, responseTTL)
+	}
+
+	cfg.Idempotency.LockTTL = 30
+	cfg.Idempotency.ResponseTTL = 3600
+	lockTTL, responseTTL = idempotencyTTLs(cfg)
+	if lockTTL != 30*time.Second || responseTTL != time.Hour {
+		t.Errorf("idempotencyTTLs() = %v, %v, want 30s, 1h", lockTTL, responseTTL)
+	}
+}
@@ -132,6 +132,134 @@ var (
 		[]string{"operation", "status"},
 	)
 
+	// 对账指标
+	reconciliationRunsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "reconciliation_runs_total",
+			Help: "Total number of reconciliation runs",
+		},
+		[]string{"status"},
+	)
+
+	reconciliationMismatchesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "reconciliation_mismatches_total",
+			Help: "Total number of payment_history rows whose status differed from Stripe",
+		},
+		[]string{"source"},
+	)
+
+	reconciliationLastMismatches = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "reconciliation_last_run_mismatches",
+			Help: "Number of mismatches found by the last reconciliation run",
+		},
+	)
+
+	// 支付过期指标
+	paymentExpiredTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payment_expired_total",
+			Help: "Total number of abandoned PaymentIntents canceled after their TTL",
+		},
+		[]string{"payment_method"},
+	)
+
+	// 手动扣款授权指标
+	authorizationsExpiring = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "payment_authorizations_expiring",
+			Help: "Number of uncaptured authorizations within the warning window before they expire",
+		},
+	)
+
+	authorizationsAutoCanceledTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "payment_authorizations_auto_canceled_total",
+			Help: "Total number of uncaptured authorizations canceled before expiry",
+		},
+	)
+
+	// 通知指标
+	notificationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "notifications_total",
+			Help: "Total number of notification send attempts",
+		},
+		[]string{"kind", "status"}, // status: sent, failed
+	)
+
+	// 外部服务调用和熔断器指标
+	upstreamRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "upstream_requests_total",
+			Help: "Total number of calls to external services (Stripe, Apple) by result",
+		},
+		[]string{"upstream", "result"}, // result: success, failure, rejected
+	)
+
+	circuitBreakerState = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "Circuit breaker state per upstream (0 closed, 1 half-open, 2 open)",
+		},
+		[]string{"upstream"},
+	)
+
+	// 幂等中间件指标
+	idempotentRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "idempotent_requests_total",
+			Help: "Total number of requests carrying an Idempotency-Key by outcome",
+		},
+		[]string{"outcome"}, // stored, replayed, in_flight, mismatch
+	)
+
+	// 支付状态查询指标
+	paymentStatusLookupsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payment_status_lookups_total",
+			Help: "Total number of payment status lookups by result",
+		},
+		[]string{"result"}, // hit, miss, coalesced
+	)
+
+	// Webhook 签名校验指标（secret 为密钥名称，none 表示没有密钥匹配）
+	webhookSignaturesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stripe_webhook_signatures_total",
+			Help: "Total number of Stripe webhook signature checks by Stripe account, matched secret and result",
+		},
+		[]string{"account", "secret", "result"}, // result: valid, invalid, too_old
+	)
+
+	// 风控指标
+	fraudDecisionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fraud_decisions_total",
+			Help: "Total number of fraud rule decisions before payment creation",
+		},
+		[]string{"payment_method", "decision", "rule_type"}, // decision: allow, block, review
+	)
+
+	// 后台任务指标
+	jobRunsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "background_job_runs_total",
+			Help: "Total number of background job runs",
+		},
+		[]string{"job", "status"},
+	)
+
+	jobRunDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "background_job_duration_seconds",
+			Help:    "Background job run duration in seconds",
+			Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300},
+		},
+		[]string{"job"},
+	)
+
 	// 系统指标
 	activeConnections = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -224,6 +352,86 @@ func RecordRedisOperation(operation, status string, duration time.Duration) {
 	}
 }
 
+// RecordReconciliationMismatch 记录一条对账不一致（source: stale_scan, event）
+func RecordReconciliationMismatch(source string) {
+	reconciliationMismatchesTotal.WithLabelValues(source).Inc()
+}
+
+// RecordReconciliationRun 记录一次对账运行结果
+func RecordReconciliationRun(status string, mismatches int) {
+	reconciliationRunsTotal.WithLabelValues(status).Inc()
+	reconciliationLastMismatches.Set(float64(mismatches))
+}
+
+// RecordPaymentExpired 记录一次过期支付取消
+func RecordPaymentExpired(paymentMethod string) {
+	paymentExpiredTotal.WithLabelValues(paymentMethod).Inc()
+}
+
+// RecordAuthorizationsExpiring 记录即将失效的未扣款授权数量
+func RecordAuthorizationsExpiring(count int) {
+	authorizationsExpiring.Set(float64(count))
+}
+
+// RecordAuthorizationAutoCanceled 记录一次授权到期前自动取消
+func RecordAuthorizationAutoCanceled() {
+	authorizationsAutoCanceledTotal.Inc()
+}
+
+// RecordNotification 记录一次通知发送（status: sent, failed）
+func RecordNotification(kind, status string) {
+	notificationsTotal.WithLabelValues(kind, status).Inc()
+}
+
+// RecordUpstreamRequest 记录一次外部服务调用（result: success, failure, rejected）
+func RecordUpstreamRequest(upstream, result string) {
+	upstreamRequestsTotal.WithLabelValues(upstream, result).Inc()
+}
+
+// SetBreakerState 更新熔断器状态指标
+func SetBreakerState(upstream, state string) {
+	value := 0.0
+	switch state {
+	case BreakerHalfOpen:
+		value = 1
+	case BreakerOpen:
+		value = 2
+	}
+	circuitBreakerState.WithLabelValues(upstream).Set(value)
+}
+
+// RecordIdempotentRequest 记录携带幂等键的请求（outcome: stored, replayed, in_flight, mismatch）
+func RecordIdempotentRequest(outcome string) {
+	idempotentRequestsTotal.WithLabelValues(outcome).Inc()
+}
+
+// RecordPaymentStatusLookup 记录一次支付状态查询（result: hit, miss, coalesced）
+func RecordPaymentStatusLookup(result string) {
+	paymentStatusLookupsTotal.WithLabelValues(result).Inc()
+}
+
+// RecordWebhookSignature 记录一次 Webhook 签名校验（account 为 Stripe 账户标识，secret 为匹配的密钥名称）
+func RecordWebhookSignature(account, secret, result string) {
+	webhookSignaturesTotal.WithLabelValues(account, secret, result).Inc()
+}
+
+// RecordFraudDecision 记录一次风控决策（ruleType 为空表示未命中规则）
+func RecordFraudDecision(paymentMethod, decision, ruleType string) {
+	fraudDecisionsTotal.WithLabelValues(paymentMethod, decision, ruleType).Inc()
+}
+
+// RecordJobRun 记录后台任务运行指标（status: success, error, skipped）
+func RecordJobRun(job, status string, duration time.Duration) {
+	jobRunsTotal.WithLabelValues(job, status).Inc()
+	if status != "skipped" {
+		jobRunDuration.WithLabelValues(job).Observe(duration.Seconds())
+	}
+
+	if status == "error" {
+		errorsTotal.WithLabelValues("job_error", job).Inc()
+	}
+}
+
 // SetActiveConnections 设置活跃连接数
 func SetActiveConnections(count float64) {
 	activeConnections.Set(count)
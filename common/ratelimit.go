@@ -3,10 +3,13 @@ package common
 import (
 	"context"
 	"fmt"
+	"net"
 	"strings"
 	"stripe-pay/cache"
 	"stripe-pay/conf"
+	"stripe-pay/tenant"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudwego/hertz/pkg/app"
@@ -57,20 +60,54 @@ var (
 	}
 )
 
-// initRateLimitStrategy 从配置初始化速率限制策略
-func initRateLimitStrategy() RateLimitStrategy {
-	_ = conf.GetConf() // 预留配置读取
-	strategy := defaultStrategy
+// currentStrategy 当前生效的策略，配置重新加载时整体替换
+var currentStrategy atomic.Pointer[RateLimitStrategy]
+
+func init() {
+	conf.Subscribe("ratelimit", func(_, cfg *conf.Config) {
+		strategy := strategyFromConfig(cfg)
+		currentStrategy.Store(&strategy)
+		zap.L().Info("Rate limit strategy updated",
+			zap.Int("global_limit", strategy.Global.Limit),
+			zap.Int("payment_limit", strategy.Payment.Limit),
+			zap.Int("user_limit", strategy.User.Limit),
+			zap.Int("whitelist", len(strategy.Whitelist)))
+	})
+}
 
-	// 从配置读取（如果配置中有）
-	// 这里可以扩展配置支持
+// rateLimitStrategy 返回当前策略，首次调用时从配置初始化
+func rateLimitStrategy() RateLimitStrategy {
+	if strategy := currentStrategy.Load(); strategy != nil {
+		return *strategy
+	}
+	strategy := strategyFromConfig(conf.GetConf())
+	currentStrategy.CompareAndSwap(nil, &strategy)
+	return *currentStrategy.Load()
+}
 
+// strategyFromConfig 从配置生成速率限制策略（未配置的项使用默认值）
+func strategyFromConfig(cfg *conf.Config) RateLimitStrategy {
+	strategy := defaultStrategy
+	strategy.Global = ruleFromConfig(cfg.RateLimit.Global, defaultStrategy.Global)
+	strategy.Payment = ruleFromConfig(cfg.RateLimit.Payment, defaultStrategy.Payment)
+	strategy.User = ruleFromConfig(cfg.RateLimit.User, defaultStrategy.User)
+	strategy.Whitelist = append([]string{}, cfg.RateLimit.Whitelist...)
 	return strategy
 }
 
-// getRateLimitKey 生成速率限制键
-func getRateLimitKey(identifier, path string) string {
-	return fmt.Sprintf("ratelimit:%s:%s", identifier, path)
+func ruleFromConfig(rule conf.RateLimitRule, fallback RateLimitConfig) RateLimitConfig {
+	if rule.Limit > 0 {
+		fallback.Limit = rule.Limit
+	}
+	if rule.Window > 0 {
+		fallback.Window = time.Duration(rule.Window) * time.Second
+	}
+	return fallback
+}
+
+// getRateLimitKey 生成速率限制键（按租户隔离，各租户的用户和 IP 分别计数）
+func getRateLimitKey(ctx context.Context, identifier, path string) string {
+	return tenant.Key(ctx, fmt.Sprintf("ratelimit:%s:%s", identifier, path))
 }
 
 // isPaymentEndpoint 判断是否为支付相关接口
@@ -204,16 +241,17 @@ func checkRateLimitMemory(key string, limit int, window time.Duration) (bool, in
 	return false, len(validTimes)
 }
 
-// isWhitelisted 检查IP是否在白名单中
+// isWhitelisted 检查IP是否在白名单中（支持单个 IP 和 CIDR）
 func isWhitelisted(ip string, whitelist []string) bool {
+	parsed := net.ParseIP(ip)
 	for _, whiteIP := range whitelist {
 		if ip == whiteIP {
 			return true
 		}
-		// 支持CIDR格式（简化实现）
-		if strings.Contains(whiteIP, "/") {
-			// 这里可以添加CIDR匹配逻辑
-			// 简化处理：只做精确匹配
+		if strings.Contains(whiteIP, "/") && parsed != nil {
+			if _, network, err := net.ParseCIDR(whiteIP); err == nil && network.Contains(parsed) {
+				return true
+			}
 		}
 	}
 	return false
@@ -221,14 +259,13 @@ func isWhitelisted(ip string, whitelist []string) bool {
 
 // RateLimitMiddleware 速率限制中间件
 func RateLimitMiddleware() app.HandlerFunc {
-	strategy := initRateLimitStrategy()
-
 	return func(ctx context.Context, c *app.RequestContext) {
+		strategy := rateLimitStrategy()
 		path := string(c.Path())
 		clientIP := c.ClientIP()
 
 		// 跳过健康检查端点
-		if path == "/ping" || path == "/health" {
+		if path == "/ping" || path == "/health" || path == "/livez" || path == "/readyz" {
 			c.Next(ctx)
 			return
 		}
@@ -248,7 +285,7 @@ func RateLimitMiddleware() app.HandlerFunc {
 		}
 
 		// 1. 按IP限制
-		ipKey := getRateLimitKey(clientIP, path)
+		ipKey := getRateLimitKey(ctx, clientIP, path)
 		exceeded := false
 		count := 0
 		var err error
@@ -293,7 +330,7 @@ func RateLimitMiddleware() app.HandlerFunc {
 		// 2. 按用户ID限制（如果提供了用户ID）
 		userID := getUserIDFromRequest(c)
 		if userID != "" && strategy.User.Limit > 0 {
-			userKey := getRateLimitKey(fmt.Sprintf("user:%s", userID), path)
+			userKey := getRateLimitKey(ctx, fmt.Sprintf("user:%s", userID), path)
 
 			if cache.IsAvailable() {
 				exceeded, count, err = checkRateLimitRedis(ctx, userKey, strategy.User.Limit, strategy.User.Window)
@@ -342,9 +379,8 @@ func RateLimitMiddleware() app.HandlerFunc {
 
 // PaymentRateLimitMiddleware 支付接口专用速率限制（更严格）
 func PaymentRateLimitMiddleware() app.HandlerFunc {
-	strategy := initRateLimitStrategy()
-
 	return func(ctx context.Context, c *app.RequestContext) {
+		strategy := rateLimitStrategy()
 		path := string(c.Path())
 		clientIP := c.ClientIP()
 
@@ -355,7 +391,7 @@ func PaymentRateLimitMiddleware() app.HandlerFunc {
 		}
 
 		config := strategy.Payment
-		ipKey := getRateLimitKey(clientIP, path)
+		ipKey := getRateLimitKey(ctx, clientIP, path)
 		exceeded := false
 		count := 0
 		var err error
@@ -0,0 +1,97 @@
+package common
+
+import (
+	"context"
+	"crypto/subtle"
+	"slices"
+	"strings"
+	"stripe-pay/conf"
+	"stripe-pay/tenant"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"go.uber.org/zap"
+)
+
+// 选择 Stripe 账户的请求头
+const (
+	RegionHeader          = "X-Region"
+	TestModeKeyHeader     = "X-Stripe-Test-Key"
+	defaultTestModeHeader = "X-Stripe-Mode"
+	testModeHeaderValue   = "test"
+)
+
+// StripeSelection 请求对应的 Stripe 账户选择条件
+type StripeSelection struct {
+	Region   string // 地区（大写，如 HK）
+	Tenant   string // 租户（由 TenantMiddleware 识别，不接受客户端指定）
+	Account  string // 租户配置的 Stripe 账户，优先于按租户和地区匹配
+	TestMode bool   // 是否使用测试模式密钥
+}
+
+type stripeSelectionKey struct{}
+
+// WithStripeSelection 在 context 中记录账户选择条件
+func WithStripeSelection(ctx context.Context, sel StripeSelection) context.Context {
+	return context.WithValue(ctx, stripeSelectionKey{}, sel)
+}
+
+// StripeSelectionFrom 读取 context 中的账户选择条件（没有时为零值，即默认账户的主密钥）
+func StripeSelectionFrom(ctx context.Context) StripeSelection {
+	sel, _ := ctx.Value(stripeSelectionKey{}).(StripeSelection)
+	return sel
+}
+
+// StripeAccountMiddleware 解析 Stripe 账户选择条件：请求的租户（需放在 TenantMiddleware 之后）、X-Region 和测试模式请求头。
+// 测试模式需要携带 stripe.test_mode.api_key（Origin 可以伪造，不能作为凭证），否则直接拒绝，避免误以为在测试而实际扣款
+func StripeAccountMiddleware() app.HandlerFunc {
+	return func(ctx context.Context, c *app.RequestContext) {
+		cfg := conf.GetConf()
+		t := tenant.FromContext(ctx)
+		sel := StripeSelection{
+			Region:  strings.ToUpper(strings.TrimSpace(string(c.Request.Header.Get(RegionHeader)))),
+			Tenant:  t.ID,
+			Account: t.StripeAccount,
+		}
+
+		header := cfg.Stripe.TestMode.Header
+		if header == "" {
+			header = defaultTestModeHeader
+		}
+		if mode := strings.TrimSpace(string(c.Request.Header.Get(header))); mode != "" && !strings.EqualFold(mode, "live") {
+			origin := string(c.Request.Header.Get("Origin"))
+			key := strings.TrimSpace(string(c.Request.Header.Get(TestModeKeyHeader)))
+			if reason := testModeDenied(cfg.Stripe.TestMode.APIKey, cfg.Stripe.TestMode.AllowedOrigins, mode, key, origin); reason != "" {
+				zap.L().Warn("Stripe test mode request rejected",
+					zap.String("reason", reason),
+					zap.String("origin", origin),
+					zap.String("mode", mode),
+					zap.String("ip", c.ClientIP()),
+					zap.String("path", string(c.Path())))
+				SendError(c, ErrForbidden.WithDetails(reason))
+				c.Abort()
+				return
+			}
+			sel.TestMode = true
+		}
+
+		c.Next(WithStripeSelection(ctx, sel))
+	}
+}
+
+// testModeDenied 检查测试模式请求，允许时返回空字符串，否则返回拒绝原因。
+// 必须携带与 apiKey 一致的测试模式密钥；配置了 allowedOrigins 时来源也必须在其中（精确匹配，不支持 *）
+func testModeDenied(apiKey string, allowedOrigins []string, mode, key, origin string) string {
+	if !strings.EqualFold(mode, testModeHeaderValue) {
+		return "unsupported stripe mode"
+	}
+	if apiKey == "" {
+		return "test mode is disabled"
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) != 1 {
+		return "test mode requires a valid " + TestModeKeyHeader
+	}
+	if len(allowedOrigins) > 0 && !slices.Contains(allowedOrigins, origin) {
+		return "test mode is not allowed for this origin"
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package common
+
+import "testing"
+
+// TestTestModeDenied 测试测试模式请求的校验
+func TestTestModeDenied(t *testing.T) {
+	staging := []string{"https://staging.example.com"}
+	tests := []struct {
+		name    string
+		apiKey  string
+		origins []string
+		mode    string
+		key     string
+		origin  string
+		want    bool
+	}{
+		{"密钥正确", "tm_secret", nil, "test", "tm_secret", "", true},
+		{"请求头大小写不敏感", "tm_secret", nil, "TEST", "tm_secret", "", true},
+		{"未配置密钥时不允许", "", nil, "test", "", "https://staging.example.com", false},
+		{"缺少密钥", "tm_secret", nil, "test", "", "", false},
+		{"密钥错误", "tm_secret", nil, "test", "tm_wrong", "", false},
+		{"只有允许的来源没有密钥", "tm_secret", staging, "test", "", "https://staging.example.com", false},
+		{"密钥正确且来源允许", "tm_secret", staging, "test", "tm_secret", "https://staging.example.com", true},
+		{"密钥正确但来源不允许", "tm_secret", staging, "test", "tm_secret", "https://evil.example.com", false},
+		{"不支持的模式", "tm_secret", nil, "sandbox", "tm_secret", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := testModeDenied(tt.apiKey, tt.origins, tt.mode, tt.key, tt.origin)
+			if (reason == "") != tt.want {
+				t.Errorf("testModeDenied() = %q, want allowed %v", reason, tt.want)
+			}
+		})
+	}
+}
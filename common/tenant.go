@@ -0,0 +1,187 @@
+package common
+
+import (
+	"context"
+	"net"
+	"strings"
+	"stripe-pay/db"
+	"stripe-pay/tenant"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"go.uber.org/zap"
+)
+
+// 识别租户的请求头
+const (
+	APIKeyHeader = "X-API-Key"
+	// AdminTenantHeader 管理接口指定操作的租户（仅在管理员鉴权通过后生效）
+	AdminTenantHeader = "X-Tenant-ID"
+)
+
+// tenantCacheTTL 租户配置的进程内缓存时间；通过管理接口修改时立即失效
+const tenantCacheTTL = 30 * time.Second
+
+// tenantSnapshot 某一时刻的租户配置索引
+type tenantSnapshot struct {
+	loadedAt time.Time
+	byID     map[string]*tenant.Tenant
+	byAPIKey map[string]*tenant.Tenant // API Key 哈希
+	byHost   map[string]*tenant.Tenant
+}
+
+var currentTenants atomic.Pointer[tenantSnapshot]
+
+// loadTenants 读取全部租户（测试中替换）
+var loadTenants = func(ctx context.Context) ([]tenant.Tenant, error) {
+	return db.ListTenants(ctx, false)
+}
+
+func newTenantSnapshot(tenants []tenant.Tenant, now time.Time) *tenantSnapshot {
+	s := &tenantSnapshot{
+		loadedAt: now,
+		byID:     map[string]*tenant.Tenant{},
+		byAPIKey: map[string]*tenant.Tenant{},
+		byHost:   map[string]*tenant.Tenant{},
+	}
+	for i := range tenants {
+		t := &tenants[i]
+		s.byID[t.ID] = t
+		if t.APIKeyHash != "" {
+			s.byAPIKey[t.APIKeyHash] = t
+		}
+		for _, host := range t.Hosts {
+			s.byHost[normalizeHost(host)] = t
+		}
+	}
+	return s
+}
+
+// resolve 按 API Key、Host 的顺序识别租户，都没有时为默认租户。
+// 携带了 API Key 但不匹配时返回 ErrUnauthorized；租户已停用时返回 ErrForbidden
+func (s *tenantSnapshot) resolve(apiKey, host string) (*tenant.Tenant, *APIError) {
+	var t *tenant.Tenant
+	switch {
+	case apiKey != "":
+		t = s.byAPIKey[tenant.HashAPIKey(apiKey)]
+		if t == nil {
+			return nil, ErrUnauthorized.WithDetails("invalid API key")
+		}
+	case s.byHost[normalizeHost(host)] != nil:
+		t = s.byHost[normalizeHost(host)]
+	default:
+		t = s.byID[tenant.DefaultID]
+		if t == nil {
+			// 租户表还没有默认租户（迁移未执行）时按默认配置处理
+			t = tenant.FromContext(context.Background())
+		}
+	}
+	if !t.Active {
+		return nil, ErrForbidden.WithDetails("tenant is disabled")
+	}
+	return t, nil
+}
+
+// normalizeHost 去掉端口并转为小写
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// tenants 返回租户配置（缓存过期时重新读取；读取失败时继续使用旧配置）
+func tenants(ctx context.Context) (*tenantSnapshot, error) {
+	now := time.Now()
+	cached := currentTenants.Load()
+	if cached != nil && now.Sub(cached.loadedAt) < tenantCacheTTL {
+		return cached, nil
+	}
+
+	list, err := loadTenants(ctx)
+	if err != nil {
+		if cached != nil {
+			zap.L().Warn("Failed to reload tenants, using cached configuration", zap.Error(err))
+			return cached, nil
+		}
+		return nil, err
+	}
+	s := newTenantSnapshot(list, now)
+	currentTenants.Store(s)
+	return s, nil
+}
+
+// InvalidateTenants 清除租户配置缓存（新增、修改租户或更换 API Key 后调用）
+func InvalidateTenants() {
+	currentTenants.Store(nil)
+}
+
+// LookupTenant 按标识查找租户（不存在时返回 nil）
+func LookupTenant(ctx context.Context, id string) (*tenant.Tenant, error) {
+	s, err := tenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.byID[id], nil
+}
+
+// TenantMiddleware 识别请求所属租户：X-API-Key 优先，其次按 Host 匹配，都没有时为默认租户。
+// 数据库未初始化时所有请求都属于默认租户
+func TenantMiddleware() app.HandlerFunc {
+	return func(ctx context.Context, c *app.RequestContext) {
+		if db.DB == nil {
+			c.Next(ctx)
+			return
+		}
+
+		s, err := tenants(ctx)
+		if err != nil {
+			zap.L().Error("Failed to load tenants", zap.Error(err))
+			SendError(c, ErrServiceUnavailable.WithDetails("tenant configuration unavailable"))
+			c.Abort()
+			return
+		}
+
+		t, apiErr := s.resolve(strings.TrimSpace(string(c.GetHeader(APIKeyHeader))), string(c.Host()))
+		if apiErr != nil {
+			zap.L().Warn("Tenant resolution failed",
+				zap.String("host", string(c.Host())),
+				zap.String("path", string(c.Path())),
+				zap.String("ip", c.ClientIP()),
+				zap.String("reason", apiErr.Details))
+			SendError(c, apiErr)
+			c.Abort()
+			return
+		}
+
+		c.Next(tenant.WithTenant(ctx, t))
+	}
+}
+
+// AdminTenantMiddleware 管理接口按 X-Tenant-ID 切换到指定租户（需放在 AdminAuthMiddleware 之后），
+// 没有该请求头时使用 TenantMiddleware 识别的租户
+func AdminTenantMiddleware() app.HandlerFunc {
+	return func(ctx context.Context, c *app.RequestContext) {
+		id := strings.TrimSpace(string(c.GetHeader(AdminTenantHeader)))
+		if id == "" || db.DB == nil {
+			c.Next(ctx)
+			return
+		}
+
+		t, err := LookupTenant(ctx, id)
+		if err != nil {
+			zap.L().Error("Failed to load tenants", zap.Error(err))
+			SendError(c, ErrServiceUnavailable.WithDetails("tenant configuration unavailable"))
+			c.Abort()
+			return
+		}
+		if t == nil {
+			SendError(c, ErrNotFound.WithDetails("tenant not found: "+id))
+			c.Abort()
+			return
+		}
+		c.Next(tenant.WithTenant(ctx, t))
+	}
+}
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"stripe-pay/tenant"
+	"testing"
+	"time"
+)
+
+// TestTenantSnapshotResolve 测试按 API Key 和 Host 识别租户
+func TestTenantSnapshotResolve(t *testing.T) {
+	s := newTenantSnapshot([]tenant.Tenant{
+		{ID: tenant.DefaultID, Active: true},
+		{ID: "acme", Hosts: []string{"pay.acme.example"}, APIKeyHash: tenant.HashAPIKey("tk_acme"), Active: true},
+		{ID: "old", Hosts: []string{"pay.old.example"}, APIKeyHash: tenant.HashAPIKey("tk_old"), Active: false},
+	}, time.Now())
+
+	tests := []struct {
+		name     string
+		apiKey   string
+		host     string
+		wantID   string
+		wantCode int
+	}{
+		{"没有 API Key 和 Host", "", "", tenant.DefaultID, 0},
+		{"按 API Key", "tk_acme", "", "acme", 0},
+		{"按 Host（忽略端口和大小写）", "", "Pay.Acme.Example:8080", "acme", 0},
+		{"API Key 优先于 Host", "tk_acme", "pay.old.example", "acme", 0},
+		{"未知 Host 为默认租户", "", "api.example.com", tenant.DefaultID, 0},
+		{"无效 API Key", "tk_unknown", "pay.acme.example", "", 401},
+		{"已停用的租户", "", "pay.old.example", "", 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, apiErr := s.resolve(tt.apiKey, tt.host)
+			if apiErr != nil {
+				if apiErr.Code != tt.wantCode {
+					t.Fatalf("resolve() error = %d %s, want %d", apiErr.Code, apiErr.Details, tt.wantCode)
+				}
+				return
+			}
+			if tt.wantCode != 0 {
+				t.Fatalf("resolve() = %s, want error %d", got.ID, tt.wantCode)
+			}
+			if got.ID != tt.wantID {
+				t.Errorf("resolve() = %s, want %s", got.ID, tt.wantID)
+			}
+		})
+	}
+}
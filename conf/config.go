@@ -1,17 +1,14 @@
 package conf
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"regexp"
 	"strconv"
-	"sync"
-
-	"gopkg.in/yaml.v3"
-)
-
-var (
-	config     *Config
-	configOnce sync.Once
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -21,16 +18,43 @@ type Config struct {
 	} `yaml:"server"`
 
 	Stripe struct {
-		SecretKey     string `yaml:"secret_key"`
-		WebhookSecret string `yaml:"webhook_secret"`
+		StripeAccount    `yaml:",inline"` // 默认账户（id 为 default），没有匹配到其他账户时使用
+		WebhookTolerance int              `yaml:"webhook_tolerance"` // 签名时间戳允许的最大偏差（秒，默认 300），超过视为重放
+		Accounts         []StripeAccount  `yaml:"accounts"`          // 其他 Stripe 账户（如不同地区的主体），按地区或租户选择
+		TestMode         struct {
+			Header         string   `yaml:"header"`          // 请求测试模式的请求头（默认 X-Stripe-Mode: test）
+			APIKey         string   `yaml:"api_key"`         // 测试模式密钥（X-Stripe-Test-Key 请求头），为空时不允许切换到测试模式
+			AllowedOrigins []string `yaml:"allowed_origins"` // 可选：进一步限定来源，为空时不检查 Origin
+		} `yaml:"test_mode"`
 	} `yaml:"stripe"`
 
+	Checkout struct {
+		ProductName        string   `yaml:"product_name"`         // Checkout 页面展示的商品名（为空时使用请求描述）
+		PaymentMethodTypes []string `yaml:"payment_method_types"` // 可选：限定支付方式，如 card, alipay, wechat_pay（为空时使用 Stripe 后台配置）
+		ExpiresIn          int      `yaml:"expires_in"`           // 可选：会话有效期（秒，30 分钟到 24 小时，为空时 Stripe 默认 24 小时）
+	} `yaml:"checkout"`
+
+	Connect struct {
+		Enabled     bool                      `yaml:"enabled"`      // 是否启用 Stripe Connect（创作者分账收款）
+		AccountType string                    `yaml:"account_type"` // 新建连接账户的类型：express（默认）或 standard
+		Country     string                    `yaml:"country"`      // 新建连接账户的国家（如 HK），为空时使用平台账户所在国家
+		RefreshURL  string                    `yaml:"refresh_url"`  // 引导链接过期或已访问时跳转的地址（启用 Connect 时必填）
+		ReturnURL   string                    `yaml:"return_url"`   // 完成或退出引导后跳转的地址（启用 Connect 时必填）
+		Fee         ConnectFeeRule            `yaml:"fee"`          // 平台抽成规则
+		CurrencyFee map[string]ConnectFeeRule `yaml:"currency_fee"` // 按币种覆盖的抽成规则（如 usd），未配置的币种使用 fee
+	} `yaml:"connect"`
+
 	Apple struct {
 		SharedSecret  string `yaml:"shared_secret"`
 		ProductionURL string `yaml:"production_url"`
 		SandboxURL    string `yaml:"sandbox_url"`
 	} `yaml:"apple"`
 
+	Upstreams struct {
+		Stripe UpstreamConfig `yaml:"stripe"`
+		Apple  UpstreamConfig `yaml:"apple"`
+	} `yaml:"upstreams"`
+
 	Log struct {
 		Level       string `yaml:"level"`       // debug, info, warn, error
 		Environment string `yaml:"environment"` // development, production
@@ -47,118 +71,481 @@ type Config struct {
 		MaxOpenConns    int    `yaml:"max_open_conns"`
 		MaxIdleConns    int    `yaml:"max_idle_conns"`
 		ConnMaxLifetime int    `yaml:"conn_max_lifetime"`
+		AutoMigrate     bool   `yaml:"auto_migrate"` // 启动时自动执行待执行的迁移
+
+		ReplicaDSNs           []string `yaml:"replica_dsns"`            // 只读从库 DSN（user:pass@tcp(host:port)/db），为空时所有读取走主库
+		ReplicaHealthInterval int      `yaml:"replica_health_interval"` // 从库健康检查间隔（秒，默认 5）
 	} `yaml:"database"`
 
 	Redis struct {
-		Address      string `yaml:"address"`
-		Port         int    `yaml:"port"`
-		Password     string `yaml:"password"`
-		DB           int    `yaml:"db"`
-		DialTimeout  int    `yaml:"dial_timeout"`  // 秒
-		ReadTimeout  int    `yaml:"read_timeout"`  // 秒
-		WriteTimeout int    `yaml:"write_timeout"` // 秒
-		PoolSize     int    `yaml:"pool_size"`
-		MinIdleConns int    `yaml:"min_idle_conns"`
+		Mode             string   `yaml:"mode"` // standalone（默认）, sentinel, cluster
+		Address          string   `yaml:"address"`
+		Port             int      `yaml:"port"`
+		Addresses        []string `yaml:"addresses"`   // sentinel 或 cluster 的种子节点（host:port），sentinel 模式填写 Sentinel 地址
+		MasterName       string   `yaml:"master_name"` // sentinel 模式的主节点名
+		Username         string   `yaml:"username"`    // ACL 用户名（可选）
+		Password         string   `yaml:"password"`
+		SentinelPassword string   `yaml:"sentinel_password"` // Sentinel 节点密码（可选）
+		DB               int      `yaml:"db"`                // cluster 模式只支持 0
+		DialTimeout      int      `yaml:"dial_timeout"`      // 秒
+		ReadTimeout      int      `yaml:"read_timeout"`      // 秒
+		WriteTimeout     int      `yaml:"write_timeout"`     // 秒
+		PoolSize         int      `yaml:"pool_size"`
+		MinIdleConns     int      `yaml:"min_idle_conns"`
+		TLS              struct {
+			Enabled            bool   `yaml:"enabled"`
+			CAFile             string `yaml:"ca_file"`              // 自定义 CA 证书（PEM），为空时使用系统 CA
+			CertFile           string `yaml:"cert_file"`            // 客户端证书（双向 TLS）
+			KeyFile            string `yaml:"key_file"`             // 客户端私钥
+			ServerName         string `yaml:"server_name"`          // 证书校验使用的主机名（可选）
+			InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // 跳过证书校验（仅用于测试环境）
+		} `yaml:"tls"`
 	} `yaml:"redis"`
+
+	Reconciliation struct {
+		Enabled       bool `yaml:"enabled"`
+		Interval      int  `yaml:"interval"`       // 运行间隔（秒）
+		StaleAfter    int  `yaml:"stale_after"`    // 非终态记录超过该时间未更新才核对（秒）
+		MaxAge        int  `yaml:"max_age"`        // 只核对该时间内创建的记录（秒）
+		BatchSize     int  `yaml:"batch_size"`     // 每页读取的记录数（每次运行翻页处理完所有记录）
+		UseEvents     bool `yaml:"use_events"`     // 是否从检查点开始拉取 Stripe 事件
+		EventLookback int  `yaml:"event_lookback"` // 首次运行（无检查点）时事件回溯时间（秒）
+	} `yaml:"reconciliation"`
+
+	PaymentExpiry struct {
+		Enabled   bool           `yaml:"enabled"`
+		Interval  int            `yaml:"interval"`   // 扫描间隔（秒）
+		BatchSize int            `yaml:"batch_size"` // 每页查询的记录数，每次运行处理所有到期记录
+		TTL       map[string]int `yaml:"ttl"`        // 支付方式 -> 未支付过期时间（秒），如 wechat_pay: 1800
+		// 使用了优惠码的支付超过该时间仍未支付时取消并释放预留（秒，所有支付方式，默认 86400）
+		PromoReservationTTL int `yaml:"promo_reservation_ttl"`
+	} `yaml:"payment_expiry"`
+
+	Capture struct {
+		ExpiryEnabled bool `yaml:"expiry_enabled"` // 是否启用授权到期检查任务
+		Interval      int  `yaml:"interval"`       // 检查间隔（秒）
+		BatchSize     int  `yaml:"batch_size"`     // 每页查询的授权数，每次运行检查所有授权
+		WarnBefore    int  `yaml:"warn_before"`    // 距离授权失效多少秒内告警
+		AutoCancel    bool `yaml:"auto_cancel"`    // 是否自动取消即将失效的授权（释放持卡人额度）
+		CancelBefore  int  `yaml:"cancel_before"`  // 距离授权失效多少秒内自动取消
+	} `yaml:"capture"`
+
+	Receipt struct {
+		NumberPrefix string  `yaml:"number_prefix"` // 收据编号前缀（默认 R-）
+		BrandColor   string  `yaml:"brand_color"`   // 收据主题色，如 #635bff
+		LogoURL      string  `yaml:"logo_url"`      // HTML 收据中显示的 Logo
+		TaxName      string  `yaml:"tax_name"`      // 税项名称，如 VAT、GST
+		TaxRate      float64 `yaml:"tax_rate"`      // 税率（百分比，价格为含税价），0 表示不显示税项
+		Company      struct {
+			Name    string   `yaml:"name"`
+			Address []string `yaml:"address"` // 地址（每行一项）
+			Email   string   `yaml:"email"`
+			Phone   string   `yaml:"phone"`
+			TaxID   string   `yaml:"tax_id"` // 税号
+			Website string   `yaml:"website"`
+		} `yaml:"company"`
+	} `yaml:"receipt"`
+
+	Notifications struct {
+		Enabled            bool   `yaml:"enabled"`
+		Driver             string `yaml:"driver"`               // smtp, file, log（默认 log）
+		From               string `yaml:"from"`                 // 发件人，如 "Example <no-reply@example.com>"
+		DefaultLocale      string `yaml:"default_locale"`       // 用户未设置语言时使用（en, zh）
+		PublicBaseURL      string `yaml:"public_base_url"`      // 对外访问地址，用于生成邮件中的收据链接
+		ExpiryReminderDays int    `yaml:"expiry_reminder_days"` // 权益到期前几天发送提醒，0 表示不提醒
+		Interval           int    `yaml:"interval"`             // 到期提醒和失败重试任务间隔（秒）
+		MaxAttempts        int    `yaml:"max_attempts"`         // 单条通知最多发送次数
+		FileDir            string `yaml:"file_dir"`             // file 驱动的输出目录
+		SMTP               struct {
+			Host     string `yaml:"host"`
+			Port     int    `yaml:"port"`
+			Username string `yaml:"username"`
+			Password string `yaml:"password"`
+			TLS      string `yaml:"tls"`     // starttls（默认）, tls, none
+			Timeout  int    `yaml:"timeout"` // 秒
+		} `yaml:"smtp"`
+	} `yaml:"notifications"`
+
+	Idempotency struct {
+		LockTTL     int `yaml:"lock_ttl"`     // 首个请求的锁超时（秒，默认 60），超时后同一幂等键可重新处理
+		ResponseTTL int `yaml:"response_ttl"` // 保存响应的时间（秒，默认 86400）
+	} `yaml:"idempotency"`
+
+	PaymentStatus struct {
+		MinRevalidateInterval int `yaml:"min_revalidate_interval"` // 同一 PaymentIntent 后台刷新状态的最小间隔（秒，默认 2）
+		FetchLockTTL          int `yaml:"fetch_lock_ttl"`          // 跨实例查询锁超时（秒，默认 5），其他实例最多等待该时间
+	} `yaml:"payment_status"`
+
+	Health struct {
+		CacheTTL int             `yaml:"cache_ttl"` // 就绪检查结果缓存时间（秒，默认 2，-1 不缓存）
+		Timeout  int             `yaml:"timeout"`   // 单个依赖检查超时（秒，默认 2）
+		Critical map[string]bool `yaml:"critical"`  // 依赖 -> 是否关键，关键依赖异常时 /readyz 返回 503（默认 database、stripe）
+	} `yaml:"health"`
+
+	Secrets struct {
+		Provider        string `yaml:"provider"`         // 密钥来源：file（secret 挂载目录）、encrypted（加密文件），为空时只使用配置文件和环境变量
+		Dir             string `yaml:"dir"`              // file 模式的目录（默认 /run/secrets），文件名为密钥名称，如 stripe_secret_key
+		EncryptedFile   string `yaml:"encrypted_file"`   // encrypted 模式的加密文件
+		KeyFile         string `yaml:"key_file"`         // 加密文件的密钥文件（base64，32 字节），也可通过 SECRETS_KEY 环境变量提供
+		RefreshInterval int    `yaml:"refresh_interval"` // 定期重新读取密钥的间隔（秒），0 表示只在文件变化或 SIGHUP 时重新读取
+	} `yaml:"secrets"`
+
+	RateLimit struct {
+		Global    RateLimitRule `yaml:"global"`    // 按 IP 的全局限制（默认每分钟 100 次）
+		Payment   RateLimitRule `yaml:"payment"`   // 支付接口按 IP 的限制（默认每分钟 10 次）
+		User      RateLimitRule `yaml:"user"`      // 按用户 ID 的限制（默认每分钟 50 次）
+		Whitelist []string      `yaml:"whitelist"` // 不受限制的 IP 或 CIDR
+	} `yaml:"rate_limit"`
+
+	CORS struct {
+		AllowOrigins []string `yaml:"allow_origins"` // 允许的来源（如 https://app.example.com），为空或包含 * 时允许所有来源
+		AllowMethods []string `yaml:"allow_methods"` // 为空时为 GET, POST, PUT, DELETE, OPTIONS
+		AllowHeaders []string `yaml:"allow_headers"` // 为空时为 Content-Type, Authorization, Accept
+		MaxAge       int      `yaml:"max_age"`       // 预检结果缓存时间（秒，默认 43200）
+	} `yaml:"cors"`
+
+	Fraud struct {
+		Enabled    bool `yaml:"enabled"`     // 是否在创建支付前执行风控规则
+		FailClosed bool `yaml:"fail_closed"` // 规则加载或计数失败时拒绝支付（默认放行）
+		CacheTTL   int  `yaml:"cache_ttl"`   // 规则缓存时间（秒，默认 30），管理接口修改规则后立即失效
+	} `yaml:"fraud"`
+
+	Admin struct {
+		APIKey string `yaml:"api_key"` // 管理接口密钥（Authorization: Bearer <api_key>），为空时管理接口不可用
+	} `yaml:"admin"`
+
+	UserAuth struct {
+		TokenSecret string `yaml:"token_secret"` // 用户令牌签名密钥（HMAC-SHA256），为空时需要用户身份的接口不可用
+		MaxTTL      int    `yaml:"max_ttl"`      // 用户令牌最长有效期（秒，默认 3600），过期时间更晚的令牌视为无效
+	} `yaml:"user_auth"`
+
+	Analytics struct {
+		RollupEnabled  bool `yaml:"rollup_enabled"`  // 是否启用每日统计汇总任务
+		RollupInterval int  `yaml:"rollup_interval"` // 汇总任务运行间隔（秒）
+		RollupDays     int  `yaml:"rollup_days"`     // 每次重新计算最近几天的汇总
+		UseRollup      bool `yaml:"use_rollup"`      // 报表默认读取汇总表（可用 source 参数覆盖）
+	} `yaml:"analytics"`
 }
 
-func Init() error {
-	var err error
-	configOnce.Do(func() {
-		config = &Config{}
+// StripeAccount 一个 Stripe 账户的密钥和选择规则
+type StripeAccount struct {
+	ID                       string          `yaml:"id"`                          // 账户标识（小写字母、数字、-、_），记录在 payment_history.stripe_account
+	SecretKey                string          `yaml:"secret_key"`                  // 主密钥（sk_live_ 为生产模式，sk_test_ 为测试模式）
+	WebhookSecret            string          `yaml:"webhook_secret"`              // 主密钥对应 Webhook 端点的签名密钥
+	WebhookSecrets           []WebhookSecret `yaml:"webhook_secrets"`             // 轮换期间的其他签名密钥，在 webhook_secret 之后按顺序尝试
+	TestSecretKey            string          `yaml:"test_secret_key"`             // 可选：测试模式密钥，允许的来源通过请求头切换
+	TestWebhookSecret        string          `yaml:"test_webhook_secret"`         // 可选：测试模式 Webhook 端点的签名密钥
+	ConnectWebhookSecret     string          `yaml:"connect_webhook_secret"`      // 可选：Connect 类型 Webhook 端点（连接账户的事件，如 account.updated）的签名密钥
+	TestConnectWebhookSecret string          `yaml:"test_connect_webhook_secret"` // 可选：测试模式 Connect 端点的签名密钥
+	Regions                  []string        `yaml:"regions"`                     // 使用该账户的地区（X-Region 请求头，如 HK、US）
+	Tenants                  []string        `yaml:"tenants"`                     // 使用该账户的租户
+}
 
-		// 读取配置文件
-		data, readErr := os.ReadFile("config.yaml")
-		if readErr != nil {
-			// 如果文件不存在，使用默认配置
-			defaultConfig()
-			err = nil
-			return
-		}
+// WebhookSecret Webhook 签名密钥（轮换时新旧密钥同时有效）
+type WebhookSecret struct {
+	Name      string    `yaml:"name"`       // 指标和日志中的名称（为空时为 secret_<序号>），不要填写密钥本身
+	Secret    string    `yaml:"secret"`     // whsec_...
+	ExpiresAt time.Time `yaml:"expires_at"` // 过期时间（RFC3339），过期后不再尝试；为空表示不过期
+}
 
-		if err = yaml.Unmarshal(data, config); err != nil {
-			return
-		}
+// ConnectFeeRule 平台抽成规则：按金额百分比加固定金额，再限制在最低和最高抽成之间（不超过支付金额）
+type ConnectFeeRule struct {
+	Percent float64 `yaml:"percent"` // 按金额的百分比，如 10 表示 10%
+	Fixed   int64   `yaml:"fixed"`   // 每笔固定金额（最小货币单位）
+	Min     int64   `yaml:"min"`     // 最低抽成（最小货币单位），0 表示不限
+	Max     int64   `yaml:"max"`     // 最高抽成（最小货币单位），0 表示不限
+}
 
-		// 从环境变量覆盖配置
-		loadFromEnv()
+// RateLimitRule 速率限制规则（0 使用默认值）
+type RateLimitRule struct {
+	Limit  int `yaml:"limit"`  // 时间窗口内允许的请求次数
+	Window int `yaml:"window"` // 时间窗口（秒）
+}
 
-		// 验证必要的配置
-		if err = validateConfig(); err != nil {
-			return
-		}
-	})
-	return err
+// UpstreamConfig 外部服务调用的超时、重试和熔断配置（0 使用默认值）
+type UpstreamConfig struct {
+	Timeout          int `yaml:"timeout"`           // 单次请求超时（秒）
+	MaxRetries       int `yaml:"max_retries"`       // 最大重试次数，-1 表示不重试
+	FailureThreshold int `yaml:"failure_threshold"` // 连续失败多少次后熔断
+	OpenTimeout      int `yaml:"open_timeout"`      // 熔断持续时间（秒），之后放行一个探测请求
 }
 
-func defaultConfig() {
-	config.Server.Port = "8080"
-	config.Server.Host = "0.0.0.0"
-	config.Log.Level = "info"
-	config.Log.Environment = "development"
-	config.Log.Output = "console"
+func defaultConfig(cfg *Config) {
+	cfg.Server.Port = "8080"
+	cfg.Server.Host = "0.0.0.0"
+	cfg.Log.Level = "info"
+	cfg.Log.Environment = "development"
+	cfg.Log.Output = "console"
 
 	// Redis 默认配置
-	config.Redis.Address = ""
-	config.Redis.Port = 6379
-	config.Redis.DB = 0
-	config.Redis.DialTimeout = 5
-	config.Redis.ReadTimeout = 3
-	config.Redis.WriteTimeout = 3
-	config.Redis.PoolSize = 10
-	config.Redis.MinIdleConns = 5
+	cfg.Redis.Address = ""
+	cfg.Redis.Port = 6379
+	cfg.Redis.DB = 0
+	cfg.Redis.DialTimeout = 5
+	cfg.Redis.ReadTimeout = 3
+	cfg.Redis.WriteTimeout = 3
+	cfg.Redis.PoolSize = 10
+	cfg.Redis.MinIdleConns = 5
+	cfg.UserAuth.MaxTTL = 3600
 }
 
-func loadFromEnv() {
+func loadFromEnv(cfg *Config) {
 	if secretKey := os.Getenv("STRIPE_SECRET_KEY"); secretKey != "" {
-		config.Stripe.SecretKey = secretKey
+		cfg.Stripe.SecretKey = secretKey
 	}
 	if webhookSecret := os.Getenv("STRIPE_WEBHOOK_SECRET"); webhookSecret != "" {
-		config.Stripe.WebhookSecret = webhookSecret
+		cfg.Stripe.WebhookSecret = webhookSecret
 	}
 	if sharedSecret := os.Getenv("APPLE_SHARED_SECRET"); sharedSecret != "" {
-		config.Apple.SharedSecret = sharedSecret
+		cfg.Apple.SharedSecret = sharedSecret
+	}
+	if adminAPIKey := os.Getenv("ADMIN_API_KEY"); adminAPIKey != "" {
+		cfg.Admin.APIKey = adminAPIKey
 	}
 	if dbPassword := os.Getenv("DB_PASSWORD"); dbPassword != "" {
-		config.Database.Password = dbPassword
+		cfg.Database.Password = dbPassword
+	}
+	if replicaDSNs := os.Getenv("DB_REPLICA_DSNS"); replicaDSNs != "" {
+		cfg.Database.ReplicaDSNs = strings.Split(replicaDSNs, ",")
+	}
+	if autoMigrate := os.Getenv("DB_AUTO_MIGRATE"); autoMigrate != "" {
+		if enabled, err := strconv.ParseBool(autoMigrate); err == nil {
+			cfg.Database.AutoMigrate = enabled
+		}
+	}
+	if reconcileEnabled := os.Getenv("RECONCILIATION_ENABLED"); reconcileEnabled != "" {
+		if enabled, err := strconv.ParseBool(reconcileEnabled); err == nil {
+			cfg.Reconciliation.Enabled = enabled
+		}
+	}
+	if expiryEnabled := os.Getenv("PAYMENT_EXPIRY_ENABLED"); expiryEnabled != "" {
+		if enabled, err := strconv.ParseBool(expiryEnabled); err == nil {
+			cfg.PaymentExpiry.Enabled = enabled
+		}
+	}
+	if captureExpiryEnabled := os.Getenv("CAPTURE_EXPIRY_ENABLED"); captureExpiryEnabled != "" {
+		if enabled, err := strconv.ParseBool(captureExpiryEnabled); err == nil {
+			cfg.Capture.ExpiryEnabled = enabled
+		}
+	}
+	if notificationsEnabled := os.Getenv("NOTIFICATIONS_ENABLED"); notificationsEnabled != "" {
+		if enabled, err := strconv.ParseBool(notificationsEnabled); err == nil {
+			cfg.Notifications.Enabled = enabled
+		}
+	}
+	if smtpPassword := os.Getenv("SMTP_PASSWORD"); smtpPassword != "" {
+		cfg.Notifications.SMTP.Password = smtpPassword
+	}
+	if fraudEnabled := os.Getenv("FRAUD_ENABLED"); fraudEnabled != "" {
+		if enabled, err := strconv.ParseBool(fraudEnabled); err == nil {
+			cfg.Fraud.Enabled = enabled
+		}
 	}
 	if redisAddr := os.Getenv("REDIS_ADDRESS"); redisAddr != "" {
-		config.Redis.Address = redisAddr
+		cfg.Redis.Address = redisAddr
 	}
 	if redisPort := os.Getenv("REDIS_PORT"); redisPort != "" {
 		if port, err := strconv.Atoi(redisPort); err == nil {
-			config.Redis.Port = port
+			cfg.Redis.Port = port
 		}
 	}
+	if redisMode := os.Getenv("REDIS_MODE"); redisMode != "" {
+		cfg.Redis.Mode = redisMode
+	}
+	if redisAddrs := os.Getenv("REDIS_ADDRESSES"); redisAddrs != "" {
+		cfg.Redis.Addresses = strings.Split(redisAddrs, ",")
+	}
+	if masterName := os.Getenv("REDIS_MASTER_NAME"); masterName != "" {
+		cfg.Redis.MasterName = masterName
+	}
+	if redisTLS := os.Getenv("REDIS_TLS"); redisTLS != "" {
+		if enabled, err := strconv.ParseBool(redisTLS); err == nil {
+			cfg.Redis.TLS.Enabled = enabled
+		}
+	}
+	if sentinelPassword := os.Getenv("REDIS_SENTINEL_PASSWORD"); sentinelPassword != "" {
+		cfg.Redis.SentinelPassword = sentinelPassword
+	}
 	if redisPassword := os.Getenv("REDIS_PASSWORD"); redisPassword != "" {
-		config.Redis.Password = redisPassword
+		cfg.Redis.Password = redisPassword
 	}
 	if redisDB := os.Getenv("REDIS_DB"); redisDB != "" {
 		if db, err := strconv.Atoi(redisDB); err == nil {
-			config.Redis.DB = db
+			cfg.Redis.DB = db
 		}
 	}
+	if secretsProvider := os.Getenv("SECRETS_PROVIDER"); secretsProvider != "" {
+		cfg.Secrets.Provider = secretsProvider
+	}
+	if secretsDir := os.Getenv("SECRETS_DIR"); secretsDir != "" {
+		cfg.Secrets.Dir = secretsDir
+	}
+	if encryptedFile := os.Getenv("SECRETS_ENCRYPTED_FILE"); encryptedFile != "" {
+		cfg.Secrets.EncryptedFile = encryptedFile
+	}
+	if keyFile := os.Getenv("SECRETS_KEY_FILE"); keyFile != "" {
+		cfg.Secrets.KeyFile = keyFile
+	}
 	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
-		config.Log.Level = logLevel
+		cfg.Log.Level = logLevel
 	}
 	if logEnv := os.Getenv("LOG_ENVIRONMENT"); logEnv != "" {
-		config.Log.Environment = logEnv
+		cfg.Log.Environment = logEnv
 	}
 	if logOutput := os.Getenv("LOG_OUTPUT"); logOutput != "" {
-		config.Log.Output = logOutput
+		cfg.Log.Output = logOutput
 	}
 }
 
-func validateConfig() error {
-	if config.Stripe.SecretKey == "" {
-		return fmt.Errorf("Stripe secret key is required")
+// stripeAccountIDPattern 账户标识格式（用于 Webhook 路径、密钥名称和数据库字段）
+var stripeAccountIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// validateStripeAccounts 校验 Stripe 账户配置
+func validateStripeAccounts(cfg *Config) []error {
+	var errs []error
+	checkWebhookSecrets := func(prefix string, secrets []WebhookSecret) {
+		for i, secret := range secrets {
+			if secret.Secret == "" {
+				errs = append(errs, fmt.Errorf("%s.webhook_secrets[%d].secret is required", prefix, i))
+			}
+		}
+	}
+	checkWebhookSecrets("stripe", cfg.Stripe.WebhookSecrets)
+
+	ids := map[string]bool{}
+	for i, account := range cfg.Stripe.Accounts {
+		prefix := fmt.Sprintf("stripe.accounts[%d]", i)
+		switch {
+		case !stripeAccountIDPattern.MatchString(account.ID):
+			errs = append(errs, fmt.Errorf("%s.id %q must be lowercase letters, digits, - or _", prefix, account.ID))
+		case account.ID == "default" || account.ID == "test":
+			errs = append(errs, fmt.Errorf("%s.id %q is reserved", prefix, account.ID))
+		case ids[account.ID]:
+			errs = append(errs, fmt.Errorf("%s.id %q is duplicated", prefix, account.ID))
+		}
+		ids[account.ID] = true
+		if account.SecretKey == "" {
+			errs = append(errs, fmt.Errorf("%s.secret_key is required", prefix))
+		}
+		checkWebhookSecrets(prefix, account.WebhookSecrets)
+	}
+
+	for _, origin := range cfg.Stripe.TestMode.AllowedOrigins {
+		if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			errs = append(errs, fmt.Errorf("stripe.test_mode.allowed_origins entry %q must start with http:// or https://", origin))
+		}
 	}
-	return nil
+	return errs
 }
 
-func GetConf() *Config {
-	if config == nil {
-		panic("config not initialized")
+// validateConnect 校验 Stripe Connect 配置
+func validateConnect(cfg *Config) []error {
+	var errs []error
+	switch cfg.Connect.AccountType {
+	case "", "express", "standard":
+	default:
+		errs = append(errs, fmt.Errorf("connect.account_type must be express or standard"))
 	}
-	return config
+	for name, u := range map[string]string{"refresh_url": cfg.Connect.RefreshURL, "return_url": cfg.Connect.ReturnURL} {
+		if u == "" && cfg.Connect.Enabled {
+			errs = append(errs, fmt.Errorf("connect.%s is required when connect is enabled", name))
+		}
+		if u != "" && !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			errs = append(errs, fmt.Errorf("connect.%s must start with http:// or https://", name))
+		}
+	}
+
+	checkFee := func(prefix string, rule ConnectFeeRule) {
+		if rule.Percent < 0 || rule.Percent > 100 {
+			errs = append(errs, fmt.Errorf("%s.percent must be between 0 and 100", prefix))
+		}
+		if rule.Fixed < 0 || rule.Min < 0 || rule.Max < 0 {
+			errs = append(errs, fmt.Errorf("%s fixed, min and max must not be negative", prefix))
+		}
+		if rule.Max > 0 && rule.Max < rule.Min {
+			errs = append(errs, fmt.Errorf("%s.max must not be less than min", prefix))
+		}
+	}
+	checkFee("connect.fee", cfg.Connect.Fee)
+	for currency, rule := range cfg.Connect.CurrencyFee {
+		if currency != strings.ToLower(currency) {
+			errs = append(errs, fmt.Errorf("connect.currency_fee key %q must be a lowercase currency code", currency))
+		}
+		checkFee("connect.currency_fee."+currency, rule)
+	}
+	return errs
+}
+
+// validateConfig 完整校验配置，返回所有问题
+func validateConfig(cfg *Config) error {
+	var errs []error
+	if cfg.Stripe.SecretKey == "" {
+		errs = append(errs, fmt.Errorf("Stripe secret key is required"))
+	}
+	if port, err := strconv.Atoi(cfg.Server.Port); cfg.Server.Port != "" && (err != nil || port <= 0 || port > 65535) {
+		errs = append(errs, fmt.Errorf("server.port must be a number between 1 and 65535"))
+	}
+
+	errs = append(errs, validateStripeAccounts(cfg)...)
+	if cfg.Stripe.WebhookTolerance < 0 {
+		errs = append(errs, fmt.Errorf("stripe.webhook_tolerance must not be negative"))
+	}
+
+	errs = append(errs, validateConnect(cfg)...)
+	if cfg.UserAuth.MaxTTL < 0 {
+		errs = append(errs, fmt.Errorf("user_auth.max_ttl must not be negative"))
+	}
+
+	if cfg.Secrets.RefreshInterval < 0 {
+		errs = append(errs, fmt.Errorf("secrets.refresh_interval must not be negative"))
+	}
+
+	switch cfg.Log.Level {
+	case "", "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log.level must be debug, info, warn or error"))
+	}
+	switch cfg.Log.Output {
+	case "", "console", "json":
+	default:
+		errs = append(errs, fmt.Errorf("log.output must be console or json"))
+	}
+
+	switch strings.ToLower(cfg.Redis.Mode) {
+	case "", "standalone", "cluster":
+	case "sentinel":
+		if cfg.Redis.MasterName == "" {
+			errs = append(errs, fmt.Errorf("redis.master_name is required in sentinel mode"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("redis.mode must be standalone, sentinel or cluster"))
+	}
+
+	for name, rule := range map[string]RateLimitRule{
+		"global":  cfg.RateLimit.Global,
+		"payment": cfg.RateLimit.Payment,
+		"user":    cfg.RateLimit.User,
+	} {
+		if rule.Limit < 0 || rule.Window < 0 {
+			errs = append(errs, fmt.Errorf("rate_limit.%s limit and window must not be negative", name))
+		}
+	}
+	for _, entry := range cfg.RateLimit.Whitelist {
+		if net.ParseIP(entry) == nil {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				errs = append(errs, fmt.Errorf("rate_limit.whitelist entry %q is not an IP or CIDR", entry))
+			}
+		}
+	}
+
+	for _, origin := range cfg.CORS.AllowOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			errs = append(errs, fmt.Errorf("cors.allow_origins entry %q must be * or start with http:// or https://", origin))
+		}
+	}
+	if cfg.CORS.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("cors.max_age must not be negative"))
+	}
+
+	return errors.Join(errs...)
 }
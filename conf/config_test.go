@@ -0,0 +1,97 @@
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestValidateConfig 测试配置校验
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(cfg *Config)
+		wantErr bool
+	}{
+		{"默认配置", func(cfg *Config) {}, false},
+		{"缺少 Stripe 密钥", func(cfg *Config) { cfg.Stripe.SecretKey = "" }, true},
+		{"端口不是数字", func(cfg *Config) { cfg.Server.Port = "http" }, true},
+		{"无效日志级别", func(cfg *Config) { cfg.Log.Level = "verbose" }, true},
+		{"无效日志输出", func(cfg *Config) { cfg.Log.Output = "file" }, true},
+		{"sentinel 缺少主节点名", func(cfg *Config) { cfg.Redis.Mode = "sentinel" }, true},
+		{"不支持的 Redis 模式", func(cfg *Config) { cfg.Redis.Mode = "replica" }, true},
+		{"负数限流", func(cfg *Config) { cfg.RateLimit.Payment.Limit = -1 }, true},
+		{"白名单支持 IP 和 CIDR", func(cfg *Config) { cfg.RateLimit.Whitelist = []string{"10.0.0.1", "192.168.0.0/16"} }, false},
+		{"无效白名单", func(cfg *Config) { cfg.RateLimit.Whitelist = []string{"localhost"} }, true},
+		{"CORS 来源", func(cfg *Config) { cfg.CORS.AllowOrigins = []string{"*", "https://app.example.com"} }, false},
+		{"CORS 来源缺少协议", func(cfg *Config) { cfg.CORS.AllowOrigins = []string{"app.example.com"} }, true},
+		{"多个 Stripe 账户", func(cfg *Config) {
+			cfg.Stripe.Accounts = []StripeAccount{{ID: "hk", SecretKey: "sk_live_hk"}, {ID: "us-east", SecretKey: "sk_live_us"}}
+		}, false},
+		{"Stripe 账户标识重复", func(cfg *Config) {
+			cfg.Stripe.Accounts = []StripeAccount{{ID: "hk", SecretKey: "sk_live_1"}, {ID: "hk", SecretKey: "sk_live_2"}}
+		}, true},
+		{"Stripe 账户使用保留标识", func(cfg *Config) { cfg.Stripe.Accounts = []StripeAccount{{ID: "default", SecretKey: "sk_live_1"}} }, true},
+		{"Stripe 账户标识格式", func(cfg *Config) { cfg.Stripe.Accounts = []StripeAccount{{ID: "HK/1", SecretKey: "sk_live_1"}} }, true},
+		{"Stripe 账户缺少密钥", func(cfg *Config) { cfg.Stripe.Accounts = []StripeAccount{{ID: "hk"}} }, true},
+		{"测试模式来源缺少协议", func(cfg *Config) { cfg.Stripe.TestMode.AllowedOrigins = []string{"*"} }, true},
+		{"Connect 抽成规则", func(cfg *Config) {
+			cfg.Connect.Fee = ConnectFeeRule{Percent: 10, Fixed: 100, Min: 200, Max: 5000}
+			cfg.Connect.CurrencyFee = map[string]ConnectFeeRule{"usd": {Percent: 8}}
+		}, false},
+		{"Connect 抽成百分比超过 100", func(cfg *Config) { cfg.Connect.Fee.Percent = 120 }, true},
+		{"Connect 最高抽成小于最低抽成", func(cfg *Config) { cfg.Connect.Fee = ConnectFeeRule{Min: 500, Max: 100} }, true},
+		{"Connect 币种使用大写", func(cfg *Config) { cfg.Connect.CurrencyFee = map[string]ConnectFeeRule{"USD": {Percent: 8}} }, true},
+		{"Connect 账户类型", func(cfg *Config) { cfg.Connect.AccountType = "custom" }, true},
+		{"Connect 跳转地址缺少协议", func(cfg *Config) { cfg.Connect.ReturnURL = "app.example.com/done" }, true},
+		{"启用 Connect 缺少跳转地址", func(cfg *Config) { cfg.Connect.Enabled = true }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			defaultConfig(cfg)
+			cfg.Stripe.SecretKey = "sk_test_123"
+			tt.setup(cfg)
+			if err := validateConfig(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestLoad 测试配置加载顺序：默认值 → 配置文件 → 环境变量
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_env")
+	t.Setenv("LOG_LEVEL", "debug")
+
+	// 文件不存在时仍然应用环境变量并校验
+	cfg, err := Load(filepath.Join(dir, "missing.yaml"))
+	if err != nil {
+		t.Fatalf("Load() without file error = %v", err)
+	}
+	if cfg.Stripe.SecretKey != "sk_test_env" || cfg.Log.Level != "debug" || cfg.Server.Port != "8080" {
+		t.Errorf("Load() without file = key %q, level %q, port %q", cfg.Stripe.SecretKey, cfg.Log.Level, cfg.Server.Port)
+	}
+
+	// 配置文件覆盖默认值，环境变量覆盖配置文件
+	path := filepath.Join(dir, "config.yaml")
+	data := "server:\n  port: \"9090\"\nlog:\n  level: warn\nstripe:\n  secret_key: sk_test_file\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Server.Port != "9090" || cfg.Server.Host != "0.0.0.0" || cfg.Log.Level != "debug" || cfg.Stripe.SecretKey != "sk_test_env" {
+		t.Errorf("Load() = port %q, host %q, level %q, key %q", cfg.Server.Port, cfg.Server.Host, cfg.Log.Level, cfg.Stripe.SecretKey)
+	}
+
+	// 校验失败时返回错误
+	t.Setenv("LOG_LEVEL", "loud")
+	if _, err := Load(path); err == nil {
+		t.Error("Load() with invalid log level should fail")
+	}
+}
@@ -0,0 +1,269 @@
+package conf
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// 默认配置文件路径
+const defaultConfigPath = "config.yaml"
+
+// 文件变更去抖时间（编辑器保存时通常会触发多次写入/重命名事件）
+const reloadDebounce = 200 * time.Millisecond
+
+var (
+	current    atomic.Pointer[Config]
+	configPath = defaultConfigPath
+	configOnce sync.Once
+
+	subscribersMu sync.Mutex
+	subscribers   []subscriber
+
+	reloadMu sync.Mutex
+)
+
+// subscriber 配置变更订阅者
+type subscriber struct {
+	name string
+	fn   func(old, new *Config)
+}
+
+// Init 加载配置文件（只执行一次）：默认值 → config.yaml（存在时）→ 环境变量 → 校验
+func Init() error {
+	var err error
+	configOnce.Do(func() {
+		var cfg *Config
+		if cfg, err = Load(configPath); err != nil {
+			return
+		}
+		current.Store(cfg)
+	})
+	return err
+}
+
+// Load 从指定路径加载并校验配置：默认值 → 配置文件（存在时）→ 环境变量 → 密钥来源 → 校验
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	defaultConfig(cfg)
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	case os.IsNotExist(err):
+		// 文件不存在时使用默认配置
+	default:
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	loadFromEnv(cfg)
+
+	// 密钥来源优先级最高：secret 挂载目录或加密文件，然后是 *_FILE 环境变量
+	if err := applySecrets(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// GetConf 返回当前配置快照；快照只读，重新加载时整体替换，不要修改返回的配置
+func GetConf() *Config {
+	cfg := current.Load()
+	if cfg == nil {
+		panic("conf: GetConf called before Init")
+	}
+	return cfg
+}
+
+// Subscribe 注册配置变更回调，重新加载成功后按注册顺序调用
+func Subscribe(name string, fn func(old, new *Config)) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	subscribers = append(subscribers, subscriber{name: name, fn: fn})
+}
+
+// Reload 重新加载配置文件；校验失败时保留当前配置并返回错误
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		zap.L().Error("Config reload rejected, keeping current configuration", zap.Error(err))
+		return err
+	}
+
+	old := current.Load()
+	if old != nil {
+		if reflect.DeepEqual(old, cfg) {
+			zap.L().Debug("Config unchanged after reload")
+			return nil
+		}
+		if names := changedSecrets(old, cfg); len(names) > 0 {
+			zap.L().Info("Secrets rotated", zap.Strings("secrets", names))
+		}
+		if sections := restartRequiredChanges(old, cfg); len(sections) > 0 {
+			zap.L().Warn("Config changes require a restart to take effect", zap.Strings("sections", sections))
+		}
+	}
+	current.Store(cfg)
+	zap.L().Info("Configuration reloaded", zap.String("path", configPath))
+
+	subscribersMu.Lock()
+	list := append([]subscriber(nil), subscribers...)
+	subscribersMu.Unlock()
+	for _, s := range list {
+		notify(s, old, cfg)
+	}
+	return nil
+}
+
+// notify 调用订阅者，panic 不影响其他订阅者
+func notify(s subscriber, old, new *Config) {
+	defer func() {
+		if r := recover(); r != nil {
+			zap.L().Error("Config subscriber panicked", zap.String("subscriber", s.name), zap.Any("panic", r))
+		}
+	}()
+	s.fn(old, new)
+}
+
+// restartRequiredChanges 返回发生变化、但只在启动时读取的配置段
+// （数据库和 Redis 密码在建立新连接时读取，轮换不需要重启）
+func restartRequiredChanges(old, new *Config) []string {
+	var sections []string
+	if !reflect.DeepEqual(old.Server, new.Server) {
+		sections = append(sections, "server")
+	}
+	oldDB, newDB := old.Database, new.Database
+	oldDB.Password, newDB.Password = "", ""
+	if !reflect.DeepEqual(oldDB, newDB) {
+		sections = append(sections, "database")
+	}
+	oldRedis, newRedis := old.Redis, new.Redis
+	oldRedis.Password, newRedis.Password = "", ""
+	if !reflect.DeepEqual(oldRedis, newRedis) {
+		sections = append(sections, "redis")
+	}
+	if !reflect.DeepEqual(old.Secrets, new.Secrets) {
+		sections = append(sections, "secrets")
+	}
+	if !reflect.DeepEqual(old.Upstreams, new.Upstreams) {
+		sections = append(sections, "upstreams")
+	}
+	if old.Log.Environment != new.Log.Environment || old.Log.Output != new.Log.Output {
+		sections = append(sections, "log.environment/log.output")
+	}
+	return sections
+}
+
+// Watch 监听配置文件、密钥文件变化和 SIGHUP 信号并重新加载配置，直到 ctx 结束
+func Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config watcher: %w", err)
+	}
+	// 监听所在目录：编辑器和 Kubernetes ConfigMap 会以重命名方式替换文件
+	dir := filepath.Dir(configPath)
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+
+	// 监听密钥目录：secret 轮换后重新读取
+	secretDirs := make(map[string]bool)
+	for _, secretDir := range secretWatchPaths(GetConf()) {
+		secretDir = filepath.Clean(secretDir)
+		if secretDir == filepath.Clean(dir) || secretDirs[secretDir] {
+			continue
+		}
+		if err := watcher.Add(secretDir); err != nil {
+			zap.L().Warn("Failed to watch secrets directory", zap.String("dir", secretDir), zap.Error(err))
+			continue
+		}
+		secretDirs[secretDir] = true
+	}
+
+	// 定期重新读取（密钥来源不支持文件通知时使用）
+	var refresh *time.Ticker
+	if interval := GetConf().Secrets.RefreshInterval; interval > 0 {
+		refresh = time.NewTicker(time.Duration(interval) * time.Second)
+	}
+	_, statErr := os.Stat(configPath)
+	hasConfigFile := statErr == nil
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer watcher.Close()
+		defer signal.Stop(hup)
+
+		var refreshC <-chan time.Time
+		if refresh != nil {
+			defer refresh.Stop()
+			refreshC = refresh.C
+		}
+
+		name := filepath.Clean(configPath)
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				zap.L().Info("Received SIGHUP, reloading configuration")
+				_ = Reload()
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != name && !isConfigMapSwap(event.Name) && !secretDirs[filepath.Dir(filepath.Clean(event.Name))] {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0 {
+					debounce = time.After(reloadDebounce)
+				}
+			case <-refreshC:
+				_ = Reload()
+			case <-debounce:
+				debounce = nil
+				if _, err := os.Stat(configPath); hasConfigFile && err != nil {
+					// 文件暂时不存在（正在替换），等待下一次事件
+					continue
+				}
+				_ = Reload()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				zap.L().Warn("Config watcher error", zap.Error(err))
+			}
+		}
+	}()
+
+	zap.L().Info("Watching configuration for changes", zap.String("path", configPath))
+	return nil
+}
+
+// isConfigMapSwap Kubernetes ConfigMap 挂载通过替换 ..data 符号链接更新文件
+func isConfigMapSwap(name string) bool {
+	return filepath.Base(name) == "..data"
+}
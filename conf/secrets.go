@@ -0,0 +1,329 @@
+package conf
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 密钥来源
+const (
+	SecretProviderNone      = ""          // 只使用配置文件和环境变量
+	SecretProviderFile      = "file"      // 目录下每个密钥一个文件（Docker/Kubernetes secret 挂载）
+	SecretProviderEncrypted = "encrypted" // AES-256-GCM 加密的 YAML 文件
+)
+
+// 默认 secret 挂载目录（Docker secrets）
+const defaultSecretsDir = "/run/secrets"
+
+// errSecretNotFound 密钥来源中没有该密钥
+var errSecretNotFound = errors.New("secret not found")
+
+// SecretProvider 密钥来源：按名称读取密钥，不存在时返回 errSecretNotFound。
+// 每次加载配置都会重新读取，轮换后的密钥在下一次重新加载时生效。
+type SecretProvider interface {
+	Name() string
+	Get(name string) (string, error)
+}
+
+// secretField 可由密钥来源提供的配置项，name 的大写形式即环境变量名
+type secretField struct {
+	name  string
+	value func(cfg *Config) *string
+}
+
+// staticSecretFields 固定的密钥配置项
+var staticSecretFields = []secretField{
+	{"stripe_secret_key", func(cfg *Config) *string { return &cfg.Stripe.SecretKey }},
+	{"stripe_webhook_secret", func(cfg *Config) *string { return &cfg.Stripe.WebhookSecret }},
+	{"apple_shared_secret", func(cfg *Config) *string { return &cfg.Apple.SharedSecret }},
+	{"admin_api_key", func(cfg *Config) *string { return &cfg.Admin.APIKey }},
+	{"user_token_secret", func(cfg *Config) *string { return &cfg.UserAuth.TokenSecret }},
+	{"db_password", func(cfg *Config) *string { return &cfg.Database.Password }},
+	{"redis_password", func(cfg *Config) *string { return &cfg.Redis.Password }},
+	{"redis_sentinel_password", func(cfg *Config) *string { return &cfg.Redis.SentinelPassword }},
+	{"smtp_password", func(cfg *Config) *string { return &cfg.Notifications.SMTP.Password }},
+	{"stripe_test_secret_key", func(cfg *Config) *string { return &cfg.Stripe.TestSecretKey }},
+	{"stripe_test_webhook_secret", func(cfg *Config) *string { return &cfg.Stripe.TestWebhookSecret }},
+	{"stripe_connect_webhook_secret", func(cfg *Config) *string { return &cfg.Stripe.ConnectWebhookSecret }},
+	{"stripe_test_connect_webhook_secret", func(cfg *Config) *string { return &cfg.Stripe.TestConnectWebhookSecret }},
+	{"stripe_test_mode_api_key", func(cfg *Config) *string { return &cfg.Stripe.TestMode.APIKey }},
+}
+
+// secretFields 所有密钥配置项，包括每个 Stripe 账户的密钥（stripe_<id>_secret_key 等）
+func secretFields(cfg *Config) []secretField {
+	fields := append([]secretField{}, staticSecretFields...)
+	for i, account := range cfg.Stripe.Accounts {
+		i, prefix := i, "stripe_"+strings.ReplaceAll(account.ID, "-", "_")+"_"
+		fields = append(fields,
+			secretField{prefix + "secret_key", func(cfg *Config) *string { return &cfg.Stripe.Accounts[i].SecretKey }},
+			secretField{prefix + "webhook_secret", func(cfg *Config) *string { return &cfg.Stripe.Accounts[i].WebhookSecret }},
+			secretField{prefix + "test_secret_key", func(cfg *Config) *string { return &cfg.Stripe.Accounts[i].TestSecretKey }},
+			secretField{prefix + "test_webhook_secret", func(cfg *Config) *string { return &cfg.Stripe.Accounts[i].TestWebhookSecret }},
+			secretField{prefix + "connect_webhook_secret", func(cfg *Config) *string { return &cfg.Stripe.Accounts[i].ConnectWebhookSecret }},
+			secretField{prefix + "test_connect_webhook_secret", func(cfg *Config) *string { return &cfg.Stripe.Accounts[i].TestConnectWebhookSecret }},
+		)
+	}
+	return fields
+}
+
+// applySecrets 依次从环境变量、配置的密钥来源和 *_FILE 环境变量读取密钥，后面的来源优先
+func applySecrets(cfg *Config) error {
+	providers := []SecretProvider{EnvProvider{}}
+	provider, err := newSecretProvider(cfg)
+	if err != nil {
+		return err
+	}
+	if provider != nil {
+		providers = append(providers, provider)
+	}
+	providers = append(providers, EnvFileProvider{})
+
+	fields := secretFields(cfg)
+	for _, p := range providers {
+		for _, field := range fields {
+			value, err := p.Get(field.name)
+			if errors.Is(err, errSecretNotFound) {
+				continue
+			}
+			if err != nil {
+				// 错误信息只包含密钥名称和文件路径，不包含密钥内容
+				return fmt.Errorf("%s secret provider: %s: %w", p.Name(), field.name, err)
+			}
+			*field.value(cfg) = value
+		}
+	}
+	return nil
+}
+
+// newSecretProvider 根据 secrets.provider 创建密钥来源，未配置时返回 nil
+func newSecretProvider(cfg *Config) (SecretProvider, error) {
+	switch strings.ToLower(cfg.Secrets.Provider) {
+	case SecretProviderNone:
+		return nil, nil
+	case SecretProviderFile:
+		dir := cfg.Secrets.Dir
+		if dir == "" {
+			dir = defaultSecretsDir
+		}
+		return DirProvider{Dir: dir}, nil
+	case SecretProviderEncrypted:
+		if cfg.Secrets.EncryptedFile == "" {
+			return nil, fmt.Errorf("secrets.encrypted_file is required for the encrypted provider")
+		}
+		key, err := loadSecretsKey(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewEncryptedFileProvider(cfg.Secrets.EncryptedFile, key)
+	default:
+		return nil, fmt.Errorf("unknown secrets.provider %q (expected file or encrypted)", cfg.Secrets.Provider)
+	}
+}
+
+// secretWatchPaths 需要监听变化的密钥目录（Kubernetes 轮换 secret 时替换挂载目录下的文件）
+func secretWatchPaths(cfg *Config) []string {
+	var dirs []string
+	switch strings.ToLower(cfg.Secrets.Provider) {
+	case SecretProviderFile:
+		dir := cfg.Secrets.Dir
+		if dir == "" {
+			dir = defaultSecretsDir
+		}
+		dirs = append(dirs, dir)
+	case SecretProviderEncrypted:
+		dirs = append(dirs, filepath.Dir(cfg.Secrets.EncryptedFile))
+	}
+	for _, field := range secretFields(cfg) {
+		if path := os.Getenv(strings.ToUpper(field.name) + "_FILE"); path != "" {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+	}
+	return dirs
+}
+
+// changedSecrets 返回值发生变化的密钥名称（用于日志，不包含密钥内容）
+func changedSecrets(old, new *Config) []string {
+	oldValues := make(map[string]string)
+	for _, field := range secretFields(old) {
+		oldValues[field.name] = *field.value(old)
+	}
+	var names []string
+	for _, field := range secretFields(new) {
+		if value := *field.value(new); value != oldValues[field.name] {
+			names = append(names, field.name)
+		}
+	}
+	return names
+}
+
+// readSecretFile 读取密钥文件，去掉末尾的换行
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errSecretNotFound
+		}
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// DirProvider 从目录读取密钥，每个密钥一个文件，文件名为密钥名称（如 /run/secrets/stripe_secret_key）
+type DirProvider struct {
+	Dir string
+}
+
+func (p DirProvider) Name() string { return SecretProviderFile }
+
+func (p DirProvider) Get(name string) (string, error) {
+	return readSecretFile(filepath.Join(p.Dir, name))
+}
+
+// secretEnvName 密钥名称对应的环境变量名（大写，- 替换为 _）
+func secretEnvName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// EnvProvider 从环境变量读取密钥（变量名为密钥名称的大写形式，如 STRIPE_HK_SECRET_KEY）
+type EnvProvider struct{}
+
+func (EnvProvider) Name() string { return "env" }
+
+func (EnvProvider) Get(name string) (string, error) {
+	if value := os.Getenv(secretEnvName(name)); value != "" {
+		return value, nil
+	}
+	return "", errSecretNotFound
+}
+
+// EnvFileProvider 按 *_FILE 环境变量约定读取密钥（如 STRIPE_SECRET_KEY_FILE=/run/secrets/stripe_key）
+type EnvFileProvider struct{}
+
+func (EnvFileProvider) Name() string { return "env_file" }
+
+func (EnvFileProvider) Get(name string) (string, error) {
+	env := secretEnvName(name) + "_FILE"
+	path := os.Getenv(env)
+	if path == "" {
+		return "", errSecretNotFound
+	}
+	value, err := readSecretFile(path)
+	if errors.Is(err, errSecretNotFound) {
+		// 显式指定的文件不存在视为配置错误
+		return "", fmt.Errorf("%s points to missing file %s", env, path)
+	}
+	return value, err
+}
+
+// EncryptedFileProvider 从加密文件读取密钥。文件内容为 base64(nonce || AES-256-GCM 密文)，
+// 明文是密钥名称到值的 YAML 映射，可用 `stripe-pay secrets encrypt` 生成
+type EncryptedFileProvider struct {
+	secrets map[string]string
+}
+
+// NewEncryptedFileProvider 读取并解密密钥文件
+func NewEncryptedFileProvider(path string, key []byte) (*EncryptedFileProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encrypted secrets file: %w", err)
+	}
+	plaintext, err := DecryptSecrets(data, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", path, err)
+	}
+	secrets := make(map[string]string)
+	if err := yaml.Unmarshal(plaintext, &secrets); err != nil {
+		// 不包装 yaml 错误，避免错误信息中带出明文片段
+		return nil, fmt.Errorf("decrypted secrets in %s are not a YAML map", path)
+	}
+	return &EncryptedFileProvider{secrets: secrets}, nil
+}
+
+func (p *EncryptedFileProvider) Name() string { return SecretProviderEncrypted }
+
+func (p *EncryptedFileProvider) Get(name string) (string, error) {
+	value, ok := p.secrets[name]
+	if !ok {
+		return "", errSecretNotFound
+	}
+	return value, nil
+}
+
+// loadSecretsKey 读取加密文件的密钥（base64 编码的 32 字节）：优先 SECRETS_KEY 环境变量，其次 secrets.key_file
+func loadSecretsKey(cfg *Config) ([]byte, error) {
+	encoded := os.Getenv("SECRETS_KEY")
+	if encoded == "" {
+		if cfg.Secrets.KeyFile == "" {
+			return nil, fmt.Errorf("SECRETS_KEY or secrets.key_file is required for the encrypted provider")
+		}
+		data, err := os.ReadFile(cfg.Secrets.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secrets key file: %w", err)
+		}
+		encoded = string(data)
+	}
+	return ParseSecretsKey(encoded)
+}
+
+// ParseSecretsKey 解析 base64 编码的 AES-256 密钥
+func ParseSecretsKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("secrets key must be 32 bytes, base64 encoded")
+	}
+	return key, nil
+}
+
+// EncryptSecrets 使用 AES-256-GCM 加密明文，返回 base64 文本
+func EncryptSecrets(plaintext, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(out, sealed)
+	return append(out, '\n'), nil
+}
+
+// DecryptSecrets 解密 EncryptSecrets 的输出
+func DecryptSecrets(data, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrong key or corrupted file")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,100 @@
+package conf
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestEncryptSecrets 测试加密文件的加解密
+func TestEncryptSecrets(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	plaintext := []byte("stripe_secret_key: sk_test_123\n")
+
+	encrypted, err := EncryptSecrets(plaintext, key)
+	if err != nil {
+		t.Fatalf("EncryptSecrets() error = %v", err)
+	}
+	if bytes.Contains(encrypted, []byte("sk_test_123")) {
+		t.Fatal("encrypted output contains the plaintext secret")
+	}
+
+	decrypted, err := DecryptSecrets(encrypted, key)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("DecryptSecrets() = %q, %v, want %q", decrypted, err, plaintext)
+	}
+
+	wrongKey := bytes.Repeat([]byte{8}, 32)
+	if _, err := DecryptSecrets(encrypted, wrongKey); err == nil {
+		t.Error("DecryptSecrets() with wrong key should fail")
+	}
+}
+
+// TestApplySecrets 测试密钥来源优先级：配置文件 < 环境变量 < 密钥来源 < *_FILE
+func TestApplySecrets(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, value string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(value), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name        string
+		setup       func(t *testing.T, cfg *Config)
+		wantKey     string
+		wantWebhook string
+		wantErr     bool
+	}{
+		{"未配置密钥来源", func(t *testing.T, cfg *Config) {}, "sk_config", "whsec_config", false},
+		{"secret 挂载目录", func(t *testing.T, cfg *Config) {
+			cfg.Secrets.Provider = SecretProviderFile
+			cfg.Secrets.Dir = dir
+			write("stripe_secret_key", "sk_mounted\n")
+		}, "sk_mounted", "whsec_config", false},
+		{"_FILE 环境变量优先", func(t *testing.T, cfg *Config) {
+			cfg.Secrets.Provider = SecretProviderFile
+			cfg.Secrets.Dir = dir
+			write("stripe_secret_key", "sk_mounted")
+			t.Setenv("STRIPE_SECRET_KEY_FILE", write("key_override", "sk_file\n"))
+			t.Setenv("STRIPE_WEBHOOK_SECRET_FILE", write("webhook", "whsec_file"))
+		}, "sk_file", "whsec_file", false},
+		{"_FILE 指向不存在的文件", func(t *testing.T, cfg *Config) {
+			t.Setenv("STRIPE_SECRET_KEY_FILE", filepath.Join(dir, "missing"))
+		}, "", "", true},
+		{"加密文件", func(t *testing.T, cfg *Config) {
+			key := bytes.Repeat([]byte{1}, 32)
+			encrypted, err := EncryptSecrets([]byte("stripe_webhook_secret: whsec_encrypted\n"), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Secrets.Provider = SecretProviderEncrypted
+			cfg.Secrets.EncryptedFile = write("secrets.enc", string(encrypted))
+			t.Setenv("SECRETS_KEY", "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=")
+		}, "sk_config", "whsec_encrypted", false},
+		{"未知密钥来源", func(t *testing.T, cfg *Config) { cfg.Secrets.Provider = "vault" }, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Stripe.SecretKey = "sk_config"
+			cfg.Stripe.WebhookSecret = "whsec_config"
+			tt.setup(t, cfg)
+
+			err := applySecrets(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applySecrets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if cfg.Stripe.SecretKey != tt.wantKey || cfg.Stripe.WebhookSecret != tt.wantWebhook {
+				t.Errorf("applySecrets() = %q, %q, want %q, %q", cfg.Stripe.SecretKey, cfg.Stripe.WebhookSecret, tt.wantKey, tt.wantWebhook)
+			}
+		})
+	}
+}
@@ -53,8 +53,8 @@ if mysqladmin ping -h localhost --silent 2>/dev/null; then
     echo "✅ MySQL 启动成功！"
     echo ""
     echo "下一步："
-    echo "  1. 运行 ./database/quick_setup.sh 创建数据库"
-    echo "  2. 或手动执行: mysql -u root < database/schema.sql"
+    echo "  1. 在 config.yaml 中配置数据库连接信息"
+    echo "  2. 运行 ./database/quick_setup.sh 创建数据库并执行迁移（stripe-pay migrate up）"
 else
     echo "❌ MySQL 启动失败"
     echo ""
@@ -65,34 +65,44 @@ else
     MYSQL_CMD="mysql -u root -p$MYSQL_PASSWORD"
 fi
 
-echo "正在创建数据库和表..."
+# 数据库名与 config.yaml 中的 database.database 保持一致（可用 DB_NAME 覆盖）
+DB_NAME="${DB_NAME:-pay_api}"
 
-# 执行 SQL 脚本
-$MYSQL_CMD < database/schema.sql 2>&1
+echo "正在创建数据库 $DB_NAME..."
+$MYSQL_CMD -e "CREATE DATABASE IF NOT EXISTS \`$DB_NAME\` CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci" 2>&1
+if [ $? -ne 0 ]; then
+    echo ""
+    echo "❌ 数据库创建失败"
+    echo "请检查："
+    echo "  1. MySQL 服务是否运行"
+    echo "  2. root 密码是否正确"
+    echo "  3. 是否有创建数据库的权限"
+    exit 1
+fi
+
+# 表结构只来自 db/migrations，由 migrate up 创建和升级（读取 config.yaml 和环境变量中的数据库配置）
+echo "正在执行数据库迁移..."
+if [ -x "./stripe-pay" ]; then
+    ./stripe-pay migrate up
+else
+    go run . migrate up
+fi
 
 if [ $? -eq 0 ]; then
     echo ""
     echo "=========================================="
-    echo "✅ 数据库和表创建成功！"
+    echo "✅ 数据库迁移完成！"
     echo "=========================================="
     echo ""
-    echo "数据库: pay_api"
-    echo "表:"
-    echo "  📋 payment_history - 支付历史记录表"
-    echo "  👤 user_payment_info - 用户支付信息表"
-    echo "  ⚙️  payment_config - 支付金额配置表"
+    echo "数据库: $DB_NAME"
+    echo "查看迁移状态: ./stripe-pay migrate status"
     echo ""
     echo "下一步："
-    echo "  1. 在 config.yaml 中配置数据库连接信息"
-    echo "  2. 重启后端服务"
+    echo "  重启后端服务"
     echo ""
 else
     echo ""
-    echo "❌ 数据库创建失败"
-    echo "请检查："
-    echo "  1. MySQL 服务是否运行"
-    echo "  2. root 密码是否正确"
-    echo "  3. 是否有创建数据库的权限"
+    echo "❌ 数据库迁移失败"
+    echo "请检查 config.yaml 中的数据库连接信息（database.host、user、password、database）"
     exit 1
 fi
-
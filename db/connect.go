@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"stripe-pay/tenant"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ConnectedAccount 创作者用户的 Stripe Connect 连接账户
+type ConnectedAccount struct {
+	ID               int64     `json:"id"`
+	TenantID         string    `json:"tenant_id"`
+	UserID           string    `json:"user_id"`
+	StripeAccount    string    `json:"stripe_account"` // 平台 Stripe 账户标识
+	Livemode         bool      `json:"livemode"`
+	AccountID        string    `json:"account_id"` // acct_...
+	AccountType      string    `json:"account_type"`
+	ChargesEnabled   bool      `json:"charges_enabled"`
+	PayoutsEnabled   bool      `json:"payouts_enabled"`
+	DetailsSubmitted bool      `json:"details_submitted"`
+	DisabledReason   string    `json:"disabled_reason,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// ConnectedAccountStatus 连接账户的能力状态（来自 Stripe Account 对象）
+type ConnectedAccountStatus struct {
+	ChargesEnabled   bool
+	PayoutsEnabled   bool
+	DetailsSubmitted bool
+	DisabledReason   string
+}
+
+const connectedAccountColumns = `id, tenant_id, user_id, stripe_account, livemode, account_id, account_type,
+	charges_enabled, payouts_enabled, details_submitted, disabled_reason, created_at, updated_at`
+
+func scanConnectedAccount(row *sql.Row) (*ConnectedAccount, error) {
+	var a ConnectedAccount
+	err := row.Scan(&a.ID, &a.TenantID, &a.UserID, &a.StripeAccount, &a.Livemode, &a.AccountID, &a.AccountType,
+		&a.ChargesEnabled, &a.PayoutsEnabled, &a.DetailsSubmitted, &a.DisabledReason, &a.CreatedAt, &a.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// GetConnectedAccount 查询当前租户的用户在指定 Stripe 账户和模式下的连接账户（不存在时返回nil）
+func GetConnectedAccount(ctx context.Context, userID string, account PaymentAccount) (*ConnectedAccount, error) {
+	if account.StripeAccount == "" {
+		account.StripeAccount = DefaultStripeAccount
+	}
+	query := `SELECT ` + connectedAccountColumns + `
+		FROM connected_accounts
+		WHERE tenant_id = ? AND user_id = ? AND stripe_account = ? AND livemode <=> ?`
+
+	a, err := scanConnectedAccount(DB.QueryRowContext(ctx, query, tenant.ID(ctx), userID, account.StripeAccount, account.Livemode))
+	if err != nil {
+		zap.L().Error("Failed to get connected account", zap.Error(err),
+			zap.String("user_id", userID),
+			zap.String("stripe_account", account.StripeAccount))
+		return nil, err
+	}
+	return a, nil
+}
+
+// GetConnectedAccountByAccountID 根据连接账户ID查询（不区分租户，用于 Webhook）
+func GetConnectedAccountByAccountID(ctx context.Context, accountID string) (*ConnectedAccount, error) {
+	query := `SELECT ` + connectedAccountColumns + ` FROM connected_accounts WHERE account_id = ?`
+
+	a, err := scanConnectedAccount(DB.QueryRowContext(ctx, query, accountID))
+	if err != nil {
+		zap.L().Error("Failed to get connected account", zap.Error(err), zap.String("account_id", accountID))
+		return nil, err
+	}
+	return a, nil
+}
+
+// SaveConnectedAccount 保存当前租户的用户在指定 Stripe 账户和模式下的连接账户
+// （已存在时保留原记录，并发创建时以先写入的为准），返回保存后的记录
+func SaveConnectedAccount(ctx context.Context, a *ConnectedAccount, account PaymentAccount) (*ConnectedAccount, error) {
+	if account.StripeAccount == "" {
+		account.StripeAccount = DefaultStripeAccount
+	}
+	query := `INSERT INTO connected_accounts
+		(tenant_id, user_id, stripe_account, livemode, account_id, account_type,
+		 charges_enabled, payouts_enabled, details_submitted, disabled_reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE user_id = user_id`
+
+	if _, err := DB.ExecContext(ctx, query, tenant.ID(ctx), a.UserID, account.StripeAccount, account.Livemode,
+		a.AccountID, a.AccountType, a.ChargesEnabled, a.PayoutsEnabled, a.DetailsSubmitted, a.DisabledReason); err != nil {
+		zap.L().Error("Failed to save connected account", zap.Error(err),
+			zap.String("user_id", a.UserID),
+			zap.String("account_id", a.AccountID),
+			zap.String("stripe_account", account.StripeAccount))
+		return nil, err
+	}
+
+	return GetConnectedAccount(ctx, a.UserID, account)
+}
+
+// UpdateConnectedAccountStatus 更新连接账户的能力状态，返回是否找到该账户
+func UpdateConnectedAccountStatus(ctx context.Context, accountID string, status ConnectedAccountStatus) (bool, error) {
+	query := `UPDATE connected_accounts
+		SET charges_enabled = ?, payouts_enabled = ?, details_submitted = ?, disabled_reason = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE account_id = ?`
+
+	result, err := DB.ExecContext(ctx, query, status.ChargesEnabled, status.PayoutsEnabled, status.DetailsSubmitted,
+		status.DisabledReason, accountID)
+	if err != nil {
+		zap.L().Error("Failed to update connected account status", zap.Error(err), zap.String("account_id", accountID))
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		// 值未变化时 RowsAffected 为 0，确认账户是否存在
+		existing, err := GetConnectedAccountByAccountID(ctx, accountID)
+		return existing != nil, err
+	}
+	return true, nil
+}
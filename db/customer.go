@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"stripe-pay/tenant"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// StripeCustomer 用户与 Stripe Customer 的映射
+type StripeCustomer struct {
+	ID               int64     `json:"id"`
+	UserID           string    `json:"user_id"`
+	StripeCustomerID string    `json:"stripe_customer_id"`
+	StripeAccount    string    `json:"stripe_account"`
+	Livemode         *bool     `json:"livemode"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// GetStripeCustomer 查询当前租户的用户在指定 Stripe 账户和模式下的 Customer（不存在时返回nil）。
+// includeLegacy 为 true 时也匹配迁移前未记录模式的记录（仅适用于默认账户的主密钥）
+func GetStripeCustomer(ctx context.Context, userID string, account PaymentAccount, includeLegacy bool) (*StripeCustomer, error) {
+	if account.StripeAccount == "" {
+		account.StripeAccount = DefaultStripeAccount
+	}
+	query := `SELECT id, user_id, stripe_customer_id, stripe_account, livemode, created_at, updated_at
+		FROM stripe_customers
+		WHERE tenant_id = ? AND user_id = ? AND stripe_account = ? AND (livemode <=> ? OR (? AND livemode IS NULL))
+		ORDER BY livemode IS NULL
+		LIMIT 1`
+
+	var c StripeCustomer
+	err := DB.QueryRowContext(ctx, query, tenant.ID(ctx), userID, account.StripeAccount, account.Livemode, includeLegacy).Scan(
+		&c.ID, &c.UserID, &c.StripeCustomerID, &c.StripeAccount, &c.Livemode, &c.CreatedAt, &c.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		zap.L().Error("Failed to get stripe customer", zap.Error(err),
+			zap.String("user_id", userID),
+			zap.String("stripe_account", account.StripeAccount))
+		return nil, err
+	}
+	return &c, nil
+}
+
+// SaveStripeCustomer 保存用户在指定 Stripe 账户和模式下的 Customer（已存在时保留原记录，并发创建时以先写入的为准）
+func SaveStripeCustomer(ctx context.Context, userID, stripeCustomerID string, account PaymentAccount) (*StripeCustomer, error) {
+	if account.StripeAccount == "" {
+		account.StripeAccount = DefaultStripeAccount
+	}
+	query := `INSERT INTO stripe_customers (tenant_id, user_id, stripe_customer_id, stripe_account, livemode)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE user_id = user_id`
+
+	if _, err := DB.ExecContext(ctx, query, tenant.ID(ctx), userID, stripeCustomerID, account.StripeAccount, account.Livemode); err != nil {
+		zap.L().Error("Failed to save stripe customer", zap.Error(err),
+			zap.String("user_id", userID),
+			zap.String("stripe_customer_id", stripeCustomerID),
+			zap.String("stripe_account", account.StripeAccount))
+		return nil, err
+	}
+
+	return GetStripeCustomer(ctx, userID, account, false)
+}
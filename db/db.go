@@ -1,52 +1,50 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"stripe-pay/conf"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"go.uber.org/zap"
 )
 
 var DB *sql.DB
 
-// Init 初始化数据库连接
+// Init 初始化数据库连接，并确保数据库结构为最新版本
 func Init() error {
 	cfg := conf.GetConf()
 
-	// 构建 DSN (Data Source Name)
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%s&parseTime=True&loc=Local",
-		cfg.Database.User,
-		cfg.Database.Password,
-		cfg.Database.Host,
-		cfg.Database.Port,
-		cfg.Database.Database,
-		cfg.Database.Charset,
-	)
-
-	var err error
-	DB, err = sql.Open("mysql", dsn)
-	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+	if err := Open(); err != nil {
+		return err
 	}
 
-	// 设置连接池参数
-	DB.SetMaxOpenConns(cfg.Database.MaxOpenConns)
-	DB.SetMaxIdleConns(cfg.Database.MaxIdleConns)
-	DB.SetConnMaxLifetime(time.Duration(cfg.Database.ConnMaxLifetime) * time.Second)
-
-	// 测试连接
-	if err = DB.Ping(); err != nil {
-		return fmt.Errorf("failed to ping database: %w", err)
+	// 自动迁移模式：启动时在咨询锁保护下执行待执行的迁移
+	if cfg.Database.AutoMigrate {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		applied, err := MigrateUp(ctx)
+		if err != nil {
+			return fmt.Errorf("database migration failed: %w", err)
+		}
+		if applied > 0 {
+			zap.L().Info("Database migrations applied on startup", zap.Int("count", applied))
+		}
 	}
 
 	// 检查数据库结构（验证迁移是否完成）
-	if err = checkDatabaseSchema(); err != nil {
+	if err := checkDatabaseSchema(); err != nil {
 		return fmt.Errorf("database schema check failed: %w", err)
 	}
 
+	// 只读从库（迁移和结构检查只使用主库）
+	if err := openReplicas(cfg); err != nil {
+		return fmt.Errorf("database replica setup failed: %w", err)
+	}
+
 	zap.L().Info("Database connected successfully",
 		zap.String("host", cfg.Database.Host),
 		zap.Int("port", cfg.Database.Port),
@@ -55,50 +53,52 @@ func Init() error {
 	return nil
 }
 
-// checkDatabaseSchema 检查数据库结构，确保必要的字段和索引存在
-func checkDatabaseSchema() error {
-	// 检查 idempotency_key 字段是否存在
-	var columnExists int
-	query := `SELECT COUNT(*) 
-		FROM INFORMATION_SCHEMA.COLUMNS 
-		WHERE table_schema = DATABASE() 
-		  AND table_name = 'payment_history' 
-		  AND column_name = 'idempotency_key'`
-
-	err := DB.QueryRow(query).Scan(&columnExists)
-	if err != nil {
-		return fmt.Errorf("failed to check idempotency_key column: %w", err)
-	}
+// Open 打开数据库连接并测试连通性（不检查数据库结构，供 migrate 等命令使用）
+func Open() error {
+	cfg := conf.GetConf()
 
-	if columnExists == 0 {
-		cfg := conf.GetConf()
-		return fmt.Errorf("database migration required: idempotency_key column does not exist. Please run: mysql -u %s -p %s < database/add_idempotency_key.sql (or check config.yaml for your database user)", cfg.Database.User, cfg.Database.Database)
+	// 构建 DSN (Data Source Name)
+	dsnCfg := mysql.NewConfig()
+	dsnCfg.User = cfg.Database.User
+	dsnCfg.Passwd = cfg.Database.Password
+	dsnCfg.Net = "tcp"
+	dsnCfg.Addr = fmt.Sprintf("%s:%d", cfg.Database.Host, cfg.Database.Port)
+	dsnCfg.DBName = cfg.Database.Database
+	dsnCfg.ParseTime = true
+	dsnCfg.Loc = time.Local
+	if cfg.Database.Charset != "" {
+		dsnCfg.Params = map[string]string{"charset": cfg.Database.Charset}
 	}
 
-	// 检查唯一索引是否存在
-	var indexExists int
-	query = `SELECT COUNT(*) 
-		FROM INFORMATION_SCHEMA.STATISTICS 
-		WHERE table_schema = DATABASE() 
-		  AND table_name = 'payment_history' 
-		  AND index_name = 'uk_idempotency_key'`
-
-	err = DB.QueryRow(query).Scan(&indexExists)
+	// 每次建立新连接时读取当前密码，密码轮换后重新加载配置即可生效
+	if err := dsnCfg.Apply(mysql.BeforeConnect(func(ctx context.Context, c *mysql.Config) error {
+		c.Passwd = conf.GetConf().Database.Password
+		return nil
+	})); err != nil {
+		return fmt.Errorf("failed to configure database: %w", err)
+	}
+	connector, err := mysql.NewConnector(dsnCfg)
 	if err != nil {
-		return fmt.Errorf("failed to check uk_idempotency_key index: %w", err)
+		return fmt.Errorf("failed to open database: %w", err)
 	}
+	DB = sql.OpenDB(connector)
+
+	// 设置连接池参数
+	DB.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	DB.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	DB.SetConnMaxLifetime(time.Duration(cfg.Database.ConnMaxLifetime) * time.Second)
 
-	if indexExists == 0 {
-		cfg := conf.GetConf()
-		return fmt.Errorf("database migration required: uk_idempotency_key index does not exist. Please run: mysql -u %s -p %s < database/add_idempotency_key.sql (or check config.yaml for your database user)", cfg.Database.User, cfg.Database.Database)
+	// 测试连接
+	if err = DB.Ping(); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	zap.L().Info("Database schema check passed: idempotency_key column and index exist")
 	return nil
 }
 
-// Close 关闭数据库连接
+// Close 关闭数据库连接（包括从库）
 func Close() error {
+	closeReplicas()
 	if DB != nil {
 		return DB.Close()
 	}
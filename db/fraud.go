@@ -0,0 +1,247 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"stripe-pay/tenant"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 风控规则类型
+const (
+	FraudRuleBlockUser            = "block_user"
+	FraudRuleBlockIP              = "block_ip"
+	FraudRuleBlockCIDR            = "block_cidr"
+	FraudRuleBlockEmail           = "block_email"
+	FraudRuleBlockCardFingerprint = "block_card_fingerprint"
+	FraudRuleVelocityUserDaily    = "velocity_user_daily"
+	FraudRuleMaxAmount            = "max_amount"
+)
+
+// 风控动作和决策
+const (
+	FraudActionBlock  = "block"
+	FraudActionReview = "review"
+
+	FraudDecisionAllow  = "allow"
+	FraudDecisionBlock  = "block"
+	FraudDecisionReview = "review"
+)
+
+// FraudRule 风控规则
+type FraudRule struct {
+	ID            int64      `json:"id"`
+	RuleType      string     `json:"rule_type"`
+	Value         string     `json:"value,omitempty"`
+	Threshold     int64      `json:"threshold,omitempty"`
+	Currency      string     `json:"currency,omitempty"`
+	PaymentMethod string     `json:"payment_method,omitempty"`
+	Action        string     `json:"action"`
+	Active        bool       `json:"active"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	Description   string     `json:"description,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// FraudDecision 风控决策记录
+type FraudDecision struct {
+	ID            int64     `json:"id"`
+	UserID        string    `json:"user_id"`
+	IP            string    `json:"ip,omitempty"`
+	PaymentMethod string    `json:"payment_method"`
+	Amount        int64     `json:"amount"`
+	Currency      string    `json:"currency"`
+	Decision      string    `json:"decision"`
+	RuleID        int64     `json:"rule_id,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+const fraudRuleColumns = `id, rule_type, value, threshold, currency, payment_method, action, active, expires_at, description, created_at, updated_at`
+
+// scanFraudRule 扫描一行风控规则
+func scanFraudRule(scanner interface{ Scan(...interface{}) error }) (*FraudRule, error) {
+	var r FraudRule
+	var value, currency, paymentMethod, description sql.NullString
+	var threshold sql.NullInt64
+	var expiresAt sql.NullTime
+	err := scanner.Scan(&r.ID, &r.RuleType, &value, &threshold, &currency, &paymentMethod, &r.Action, &r.Active,
+		&expiresAt, &description, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	r.Value = value.String
+	r.Threshold = threshold.Int64
+	r.Currency = currency.String
+	r.PaymentMethod = paymentMethod.String
+	r.Description = description.String
+	if expiresAt.Valid {
+		r.ExpiresAt = &expiresAt.Time
+	}
+	return &r, nil
+}
+
+// CreateFraudRule 创建当前租户的风控规则
+func CreateFraudRule(ctx context.Context, r *FraudRule) error {
+	query := `INSERT INTO fraud_rules
+		(tenant_id, rule_type, value, threshold, currency, payment_method, action, active, expires_at, description)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	var threshold, expiresAt interface{}
+	if r.Threshold != 0 {
+		threshold = r.Threshold
+	}
+	if r.ExpiresAt != nil {
+		expiresAt = *r.ExpiresAt
+	}
+
+	result, err := DB.ExecContext(ctx, query, tenant.ID(ctx), r.RuleType, nullString(r.Value), threshold, nullString(r.Currency),
+		nullString(r.PaymentMethod), r.Action, r.Active, expiresAt, nullString(r.Description))
+	if err != nil {
+		zap.L().Error("Failed to create fraud rule", zap.Error(err), zap.String("rule_type", r.RuleType))
+		return err
+	}
+	r.ID, _ = result.LastInsertId()
+
+	zap.L().Info("Fraud rule created", zap.Int64("rule_id", r.ID), zap.String("rule_type", r.RuleType))
+	return nil
+}
+
+// GetFraudRule 按 ID 查询当前租户的风控规则（不存在时返回 nil）
+func GetFraudRule(ctx context.Context, id int64) (*FraudRule, error) {
+	row := DB.QueryRowContext(ctx, `SELECT `+fraudRuleColumns+` FROM fraud_rules WHERE tenant_id = ? AND id = ?`, tenant.ID(ctx), id)
+	r, err := scanFraudRule(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		zap.L().Error("Failed to get fraud rule", zap.Error(err), zap.Int64("rule_id", id))
+		return nil, err
+	}
+	return r, nil
+}
+
+// ListFraudRules 查询当前租户的风控规则；activeOnly 时只返回启用且未过期的规则
+func ListFraudRules(ctx context.Context, activeOnly bool) ([]FraudRule, error) {
+	query := `SELECT ` + fraudRuleColumns + ` FROM fraud_rules WHERE tenant_id = ?`
+	if activeOnly {
+		query += ` AND active = TRUE AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`
+	}
+	query += ` ORDER BY id`
+
+	rows, err := DB.QueryContext(ctx, query, tenant.ID(ctx))
+	if err != nil {
+		zap.L().Error("Failed to list fraud rules", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []FraudRule
+	for rows.Next() {
+		r, err := scanFraudRule(rows)
+		if err != nil {
+			zap.L().Error("Failed to scan fraud rule", zap.Error(err))
+			continue
+		}
+		rules = append(rules, *r)
+	}
+	return rules, rows.Err()
+}
+
+// SetFraudRuleActive 启用或停用当前租户的风控规则，返回是否找到
+func SetFraudRuleActive(ctx context.Context, id int64, active bool) (bool, error) {
+	result, err := DB.ExecContext(ctx, `UPDATE fraud_rules SET active = ? WHERE tenant_id = ? AND id = ?`, active, tenant.ID(ctx), id)
+	if err != nil {
+		zap.L().Error("Failed to update fraud rule", zap.Error(err), zap.Int64("rule_id", id))
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		// 值未变化时 RowsAffected 为 0，确认规则是否存在
+		existing, err := GetFraudRule(ctx, id)
+		return existing != nil, err
+	}
+	return true, nil
+}
+
+// CountUserPaymentsSince 统计当前租户的用户在 since 之后创建的支付数量（paymentMethod 为空时统计所有支付方式）
+func CountUserPaymentsSince(ctx context.Context, userID, paymentMethod string, since time.Time) (int, error) {
+	query := `SELECT COUNT(*) FROM payment_history WHERE tenant_id = ? AND user_id = ? AND created_at >= ?`
+	args := []interface{}{tenant.ID(ctx), userID, since}
+	if paymentMethod != "" {
+		query += ` AND payment_method = ?`
+		args = append(args, paymentMethod)
+	}
+
+	var count int
+	if err := DB.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		zap.L().Error("Failed to count user payments", zap.Error(err), zap.String("user_id", userID))
+		return 0, err
+	}
+	return count, nil
+}
+
+// SaveFraudDecision 保存当前租户的风控决策
+func SaveFraudDecision(ctx context.Context, d *FraudDecision) error {
+	query := `INSERT INTO fraud_decisions
+		(tenant_id, user_id, ip, payment_method, amount, currency, decision, rule_id, reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	var ruleID interface{}
+	if d.RuleID != 0 {
+		ruleID = d.RuleID
+	}
+
+	result, err := DB.ExecContext(ctx, query, tenant.ID(ctx), d.UserID, nullString(d.IP), d.PaymentMethod, d.Amount,
+		strings.ToLower(d.Currency), d.Decision, ruleID, nullString(d.Reason))
+	if err != nil {
+		zap.L().Error("Failed to save fraud decision", zap.Error(err), zap.String("user_id", d.UserID))
+		return err
+	}
+	d.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// ListFraudDecisions 查询当前租户的风控决策（按时间倒序，decision、userID 为空时不过滤；配置了从库时读取从库）
+func ListFraudDecisions(ctx context.Context, decision, userID string, limit int) ([]FraudDecision, error) {
+	query := `SELECT id, user_id, ip, payment_method, amount, currency, decision, rule_id, reason, created_at
+		FROM fraud_decisions WHERE tenant_id = ?`
+	args := []interface{}{tenant.ID(ctx)}
+	if decision != "" {
+		query += ` AND decision = ?`
+		args = append(args, decision)
+	}
+	if userID != "" {
+		query += ` AND user_id = ?`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := reader(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		zap.L().Error("Failed to list fraud decisions", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var decisions []FraudDecision
+	for rows.Next() {
+		var d FraudDecision
+		var ip, reason sql.NullString
+		var ruleID sql.NullInt64
+		if err := rows.Scan(&d.ID, &d.UserID, &ip, &d.PaymentMethod, &d.Amount, &d.Currency, &d.Decision,
+			&ruleID, &reason, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		d.IP = ip.String
+		d.RuleID = ruleID.Int64
+		d.Reason = reason.String
+		decisions = append(decisions, d)
+	}
+	return decisions, rows.Err()
+}
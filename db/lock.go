@@ -0,0 +1,41 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// TryLock 尝试获取 MySQL 命名锁（GET_LOCK，不等待），用于多实例部署时保证后台任务只在一个实例上执行
+// 返回的 unlock 会释放锁并归还连接；未获取到锁时 acquired 为 false
+func TryLock(ctx context.Context, name string) (unlock func(), acquired bool, err error) {
+	if DB == nil {
+		return nil, false, fmt.Errorf("database not initialized")
+	}
+
+	// 命名锁属于会话，必须固定在同一个连接上获取和释放
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get database connection: %w", err)
+	}
+
+	var result sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 0)`, name).Scan(&result); err != nil {
+		conn.Close()
+		return nil, false, fmt.Errorf("failed to acquire lock %s: %w", name, err)
+	}
+	if !result.Valid || result.Int64 != 1 {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	unlock = func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, name); err != nil {
+			zap.L().Warn("Failed to release lock", zap.Error(err), zap.String("lock", name))
+		}
+		conn.Close()
+	}
+	return unlock, true, nil
+}
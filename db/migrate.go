@@ -0,0 +1,513 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+const (
+	// migrationLockName MySQL 咨询锁名称，保证多实例同时启动时只有一个实例执行迁移
+	migrationLockName = "stripe_pay_schema_migrations"
+	// migrationLockTimeout 获取咨询锁的最长等待时间（秒）
+	migrationLockTimeout = 60
+)
+
+// migrationFilePattern 迁移文件命名规则：<版本号>_<名称>.<up|down>.sql
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration 一个版本化的数据库迁移
+type Migration struct {
+	Version  int64
+	Name     string
+	UpSQL    string
+	DownSQL  string
+	Checksum string // up 脚本的 SHA-256，用于检测已执行的迁移文件是否被修改
+}
+
+// MigrationStatus 迁移执行状态
+type MigrationStatus struct {
+	Version          int64      `json:"version"`
+	Name             string     `json:"name"`
+	Applied          bool       `json:"applied"`
+	AppliedAt        *time.Time `json:"applied_at,omitempty"`
+	ChecksumMismatch bool       `json:"checksum_mismatch"`
+	Missing          bool       `json:"missing"` // 数据库中已记录但二进制中不存在的迁移
+}
+
+// appliedMigration schema_migrations 表中的一条记录
+type appliedMigration struct {
+	Version   int64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// ChecksumMismatchError 已执行迁移的文件内容与记录的校验和不一致
+type ChecksumMismatchError struct {
+	Version int64
+	Name    string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for applied migration %04d_%s: migration files must not be modified after they are applied", e.Version, e.Name)
+}
+
+// LoadMigrations 读取内嵌的迁移文件，按版本号升序返回
+func LoadMigrations() ([]*Migration, error) {
+	return loadMigrationsFS(migrationFS, "migrations")
+}
+
+func loadMigrationsFS(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		matches := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+		name := matches[2]
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration version %d has conflicting names: %s, %s", version, m.Name, name)
+		}
+
+		if matches[3] == "up" {
+			m.UpSQL = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.DownSQL = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.UpSQL == "" {
+			return nil, fmt.Errorf("migration %04d_%s is missing its up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// MigrateUp 执行所有待执行的迁移，返回本次执行的迁移数量
+func MigrateUp(ctx context.Context) (int, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	conn, unlock, err := acquireMigrationLock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return 0, err
+	}
+
+	applied, err := loadAppliedMigrations(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := verifyChecksums(migrations, applied); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		zap.L().Info("Applying migration", zap.Int64("version", m.Version), zap.String("name", m.Name))
+		start := time.Now()
+		if err := execStatements(ctx, conn, m.UpSQL); err != nil {
+			return count, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+
+		_, err := conn.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version, name, checksum, execution_ms) VALUES (?, ?, ?, ?)`,
+			m.Version, m.Name, m.Checksum, time.Since(start).Milliseconds())
+		if err != nil {
+			return count, fmt.Errorf("failed to record migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+
+		zap.L().Info("Migration applied",
+			zap.Int64("version", m.Version),
+			zap.String("name", m.Name),
+			zap.Duration("duration", time.Since(start)))
+		count++
+	}
+
+	return count, nil
+}
+
+// MigrateDown 回滚最近执行的 steps 个迁移，返回实际回滚的数量
+func MigrateDown(ctx context.Context, steps int) (int, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	byVersion := make(map[int64]*Migration, len(migrations))
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	conn, unlock, err := acquireMigrationLock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return 0, err
+	}
+
+	applied, err := loadAppliedMigrations(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := verifyChecksums(migrations, applied); err != nil {
+		return 0, err
+	}
+
+	versions := make([]int64, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+	count := 0
+	for _, v := range versions {
+		if count >= steps {
+			break
+		}
+
+		m, ok := byVersion[v]
+		if !ok {
+			return count, fmt.Errorf("cannot roll back migration %d: migration file not found in this binary", v)
+		}
+		if strings.TrimSpace(m.DownSQL) == "" {
+			return count, fmt.Errorf("cannot roll back migration %04d_%s: no down script", m.Version, m.Name)
+		}
+
+		zap.L().Info("Rolling back migration", zap.Int64("version", m.Version), zap.String("name", m.Name))
+		if err := execStatements(ctx, conn, m.DownSQL); err != nil {
+			return count, fmt.Errorf("rollback of %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+
+		if _, err := conn.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, m.Version); err != nil {
+			return count, fmt.Errorf("failed to remove migration record %04d_%s: %w", m.Version, m.Name, err)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// GetMigrationStatus 返回所有迁移的执行状态（不加锁，只读）
+func GetMigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]appliedMigration)
+	exists, err := migrationsTableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		conn, err := DB.Conn(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get database connection: %w", err)
+		}
+		defer conn.Close()
+
+		applied, err = loadAppliedMigrations(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	known := make(map[int64]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			appliedAt := a.AppliedAt
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+			status.ChecksumMismatch = a.Checksum != m.Checksum
+		}
+		statuses = append(statuses, status)
+	}
+
+	for v, a := range applied {
+		if known[v] {
+			continue
+		}
+		appliedAt := a.AppliedAt
+		statuses = append(statuses, MigrationStatus{
+			Version:   v,
+			Name:      a.Name,
+			Applied:   true,
+			AppliedAt: &appliedAt,
+			Missing:   true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, nil
+}
+
+// CurrentSchemaVersion 返回已执行的最高迁移版本（未执行任何迁移时返回 0）
+func CurrentSchemaVersion(ctx context.Context) (int64, error) {
+	exists, err := migrationsTableExists(ctx)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	if err := DB.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to query schema version: %w", err)
+	}
+	return version.Int64, nil
+}
+
+// checkDatabaseSchema 检查数据库结构：所有迁移都已执行，且已执行的迁移文件未被修改
+func checkDatabaseSchema() error {
+	statuses, err := GetMigrationStatus(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to read migration status: %w", err)
+	}
+
+	pending := 0
+	for _, s := range statuses {
+		if s.ChecksumMismatch {
+			return &ChecksumMismatchError{Version: s.Version, Name: s.Name}
+		}
+		if s.Missing {
+			zap.L().Warn("Database has a migration that this binary does not know about",
+				zap.Int64("version", s.Version),
+				zap.String("name", s.Name))
+		}
+		if !s.Applied {
+			pending++
+		}
+	}
+
+	if pending > 0 {
+		return fmt.Errorf("database migration required: %d pending migration(s). Please run: stripe-pay migrate up (or set database.auto_migrate: true)", pending)
+	}
+
+	zap.L().Info("Database schema check passed: all migrations applied", zap.Int("migrations", len(statuses)))
+	return nil
+}
+
+// acquireMigrationLock 在独立连接上获取 MySQL 咨询锁（GET_LOCK 与会话绑定，必须在同一连接上执行迁移）
+func acquireMigrationLock(ctx context.Context) (*sql.Conn, func(), error) {
+	if DB == nil {
+		return nil, nil, fmt.Errorf("database not initialized")
+	}
+
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get database connection: %w", err)
+	}
+
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, migrationLockName, migrationLockTimeout).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		conn.Close()
+		return nil, nil, fmt.Errorf("timed out waiting for migration lock after %ds (another instance may be migrating)", migrationLockTimeout)
+	}
+
+	unlock := func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, migrationLockName); err != nil {
+			zap.L().Warn("Failed to release migration lock", zap.Error(err))
+		}
+		conn.Close()
+	}
+	return conn, unlock, nil
+}
+
+// ensureMigrationsTable 创建 schema_migrations 表（如果不存在）
+func ensureMigrationsTable(ctx context.Context, conn *sql.Conn) error {
+	query := `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT NOT NULL PRIMARY KEY COMMENT '迁移版本号',
+		name VARCHAR(255) NOT NULL COMMENT '迁移名称',
+		checksum CHAR(64) NOT NULL COMMENT 'up 脚本 SHA-256',
+		execution_ms BIGINT NOT NULL DEFAULT 0 COMMENT '执行耗时（毫秒）',
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '执行时间'
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='数据库迁移记录表'`
+
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// migrationsTableExists 检查 schema_migrations 表是否存在
+func migrationsTableExists(ctx context.Context) (bool, error) {
+	if DB == nil {
+		return false, fmt.Errorf("database not initialized")
+	}
+
+	var count int
+	query := `SELECT COUNT(*)
+		FROM INFORMATION_SCHEMA.TABLES
+		WHERE table_schema = DATABASE()
+		  AND table_name = 'schema_migrations'`
+	if err := DB.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check schema_migrations table: %w", err)
+	}
+	return count > 0, nil
+}
+
+// loadAppliedMigrations 读取已执行的迁移记录
+func loadAppliedMigrations(ctx context.Context, conn *sql.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		applied[a.Version] = a
+	}
+	return applied, rows.Err()
+}
+
+// verifyChecksums 校验已执行迁移的文件内容未被修改
+func verifyChecksums(migrations []*Migration, applied map[int64]appliedMigration) error {
+	for _, m := range migrations {
+		if a, ok := applied[m.Version]; ok && a.Checksum != m.Checksum {
+			return &ChecksumMismatchError{Version: m.Version, Name: m.Name}
+		}
+	}
+	return nil
+}
+
+// execStatements 逐条执行迁移脚本中的 SQL 语句
+func execStatements(ctx context.Context, conn *sql.Conn, script string) error {
+	for _, stmt := range splitStatements(script) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitStatements 按分号拆分 SQL 脚本，忽略注释以及引号内的分号
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	var quote rune
+
+	runes := []rune(script)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		if quote != 0 {
+			current.WriteRune(r)
+			if r == '\\' && i+1 < len(runes) {
+				i++
+				current.WriteRune(runes[i])
+				continue
+			}
+			if r == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch {
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+			current.WriteRune(r)
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-',
+			r == '#':
+			// 单行注释：跳到行尾
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			current.WriteRune('\n')
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			// 块注释
+			i += 2
+			for i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/') {
+				i++
+			}
+			i++
+		case r == ';':
+			if stmt := strings.TrimSpace(current.String()); stmt != "" {
+				statements = append(statements, stmt)
+			}
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	if stmt := strings.TrimSpace(current.String()); stmt != "" {
+		statements = append(statements, stmt)
+	}
+	return statements
+}
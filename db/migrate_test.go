@@ -0,0 +1,113 @@
+package db
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+// TestLoadMigrations 测试内嵌迁移文件可以正确加载
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatalf("LoadMigrations() failed: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("expected at least one embedded migration")
+	}
+
+	for i, m := range migrations {
+		if m.Checksum == "" {
+			t.Errorf("migration %d has empty checksum", m.Version)
+		}
+		if i > 0 && migrations[i-1].Version >= m.Version {
+			t.Errorf("migrations not sorted: %d before %d", migrations[i-1].Version, m.Version)
+		}
+	}
+
+	// 基线迁移创建的是已有生产表，不能通过 migrate down 删除
+	if migrations[0].Version != 1 || migrations[0].DownSQL != "" {
+		t.Errorf("baseline migration %04d_%s must not have a down script", migrations[0].Version, migrations[0].Name)
+	}
+}
+
+// TestLoadMigrationsFS 测试迁移文件命名校验
+func TestLoadMigrationsFS(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "正常的 up/down 文件",
+			files: fstest.MapFS{
+				"m/0002_add_b.up.sql":      {Data: []byte("ALTER TABLE a ADD b INT;")},
+				"m/0001_create_a.up.sql":   {Data: []byte("CREATE TABLE a (id INT);")},
+				"m/0001_create_a.down.sql": {Data: []byte("DROP TABLE a;")},
+			},
+			want: 2,
+		},
+		{
+			name:    "非法文件名",
+			files:   fstest.MapFS{"m/create_a.sql": {Data: []byte("SELECT 1;")}},
+			wantErr: true,
+		},
+		{
+			name:    "缺少 up 脚本",
+			files:   fstest.MapFS{"m/0001_create_a.down.sql": {Data: []byte("DROP TABLE a;")}},
+			wantErr: true,
+		},
+		{
+			name: "同版本名称冲突",
+			files: fstest.MapFS{
+				"m/0001_create_a.up.sql": {Data: []byte("SELECT 1;")},
+				"m/0001_create_b.up.sql": {Data: []byte("SELECT 1;")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrationsFS(tt.files, "m")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadMigrationsFS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(migrations) != tt.want {
+				t.Errorf("got %d migrations, want %d", len(migrations), tt.want)
+			}
+		})
+	}
+}
+
+// TestSplitStatements 测试 SQL 脚本拆分
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"单条语句", "SELECT 1;", []string{"SELECT 1"}},
+		{"多条语句", "SELECT 1;\nSELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"末尾无分号", "SELECT 1;\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"行注释", "-- comment; here\nSELECT 1;", []string{"SELECT 1"}},
+		{"块注释", "/* a; b */ SELECT 1;", []string{"SELECT 1"}},
+		{"引号内分号", "INSERT INTO t VALUES ('a;b');", []string{"INSERT INTO t VALUES ('a;b')"}},
+		{"COMMENT 中的分号", "CREATE TABLE t (id INT COMMENT '主键;自增');", []string{"CREATE TABLE t (id INT COMMENT '主键;自增')"}},
+		{"空脚本", "  \n-- only comment\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitStatements(tt.script)
+			if len(got) != len(tt.want) {
+				t.Fatalf("splitStatements() = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("statement %d = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
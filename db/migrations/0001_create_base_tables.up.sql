@@ -1,10 +1,5 @@
--- Pay API 数据库结构
--- 创建数据库
-CREATE DATABASE IF NOT EXISTS pay_api CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
+-- 基础表结构：支付历史、用户支付信息、支付金额配置
 
-USE pay_api;
-
--- 1. 支付历史表（存储所有支付记录）
 CREATE TABLE IF NOT EXISTS payment_history (
     id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
     payment_intent_id VARCHAR(255) NOT NULL UNIQUE COMMENT 'Stripe PaymentIntent ID',
@@ -26,7 +21,6 @@ CREATE TABLE IF NOT EXISTS payment_history (
     UNIQUE INDEX uk_idempotency_key (idempotency_key) COMMENT '幂等性密钥唯一索引'
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='支付历史记录表';
 
--- 2. 用户支付信息表（存储用户是否支付成功过）
 CREATE TABLE IF NOT EXISTS user_payment_info (
     id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
     user_id VARCHAR(255) NOT NULL UNIQUE COMMENT '用户ID',
@@ -41,7 +35,6 @@ CREATE TABLE IF NOT EXISTS user_payment_info (
     INDEX idx_has_paid (has_paid)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='用户支付信息表';
 
--- 3. 支付金额配置表（存储需要支付的金额，币种固定为港币）
 CREATE TABLE IF NOT EXISTS payment_config (
     id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
     amount BIGINT UNSIGNED NOT NULL DEFAULT 5900 COMMENT '支付金额（分），默认59港币',
@@ -52,8 +45,7 @@ CREATE TABLE IF NOT EXISTS payment_config (
     UNIQUE KEY uk_currency (currency) COMMENT '币种唯一索引，确保每种币种只有一条配置'
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='支付金额配置表';
 
--- 初始化默认配置（59港币）
-INSERT INTO payment_config (amount, currency, description) 
+-- 初始化默认配置（59港币），已存在时保持不变
+INSERT INTO payment_config (amount, currency, description)
 VALUES (5900, 'hkd', '默认支付金额配置')
-ON DUPLICATE KEY UPDATE amount = 5900, updated_at = CURRENT_TIMESTAMP;
-
+ON DUPLICATE KEY UPDATE id = id;
@@ -0,0 +1,3 @@
+DROP INDEX idx_status_updated_at ON payment_history;
+
+DROP TABLE IF EXISTS reconciliation_runs;
@@ -0,0 +1,20 @@
+-- 对账任务运行记录：每次运行的统计结果、不一致明细以及事件检查点
+
+CREATE TABLE IF NOT EXISTS reconciliation_runs (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    trigger_source VARCHAR(50) NOT NULL COMMENT '触发方式: scheduler, admin',
+    dry_run BOOLEAN NOT NULL DEFAULT FALSE COMMENT '是否只检查不修正',
+    scanned_count INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '核对的支付记录数',
+    events_scanned INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '读取的 Stripe 事件数',
+    mismatch_count INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '状态不一致的记录数',
+    corrected_count INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '已修正的记录数',
+    error_count INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '核对失败的记录数',
+    event_checkpoint BIGINT NOT NULL DEFAULT 0 COMMENT '已处理的最新事件创建时间（Unix 秒），下次从这里继续',
+    details JSON COMMENT '不一致和失败记录明细（JSON格式）',
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '开始时间',
+    finished_at TIMESTAMP NULL COMMENT '结束时间',
+    INDEX idx_started_at (started_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='对账任务运行记录表';
+
+-- 对账任务按 updated_at 扫描非终态记录
+CREATE INDEX idx_status_updated_at ON payment_history (status, updated_at);
@@ -0,0 +1,4 @@
+ALTER TABLE payment_history
+    DROP INDEX idx_payment_method_status_created_at,
+    DROP INDEX idx_released_idempotency_key,
+    DROP COLUMN released_idempotency_key;
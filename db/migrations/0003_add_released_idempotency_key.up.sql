@@ -0,0 +1,6 @@
+-- 过期/取消的支付释放幂等键：原幂等键移到 released_idempotency_key，同一幂等键可以重新发起支付
+
+ALTER TABLE payment_history
+    ADD COLUMN released_idempotency_key VARCHAR(255) NULL COMMENT '已释放的幂等性密钥（支付过期或取消后移到此列）' AFTER idempotency_key,
+    ADD INDEX idx_released_idempotency_key (released_idempotency_key),
+    ADD INDEX idx_payment_method_status_created_at (payment_method, status, created_at);
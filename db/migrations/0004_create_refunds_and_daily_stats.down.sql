@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS payment_daily_stats;
+
+DROP TABLE IF EXISTS payment_refunds;
@@ -0,0 +1,31 @@
+-- 退款记录表：RefundPayment 接口和 charge.refunded Webhook 写入，用于统计退款率
+CREATE TABLE IF NOT EXISTS payment_refunds (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    refund_id VARCHAR(255) NOT NULL COMMENT 'Stripe Refund ID',
+    payment_intent_id VARCHAR(255) NOT NULL COMMENT 'Stripe PaymentIntent ID',
+    amount BIGINT UNSIGNED NOT NULL COMMENT '退款金额（分）',
+    currency VARCHAR(10) NOT NULL COMMENT '币种',
+    status VARCHAR(50) NOT NULL COMMENT '退款状态: pending, succeeded, failed, canceled',
+    reason VARCHAR(50) NULL COMMENT '退款原因',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
+    UNIQUE KEY uk_refund_id (refund_id),
+    INDEX idx_payment_intent_id (payment_intent_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='退款记录表';
+
+-- 每日统计汇总表：由 daily_rollup 任务按 日期/币种/支付方式 预聚合 payment_history
+CREATE TABLE IF NOT EXISTS payment_daily_stats (
+    day DATE NOT NULL COMMENT '日期（按支付创建时间）',
+    currency VARCHAR(10) NOT NULL COMMENT '币种',
+    payment_method VARCHAR(50) NOT NULL COMMENT '支付方式',
+    attempts INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '发起支付数',
+    succeeded_count INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '支付成功数',
+    succeeded_amount BIGINT UNSIGNED NOT NULL DEFAULT 0 COMMENT '支付成功金额（分）',
+    canceled_count INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '取消数',
+    refunded_payments INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '发生退款的支付数',
+    refund_amount BIGINT UNSIGNED NOT NULL DEFAULT 0 COMMENT '退款金额（分）',
+    new_payers INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '首次支付成功的用户数',
+    returning_payers INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '再次支付成功的用户数',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
+    PRIMARY KEY (day, currency, payment_method)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='每日支付统计汇总表';
@@ -0,0 +1,3 @@
+ALTER TABLE payment_history
+    DROP INDEX idx_created_at_id,
+    DROP INDEX idx_user_created_at_id;
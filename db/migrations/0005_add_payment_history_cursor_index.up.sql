@@ -0,0 +1,5 @@
+-- 支付历史游标分页：按 (created_at, id) 倒序翻页，用户维度和全局维度各一个复合索引
+
+ALTER TABLE payment_history
+    ADD INDEX idx_user_created_at_id (user_id, created_at, id),
+    ADD INDEX idx_created_at_id (created_at, id);
@@ -0,0 +1,34 @@
+-- 优惠码表：百分比或固定金额折扣，支持有效期、总次数、每用户次数和币种限制
+CREATE TABLE IF NOT EXISTS promo_codes (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    code VARCHAR(64) NOT NULL COMMENT '优惠码（大写）',
+    discount_type VARCHAR(16) NOT NULL COMMENT '折扣类型: percent, amount',
+    percent_off INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '折扣百分比（1-100，percent 类型）',
+    amount_off BIGINT UNSIGNED NOT NULL DEFAULT 0 COMMENT '减免金额（分，amount 类型）',
+    currency VARCHAR(10) NULL COMMENT '限定币种（amount 类型必填，为空不限）',
+    max_redemptions INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '最大使用次数（0 不限）',
+    per_user_limit INT UNSIGNED NOT NULL DEFAULT 1 COMMENT '每个用户最大使用次数（0 不限）',
+    redeemed_count INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '已占用次数（预留 + 已使用）',
+    expires_at TIMESTAMP NULL COMMENT '过期时间（为空不过期）',
+    active BOOLEAN NOT NULL DEFAULT TRUE COMMENT '是否启用',
+    description VARCHAR(255) NULL COMMENT '备注',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
+    UNIQUE KEY uk_code (code)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='优惠码表';
+
+-- 优惠码使用记录表：创建支付前预留，支付成功后标记为已使用，支付取消后释放
+CREATE TABLE IF NOT EXISTS promo_redemptions (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    promo_code_id BIGINT UNSIGNED NOT NULL COMMENT '优惠码ID',
+    user_id VARCHAR(255) NOT NULL COMMENT '用户ID',
+    payment_intent_id VARCHAR(255) NULL COMMENT 'Stripe PaymentIntent ID（创建成功后写入）',
+    status VARCHAR(16) NOT NULL COMMENT '状态: reserved, redeemed, released',
+    original_amount BIGINT UNSIGNED NOT NULL COMMENT '原价（分）',
+    discount_amount BIGINT UNSIGNED NOT NULL COMMENT '折扣金额（分）',
+    currency VARCHAR(10) NOT NULL COMMENT '币种',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
+    UNIQUE KEY uk_payment_intent_id (payment_intent_id),
+    INDEX idx_promo_user_status (promo_code_id, user_id, status)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='优惠码使用记录表';
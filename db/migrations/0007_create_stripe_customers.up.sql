@@ -0,0 +1,10 @@
+-- Stripe Customer 映射表：每个用户对应一个 Stripe Customer，用于保存支付方式
+CREATE TABLE IF NOT EXISTS stripe_customers (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL COMMENT '用户ID',
+    stripe_customer_id VARCHAR(255) NOT NULL COMMENT 'Stripe Customer ID',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
+    UNIQUE KEY uk_user_id (user_id),
+    UNIQUE KEY uk_stripe_customer_id (stripe_customer_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='Stripe Customer 映射表';
@@ -0,0 +1,16 @@
+-- 收据表：每笔成功支付一张收据，receipt_number 按自增 ID 连续编号
+CREATE TABLE IF NOT EXISTS payment_receipts (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    receipt_number VARCHAR(64) NULL COMMENT '收据编号（前缀 + 序号）',
+    payment_intent_id VARCHAR(255) NOT NULL COMMENT 'Stripe PaymentIntent ID',
+    payment_id VARCHAR(255) NOT NULL COMMENT '支付ID',
+    user_id VARCHAR(255) NOT NULL COMMENT '用户ID',
+    amount BIGINT NOT NULL COMMENT '实收金额（最小货币单位，含税）',
+    currency VARCHAR(10) NOT NULL COMMENT '币种',
+    tax_rate_bps INT NOT NULL DEFAULT 0 COMMENT '开具时的税率（万分比）',
+    tax_amount BIGINT NOT NULL DEFAULT 0 COMMENT '税额（最小货币单位）',
+    issued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '开具时间',
+    UNIQUE KEY uk_payment_intent_id (payment_intent_id),
+    UNIQUE KEY uk_receipt_number (receipt_number),
+    INDEX idx_user_id (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='支付收据表';
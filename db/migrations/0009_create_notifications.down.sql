@@ -0,0 +1,4 @@
+ALTER TABLE user_payment_info
+    DROP INDEX idx_last_payment_at;
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS user_notification_settings;
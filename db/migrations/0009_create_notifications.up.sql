@@ -0,0 +1,34 @@
+-- 用户通知设置：邮箱、语言和退订
+CREATE TABLE IF NOT EXISTS user_notification_settings (
+    user_id VARCHAR(255) NOT NULL PRIMARY KEY COMMENT '用户ID',
+    email VARCHAR(254) NULL COMMENT '通知邮箱',
+    locale VARCHAR(16) NULL COMMENT '通知语言（en, zh）',
+    opted_out BOOLEAN NOT NULL DEFAULT FALSE COMMENT '是否退订',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='用户通知设置表';
+
+-- 通知发送记录：dedupe_key 唯一，同一事件只发送一次
+CREATE TABLE IF NOT EXISTS notifications (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    dedupe_key VARCHAR(255) NOT NULL COMMENT '去重键，如 payment_succeeded:pi_xxx',
+    user_id VARCHAR(255) NOT NULL COMMENT '用户ID',
+    kind VARCHAR(64) NOT NULL COMMENT '通知类型',
+    channel VARCHAR(32) NOT NULL DEFAULT 'email' COMMENT '发送渠道',
+    recipient VARCHAR(254) NOT NULL COMMENT '收件人',
+    locale VARCHAR(16) NOT NULL COMMENT '语言',
+    payload TEXT NULL COMMENT '模板数据（JSON），重试时重新渲染',
+    status VARCHAR(32) NOT NULL DEFAULT 'pending' COMMENT 'pending, sent, failed',
+    attempts INT NOT NULL DEFAULT 0 COMMENT '发送次数',
+    last_error TEXT NULL COMMENT '最近一次发送错误',
+    sent_at TIMESTAMP NULL COMMENT '发送成功时间',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
+    UNIQUE KEY uk_dedupe_key (dedupe_key),
+    INDEX idx_status_updated (status, updated_at),
+    INDEX idx_user_id (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='通知发送记录表';
+
+-- 权益到期提醒按最近支付时间范围扫描
+ALTER TABLE user_payment_info
+    ADD INDEX idx_last_payment_at (last_payment_at);
@@ -0,0 +1,32 @@
+-- 风控规则：黑名单（用户、IP、网段、邮箱、卡指纹）、每日次数限制和金额上限
+CREATE TABLE IF NOT EXISTS fraud_rules (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    rule_type VARCHAR(32) NOT NULL COMMENT 'block_user, block_ip, block_cidr, block_email, block_card_fingerprint, velocity_user_daily, max_amount',
+    value VARCHAR(255) NULL COMMENT '黑名单值（用户ID、IP、CIDR、邮箱或 @域名、卡指纹）',
+    threshold BIGINT NULL COMMENT '次数上限或金额上限（最小货币单位）',
+    currency VARCHAR(10) NULL COMMENT '金额上限适用的币种（为空适用所有币种）',
+    payment_method VARCHAR(32) NULL COMMENT '只对该支付方式生效（为空对所有支付方式生效）',
+    action VARCHAR(16) NOT NULL DEFAULT 'block' COMMENT 'block 拒绝, review 放行但标记待审核',
+    active BOOLEAN NOT NULL DEFAULT TRUE COMMENT '是否启用',
+    expires_at TIMESTAMP NULL COMMENT '过期时间（为空不过期）',
+    description VARCHAR(500) NULL COMMENT '备注',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
+    INDEX idx_active (active)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='风控规则表';
+
+-- 风控决策记录：每次创建支付前的检查结果，用于审核
+CREATE TABLE IF NOT EXISTS fraud_decisions (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL COMMENT '用户ID',
+    ip VARCHAR(64) NULL COMMENT '客户端 IP',
+    payment_method VARCHAR(32) NOT NULL COMMENT '支付方式',
+    amount BIGINT NOT NULL COMMENT '金额（最小货币单位）',
+    currency VARCHAR(10) NOT NULL COMMENT '币种',
+    decision VARCHAR(16) NOT NULL COMMENT 'allow, block, review',
+    rule_id BIGINT UNSIGNED NULL COMMENT '命中的规则',
+    reason VARCHAR(255) NULL COMMENT '命中原因',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+    INDEX idx_decision_created_at (decision, created_at),
+    INDEX idx_user_created_at (user_id, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='风控决策记录表';
@@ -0,0 +1,12 @@
+DELETE FROM stripe_customers WHERE stripe_account <> 'default' OR livemode IS NOT NULL;
+
+ALTER TABLE stripe_customers
+    DROP INDEX uk_user_account,
+    ADD UNIQUE KEY uk_user_id (user_id),
+    DROP COLUMN livemode,
+    DROP COLUMN stripe_account;
+
+ALTER TABLE payment_history
+    DROP INDEX idx_stripe_account_livemode,
+    DROP COLUMN livemode,
+    DROP COLUMN stripe_account;
@@ -0,0 +1,12 @@
+-- 多 Stripe 账户：记录每笔支付和每个 Customer 所属的账户与模式（livemode 为空表示迁移前的记录，使用默认账户的主密钥）
+
+ALTER TABLE payment_history
+    ADD COLUMN stripe_account VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT 'Stripe 账户标识（stripe.accounts[].id）' AFTER payment_method,
+    ADD COLUMN livemode BOOLEAN NULL COMMENT '是否为生产模式' AFTER stripe_account,
+    ADD INDEX idx_stripe_account_livemode (stripe_account, livemode);
+
+ALTER TABLE stripe_customers
+    ADD COLUMN stripe_account VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT 'Stripe 账户标识' AFTER stripe_customer_id,
+    ADD COLUMN livemode BOOLEAN NULL COMMENT '是否为生产模式' AFTER stripe_account,
+    DROP INDEX uk_user_id,
+    ADD UNIQUE KEY uk_user_account (user_id, stripe_account, livemode);
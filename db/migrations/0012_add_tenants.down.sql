@@ -0,0 +1,44 @@
+-- 回滚前只保留 default 租户的数据，否则恢复原唯一索引时会冲突
+DELETE FROM payment_history WHERE tenant_id <> 'default';
+DELETE FROM user_payment_info WHERE tenant_id <> 'default';
+DELETE FROM payment_config WHERE tenant_id <> 'default';
+DELETE FROM stripe_customers WHERE tenant_id <> 'default';
+DELETE FROM promo_codes WHERE tenant_id <> 'default';
+DELETE FROM user_notification_settings WHERE tenant_id <> 'default';
+DELETE FROM notifications WHERE tenant_id <> 'default';
+
+ALTER TABLE notifications
+    DROP COLUMN tenant_id;
+
+ALTER TABLE user_notification_settings
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (user_id),
+    DROP COLUMN tenant_id;
+
+ALTER TABLE promo_codes
+    DROP INDEX uk_tenant_code,
+    ADD UNIQUE KEY uk_code (code),
+    DROP COLUMN tenant_id;
+
+ALTER TABLE stripe_customers
+    DROP INDEX uk_tenant_user_account,
+    ADD UNIQUE KEY uk_user_account (user_id, stripe_account, livemode),
+    DROP COLUMN tenant_id;
+
+ALTER TABLE payment_config
+    DROP INDEX uk_tenant_currency,
+    ADD UNIQUE KEY uk_currency (currency),
+    DROP COLUMN tenant_id;
+
+ALTER TABLE user_payment_info
+    DROP INDEX uk_tenant_user,
+    ADD UNIQUE KEY user_id (user_id),
+    DROP COLUMN tenant_id;
+
+ALTER TABLE payment_history
+    DROP INDEX idx_tenant_user,
+    DROP INDEX uk_tenant_idempotency_key,
+    ADD UNIQUE INDEX uk_idempotency_key (idempotency_key),
+    DROP COLUMN tenant_id;
+
+DROP TABLE IF EXISTS tenants;
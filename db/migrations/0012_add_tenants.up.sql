@@ -0,0 +1,50 @@
+-- 多租户：租户表，以及各业务表的 tenant_id（迁移前的数据属于 default 租户）
+CREATE TABLE IF NOT EXISTS tenants (
+    id VARCHAR(64) NOT NULL PRIMARY KEY COMMENT '租户标识',
+    name VARCHAR(255) NOT NULL COMMENT '名称',
+    api_key_hash CHAR(64) NULL COMMENT 'API Key 的 SHA-256（十六进制）',
+    hosts JSON NULL COMMENT '识别租户的 Host 列表',
+    stripe_account VARCHAR(64) NULL COMMENT 'Stripe 账户标识（stripe.accounts[].id），为空时按地区选择',
+    apple_bundle_id VARCHAR(255) NULL COMMENT 'Apple bundle ID，为空不校验',
+    currency VARCHAR(10) NOT NULL DEFAULT 'hkd' COMMENT '定价币种',
+    active BOOLEAN NOT NULL DEFAULT TRUE COMMENT '是否启用',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
+    UNIQUE KEY uk_api_key_hash (api_key_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='租户表';
+
+INSERT INTO tenants (id, name) VALUES ('default', 'Default') ON DUPLICATE KEY UPDATE id = id;
+
+ALTER TABLE payment_history
+    ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '租户标识' AFTER id,
+    DROP INDEX uk_idempotency_key,
+    ADD UNIQUE INDEX uk_tenant_idempotency_key (tenant_id, idempotency_key),
+    ADD INDEX idx_tenant_user (tenant_id, user_id);
+
+ALTER TABLE user_payment_info
+    ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '租户标识' AFTER id,
+    DROP INDEX user_id,
+    ADD UNIQUE KEY uk_tenant_user (tenant_id, user_id);
+
+ALTER TABLE payment_config
+    ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '租户标识' AFTER id,
+    DROP INDEX uk_currency,
+    ADD UNIQUE KEY uk_tenant_currency (tenant_id, currency);
+
+ALTER TABLE stripe_customers
+    ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '租户标识' AFTER id,
+    DROP INDEX uk_user_account,
+    ADD UNIQUE KEY uk_tenant_user_account (tenant_id, user_id, stripe_account, livemode);
+
+ALTER TABLE promo_codes
+    ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '租户标识' AFTER id,
+    DROP INDEX uk_code,
+    ADD UNIQUE KEY uk_tenant_code (tenant_id, code);
+
+ALTER TABLE user_notification_settings
+    ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '租户标识' FIRST,
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (tenant_id, user_id);
+
+ALTER TABLE notifications
+    ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '租户标识' AFTER id;
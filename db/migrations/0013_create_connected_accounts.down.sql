@@ -0,0 +1 @@
+DROP TABLE IF EXISTS connected_accounts;
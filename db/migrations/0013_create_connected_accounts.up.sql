@@ -0,0 +1,18 @@
+-- Stripe Connect 连接账户表：创作者用户在平台 Stripe 账户下的连接账户，用于分账收款
+CREATE TABLE IF NOT EXISTS connected_accounts (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '租户标识',
+    user_id VARCHAR(255) NOT NULL COMMENT '创作者用户ID',
+    stripe_account VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '平台 Stripe 账户标识',
+    livemode BOOLEAN NOT NULL COMMENT '是否为生产模式',
+    account_id VARCHAR(255) NOT NULL COMMENT '连接账户ID（acct_...）',
+    account_type VARCHAR(32) NOT NULL COMMENT 'express 或 standard',
+    charges_enabled BOOLEAN NOT NULL DEFAULT FALSE COMMENT '是否可以收款',
+    payouts_enabled BOOLEAN NOT NULL DEFAULT FALSE COMMENT '是否可以提现',
+    details_submitted BOOLEAN NOT NULL DEFAULT FALSE COMMENT '是否已提交引导资料',
+    disabled_reason VARCHAR(64) NOT NULL DEFAULT '' COMMENT '账户受限原因（requirements.disabled_reason）',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
+    UNIQUE KEY uk_tenant_user_account (tenant_id, user_id, stripe_account, livemode),
+    UNIQUE KEY uk_account_id (account_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='Stripe Connect 连接账户表';
@@ -0,0 +1,45 @@
+-- 回滚前只保留 default 租户的数据，否则恢复原唯一索引时会冲突
+DELETE FROM reconciliation_runs WHERE tenant_id <> 'default';
+DELETE FROM fraud_decisions WHERE tenant_id <> 'default';
+DELETE FROM fraud_rules WHERE tenant_id <> 'default';
+DELETE FROM payment_daily_stats WHERE tenant_id <> 'default';
+DELETE FROM payment_receipts WHERE tenant_id <> 'default';
+DELETE FROM payment_refunds WHERE tenant_id <> 'default';
+
+ALTER TABLE reconciliation_runs
+    DROP INDEX idx_tenant_started_at,
+    ADD INDEX idx_started_at (started_at),
+    DROP COLUMN tenant_id;
+
+ALTER TABLE fraud_decisions
+    DROP INDEX idx_tenant_decision_created_at,
+    DROP INDEX idx_tenant_user_created_at,
+    ADD INDEX idx_decision_created_at (decision, created_at),
+    ADD INDEX idx_user_created_at (user_id, created_at),
+    DROP COLUMN tenant_id;
+
+ALTER TABLE fraud_rules
+    DROP INDEX idx_tenant_active,
+    ADD INDEX idx_active (active),
+    DROP COLUMN tenant_id;
+
+ALTER TABLE payment_daily_stats
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (day, currency, payment_method),
+    DROP COLUMN tenant_id;
+
+ALTER TABLE payment_receipts
+    DROP INDEX uk_tenant_payment_intent_id,
+    DROP INDEX uk_tenant_receipt_number,
+    DROP INDEX idx_tenant_user,
+    ADD UNIQUE KEY uk_payment_intent_id (payment_intent_id),
+    ADD UNIQUE KEY uk_receipt_number (receipt_number),
+    ADD INDEX idx_user_id (user_id),
+    DROP COLUMN tenant_id;
+
+ALTER TABLE payment_refunds
+    DROP INDEX uk_tenant_refund_id,
+    DROP INDEX idx_tenant_payment_intent_id,
+    ADD UNIQUE KEY uk_refund_id (refund_id),
+    ADD INDEX idx_payment_intent_id (payment_intent_id),
+    DROP COLUMN tenant_id;
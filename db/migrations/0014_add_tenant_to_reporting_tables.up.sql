@@ -0,0 +1,87 @@
+-- 多租户：0012 遗漏的退款、收据、每日统计、风控和对账表的 tenant_id
+-- 退款和收据按对应支付记录的租户回填；风控决策和对账记录无法区分租户，归属 default 租户
+
+ALTER TABLE payment_refunds
+    ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '租户标识' AFTER id,
+    DROP INDEX uk_refund_id,
+    DROP INDEX idx_payment_intent_id,
+    ADD UNIQUE KEY uk_tenant_refund_id (tenant_id, refund_id),
+    ADD INDEX idx_tenant_payment_intent_id (tenant_id, payment_intent_id);
+
+UPDATE payment_refunds r
+    JOIN payment_history p ON p.payment_intent_id = r.payment_intent_id
+    SET r.tenant_id = p.tenant_id;
+
+ALTER TABLE payment_receipts
+    ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '租户标识' AFTER id,
+    DROP INDEX uk_payment_intent_id,
+    DROP INDEX uk_receipt_number,
+    DROP INDEX idx_user_id,
+    ADD UNIQUE KEY uk_tenant_payment_intent_id (tenant_id, payment_intent_id),
+    ADD UNIQUE KEY uk_tenant_receipt_number (tenant_id, receipt_number),
+    ADD INDEX idx_tenant_user (tenant_id, user_id);
+
+UPDATE payment_receipts r
+    JOIN payment_history p ON p.payment_intent_id = r.payment_intent_id
+    SET r.tenant_id = p.tenant_id;
+
+-- 原有汇总混合了所有租户，清空后按租户重新计算（与 daily_rollup 任务的口径一致）
+ALTER TABLE payment_daily_stats
+    ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '租户标识' FIRST,
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (tenant_id, day, currency, payment_method);
+
+DELETE FROM payment_daily_stats;
+
+INSERT INTO payment_daily_stats
+    (tenant_id, day, currency, payment_method, attempts, succeeded_count, succeeded_amount, canceled_count,
+     refunded_payments, refund_amount, new_payers, returning_payers)
+SELECT p.tenant_id, DATE(p.created_at), p.currency, p.payment_method,
+    COUNT(*),
+    COALESCE(SUM(p.status = 'succeeded'), 0),
+    COALESCE(SUM(CASE WHEN p.status = 'succeeded' THEN p.amount ELSE 0 END), 0),
+    COALESCE(SUM(p.status = 'canceled'), 0),
+    COUNT(DISTINCT r.payment_intent_id),
+    COALESCE(SUM(r.refund_amount), 0),
+    COUNT(DISTINCT CASE WHEN p.status = 'succeeded' AND p.created_at = f.first_at THEN p.user_id END),
+    COUNT(DISTINCT CASE WHEN p.status = 'succeeded' AND p.created_at > f.first_at THEN p.user_id END)
+FROM payment_history p
+LEFT JOIN (
+    SELECT tenant_id, payment_intent_id, SUM(amount) AS refund_amount
+    FROM payment_refunds
+    WHERE status IN ('pending', 'succeeded')
+    GROUP BY tenant_id, payment_intent_id
+) r ON r.tenant_id = p.tenant_id AND r.payment_intent_id = p.payment_intent_id
+LEFT JOIN (
+    SELECT tenant_id, user_id, MIN(created_at) AS first_at
+    FROM payment_history
+    WHERE status = 'succeeded'
+    GROUP BY tenant_id, user_id
+) f ON f.tenant_id = p.tenant_id AND f.user_id = p.user_id
+GROUP BY p.tenant_id, DATE(p.created_at), p.currency, p.payment_method;
+
+-- 已有风控规则原先对所有租户生效，复制到其他租户保持原有拦截效果
+ALTER TABLE fraud_rules
+    ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '租户标识' AFTER id,
+    DROP INDEX idx_active,
+    ADD INDEX idx_tenant_active (tenant_id, active);
+
+INSERT INTO fraud_rules
+    (tenant_id, rule_type, value, threshold, currency, payment_method, action, active, expires_at, description)
+SELECT t.id, r.rule_type, r.value, r.threshold, r.currency, r.payment_method, r.action, r.active, r.expires_at, r.description
+FROM fraud_rules r
+JOIN tenants t ON t.id <> 'default'
+WHERE r.tenant_id = 'default';
+
+ALTER TABLE fraud_decisions
+    ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '租户标识' AFTER id,
+    DROP INDEX idx_decision_created_at,
+    DROP INDEX idx_user_created_at,
+    ADD INDEX idx_tenant_decision_created_at (tenant_id, decision, created_at),
+    ADD INDEX idx_tenant_user_created_at (tenant_id, user_id, created_at);
+
+-- 对账按租户运行，事件检查点也按租户记录
+ALTER TABLE reconciliation_runs
+    ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '租户标识' AFTER id,
+    DROP INDEX idx_started_at,
+    ADD INDEX idx_tenant_started_at (tenant_id, started_at);
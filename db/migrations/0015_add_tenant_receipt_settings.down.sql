@@ -0,0 +1,4 @@
+ALTER TABLE tenants
+    DROP COLUMN receipt_settings;
+
+DROP TABLE IF EXISTS receipt_sequences;
@@ -0,0 +1,15 @@
+-- 收据按租户连续编号，以及租户级收据设置（公司信息、税率、样式）
+CREATE TABLE IF NOT EXISTS receipt_sequences (
+    tenant_id VARCHAR(64) NOT NULL PRIMARY KEY COMMENT '租户标识',
+    last_number BIGINT UNSIGNED NOT NULL DEFAULT 0 COMMENT '已分配的最大序号',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='收据编号计数器表';
+
+-- 迁移前的编号来自全局自增 ID，各租户从当前最大 ID 之后继续编号，保证不与已有编号重复
+INSERT INTO receipt_sequences (tenant_id, last_number)
+SELECT t.id, (SELECT COALESCE(MAX(id), 0) FROM payment_receipts)
+FROM tenants t
+ON DUPLICATE KEY UPDATE last_number = GREATEST(receipt_sequences.last_number, VALUES(last_number));
+
+ALTER TABLE tenants
+    ADD COLUMN receipt_settings JSON NULL COMMENT '收据设置，为空时使用全局 receipt 配置' AFTER currency;
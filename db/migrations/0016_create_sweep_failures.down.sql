@@ -0,0 +1,4 @@
+ALTER TABLE payment_history
+    DROP INDEX idx_status_created_at_id;
+
+DROP TABLE IF EXISTS sweep_failures;
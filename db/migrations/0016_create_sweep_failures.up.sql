@@ -0,0 +1,16 @@
+-- 后台扫描任务（过期取消、授权到期检查）的失败记录：失败的支付记录按失败次数退避，退避期间跳过，不挤占后面的记录
+CREATE TABLE IF NOT EXISTS sweep_failures (
+    job VARCHAR(64) NOT NULL COMMENT '任务名（payment_expiry, authorization_expiry）',
+    payment_history_id BIGINT UNSIGNED NOT NULL COMMENT 'payment_history.id',
+    attempts INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '连续失败次数',
+    last_error VARCHAR(512) NOT NULL DEFAULT '' COMMENT '最近一次失败原因',
+    next_attempt_at TIMESTAMP NOT NULL COMMENT '下次重试时间，之前扫描时跳过',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '首次失败时间',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
+    PRIMARY KEY (job, payment_history_id),
+    INDEX idx_job_next_attempt (job, next_attempt_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci COMMENT='后台扫描任务失败记录表';
+
+-- 扫描按状态过滤，再按 (created_at, id) 游标翻页
+ALTER TABLE payment_history
+    ADD INDEX idx_status_created_at_id (status, created_at, id);
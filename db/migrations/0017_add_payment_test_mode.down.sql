@@ -0,0 +1,2 @@
+ALTER TABLE payment_history
+    DROP COLUMN test_mode;
@@ -0,0 +1,4 @@
+-- 测试模式：记录支付是否通过 X-Stripe-Mode 请求的测试支付（不计入权益和报表）。
+-- 与 livemode 分开记录：主密钥为测试密钥的部署（如预发环境）的普通支付 livemode 为 false，但仍然正常授予权益
+ALTER TABLE payment_history
+    ADD COLUMN test_mode BOOLEAN NOT NULL DEFAULT FALSE COMMENT '是否为 X-Stripe-Mode 请求的测试支付' AFTER livemode;
@@ -0,0 +1,2 @@
+ALTER TABLE promo_redemptions
+    DROP INDEX idx_status_created_at;
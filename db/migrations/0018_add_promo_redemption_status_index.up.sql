@@ -0,0 +1,3 @@
+-- 过期扫描按预留时间查找长时间未支付的优惠码预留
+ALTER TABLE promo_redemptions
+    ADD INDEX idx_status_created_at (status, created_at);
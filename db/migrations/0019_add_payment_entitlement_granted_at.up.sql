@@ -0,0 +1,6 @@
+-- 权益发放记录：支付成功后只累计一次用户支付统计（状态可能先由轮询或后台刷新写为 succeeded，不能用状态判断是否已发放）。
+-- 已成功的历史支付视为已发放
+ALTER TABLE payment_history
+    ADD COLUMN entitlement_granted_at TIMESTAMP NULL DEFAULT NULL COMMENT '用户支付统计累计时间' AFTER test_mode;
+
+UPDATE payment_history SET entitlement_granted_at = updated_at WHERE status = 'succeeded';
@@ -0,0 +1,4 @@
+ALTER TABLE stripe_customers
+    DROP INDEX uk_tenant_user_account,
+    ADD UNIQUE KEY uk_tenant_user_account (tenant_id, user_id, stripe_account, livemode),
+    DROP COLUMN livemode_key;
@@ -0,0 +1,16 @@
+-- uk_tenant_user_account 包含可为空的 livemode，MySQL 唯一索引允许多条 NULL，迁移前的记录（livemode 为空）可能重复。
+-- 用非空的生成列代替 livemode 建唯一索引：NULL 记为 -1（迁移前的记录，属于默认账户的主密钥）
+-- 先删除重复的迁移前记录（保留最早的一条）
+DELETE newer FROM stripe_customers newer
+    JOIN stripe_customers older
+        ON older.tenant_id = newer.tenant_id
+        AND older.user_id = newer.user_id
+        AND older.stripe_account = newer.stripe_account
+        AND older.livemode IS NULL
+        AND older.id < newer.id
+    WHERE newer.livemode IS NULL;
+
+ALTER TABLE stripe_customers
+    ADD COLUMN livemode_key TINYINT AS (IFNULL(livemode, -1)) STORED NOT NULL COMMENT '唯一索引用的模式（livemode 为空时为 -1）' AFTER livemode,
+    DROP INDEX uk_tenant_user_account,
+    ADD UNIQUE KEY uk_tenant_user_account (tenant_id, user_id, stripe_account, livemode_key);
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"stripe-pay/tenant"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 通知状态
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+)
+
+// NotificationSettings 用户通知设置
+type NotificationSettings struct {
+	UserID    string    `json:"user_id"`
+	Email     string    `json:"email"`
+	Locale    string    `json:"locale"`
+	OptedOut  bool      `json:"opted_out"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Notification 通知发送记录
+type Notification struct {
+	ID        int64      `json:"id"`
+	TenantID  string     `json:"tenant_id"`
+	DedupeKey string     `json:"dedupe_key"`
+	UserID    string     `json:"user_id"`
+	Kind      string     `json:"kind"`
+	Channel   string     `json:"channel"`
+	Recipient string     `json:"recipient"`
+	Locale    string     `json:"locale"`
+	Payload   string     `json:"payload"`
+	Status    string     `json:"status"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error,omitempty"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// GetNotificationSettings 查询当前租户的用户通知设置（不存在时返回 nil）
+func GetNotificationSettings(ctx context.Context, userID string) (*NotificationSettings, error) {
+	query := `SELECT user_id, email, locale, opted_out, created_at, updated_at
+		FROM user_notification_settings WHERE tenant_id = ? AND user_id = ?`
+
+	var s NotificationSettings
+	var email, locale sql.NullString
+	err := DB.QueryRowContext(ctx, query, tenant.ID(ctx), userID).Scan(&s.UserID, &email, &locale, &s.OptedOut, &s.CreatedAt, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		zap.L().Error("Failed to get notification settings", zap.Error(err), zap.String("user_id", userID))
+		return nil, err
+	}
+	s.Email = email.String
+	s.Locale = locale.String
+	return &s, nil
+}
+
+// SaveNotificationSettings 保存当前租户的用户通知设置（存在时覆盖）
+func SaveNotificationSettings(ctx context.Context, s *NotificationSettings) error {
+	query := `INSERT INTO user_notification_settings (tenant_id, user_id, email, locale, opted_out)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			email = VALUES(email),
+			locale = VALUES(locale),
+			opted_out = VALUES(opted_out)`
+
+	_, err := DB.ExecContext(ctx, query, tenant.ID(ctx), s.UserID, nullString(s.Email), nullString(s.Locale), s.OptedOut)
+	if err != nil {
+		zap.L().Error("Failed to save notification settings", zap.Error(err), zap.String("user_id", s.UserID))
+	}
+	return err
+}
+
+// ReserveNotification 按 dedupe_key 写入一条待发送记录，已存在时返回 false（同一事件只发送一次）。
+// 未指定租户时记录到 context 中的租户
+func ReserveNotification(ctx context.Context, n *Notification) (bool, error) {
+	if n.TenantID == "" {
+		n.TenantID = tenant.ID(ctx)
+	}
+	query := `INSERT IGNORE INTO notifications
+		(tenant_id, dedupe_key, user_id, kind, channel, recipient, locale, payload, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	res, err := DB.ExecContext(ctx, query, n.TenantID, n.DedupeKey, n.UserID, n.Kind, n.Channel, n.Recipient, n.Locale, n.Payload, NotificationPending)
+	if err != nil {
+		zap.L().Error("Failed to reserve notification", zap.Error(err), zap.String("dedupe_key", n.DedupeKey))
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil || affected == 0 {
+		return false, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return false, err
+	}
+	n.ID = id
+	n.Status = NotificationPending
+	return true, nil
+}
+
+// MarkNotificationSent 标记通知发送成功
+func MarkNotificationSent(ctx context.Context, id int64) error {
+	_, err := DB.ExecContext(ctx, `UPDATE notifications
+		SET status = ?, attempts = attempts + 1, last_error = NULL, sent_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, NotificationSent, id)
+	return err
+}
+
+// MarkNotificationFailed 记录一次发送失败
+func MarkNotificationFailed(ctx context.Context, id int64, sendErr string) error {
+	_, err := DB.ExecContext(ctx, `UPDATE notifications
+		SET status = ?, attempts = attempts + 1, last_error = ?
+		WHERE id = ?`, NotificationFailed, sendErr, id)
+	return err
+}
+
+// ListRetryableNotifications 查询需要重试的通知：发送失败且次数未用完，或长时间停留在 pending（发送过程中进程退出）
+func ListRetryableNotifications(ctx context.Context, maxAttempts int, pendingBefore time.Time, limit int) ([]Notification, error) {
+	query := `SELECT id, tenant_id, dedupe_key, user_id, kind, channel, recipient, locale, payload, status, attempts, created_at, updated_at
+		FROM notifications
+		WHERE (status = ? AND attempts < ?) OR (status = ? AND updated_at < ?)
+		ORDER BY id
+		LIMIT ?`
+
+	rows, err := DB.QueryContext(ctx, query, NotificationFailed, maxAttempts, NotificationPending, pendingBefore, limit)
+	if err != nil {
+		zap.L().Error("Failed to list retryable notifications", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var n Notification
+		var payload sql.NullString
+		if err := rows.Scan(&n.ID, &n.TenantID, &n.DedupeKey, &n.UserID, &n.Kind, &n.Channel, &n.Recipient, &n.Locale,
+			&payload, &n.Status, &n.Attempts, &n.CreatedAt, &n.UpdatedAt); err != nil {
+			return nil, err
+		}
+		n.Payload = payload.String
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// TenantUser 租户下的用户
+type TenantUser struct {
+	TenantID string
+	UserID   string
+}
+
+// ListUsersLastPaidBetween 查询所有租户中最近一次支付时间在 [from, to) 内的用户（权益到期提醒）
+func ListUsersLastPaidBetween(ctx context.Context, from, to time.Time, limit, offset int) ([]TenantUser, error) {
+	query := `SELECT tenant_id, user_id FROM user_payment_info
+		WHERE has_paid = TRUE AND last_payment_at >= ? AND last_payment_at < ?
+		ORDER BY last_payment_at, id
+		LIMIT ? OFFSET ?`
+
+	rows, err := DB.QueryContext(ctx, query, from, to, limit, offset)
+	if err != nil {
+		zap.L().Error("Failed to list users by last payment time", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []TenantUser
+	for rows.Next() {
+		var u TenantUser
+		if err := rows.Scan(&u.TenantID, &u.UserID); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// nullString 空字符串写入 NULL
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
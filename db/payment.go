@@ -1,11 +1,13 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"strings"
 	"stripe-pay/conf"
+	"stripe-pay/tenant"
 	"time"
 
 	"go.uber.org/zap"
@@ -31,6 +33,10 @@ type PaymentHistory struct {
 	Currency        string    `json:"currency"`
 	Status          string    `json:"status"`
 	PaymentMethod   string    `json:"payment_method"`
+	StripeAccount   string    `json:"stripe_account"` // Stripe 账户标识（默认 default）
+	Livemode        *bool     `json:"livemode"`       // 是否为生产模式，为空表示迁移前的记录
+	TestMode        bool      `json:"test_mode"`      // 是否通过 X-Stripe-Mode 请求的测试支付（不计入权益和报表）
+	TenantID        string    `json:"tenant_id"`      // 所属租户
 	Description     string    `json:"description"`
 	Metadata        string    `json:"metadata"` // JSON 字符串
 	CreatedAt       time.Time `json:"created_at"`
@@ -53,8 +59,8 @@ type UserPaymentInfo struct {
 // SavePaymentHistory 保存支付历史记录
 func SavePaymentHistory(ph *PaymentHistory) error {
 	query := `INSERT INTO payment_history 
-		(payment_intent_id, payment_id, idempotency_key, user_id, amount, currency, status, payment_method, description, metadata)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		(payment_intent_id, payment_id, idempotency_key, user_id, amount, currency, status, payment_method, stripe_account, livemode, test_mode, tenant_id, description, metadata)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON DUPLICATE KEY UPDATE
 			status = VALUES(status),
 			updated_at = CURRENT_TIMESTAMP`
@@ -63,6 +69,12 @@ func SavePaymentHistory(ph *PaymentHistory) error {
 	if ph.Metadata != "" {
 		metadataJSON = ph.Metadata
 	}
+	if ph.StripeAccount == "" {
+		ph.StripeAccount = DefaultStripeAccount
+	}
+	if ph.TenantID == "" {
+		ph.TenantID = tenant.DefaultID
+	}
 
 	result, err := DB.Exec(query,
 		ph.PaymentIntentID,
@@ -73,6 +85,10 @@ func SavePaymentHistory(ph *PaymentHistory) error {
 		ph.Currency,
 		ph.Status,
 		ph.PaymentMethod,
+		ph.StripeAccount,
+		ph.Livemode,
+		ph.TestMode,
+		ph.TenantID,
 		ph.Description,
 		metadataJSON,
 	)
@@ -84,7 +100,7 @@ func SavePaymentHistory(ph *PaymentHistory) error {
 			zap.L().Error("Database migration required: idempotency_key column does not exist",
 				zap.String("payment_intent_id", ph.PaymentIntentID),
 				zap.String("error", err.Error()))
-			return fmt.Errorf("database migration required: please run 'mysql -u %s -p %s < database/add_idempotency_key.sql' to add idempotency_key column (check config.yaml for your database user)", cfg.Database.User, cfg.Database.Database)
+			return fmt.Errorf("database migration required: please run 'stripe-pay migrate up' against database %s (user %s) to add idempotency_key column", cfg.Database.Database, cfg.Database.User)
 		}
 		// 检查是否是唯一约束冲突（idempotency_key重复）
 		if strings.Contains(err.Error(), "Duplicate entry") || strings.Contains(err.Error(), "UNIQUE constraint") {
@@ -105,13 +121,13 @@ func SavePaymentHistory(ph *PaymentHistory) error {
 	return nil
 }
 
-// UpdatePaymentStatus 更新支付状态
-func UpdatePaymentStatus(paymentIntentID, status string) error {
+// UpdatePaymentStatus 更新当前租户的支付状态
+func UpdatePaymentStatus(ctx context.Context, paymentIntentID, status string) error {
 	query := `UPDATE payment_history 
 		SET status = ?, updated_at = CURRENT_TIMESTAMP 
-		WHERE payment_intent_id = ?`
+		WHERE tenant_id = ? AND payment_intent_id = ?`
 
-	_, err := DB.Exec(query, status, paymentIntentID)
+	_, err := DB.ExecContext(ctx, query, status, tenant.ID(ctx), paymentIntentID)
 	if err != nil {
 		zap.L().Error("Failed to update payment status", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
 		return err
@@ -121,13 +137,14 @@ func UpdatePaymentStatus(paymentIntentID, status string) error {
 	return nil
 }
 
-// UpdateUserPaymentInfo 更新用户支付信息（支付成功时调用）
-func UpdateUserPaymentInfo(userID string, amount int64) error {
+// UpdateUserPaymentInfo 更新用户支付信息（生产模式的支付成功时调用，按 context 中的租户；测试模式的支付不能调用）
+func UpdateUserPaymentInfo(ctx context.Context, userID string, amount int64) error {
 	now := time.Now()
+	tenantID := tenant.ID(ctx)
 
 	// 先检查用户是否存在
 	var exists bool
-	err := DB.QueryRow("SELECT EXISTS(SELECT 1 FROM user_payment_info WHERE user_id = ?)", userID).Scan(&exists)
+	err := DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM user_payment_info WHERE tenant_id = ? AND user_id = ?)", tenantID, userID).Scan(&exists)
 	if err != nil {
 		zap.L().Error("Failed to check user payment info", zap.Error(err))
 		return err
@@ -136,10 +153,10 @@ func UpdateUserPaymentInfo(userID string, amount int64) error {
 	if !exists {
 		// 插入新记录
 		query := `INSERT INTO user_payment_info 
-			(user_id, has_paid, first_payment_at, last_payment_at, total_payment_count, total_payment_amount)
-			VALUES (?, TRUE, ?, ?, 1, ?)`
+			(tenant_id, user_id, has_paid, first_payment_at, last_payment_at, total_payment_count, total_payment_amount)
+			VALUES (?, ?, TRUE, ?, ?, 1, ?)`
 
-		_, err = DB.Exec(query, userID, now, now, amount)
+		_, err = DB.ExecContext(ctx, query, tenantID, userID, now, now, amount)
 		if err != nil {
 			zap.L().Error("Failed to insert user payment info", zap.Error(err))
 			return err
@@ -148,7 +165,7 @@ func UpdateUserPaymentInfo(userID string, amount int64) error {
 		// 更新现有记录
 		// 先检查是否是首次支付
 		var firstPaymentAt sql.NullTime
-		err = DB.QueryRow("SELECT first_payment_at FROM user_payment_info WHERE user_id = ?", userID).Scan(&firstPaymentAt)
+		err = DB.QueryRowContext(ctx, "SELECT first_payment_at FROM user_payment_info WHERE tenant_id = ? AND user_id = ?", tenantID, userID).Scan(&firstPaymentAt)
 
 		// 如果是首次支付，更新首次支付时间
 		if err == nil && (!firstPaymentAt.Valid || firstPaymentAt.Time.IsZero()) {
@@ -159,8 +176,8 @@ func UpdateUserPaymentInfo(userID string, amount int64) error {
 					total_payment_count = total_payment_count + 1,
 					total_payment_amount = total_payment_amount + ?,
 					updated_at = CURRENT_TIMESTAMP
-				WHERE user_id = ?`
-			_, err = DB.Exec(query, now, now, amount, userID)
+				WHERE tenant_id = ? AND user_id = ?`
+			_, err = DB.ExecContext(ctx, query, now, now, amount, tenantID, userID)
 		} else {
 			// 非首次支付，只更新最近支付时间和统计
 			query := `UPDATE user_payment_info 
@@ -169,8 +186,8 @@ func UpdateUserPaymentInfo(userID string, amount int64) error {
 					total_payment_count = total_payment_count + 1,
 					total_payment_amount = total_payment_amount + ?,
 					updated_at = CURRENT_TIMESTAMP
-				WHERE user_id = ?`
-			_, err = DB.Exec(query, now, amount, userID)
+				WHERE tenant_id = ? AND user_id = ?`
+			_, err = DB.ExecContext(ctx, query, now, amount, tenantID, userID)
 		}
 
 		if err != nil {
@@ -179,12 +196,63 @@ func UpdateUserPaymentInfo(userID string, amount int64) error {
 		}
 	}
 
-	zap.L().Info("User payment info updated", zap.String("user_id", userID))
+	zap.L().Info("User payment info updated", zap.String("tenant_id", tenantID), zap.String("user_id", userID))
 	return nil
 }
 
-// GetUserPaymentInfo 获取用户支付信息（实时从 payment_history 查询，确保准确性）
-func GetUserPaymentInfo(userID string) (*UserPaymentInfo, error) {
+// GrantPaymentEntitlement 支付成功后累计用户支付统计（当前租户），每个 PaymentIntent 只累计一次。
+// 在同一事务中标记 payment_history.entitlement_granted_at 并更新 user_payment_info，返回本次是否累计
+func GrantPaymentEntitlement(ctx context.Context, paymentIntentID, userID string, amount int64) (bool, error) {
+	tenantID := tenant.ID(ctx)
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE payment_history SET entitlement_granted_at = CURRENT_TIMESTAMP
+		WHERE tenant_id = ? AND payment_intent_id = ? AND entitlement_granted_at IS NULL`,
+		tenantID, paymentIntentID)
+	if err != nil {
+		zap.L().Error("Failed to mark entitlement granted", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
+		return false, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return false, nil
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `INSERT INTO user_payment_info
+		(tenant_id, user_id, has_paid, first_payment_at, last_payment_at, total_payment_count, total_payment_amount)
+		VALUES (?, ?, TRUE, ?, ?, 1, ?)
+		ON DUPLICATE KEY UPDATE
+			has_paid = TRUE,
+			first_payment_at = COALESCE(first_payment_at, VALUES(first_payment_at)),
+			last_payment_at = VALUES(last_payment_at),
+			total_payment_count = total_payment_count + 1,
+			total_payment_amount = total_payment_amount + VALUES(total_payment_amount),
+			updated_at = CURRENT_TIMESTAMP`,
+		tenantID, userID, now, now, amount)
+	if err != nil {
+		zap.L().Error("Failed to update user payment info", zap.Error(err), zap.String("user_id", userID))
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	zap.L().Info("User payment info updated",
+		zap.String("tenant_id", tenantID),
+		zap.String("user_id", userID),
+		zap.String("payment_intent_id", paymentIntentID))
+	return true, nil
+}
+
+// GetUserPaymentInfo 获取用户支付信息（实时从 payment_history 查询，通过 X-Stripe-Mode 请求的测试支付不计入权益）。
+// 配置了从库时读取从库，需要强一致时（如创建支付前的重复支付检查）使用 WithPrimary
+func GetUserPaymentInfo(ctx context.Context, userID string) (*UserPaymentInfo, error) {
 	// 从 payment_history 实时查询成功的支付记录
 	query := `SELECT 
 		COUNT(*) as total_count,
@@ -192,13 +260,13 @@ func GetUserPaymentInfo(userID string) (*UserPaymentInfo, error) {
 		MIN(created_at) as first_payment,
 		MAX(created_at) as last_payment
 		FROM payment_history 
-		WHERE user_id = ? AND status = 'succeeded'`
+		WHERE tenant_id = ? AND user_id = ? AND status = 'succeeded' AND test_mode = FALSE`
 
 	var totalCount int
 	var totalAmount int64
 	var firstPayment, lastPayment sql.NullTime
 
-	err := DB.QueryRow(query, userID).Scan(&totalCount, &totalAmount, &firstPayment, &lastPayment)
+	err := reader(ctx).QueryRowContext(ctx, query, tenant.ID(ctx), userID).Scan(&totalCount, &totalAmount, &firstPayment, &lastPayment)
 	if err != nil && err != sql.ErrNoRows {
 		zap.L().Error("Failed to query payment history for user", zap.Error(err), zap.String("user_id", userID))
 		return nil, err
@@ -222,7 +290,7 @@ func GetUserPaymentInfo(userID string) (*UserPaymentInfo, error) {
 	// 如果用户有支付记录，尝试同步到 user_payment_info 表（异步更新，不影响返回）
 	if totalCount > 0 {
 		go func() {
-			if err := UpdateUserPaymentInfo(userID, totalAmount); err != nil {
+			if err := UpdateUserPaymentInfo(context.WithoutCancel(ctx), userID, totalAmount); err != nil {
 				zap.L().Warn("Failed to sync user payment info", zap.Error(err), zap.String("user_id", userID))
 			}
 		}()
@@ -231,20 +299,57 @@ func GetUserPaymentInfo(userID string) (*UserPaymentInfo, error) {
 	return info, nil
 }
 
-// GetPaymentHistory 获取支付历史（按用户ID）
-func GetPaymentHistory(userID string, limit int) ([]PaymentHistory, error) {
+// GetUserPaymentInfoRecord 读取 user_payment_info 表中保存的用户支付汇总（不存在时返回 nil）
+func GetUserPaymentInfoRecord(ctx context.Context, userID string) (*UserPaymentInfo, error) {
+	query := `SELECT id, user_id, has_paid, first_payment_at, last_payment_at,
+		total_payment_count, total_payment_amount, created_at, updated_at
+		FROM user_payment_info
+		WHERE tenant_id = ? AND user_id = ?`
+
+	info := &UserPaymentInfo{}
+	var firstPayment, lastPayment sql.NullTime
+	err := DB.QueryRowContext(ctx, query, tenant.ID(ctx), userID).Scan(
+		&info.ID,
+		&info.UserID,
+		&info.HasPaid,
+		&firstPayment,
+		&lastPayment,
+		&info.TotalPaymentCount,
+		&info.TotalPaymentAmount,
+		&info.CreatedAt,
+		&info.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		zap.L().Error("Failed to get user payment info record", zap.Error(err), zap.String("user_id", userID))
+		return nil, err
+	}
+
+	if firstPayment.Valid {
+		info.FirstPaymentAt = &firstPayment.Time
+	}
+	if lastPayment.Valid {
+		info.LastPaymentAt = &lastPayment.Time
+	}
+	return info, nil
+}
+
+// GetPaymentHistory 获取支付历史（按用户ID，配置了从库时读取从库）
+func GetPaymentHistory(ctx context.Context, userID string, limit int) ([]PaymentHistory, error) {
 	if limit <= 0 {
 		limit = 50
 	}
 
-	query := `SELECT id, payment_intent_id, payment_id, idempotency_key, user_id, amount, currency, 
-		status, payment_method, description, metadata, created_at, updated_at
+	query := `SELECT id, payment_intent_id, payment_id, COALESCE(idempotency_key, ''), user_id, amount, currency, 
+		status, payment_method, stripe_account, livemode, test_mode, tenant_id, description, metadata, created_at, updated_at
 		FROM payment_history 
-		WHERE user_id = ? 
+		WHERE tenant_id = ? AND user_id = ? 
 		ORDER BY created_at DESC 
 		LIMIT ?`
 
-	rows, err := DB.Query(query, userID, limit)
+	rows, err := reader(ctx).QueryContext(ctx, query, tenant.ID(ctx), userID, limit)
 	if err != nil {
 		zap.L().Error("Failed to query payment history", zap.Error(err))
 		return nil, err
@@ -264,6 +369,10 @@ func GetPaymentHistory(userID string, limit int) ([]PaymentHistory, error) {
 			&ph.Currency,
 			&ph.Status,
 			&ph.PaymentMethod,
+			&ph.StripeAccount,
+			&ph.Livemode,
+			&ph.TestMode,
+			&ph.TenantID,
 			&ph.Description,
 			&ph.Metadata,
 			&ph.CreatedAt,
@@ -279,22 +388,22 @@ func GetPaymentHistory(userID string, limit int) ([]PaymentHistory, error) {
 	return history, nil
 }
 
-// GetPaymentByIdempotencyKey 根据幂等性密钥获取支付记录
-func GetPaymentByIdempotencyKey(idempotencyKey string) (*PaymentHistory, error) {
+// GetPaymentByIdempotencyKey 根据幂等性密钥获取当前租户的支付记录（始终读取主库，避免从库延迟导致重复创建支付）
+func GetPaymentByIdempotencyKey(ctx context.Context, idempotencyKey string) (*PaymentHistory, error) {
 	if idempotencyKey == "" {
 		return nil, nil
 	}
 
 	// 先检查字段是否存在（处理数据库迁移未执行的情况）
 	// 如果字段不存在，查询会失败，但我们不想因为这个阻止请求
-	query := `SELECT id, payment_intent_id, payment_id, idempotency_key, user_id, amount, currency, 
-		status, payment_method, description, metadata, created_at, updated_at
+	query := `SELECT id, payment_intent_id, payment_id, COALESCE(idempotency_key, ''), user_id, amount, currency, 
+		status, payment_method, stripe_account, livemode, test_mode, tenant_id, description, metadata, created_at, updated_at
 		FROM payment_history 
-		WHERE idempotency_key = ? 
+		WHERE tenant_id = ? AND idempotency_key = ? 
 		LIMIT 1`
 
 	ph := &PaymentHistory{}
-	err := DB.QueryRow(query, idempotencyKey).Scan(
+	err := DB.QueryRowContext(ctx, query, tenant.ID(ctx), idempotencyKey).Scan(
 		&ph.ID,
 		&ph.PaymentIntentID,
 		&ph.PaymentID,
@@ -304,6 +413,10 @@ func GetPaymentByIdempotencyKey(idempotencyKey string) (*PaymentHistory, error)
 		&ph.Currency,
 		&ph.Status,
 		&ph.PaymentMethod,
+		&ph.StripeAccount,
+		&ph.Livemode,
+		&ph.TestMode,
+		&ph.TenantID,
 		&ph.Description,
 		&ph.Metadata,
 		&ph.CreatedAt,
@@ -334,20 +447,20 @@ func GetPaymentByIdempotencyKey(idempotencyKey string) (*PaymentHistory, error)
 	return ph, nil
 }
 
-// GetPaymentByPaymentID 根据payment_id获取支付记录
-func GetPaymentByPaymentID(paymentID string) (*PaymentHistory, error) {
+// GetPaymentByPaymentID 根据payment_id获取当前租户的支付记录
+func GetPaymentByPaymentID(ctx context.Context, paymentID string) (*PaymentHistory, error) {
 	if paymentID == "" {
 		return nil, nil
 	}
 
-	query := `SELECT id, payment_intent_id, payment_id, idempotency_key, user_id, amount, currency, 
-		status, payment_method, description, metadata, created_at, updated_at
+	query := `SELECT id, payment_intent_id, payment_id, COALESCE(idempotency_key, ''), user_id, amount, currency, 
+		status, payment_method, stripe_account, livemode, test_mode, tenant_id, description, metadata, created_at, updated_at
 		FROM payment_history 
-		WHERE payment_id = ? 
+		WHERE tenant_id = ? AND payment_id = ? 
 		LIMIT 1`
 
 	ph := &PaymentHistory{}
-	err := DB.QueryRow(query, paymentID).Scan(
+	err := DB.QueryRowContext(ctx, query, tenant.ID(ctx), paymentID).Scan(
 		&ph.ID,
 		&ph.PaymentIntentID,
 		&ph.PaymentID,
@@ -357,6 +470,10 @@ func GetPaymentByPaymentID(paymentID string) (*PaymentHistory, error) {
 		&ph.Currency,
 		&ph.Status,
 		&ph.PaymentMethod,
+		&ph.StripeAccount,
+		&ph.Livemode,
+		&ph.TestMode,
+		&ph.TenantID,
 		&ph.Description,
 		&ph.Metadata,
 		&ph.CreatedAt,
@@ -379,8 +496,79 @@ func GetPaymentByPaymentID(paymentID string) (*PaymentHistory, error) {
 	return ph, nil
 }
 
-// SavePaymentWithMetadata 保存支付记录（带元数据）
-func SavePaymentWithMetadata(paymentIntentID, paymentID, idempotencyKey, userID string, amount int64, currency, status, paymentMethod, description string, metadata map[string]string) error {
+// GetPaymentByIntentID 根据payment_intent_id获取当前租户的支付记录
+func GetPaymentByIntentID(ctx context.Context, paymentIntentID string) (*PaymentHistory, error) {
+	return getPaymentByIntentID(ctx, paymentIntentID, true)
+}
+
+// GetPaymentByIntentIDAnyTenant 根据payment_intent_id获取支付记录（不限租户）
+// 只用于 Webhook、对账和后台清理任务这类没有请求租户的路径，调用方需按记录的 tenant_id 设置租户后再做后续处理
+func GetPaymentByIntentIDAnyTenant(ctx context.Context, paymentIntentID string) (*PaymentHistory, error) {
+	return getPaymentByIntentID(ctx, paymentIntentID, false)
+}
+
+func getPaymentByIntentID(ctx context.Context, paymentIntentID string, scoped bool) (*PaymentHistory, error) {
+	if paymentIntentID == "" {
+		return nil, nil
+	}
+
+	query := `SELECT id, payment_intent_id, payment_id, COALESCE(idempotency_key, ''), user_id, amount, currency, 
+		status, payment_method, stripe_account, livemode, test_mode, tenant_id, description, metadata, created_at, updated_at
+		FROM payment_history 
+		WHERE payment_intent_id = ?`
+	args := []interface{}{paymentIntentID}
+	if scoped {
+		query += ` AND tenant_id = ?`
+		args = append(args, tenant.ID(ctx))
+	}
+	query += ` LIMIT 1`
+
+	ph := &PaymentHistory{}
+	err := DB.QueryRowContext(ctx, query, args...).Scan(
+		&ph.ID,
+		&ph.PaymentIntentID,
+		&ph.PaymentID,
+		&ph.IdempotencyKey,
+		&ph.UserID,
+		&ph.Amount,
+		&ph.Currency,
+		&ph.Status,
+		&ph.PaymentMethod,
+		&ph.StripeAccount,
+		&ph.Livemode,
+		&ph.TestMode,
+		&ph.TenantID,
+		&ph.Description,
+		&ph.Metadata,
+		&ph.CreatedAt,
+		&ph.UpdatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		zap.L().Debug("No payment found with payment_intent_id", zap.String("payment_intent_id", paymentIntentID))
+		return nil, nil
+	}
+
+	if err != nil {
+		zap.L().Error("Failed to get payment by payment_intent_id", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
+		return nil, err
+	}
+
+	return ph, nil
+}
+
+// PaymentAccount 支付所属的 Stripe 账户和模式
+type PaymentAccount struct {
+	StripeAccount string // 为空时为 default
+	Livemode      *bool  // 为空表示未知
+	TestMode      bool   // 请求通过 X-Stripe-Mode 选择了测试模式
+}
+
+// DefaultStripeAccount 默认 Stripe 账户标识
+const DefaultStripeAccount = "default"
+
+// SavePaymentWithMetadata 保存支付记录（带元数据、所属 Stripe 账户和 context 中的租户）
+func SavePaymentWithMetadata(ctx context.Context, account PaymentAccount, paymentIntentID, paymentID, idempotencyKey, userID string, amount int64, currency, status, paymentMethod, description string, metadata map[string]string) error {
 	metadataJSON := ""
 	if len(metadata) > 0 {
 		bytes, err := json.Marshal(metadata)
@@ -398,6 +586,10 @@ func SavePaymentWithMetadata(paymentIntentID, paymentID, idempotencyKey, userID
 		Currency:        currency,
 		Status:          status,
 		PaymentMethod:   paymentMethod,
+		StripeAccount:   account.StripeAccount,
+		Livemode:        account.Livemode,
+		TestMode:        account.TestMode,
+		TenantID:        tenant.ID(ctx),
 		Description:     description,
 		Metadata:        metadataJSON,
 	}
@@ -415,19 +607,19 @@ type PaymentConfig struct {
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
-// GetPaymentConfig 获取支付金额配置（按币种，默认 hkd）
-func GetPaymentConfig(currency string) (*PaymentConfig, error) {
+// GetPaymentConfig 获取当前租户的支付金额配置（按币种，为空时使用租户的定价币种）
+func GetPaymentConfig(ctx context.Context, currency string) (*PaymentConfig, error) {
 	if currency == "" {
-		currency = "hkd"
+		currency = tenant.FromContext(ctx).PricingCurrency()
 	}
 
 	query := `SELECT id, amount, currency, description, created_at, updated_at
 		FROM payment_config 
-		WHERE currency = ? 
+		WHERE tenant_id = ? AND currency = ? 
 		LIMIT 1`
 
 	config := &PaymentConfig{}
-	err := DB.QueryRow(query, currency).Scan(
+	err := DB.QueryRowContext(ctx, query, tenant.ID(ctx), currency).Scan(
 		&config.ID,
 		&config.Amount,
 		&config.Currency,
@@ -440,7 +632,7 @@ func GetPaymentConfig(currency string) (*PaymentConfig, error) {
 		// 如果不存在，返回默认值
 		return &PaymentConfig{
 			Amount:   5900,
-			Currency: "hkd",
+			Currency: currency,
 		}, nil
 	}
 
@@ -452,26 +644,226 @@ func GetPaymentConfig(currency string) (*PaymentConfig, error) {
 	return config, nil
 }
 
-// UpdatePaymentConfig 更新支付金额配置
-func UpdatePaymentConfig(currency string, amount int64, description string) error {
+// UpdatePaymentConfig 更新当前租户的支付金额配置
+func UpdatePaymentConfig(ctx context.Context, currency string, amount int64, description string) error {
 	if currency == "" {
-		currency = "hkd"
+		currency = tenant.FromContext(ctx).PricingCurrency()
 	}
 
 	// 使用 INSERT ... ON DUPLICATE KEY UPDATE 确保存在则更新，不存在则插入
-	query := `INSERT INTO payment_config (currency, amount, description, updated_at)
-		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	query := `INSERT INTO payment_config (tenant_id, currency, amount, description, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
 		ON DUPLICATE KEY UPDATE
 			amount = VALUES(amount),
 			description = VALUES(description),
 			updated_at = CURRENT_TIMESTAMP`
 
-	_, err := DB.Exec(query, currency, amount, description)
+	_, err := DB.ExecContext(ctx, query, tenant.ID(ctx), currency, amount, description)
 	if err != nil {
 		zap.L().Error("Failed to update payment config", zap.Error(err), zap.String("currency", currency), zap.Int64("amount", amount))
 		return err
 	}
 
-	zap.L().Info("Payment config updated", zap.String("currency", currency), zap.Int64("amount", amount))
+	zap.L().Info("Payment config updated", zap.String("tenant_id", tenant.ID(ctx)), zap.String("currency", currency), zap.Int64("amount", amount))
+	return nil
+}
+
+// sweepColumns 后台扫描查询的列（p 为 payment_history）
+const sweepColumns = `p.id, p.payment_intent_id, p.payment_id, p.idempotency_key, p.user_id, p.amount, p.currency,
+		p.status, p.payment_method, p.stripe_account, p.livemode, p.test_mode, p.tenant_id, p.description, p.metadata, p.created_at, p.updated_at`
+
+// sweepQuery 生成后台扫描查询：跳过该任务退避中的记录，按 (created_at, id) 升序从 after 之后翻页
+func sweepQuery(job, where string, args []interface{}, after *PaymentCursor, limit int) (string, []interface{}) {
+	query := `SELECT ` + sweepColumns + `
+		FROM payment_history p
+		LEFT JOIN sweep_failures f ON f.job = ? AND f.payment_history_id = p.id
+		WHERE ` + where + ` AND (f.next_attempt_at IS NULL OR f.next_attempt_at <= CURRENT_TIMESTAMP)`
+	args = append([]interface{}{job}, args...)
+	if after != nil {
+		query += " AND (p.created_at > ? OR (p.created_at = ? AND p.id > ?))"
+		args = append(args, after.CreatedAt, after.CreatedAt, after.ID)
+	}
+	query += " ORDER BY p.created_at ASC, p.id ASC LIMIT ?"
+	return query, append(args, limit)
+}
+
+// ListExpirablePayments 查询指定支付方式下创建时间早于 createdBefore 且仍处于可取消状态的支付记录，
+// 从 after 之后按 (created_at, id) 升序返回一页（after 为 nil 时从头开始），跳过退避中的失败记录
+func ListExpirablePayments(ctx context.Context, paymentMethod string, statuses []string, createdBefore time.Time, after *PaymentCursor, limit int) ([]PaymentHistory, error) {
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(statuses)), ",")
+	args := make([]interface{}, 0, len(statuses)+2)
+	args = append(args, paymentMethod)
+	for _, status := range statuses {
+		args = append(args, status)
+	}
+	args = append(args, createdBefore)
+
+	query, args := sweepQuery(SweepJobPaymentExpiry,
+		`p.payment_method = ? AND p.status IN (`+placeholders+`) AND p.created_at < ?`, args, after, limit)
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		zap.L().Error("Failed to query expirable payments", zap.Error(err), zap.String("payment_method", paymentMethod))
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPaymentHistoryRows(rows)
+}
+
+// ListPromoReservedPayments 查询占用优惠码预留超过 reservedBefore 且仍处于可取消状态的支付记录（所有支付方式），
+// 从 after 之后按 (created_at, id) 升序返回一页（after 为 nil 时从头开始），跳过退避中的失败记录
+func ListPromoReservedPayments(ctx context.Context, statuses []string, reservedBefore time.Time, after *PaymentCursor, limit int) ([]PaymentHistory, error) {
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(statuses)), ",")
+	args := make([]interface{}, 0, len(statuses)+2)
+	for _, status := range statuses {
+		args = append(args, status)
+	}
+	args = append(args, PromoRedemptionReserved, reservedBefore)
+
+	query, args := sweepQuery(SweepJobPromoReservation,
+		`p.status IN (`+placeholders+`) AND p.payment_intent_id IN (
+			SELECT payment_intent_id FROM promo_redemptions WHERE status = ? AND created_at < ? AND payment_intent_id IS NOT NULL)`,
+		args, after, limit)
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		zap.L().Error("Failed to query promo reserved payments", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPaymentHistoryRows(rows)
+}
+
+// ListAuthorizedPayments 查询已授权待扣款（requires_capture）且创建时间早于 createdBefore 的支付记录，
+// 从 after 之后按 (created_at, id) 升序返回一页（after 为 nil 时从头开始），跳过退避中的失败记录
+func ListAuthorizedPayments(ctx context.Context, createdBefore time.Time, after *PaymentCursor, limit int) ([]PaymentHistory, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query, args := sweepQuery(SweepJobAuthorizationExpiry,
+		`p.status = 'requires_capture' AND p.created_at < ?`, []interface{}{createdBefore}, after, limit)
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		zap.L().Error("Failed to query authorized payments", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPaymentHistoryRows(rows)
+}
+
+// UpdatePaymentAmount 更新当前租户的支付金额（部分扣款后记录实际扣款金额）
+func UpdatePaymentAmount(ctx context.Context, paymentIntentID string, amount int64) error {
+	_, err := DB.ExecContext(ctx,
+		`UPDATE payment_history SET amount = ?, updated_at = CURRENT_TIMESTAMP WHERE tenant_id = ? AND payment_intent_id = ?`,
+		amount, tenant.ID(ctx), paymentIntentID)
+	if err != nil {
+		zap.L().Error("Failed to update payment amount", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
+	}
+	return err
+}
+
+// AttachCheckoutPaymentIntent 将 Checkout Session 占位记录关联到实际的 PaymentIntent（payment_intent_id 由 cs_ 改为 pi_，重复调用无副作用）
+func AttachCheckoutPaymentIntent(ctx context.Context, paymentID, paymentIntentID string) (bool, error) {
+	result, err := DB.ExecContext(ctx,
+		`UPDATE payment_history SET payment_intent_id = ?, updated_at = CURRENT_TIMESTAMP 
+		WHERE payment_id = ? AND payment_intent_id LIKE 'cs\_%'`,
+		paymentIntentID, paymentID)
+	if err != nil {
+		zap.L().Error("Failed to attach checkout payment intent", zap.Error(err),
+			zap.String("payment_id", paymentID),
+			zap.String("payment_intent_id", paymentIntentID))
+		return false, err
+	}
+
+	affected, _ := result.RowsAffected()
+	if affected > 0 {
+		zap.L().Info("Checkout session attached to payment intent",
+			zap.String("payment_id", paymentID),
+			zap.String("payment_intent_id", paymentIntentID))
+	}
+	return affected > 0, nil
+}
+
+// ReleaseIdempotencyKey 释放当前租户支付记录的幂等键（移到 released_idempotency_key），同一幂等键可以重新发起支付
+func ReleaseIdempotencyKey(ctx context.Context, paymentIntentID string) error {
+	query := `UPDATE payment_history 
+		SET released_idempotency_key = idempotency_key, idempotency_key = NULL, updated_at = CURRENT_TIMESTAMP 
+		WHERE tenant_id = ? AND payment_intent_id = ? AND idempotency_key IS NOT NULL AND idempotency_key <> ''`
+
+	result, err := DB.ExecContext(ctx, query, tenant.ID(ctx), paymentIntentID)
+	if err != nil {
+		zap.L().Error("Failed to release idempotency key", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
+		return err
+	}
+
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		zap.L().Info("Idempotency key released", zap.String("payment_intent_id", paymentIntentID))
+	}
 	return nil
 }
+
+// CountReleasedIdempotencyKey 统计某个幂等键被释放的次数
+func CountReleasedIdempotencyKey(ctx context.Context, idempotencyKey string) (int, error) {
+	var count int
+	err := DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM payment_history WHERE tenant_id = ? AND released_idempotency_key = ?`, tenant.ID(ctx), idempotencyKey).Scan(&count)
+	if err != nil {
+		zap.L().Error("Failed to count released idempotency key", zap.Error(err), zap.String("idempotency_key", idempotencyKey))
+		return 0, err
+	}
+	return count, nil
+}
+
+// scanPaymentHistoryRows 扫描 payment_history 查询结果（兼容 NULL 字段）
+func scanPaymentHistoryRows(rows *sql.Rows) ([]PaymentHistory, error) {
+	var payments []PaymentHistory
+	for rows.Next() {
+		var ph PaymentHistory
+		var idempotencyKey, description, metadata sql.NullString
+		err := rows.Scan(
+			&ph.ID,
+			&ph.PaymentIntentID,
+			&ph.PaymentID,
+			&idempotencyKey,
+			&ph.UserID,
+			&ph.Amount,
+			&ph.Currency,
+			&ph.Status,
+			&ph.PaymentMethod,
+			&ph.StripeAccount,
+			&ph.Livemode,
+			&ph.TestMode,
+			&ph.TenantID,
+			&description,
+			&metadata,
+			&ph.CreatedAt,
+			&ph.UpdatedAt,
+		)
+		if err != nil {
+			zap.L().Error("Failed to scan payment history", zap.Error(err))
+			continue
+		}
+		ph.IdempotencyKey = idempotencyKey.String
+		ph.Description = description.String
+		ph.Metadata = metadata.String
+		payments = append(payments, ph)
+	}
+
+	return payments, rows.Err()
+}
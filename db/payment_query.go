@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 分页参数
+const (
+	DefaultPaymentPageSize = 50
+	MaxPaymentPageSize     = 100
+)
+
+// PaymentCursor 支付记录游标（按 created_at, id 倒序翻页的位置）
+type PaymentCursor struct {
+	CreatedAt time.Time
+	ID        int64
+}
+
+// PaymentQuery 支付记录查询条件（用户支付历史和管理端搜索共用）
+type PaymentQuery struct {
+	TenantID      string // 为空时不按租户过滤（仅管理端）
+	UserID        string // 为空时不按用户过滤（仅管理端）
+	Statuses      []string
+	PaymentMethod string
+	Currency      string
+	CreatedFrom   *time.Time // 包含
+	CreatedTo     *time.Time // 不包含
+	Cursor        *PaymentCursor
+	Limit         int
+}
+
+// PaymentPage 一页支付记录
+type PaymentPage struct {
+	Payments   []PaymentHistory `json:"payments"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	HasMore    bool             `json:"has_more"`
+}
+
+// EncodePaymentCursor 将游标编码为不透明字符串
+func EncodePaymentCursor(cursor PaymentCursor) string {
+	raw := strconv.FormatInt(cursor.CreatedAt.UnixMicro(), 10) + ":" + strconv.FormatInt(cursor.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodePaymentCursor 解析游标字符串
+func DecodePaymentCursor(s string) (*PaymentCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	micros, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || id <= 0 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &PaymentCursor{CreatedAt: time.UnixMicro(micros).In(time.Local), ID: id}, nil
+}
+
+// ClampPaymentPageSize 规范化分页大小（默认 DefaultPaymentPageSize，上限 MaxPaymentPageSize）
+func ClampPaymentPageSize(limit int) int {
+	if limit <= 0 {
+		return DefaultPaymentPageSize
+	}
+	if limit > MaxPaymentPageSize {
+		return MaxPaymentPageSize
+	}
+	return limit
+}
+
+// buildPaymentQuery 构建查询 SQL（多取一条用于判断是否还有下一页）
+func buildPaymentQuery(q PaymentQuery) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if q.TenantID != "" {
+		conditions = append(conditions, "tenant_id = ?")
+		args = append(args, q.TenantID)
+	}
+	if q.UserID != "" {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, q.UserID)
+	}
+	if len(q.Statuses) > 0 {
+		conditions = append(conditions, "status IN ("+strings.TrimSuffix(strings.Repeat("?,", len(q.Statuses)), ",")+")")
+		for _, status := range q.Statuses {
+			args = append(args, status)
+		}
+	}
+	if q.PaymentMethod != "" {
+		conditions = append(conditions, "payment_method = ?")
+		args = append(args, q.PaymentMethod)
+	}
+	if q.Currency != "" {
+		conditions = append(conditions, "currency = ?")
+		args = append(args, q.Currency)
+	}
+	if q.CreatedFrom != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *q.CreatedFrom)
+	}
+	if q.CreatedTo != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, *q.CreatedTo)
+	}
+	if q.Cursor != nil {
+		conditions = append(conditions, "(created_at < ? OR (created_at = ? AND id < ?))")
+		args = append(args, q.Cursor.CreatedAt, q.Cursor.CreatedAt, q.Cursor.ID)
+	}
+
+	query := `SELECT id, payment_intent_id, payment_id, idempotency_key, user_id, amount, currency,
+		status, payment_method, stripe_account, livemode, test_mode, tenant_id, description, metadata, created_at, updated_at
+		FROM payment_history`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT ?"
+	args = append(args, ClampPaymentPageSize(q.Limit)+1)
+
+	return query, args
+}
+
+// SearchPayments 按条件分页查询支付记录（配置了从库时读取从库）
+func SearchPayments(ctx context.Context, q PaymentQuery) (*PaymentPage, error) {
+	query, args := buildPaymentQuery(q)
+
+	rows, err := reader(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		zap.L().Error("Failed to search payments", zap.Error(err), zap.String("user_id", q.UserID))
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments, err := scanPaymentHistoryRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &PaymentPage{Payments: payments}
+	limit := ClampPaymentPageSize(q.Limit)
+	if len(payments) > limit {
+		page.Payments = payments[:limit]
+		page.HasMore = true
+		last := page.Payments[limit-1]
+		page.NextCursor = EncodePaymentCursor(PaymentCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	if page.Payments == nil {
+		page.Payments = []PaymentHistory{}
+	}
+	return page, nil
+}
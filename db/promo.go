@@ -0,0 +1,383 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"stripe-pay/tenant"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 优惠码折扣类型
+const (
+	PromoDiscountPercent = "percent"
+	PromoDiscountAmount  = "amount"
+)
+
+// 优惠码使用记录状态
+const (
+	PromoRedemptionReserved = "reserved"
+	PromoRedemptionRedeemed = "redeemed"
+	PromoRedemptionReleased = "released"
+)
+
+// PromoCode 优惠码
+type PromoCode struct {
+	ID             int64      `json:"id"`
+	Code           string     `json:"code"`
+	DiscountType   string     `json:"discount_type"`
+	PercentOff     int64      `json:"percent_off,omitempty"`
+	AmountOff      int64      `json:"amount_off,omitempty"`
+	Currency       string     `json:"currency,omitempty"`
+	MaxRedemptions int        `json:"max_redemptions"`
+	PerUserLimit   int        `json:"per_user_limit"`
+	RedeemedCount  int        `json:"redeemed_count"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	Active         bool       `json:"active"`
+	Description    string     `json:"description,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// PromoRedemption 优惠码预留结果
+type PromoRedemption struct {
+	ID             int64
+	PromoCodeID    int64
+	Code           string
+	UserID         string
+	OriginalAmount int64
+	DiscountAmount int64
+	Currency       string
+}
+
+// PromoCodeUnavailableError 优惠码不可用（不存在、已过期、次数用完、币种不符等）
+type PromoCodeUnavailableError struct {
+	Code   string
+	Reason string
+}
+
+func (e *PromoCodeUnavailableError) Error() string {
+	return fmt.Sprintf("promo code %s is not applicable: %s", e.Code, e.Reason)
+}
+
+// NormalizePromoCode 规范化优惠码（去空格并转大写）
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CheckApplicable 检查优惠码是否可用于指定币种的支付（不含次数限制）
+func (p *PromoCode) CheckApplicable(currency string, now time.Time) error {
+	if !p.Active {
+		return &PromoCodeUnavailableError{Code: p.Code, Reason: "inactive"}
+	}
+	if p.ExpiresAt != nil && !now.Before(*p.ExpiresAt) {
+		return &PromoCodeUnavailableError{Code: p.Code, Reason: "expired"}
+	}
+	if p.Currency != "" && !strings.EqualFold(p.Currency, currency) {
+		return &PromoCodeUnavailableError{Code: p.Code, Reason: "not valid for currency " + strings.ToLower(currency)}
+	}
+	return nil
+}
+
+// Discount 计算折扣金额（不超过原价）
+func (p *PromoCode) Discount(amount int64) int64 {
+	var discount int64
+	switch p.DiscountType {
+	case PromoDiscountPercent:
+		discount = amount * p.PercentOff / 100
+	case PromoDiscountAmount:
+		discount = p.AmountOff
+	}
+	if discount > amount {
+		discount = amount
+	}
+	if discount < 0 {
+		discount = 0
+	}
+	return discount
+}
+
+const promoCodeColumns = `id, code, discount_type, percent_off, amount_off, currency, max_redemptions, per_user_limit,
+	redeemed_count, expires_at, active, description, created_at, updated_at`
+
+// scanPromoCode 扫描一行优惠码
+func scanPromoCode(scanner interface{ Scan(...interface{}) error }) (*PromoCode, error) {
+	var p PromoCode
+	var currency, description sql.NullString
+	var expiresAt sql.NullTime
+	err := scanner.Scan(&p.ID, &p.Code, &p.DiscountType, &p.PercentOff, &p.AmountOff, &currency,
+		&p.MaxRedemptions, &p.PerUserLimit, &p.RedeemedCount, &expiresAt, &p.Active, &description,
+		&p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.Currency = currency.String
+	p.Description = description.String
+	if expiresAt.Valid {
+		p.ExpiresAt = &expiresAt.Time
+	}
+	return &p, nil
+}
+
+// CreatePromoCode 为当前租户创建优惠码（不同租户可以使用相同的优惠码）
+func CreatePromoCode(ctx context.Context, p *PromoCode) error {
+	query := `INSERT INTO promo_codes
+		(tenant_id, code, discount_type, percent_off, amount_off, currency, max_redemptions, per_user_limit, expires_at, active, description)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	var currency, description, expiresAt interface{}
+	if p.Currency != "" {
+		currency = p.Currency
+	}
+	if p.Description != "" {
+		description = p.Description
+	}
+	if p.ExpiresAt != nil {
+		expiresAt = *p.ExpiresAt
+	}
+
+	result, err := DB.ExecContext(ctx, query, tenant.ID(ctx), p.Code, p.DiscountType, p.PercentOff, p.AmountOff, currency,
+		p.MaxRedemptions, p.PerUserLimit, expiresAt, p.Active, description)
+	if err != nil {
+		zap.L().Error("Failed to create promo code", zap.Error(err), zap.String("code", p.Code))
+		return err
+	}
+	p.ID, _ = result.LastInsertId()
+
+	zap.L().Info("Promo code created", zap.String("code", p.Code), zap.String("discount_type", p.DiscountType))
+	return nil
+}
+
+// GetPromoCode 按优惠码查询当前租户的优惠码（不存在时返回 nil）
+func GetPromoCode(ctx context.Context, code string) (*PromoCode, error) {
+	row := DB.QueryRowContext(ctx, `SELECT `+promoCodeColumns+` FROM promo_codes WHERE tenant_id = ? AND code = ?`, tenant.ID(ctx), code)
+	p, err := scanPromoCode(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		zap.L().Error("Failed to get promo code", zap.Error(err), zap.String("code", code))
+		return nil, err
+	}
+	return p, nil
+}
+
+// ListPromoCodes 查询当前租户的优惠码列表（按创建时间倒序）
+func ListPromoCodes(ctx context.Context, limit int) ([]PromoCode, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := DB.QueryContext(ctx, `SELECT `+promoCodeColumns+` FROM promo_codes WHERE tenant_id = ? ORDER BY created_at DESC, id DESC LIMIT ?`, tenant.ID(ctx), limit)
+	if err != nil {
+		zap.L().Error("Failed to list promo codes", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []PromoCode
+	for rows.Next() {
+		p, err := scanPromoCode(rows)
+		if err != nil {
+			zap.L().Error("Failed to scan promo code", zap.Error(err))
+			continue
+		}
+		codes = append(codes, *p)
+	}
+	return codes, rows.Err()
+}
+
+// SetPromoCodeActive 启用或停用当前租户的优惠码，返回是否找到
+func SetPromoCodeActive(ctx context.Context, code string, active bool) (bool, error) {
+	result, err := DB.ExecContext(ctx, `UPDATE promo_codes SET active = ?, updated_at = CURRENT_TIMESTAMP WHERE tenant_id = ? AND code = ?`,
+		active, tenant.ID(ctx), code)
+	if err != nil {
+		zap.L().Error("Failed to update promo code", zap.Error(err), zap.String("code", code))
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// ReservePromoCode 在事务内校验并预留一次优惠码使用（锁定优惠码行，保证总次数和每用户次数不超限）
+func ReservePromoCode(ctx context.Context, code, userID string, amount int64, currency string) (*PromoRedemption, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	p, err := scanPromoCode(tx.QueryRowContext(ctx, `SELECT `+promoCodeColumns+` FROM promo_codes WHERE tenant_id = ? AND code = ? FOR UPDATE`, tenant.ID(ctx), code))
+	if err == sql.ErrNoRows {
+		return nil, &PromoCodeUnavailableError{Code: code, Reason: "not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.CheckApplicable(currency, time.Now()); err != nil {
+		return nil, err
+	}
+	if p.MaxRedemptions > 0 && p.RedeemedCount >= p.MaxRedemptions {
+		return nil, &PromoCodeUnavailableError{Code: code, Reason: "redemption limit reached"}
+	}
+	if p.PerUserLimit > 0 {
+		var used int
+		err := tx.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM promo_redemptions WHERE promo_code_id = ? AND user_id = ? AND status IN (?, ?)`,
+			p.ID, userID, PromoRedemptionReserved, PromoRedemptionRedeemed).Scan(&used)
+		if err != nil {
+			return nil, err
+		}
+		if used >= p.PerUserLimit {
+			return nil, &PromoCodeUnavailableError{Code: code, Reason: "per-user limit reached"}
+		}
+	}
+
+	redemption := &PromoRedemption{
+		PromoCodeID:    p.ID,
+		Code:           p.Code,
+		UserID:         userID,
+		OriginalAmount: amount,
+		DiscountAmount: p.Discount(amount),
+		Currency:       strings.ToLower(currency),
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO promo_redemptions (promo_code_id, user_id, status, original_amount, discount_amount, currency)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		p.ID, userID, PromoRedemptionReserved, redemption.OriginalAmount, redemption.DiscountAmount, redemption.Currency)
+	if err != nil {
+		return nil, err
+	}
+	redemption.ID, _ = result.LastInsertId()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE promo_codes SET redeemed_count = redeemed_count + 1 WHERE id = ?`, p.ID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	zap.L().Info("Promo code reserved",
+		zap.String("code", code),
+		zap.String("user_id", userID),
+		zap.Int64("discount_amount", redemption.DiscountAmount))
+	return redemption, nil
+}
+
+// ErrPromoRedemptionAttached PaymentIntent 已关联了其他预留记录（同一幂等请求并发创建）
+var ErrPromoRedemptionAttached = errors.New("payment intent already has a promo redemption")
+
+// AttachPromoRedemption 将预留记录关联到创建成功的 PaymentIntent
+func AttachPromoRedemption(ctx context.Context, redemptionID int64, paymentIntentID string) error {
+	_, err := DB.ExecContext(ctx,
+		`UPDATE promo_redemptions SET payment_intent_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		paymentIntentID, redemptionID, PromoRedemptionReserved)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return ErrPromoRedemptionAttached
+		}
+		zap.L().Error("Failed to attach promo redemption", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
+		return err
+	}
+	return nil
+}
+
+// ReleasePromoRedemption 释放预留记录（PaymentIntent 创建失败时使用）
+func ReleasePromoRedemption(ctx context.Context, redemptionID int64) error {
+	return releasePromoRedemptions(ctx, `id = ?`, redemptionID)
+}
+
+// ReleasePromoRedemptionByIntent 释放 PaymentIntent 关联的预留记录（支付取消时使用）
+func ReleasePromoRedemptionByIntent(ctx context.Context, paymentIntentID string) error {
+	return releasePromoRedemptions(ctx, `payment_intent_id = ?`, paymentIntentID)
+}
+
+// ReleaseOrphanPromoRedemptions 释放早于 reservedBefore 且没有关联 PaymentIntent 的预留记录
+// （预留后创建 PaymentIntent 前进程退出等），每次最多 limit 条，返回释放的数量
+func ReleaseOrphanPromoRedemptions(ctx context.Context, reservedBefore time.Time, limit int) (int, error) {
+	rows, err := DB.QueryContext(ctx,
+		`SELECT id FROM promo_redemptions WHERE status = ? AND created_at < ? AND payment_intent_id IS NULL ORDER BY id LIMIT ?`,
+		PromoRedemptionReserved, reservedBefore, limit)
+	if err != nil {
+		zap.L().Error("Failed to query orphan promo redemptions", zap.Error(err))
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, id := range ids {
+		if err := ReleasePromoRedemption(ctx, id); err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// releasePromoRedemptions 在事务内把预留记录标记为已释放并归还优惠码次数（重复释放无副作用）
+func releasePromoRedemptions(ctx context.Context, where string, arg interface{}) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id, promoCodeID int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, promo_code_id FROM promo_redemptions WHERE `+where+` AND status = ? FOR UPDATE`,
+		arg, PromoRedemptionReserved).Scan(&id, &promoCodeID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE promo_redemptions SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		PromoRedemptionReleased, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE promo_codes SET redeemed_count = redeemed_count - 1 WHERE id = ? AND redeemed_count > 0`,
+		promoCodeID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	zap.L().Info("Promo redemption released", zap.Int64("redemption_id", id), zap.Int64("promo_code_id", promoCodeID))
+	return nil
+}
+
+// MarkPromoRedeemed 支付成功后将预留记录标记为已使用
+func MarkPromoRedeemed(ctx context.Context, paymentIntentID string) error {
+	_, err := DB.ExecContext(ctx,
+		`UPDATE promo_redemptions SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE payment_intent_id = ? AND status = ?`,
+		PromoRedemptionRedeemed, paymentIntentID, PromoRedemptionReserved)
+	if err != nil {
+		zap.L().Error("Failed to mark promo redemption as redeemed", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
+	}
+	return err
+}
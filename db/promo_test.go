@@ -0,0 +1,60 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPromoCodeDiscount 测试优惠码折扣计算
+func TestPromoCodeDiscount(t *testing.T) {
+	tests := []struct {
+		name   string
+		promo  PromoCode
+		amount int64
+		want   int64
+	}{
+		{"百分比折扣", PromoCode{DiscountType: PromoDiscountPercent, PercentOff: 20}, 5900, 1180},
+		{"百分比折扣向下取整", PromoCode{DiscountType: PromoDiscountPercent, PercentOff: 15}, 999, 149},
+		{"固定金额折扣", PromoCode{DiscountType: PromoDiscountAmount, AmountOff: 1000}, 5900, 1000},
+		{"固定金额超过原价", PromoCode{DiscountType: PromoDiscountAmount, AmountOff: 8000}, 5900, 5900},
+		{"未知类型", PromoCode{DiscountType: "other", AmountOff: 1000}, 5900, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.promo.Discount(tt.amount); got != tt.want {
+				t.Errorf("Discount(%d) = %d, want %d", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPromoCodeCheckApplicable 测试优惠码启用状态、有效期和币种限制
+func TestPromoCodeCheckApplicable(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		promo    PromoCode
+		currency string
+		wantErr  bool
+	}{
+		{"可用", PromoCode{Code: "SPRING", Active: true, ExpiresAt: &future}, "hkd", false},
+		{"不限币种", PromoCode{Code: "SPRING", Active: true}, "usd", false},
+		{"币种匹配忽略大小写", PromoCode{Code: "SPRING", Active: true, Currency: "hkd"}, "HKD", false},
+		{"已停用", PromoCode{Code: "SPRING", Active: false}, "hkd", true},
+		{"已过期", PromoCode{Code: "SPRING", Active: true, ExpiresAt: &past}, "hkd", true},
+		{"币种不符", PromoCode{Code: "SPRING", Active: true, Currency: "usd"}, "hkd", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.promo.CheckApplicable(tt.currency, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckApplicable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
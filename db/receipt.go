@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"stripe-pay/tenant"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultReceiptNumberPrefix 收据编号默认前缀
+const DefaultReceiptNumberPrefix = "R-"
+
+// PaymentReceipt 支付收据（金额和税额在开具时确定，之后不随配置变化）
+type PaymentReceipt struct {
+	ID              int64     `json:"id"`
+	ReceiptNumber   string    `json:"receipt_number"`
+	PaymentIntentID string    `json:"payment_intent_id"`
+	PaymentID       string    `json:"payment_id"`
+	UserID          string    `json:"user_id"`
+	Amount          int64     `json:"amount"`
+	Currency        string    `json:"currency"`
+	TaxRateBps      int       `json:"tax_rate_bps"`
+	TaxAmount       int64     `json:"tax_amount"`
+	IssuedAt        time.Time `json:"issued_at"`
+}
+
+// FormatReceiptNumber 生成收据编号：前缀 + 8 位序号，如 R-00000042
+func FormatReceiptNumber(prefix string, seq int64) string {
+	if prefix == "" {
+		prefix = DefaultReceiptNumberPrefix
+	}
+	return fmt.Sprintf("%s%08d", prefix, seq)
+}
+
+// InclusiveTax 从含税金额中计算税额（税率为万分比，四舍五入到最小货币单位）
+func InclusiveTax(amount int64, taxRateBps int) int64 {
+	if amount <= 0 || taxRateBps <= 0 {
+		return 0
+	}
+	divisor := int64(10000 + taxRateBps)
+	return (amount*int64(taxRateBps) + divisor/2) / divisor
+}
+
+// IssueReceipt 为当前租户的支付开具收据（按 payment_intent_id 幂等，已开具时返回原收据）
+// 收据编号按租户连续分配：序号取自 receipt_sequences 中该租户的计数器，与收据在同一事务中写入，不会跳号
+func IssueReceipt(ctx context.Context, r *PaymentReceipt, numberPrefix string) (*PaymentReceipt, error) {
+	existing, err := GetReceiptByIntentID(ctx, r.PaymentIntentID)
+	if err != nil || existing != nil {
+		return existing, err
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `INSERT INTO payment_receipts
+		(tenant_id, payment_intent_id, payment_id, user_id, amount, currency, tax_rate_bps, tax_amount)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		tenant.ID(ctx), r.PaymentIntentID, r.PaymentID, r.UserID, r.Amount, strings.ToLower(r.Currency), r.TaxRateBps, r.TaxAmount)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			// 并发开具：以先写入的为准
+			tx.Rollback()
+			return GetReceiptByIntentID(ctx, r.PaymentIntentID)
+		}
+		zap.L().Error("Failed to issue receipt", zap.Error(err), zap.String("payment_intent_id", r.PaymentIntentID))
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	seq, err := nextReceiptSequence(ctx, tx)
+	if err != nil {
+		zap.L().Error("Failed to allocate receipt number", zap.Error(err), zap.Int64("receipt_id", id))
+		return nil, err
+	}
+	number := FormatReceiptNumber(numberPrefix, seq)
+	if _, err := tx.ExecContext(ctx, `UPDATE payment_receipts SET receipt_number = ? WHERE id = ?`, number, id); err != nil {
+		zap.L().Error("Failed to assign receipt number", zap.Error(err), zap.Int64("receipt_id", id))
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	zap.L().Info("Receipt issued",
+		zap.String("receipt_number", number),
+		zap.String("tenant_id", tenant.ID(ctx)),
+		zap.String("payment_intent_id", r.PaymentIntentID))
+	return GetReceiptByIntentID(ctx, r.PaymentIntentID)
+}
+
+// nextReceiptSequence 在事务中分配当前租户的下一个收据序号（锁定计数器行，同一租户的开具串行执行）
+func nextReceiptSequence(ctx context.Context, tx *sql.Tx) (int64, error) {
+	tenantID := tenant.ID(ctx)
+	if _, err := tx.ExecContext(ctx,
+		`INSERT IGNORE INTO receipt_sequences (tenant_id, last_number) VALUES (?, 0)`, tenantID); err != nil {
+		return 0, err
+	}
+
+	var last int64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT last_number FROM receipt_sequences WHERE tenant_id = ? FOR UPDATE`, tenantID).Scan(&last); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE receipt_sequences SET last_number = ? WHERE tenant_id = ?`, last+1, tenantID); err != nil {
+		return 0, err
+	}
+	return last + 1, nil
+}
+
+// GetReceiptByIntentID 查询当前租户支付的收据（不存在时返回 nil）
+func GetReceiptByIntentID(ctx context.Context, paymentIntentID string) (*PaymentReceipt, error) {
+	query := `SELECT id, receipt_number, payment_intent_id, payment_id, user_id, amount, currency, tax_rate_bps, tax_amount, issued_at
+		FROM payment_receipts WHERE tenant_id = ? AND payment_intent_id = ?`
+
+	var r PaymentReceipt
+	var number sql.NullString
+	err := DB.QueryRowContext(ctx, query, tenant.ID(ctx), paymentIntentID).Scan(&r.ID, &number, &r.PaymentIntentID, &r.PaymentID,
+		&r.UserID, &r.Amount, &r.Currency, &r.TaxRateBps, &r.TaxAmount, &r.IssuedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		zap.L().Error("Failed to get receipt", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
+		return nil, err
+	}
+	r.ReceiptNumber = number.String
+	return &r, nil
+}
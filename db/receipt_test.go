@@ -0,0 +1,48 @@
+package db
+
+import "testing"
+
+// TestFormatReceiptNumber 测试收据编号格式
+func TestFormatReceiptNumber(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		seq    int64
+		want   string
+	}{
+		{"默认前缀", "", 42, "R-00000042"},
+		{"自定义前缀", "HK-2024-", 7, "HK-2024-00000007"},
+		{"超过 8 位不截断", "R-", 123456789, "R-123456789"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatReceiptNumber(tt.prefix, tt.seq); got != tt.want {
+				t.Errorf("FormatReceiptNumber() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestInclusiveTax 测试含税金额的税额计算
+func TestInclusiveTax(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount int64
+		bps    int
+		want   int64
+	}{
+		{"无税率", 5900, 0, 0},
+		{"13% 增值税", 11300, 1300, 1300},
+		{"四舍五入", 5900, 1300, 679},
+		{"零金额", 0, 1300, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := InclusiveTax(tt.amount, tt.bps); got != tt.want {
+				t.Errorf("InclusiveTax(%d, %d) = %d, want %d", tt.amount, tt.bps, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"stripe-pay/tenant"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ReconciliationRun 对账任务运行记录
+type ReconciliationRun struct {
+	ID              int64      `json:"id"`
+	TriggerSource   string     `json:"trigger_source"` // scheduler, admin
+	DryRun          bool       `json:"dry_run"`
+	ScannedCount    int        `json:"scanned_count"`
+	EventsScanned   int        `json:"events_scanned"`
+	MismatchCount   int        `json:"mismatch_count"`
+	CorrectedCount  int        `json:"corrected_count"`
+	ErrorCount      int        `json:"error_count"`
+	EventCheckpoint int64      `json:"event_checkpoint"` // Unix 秒
+	Details         string     `json:"details"`          // JSON 字符串
+	StartedAt       time.Time  `json:"started_at"`
+	FinishedAt      *time.Time `json:"finished_at"`
+}
+
+// ListStalePayments 查询当前租户需要对账的非终态支付记录
+// 只返回 updated_at 早于 updatedBefore 且 created_at 晚于 createdAfter 的记录，
+// 从 after 之后按 (created_at, id) 升序返回一页（after 为 nil 时从头开始），跳过退避中的失败记录
+func ListStalePayments(ctx context.Context, statuses []string, updatedBefore, createdAfter time.Time, after *PaymentCursor, limit int) ([]PaymentHistory, error) {
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(statuses)), ",")
+	args := make([]interface{}, 0, len(statuses)+3)
+	args = append(args, tenant.ID(ctx))
+	for _, status := range statuses {
+		args = append(args, status)
+	}
+	args = append(args, updatedBefore, createdAfter)
+
+	query, args := sweepQuery(SweepJobReconcile,
+		`p.tenant_id = ? AND p.status IN (`+placeholders+`) AND p.updated_at < ? AND p.created_at > ?`, args, after, limit)
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		zap.L().Error("Failed to query stale payments", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPaymentHistoryRows(rows)
+}
+
+// SaveReconciliationRun 保存当前租户的对账任务运行记录
+func SaveReconciliationRun(ctx context.Context, run *ReconciliationRun) error {
+	query := `INSERT INTO reconciliation_runs 
+		(tenant_id, trigger_source, dry_run, scanned_count, events_scanned, mismatch_count, corrected_count, error_count,
+		 event_checkpoint, details, started_at, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	var details interface{}
+	if run.Details != "" {
+		details = run.Details
+	}
+
+	result, err := DB.ExecContext(ctx, query,
+		tenant.ID(ctx),
+		run.TriggerSource,
+		run.DryRun,
+		run.ScannedCount,
+		run.EventsScanned,
+		run.MismatchCount,
+		run.CorrectedCount,
+		run.ErrorCount,
+		run.EventCheckpoint,
+		details,
+		run.StartedAt,
+		run.FinishedAt,
+	)
+	if err != nil {
+		zap.L().Error("Failed to save reconciliation run", zap.Error(err))
+		return err
+	}
+
+	run.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetLastEventCheckpoint 获取当前租户最近一次非 dry-run 对账记录的事件检查点（没有记录时返回 0）
+func GetLastEventCheckpoint(ctx context.Context) (int64, error) {
+	var checkpoint int64
+	err := DB.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(event_checkpoint), 0) FROM reconciliation_runs WHERE tenant_id = ? AND dry_run = FALSE`,
+		tenant.ID(ctx)).Scan(&checkpoint)
+	if err != nil {
+		zap.L().Error("Failed to get reconciliation checkpoint", zap.Error(err))
+		return 0, err
+	}
+	return checkpoint, nil
+}
+
+// ListReconciliationRuns 查询当前租户最近的对账任务运行记录（配置了从库时读取从库）
+func ListReconciliationRuns(ctx context.Context, limit int) ([]ReconciliationRun, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+
+	query := `SELECT id, trigger_source, dry_run, scanned_count, events_scanned, mismatch_count, corrected_count,
+		error_count, event_checkpoint, details, started_at, finished_at
+		FROM reconciliation_runs 
+		WHERE tenant_id = ? 
+		ORDER BY id DESC 
+		LIMIT ?`
+
+	rows, err := reader(ctx).QueryContext(ctx, query, tenant.ID(ctx), limit)
+	if err != nil {
+		zap.L().Error("Failed to query reconciliation runs", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []ReconciliationRun
+	for rows.Next() {
+		var run ReconciliationRun
+		var details sql.NullString
+		var finishedAt sql.NullTime
+		err := rows.Scan(
+			&run.ID,
+			&run.TriggerSource,
+			&run.DryRun,
+			&run.ScannedCount,
+			&run.EventsScanned,
+			&run.MismatchCount,
+			&run.CorrectedCount,
+			&run.ErrorCount,
+			&run.EventCheckpoint,
+			&details,
+			&run.StartedAt,
+			&finishedAt,
+		)
+		if err != nil {
+			zap.L().Error("Failed to scan reconciliation run", zap.Error(err))
+			continue
+		}
+		run.Details = details.String
+		if finishedAt.Valid {
+			run.FinishedAt = &finishedAt.Time
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// TouchPaymentHistory 刷新当前租户支付记录的 updated_at（对账确认状态一致时调用，避免下次重复扫描同一批记录）
+func TouchPaymentHistory(ctx context.Context, paymentIntentID string) error {
+	_, err := DB.ExecContext(ctx,
+		`UPDATE payment_history SET updated_at = CURRENT_TIMESTAMP WHERE tenant_id = ? AND payment_intent_id = ?`,
+		tenant.ID(ctx), paymentIntentID)
+	if err != nil {
+		zap.L().Warn("Failed to touch payment history", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
+	}
+	return err
+}
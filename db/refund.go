@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"stripe-pay/tenant"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PaymentRefund 退款记录
+type PaymentRefund struct {
+	ID              int64     `json:"id"`
+	RefundID        string    `json:"refund_id"`
+	PaymentIntentID string    `json:"payment_intent_id"`
+	Amount          int64     `json:"amount"`
+	Currency        string    `json:"currency"`
+	Status          string    `json:"status"`
+	Reason          string    `json:"reason"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// SaveRefund 保存当前租户的退款记录（按 refund_id 幂等，已存在时更新状态）
+func SaveRefund(ctx context.Context, r *PaymentRefund) error {
+	query := `INSERT INTO payment_refunds 
+		(tenant_id, refund_id, payment_intent_id, amount, currency, status, reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			amount = VALUES(amount),
+			status = VALUES(status),
+			updated_at = CURRENT_TIMESTAMP`
+
+	var reason interface{}
+	if r.Reason != "" {
+		reason = r.Reason
+	}
+
+	_, err := DB.ExecContext(ctx, query, tenant.ID(ctx), r.RefundID, r.PaymentIntentID, r.Amount, r.Currency, r.Status, reason)
+	if err != nil {
+		zap.L().Error("Failed to save refund", zap.Error(err), zap.String("refund_id", r.RefundID))
+		return err
+	}
+
+	zap.L().Info("Refund saved",
+		zap.String("refund_id", r.RefundID),
+		zap.String("payment_intent_id", r.PaymentIntentID),
+		zap.String("status", r.Status))
+	return nil
+}
+
+// GetRefundsByIntentID 查询当前租户支付的所有退款记录
+func GetRefundsByIntentID(ctx context.Context, paymentIntentID string) ([]PaymentRefund, error) {
+	query := `SELECT id, refund_id, payment_intent_id, amount, currency, status, reason, created_at, updated_at
+		FROM payment_refunds 
+		WHERE tenant_id = ? AND payment_intent_id = ? 
+		ORDER BY created_at ASC`
+
+	rows, err := DB.QueryContext(ctx, query, tenant.ID(ctx), paymentIntentID)
+	if err != nil {
+		zap.L().Error("Failed to query refunds", zap.Error(err), zap.String("payment_intent_id", paymentIntentID))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refunds []PaymentRefund
+	for rows.Next() {
+		var r PaymentRefund
+		var reason sql.NullString
+		if err := rows.Scan(&r.ID, &r.RefundID, &r.PaymentIntentID, &r.Amount, &r.Currency, &r.Status, &reason, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			zap.L().Error("Failed to scan refund", zap.Error(err))
+			continue
+		}
+		r.Reason = reason.String
+		refunds = append(refunds, r)
+	}
+
+	return refunds, rows.Err()
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"stripe-pay/conf"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"go.uber.org/zap"
+)
+
+// 默认从库健康检查间隔
+const defaultReplicaHealthInterval = 5 * time.Second
+
+// replica 只读从库连接
+type replica struct {
+	addr    string // host:port（日志和状态展示用，不包含密码）
+	db      *sql.DB
+	healthy atomic.Bool
+	checked atomic.Bool // 是否已完成首次检查
+}
+
+var (
+	replicas       []*replica
+	replicaNext    atomic.Uint64
+	replicaStop    chan struct{}
+	replicaStopped sync.Once
+)
+
+// primaryKey 上下文标记：读取固定到主库
+type primaryKey struct{}
+
+// WithPrimary 返回读取固定到主库的上下文（幂等检查、写入后立即读取等需要强一致的场景）
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// isPinnedToPrimary 上下文是否要求读取主库
+func isPinnedToPrimary(ctx context.Context) bool {
+	pinned, _ := ctx.Value(primaryKey{}).(bool)
+	return pinned
+}
+
+// reader 返回只读查询使用的连接：按轮询选择健康的从库；上下文固定主库或没有健康从库时返回主库
+func reader(ctx context.Context) *sql.DB {
+	if len(replicas) == 0 || isPinnedToPrimary(ctx) {
+		return DB
+	}
+	if r := pickReplica(replicas, replicaNext.Add(1)); r != nil {
+		return r.db
+	}
+	return DB
+}
+
+// pickReplica 从第 n 个开始轮询，返回第一个健康的从库
+func pickReplica(list []*replica, n uint64) *replica {
+	for i := 0; i < len(list); i++ {
+		r := list[(n+uint64(i))%uint64(len(list))]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// replicaDSN 规范化从库 DSN：与主库保持一致的时间解析和时区设置
+func replicaDSN(dsn, charset string) (string, string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid replica DSN: %w", err)
+	}
+	cfg.ParseTime = true
+	cfg.Loc = time.Local
+	if charset != "" {
+		if cfg.Params == nil {
+			cfg.Params = make(map[string]string)
+		}
+		if _, ok := cfg.Params["charset"]; !ok {
+			cfg.Params["charset"] = charset
+		}
+	}
+	return cfg.FormatDSN(), cfg.Addr, nil
+}
+
+// openReplicas 打开配置的从库并启动健康检查；连接失败的从库标记为不健康，恢复后自动加入轮询
+func openReplicas(cfg *conf.Config) error {
+	if len(cfg.Database.ReplicaDSNs) == 0 {
+		return nil
+	}
+
+	list := make([]*replica, 0, len(cfg.Database.ReplicaDSNs))
+	for _, raw := range cfg.Database.ReplicaDSNs {
+		dsn, addr, err := replicaDSN(raw, cfg.Database.Charset)
+		if err != nil {
+			closeReplicaList(list)
+			return err
+		}
+		conn, err := sql.Open("mysql", dsn)
+		if err != nil {
+			closeReplicaList(list)
+			return fmt.Errorf("failed to open replica %s: %w", addr, err)
+		}
+		conn.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+		conn.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+		conn.SetConnMaxLifetime(time.Duration(cfg.Database.ConnMaxLifetime) * time.Second)
+		list = append(list, &replica{addr: addr, db: conn})
+	}
+
+	checkReplicas(list)
+	replicas = list
+	replicaStop = make(chan struct{})
+
+	interval := time.Duration(cfg.Database.ReplicaHealthInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultReplicaHealthInterval
+	}
+	go replicaHealthLoop(list, interval, replicaStop)
+
+	zap.L().Info("Database replicas configured", zap.Int("count", len(list)), zap.Duration("health_interval", interval))
+	return nil
+}
+
+// replicaHealthLoop 定期检查从库健康状态
+func replicaHealthLoop(list []*replica, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			checkReplicas(list)
+		}
+	}
+}
+
+// checkReplicas Ping 每个从库并更新健康状态，状态变化时记录日志
+func checkReplicas(list []*replica) {
+	for _, r := range list {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err := r.db.PingContext(ctx)
+		cancel()
+
+		healthy := err == nil
+		firstCheck := !r.checked.Swap(true)
+		if r.healthy.Swap(healthy) != healthy || firstCheck {
+			if healthy {
+				zap.L().Info("Database replica is healthy", zap.String("replica", r.addr))
+			} else {
+				zap.L().Warn("Database replica is unhealthy, routing reads elsewhere", zap.String("replica", r.addr), zap.Error(err))
+			}
+		}
+	}
+}
+
+// ReplicaStatus 从库状态
+type ReplicaStatus struct {
+	Addr    string      `json:"addr"`
+	Healthy bool        `json:"healthy"`
+	Stats   sql.DBStats `json:"stats"`
+}
+
+// ReplicaStatuses 所有从库的健康状态和连接池统计
+func ReplicaStatuses() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, 0, len(replicas))
+	for _, r := range replicas {
+		statuses = append(statuses, ReplicaStatus{Addr: r.addr, Healthy: r.healthy.Load(), Stats: r.db.Stats()})
+	}
+	return statuses
+}
+
+// closeReplicas 停止健康检查并关闭从库连接（可重复调用）
+func closeReplicas() {
+	replicaStopped.Do(func() {
+		if replicaStop != nil {
+			close(replicaStop)
+		}
+		closeReplicaList(replicas)
+	})
+}
+
+func closeReplicaList(list []*replica) {
+	for _, r := range list {
+		r.db.Close()
+	}
+}
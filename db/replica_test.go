@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestPickReplica 测试轮询跳过不健康的从库
+func TestPickReplica(t *testing.T) {
+	list := []*replica{{addr: "r0"}, {addr: "r1"}, {addr: "r2"}}
+	list[0].healthy.Store(true)
+	list[2].healthy.Store(true)
+
+	tests := []struct {
+		name string
+		n    uint64
+		want string
+	}{
+		{"轮询到健康从库", 0, "r0"},
+		{"跳过不健康从库", 1, "r2"},
+		{"回绕到第一个", 3, "r0"},
+		{"从第三个开始", 5, "r2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pickReplica(list, tt.n); got == nil || got.addr != tt.want {
+				t.Errorf("pickReplica(%d) = %v, want %s", tt.n, got, tt.want)
+			}
+		})
+	}
+
+	list[0].healthy.Store(false)
+	list[2].healthy.Store(false)
+	if got := pickReplica(list, 0); got != nil {
+		t.Errorf("pickReplica() with no healthy replica = %s, want nil", got.addr)
+	}
+}
+
+// TestReplicaDSN 测试从库 DSN 规范化
+func TestReplicaDSN(t *testing.T) {
+	dsn, addr, err := replicaDSN("reader:secret@tcp(10.0.0.2:3306)/pay_api", "utf8mb4")
+	if err != nil {
+		t.Fatalf("replicaDSN() error = %v", err)
+	}
+	if addr != "10.0.0.2:3306" {
+		t.Errorf("addr = %s, want 10.0.0.2:3306", addr)
+	}
+	for _, want := range []string{"parseTime=true", "charset=utf8mb4", "loc=Local"} {
+		if !strings.Contains(dsn, want) {
+			t.Errorf("dsn = %s, want it to contain %s", dsn, want)
+		}
+	}
+
+	if _, _, err := replicaDSN("not a dsn", ""); err == nil {
+		t.Error("replicaDSN() with invalid DSN error = nil, want error")
+	}
+}
+
+// TestWithPrimary 测试上下文固定主库
+func TestWithPrimary(t *testing.T) {
+	if isPinnedToPrimary(context.Background()) {
+		t.Error("isPinnedToPrimary(Background) = true, want false")
+	}
+	if !isPinnedToPrimary(WithPrimary(context.Background())) {
+		t.Error("isPinnedToPrimary(WithPrimary) = false, want true")
+	}
+}
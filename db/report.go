@@ -0,0 +1,241 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"stripe-pay/tenant"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 报表分组维度
+const (
+	ReportDimDay      = "day"
+	ReportDimCurrency = "currency"
+	ReportDimMethod   = "method"
+)
+
+// reportDimColumns 分组维度对应的列（payment_history 实时查询）
+var reportDimColumns = map[string]string{
+	ReportDimDay:      "DATE(p.created_at)",
+	ReportDimCurrency: "p.currency",
+	ReportDimMethod:   "p.payment_method",
+}
+
+// rollupDimColumns 分组维度对应的列（payment_daily_stats 汇总表）
+var rollupDimColumns = map[string]string{
+	ReportDimDay:      "day",
+	ReportDimCurrency: "currency",
+	ReportDimMethod:   "payment_method",
+}
+
+// IsValidReportDim 判断分组维度是否合法
+func IsValidReportDim(dim string) bool {
+	_, ok := reportDimColumns[dim]
+	return ok
+}
+
+// ReportFilter 报表查询条件
+type ReportFilter struct {
+	Start         time.Time // 包含
+	End           time.Time // 不包含
+	GroupBy       []string  // day, currency, method
+	Currency      string
+	PaymentMethod string
+}
+
+// ReportRow 报表一行（按分组维度聚合的支付指标）
+type ReportRow struct {
+	Day              string `json:"day,omitempty"`
+	Currency         string `json:"currency,omitempty"`
+	PaymentMethod    string `json:"payment_method,omitempty"`
+	Attempts         int64  `json:"attempts"`
+	SucceededCount   int64  `json:"succeeded_count"`
+	SucceededAmount  int64  `json:"succeeded_amount"`
+	CanceledCount    int64  `json:"canceled_count"`
+	RefundedPayments int64  `json:"refunded_payments"`
+	RefundAmount     int64  `json:"refund_amount"`
+	NewPayers        int64  `json:"new_payers"`
+	ReturningPayers  int64  `json:"returning_payers"`
+}
+
+// reportAggregates 实时聚合的指标列
+// 退款按原支付的日期/币种/支付方式归属；新用户指该笔成功支付是用户的首次成功支付
+const reportAggregates = `COUNT(*),
+		COALESCE(SUM(p.status = 'succeeded'), 0),
+		COALESCE(SUM(CASE WHEN p.status = 'succeeded' THEN p.amount ELSE 0 END), 0),
+		COALESCE(SUM(p.status = 'canceled'), 0),
+		COUNT(DISTINCT r.payment_intent_id),
+		COALESCE(SUM(r.refund_amount), 0),
+		COUNT(DISTINCT CASE WHEN p.status = 'succeeded' AND p.created_at = f.first_at THEN CONCAT(p.tenant_id, ':', p.user_id) END),
+		COUNT(DISTINCT CASE WHEN p.status = 'succeeded' AND p.created_at > f.first_at THEN CONCAT(p.tenant_id, ':', p.user_id) END)`
+
+// reportJoins 实时聚合需要的关联子查询
+const reportJoins = `FROM payment_history p
+		LEFT JOIN (
+			SELECT tenant_id, payment_intent_id, SUM(amount) AS refund_amount
+			FROM payment_refunds
+			WHERE status IN ('pending', 'succeeded')
+			GROUP BY tenant_id, payment_intent_id
+		) r ON r.tenant_id = p.tenant_id AND r.payment_intent_id = p.payment_intent_id
+		LEFT JOIN (
+			SELECT tenant_id, user_id, MIN(created_at) AS first_at
+			FROM payment_history
+			WHERE status = 'succeeded' AND test_mode = FALSE
+			GROUP BY tenant_id, user_id
+		) f ON f.tenant_id = p.tenant_id AND f.user_id = p.user_id`
+
+// QueryReport 从 payment_history 实时聚合当前租户的报表（不含 X-Stripe-Mode 请求的测试支付）
+func QueryReport(ctx context.Context, filter ReportFilter) ([]ReportRow, error) {
+	dims, err := dimColumns(filter.GroupBy, reportDimColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	where := []string{"p.tenant_id = ?", "p.test_mode = FALSE", "p.created_at >= ?", "p.created_at < ?"}
+	args := []interface{}{tenant.ID(ctx), filter.Start, filter.End}
+	if filter.Currency != "" {
+		where = append(where, "p.currency = ?")
+		args = append(args, filter.Currency)
+	}
+	if filter.PaymentMethod != "" {
+		where = append(where, "p.payment_method = ?")
+		args = append(args, filter.PaymentMethod)
+	}
+
+	query := buildReportQuery(dims, reportAggregates, reportJoins, where)
+	return runReportQuery(ctx, query, args, filter.GroupBy)
+}
+
+// QueryReportRollup 从 payment_daily_stats 汇总表读取当前租户的报表
+// 跨天汇总时新用户/老用户数为每日去重后的累加值
+func QueryReportRollup(ctx context.Context, filter ReportFilter) ([]ReportRow, error) {
+	dims, err := dimColumns(filter.GroupBy, rollupDimColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	where := []string{"tenant_id = ?", "day >= ?", "day < ?"}
+	args := []interface{}{tenant.ID(ctx), filter.Start.Format("2006-01-02"), filter.End.Format("2006-01-02")}
+	if filter.Currency != "" {
+		where = append(where, "currency = ?")
+		args = append(args, filter.Currency)
+	}
+	if filter.PaymentMethod != "" {
+		where = append(where, "payment_method = ?")
+		args = append(args, filter.PaymentMethod)
+	}
+
+	aggregates := `COALESCE(SUM(attempts), 0), COALESCE(SUM(succeeded_count), 0), COALESCE(SUM(succeeded_amount), 0),
+		COALESCE(SUM(canceled_count), 0), COALESCE(SUM(refunded_payments), 0), COALESCE(SUM(refund_amount), 0),
+		COALESCE(SUM(new_payers), 0), COALESCE(SUM(returning_payers), 0)`
+	query := buildReportQuery(dims, aggregates, "FROM payment_daily_stats", where)
+	return runReportQuery(ctx, query, args, filter.GroupBy)
+}
+
+// RefreshDailyStats 重新计算 [from, to) 日期范围内所有租户的每日统计并写入汇总表（按租户分别汇总，不含测试支付）
+func RefreshDailyStats(ctx context.Context, from, to time.Time) (int64, error) {
+	dims := []string{"p.tenant_id", reportDimColumns[ReportDimDay], reportDimColumns[ReportDimCurrency], reportDimColumns[ReportDimMethod]}
+	selectQuery := buildReportQuery(dims, reportAggregates, reportJoins, []string{"p.test_mode = FALSE", "p.created_at >= ?", "p.created_at < ?"})
+
+	query := `INSERT INTO payment_daily_stats 
+		(tenant_id, day, currency, payment_method, attempts, succeeded_count, succeeded_amount, canceled_count,
+		 refunded_payments, refund_amount, new_payers, returning_payers)
+		` + selectQuery + `
+		ON DUPLICATE KEY UPDATE
+			attempts = VALUES(attempts),
+			succeeded_count = VALUES(succeeded_count),
+			succeeded_amount = VALUES(succeeded_amount),
+			canceled_count = VALUES(canceled_count),
+			refunded_payments = VALUES(refunded_payments),
+			refund_amount = VALUES(refund_amount),
+			new_payers = VALUES(new_payers),
+			returning_payers = VALUES(returning_payers),
+			updated_at = CURRENT_TIMESTAMP`
+
+	result, err := DB.ExecContext(ctx, query, from, to)
+	if err != nil {
+		zap.L().Error("Failed to refresh daily stats", zap.Error(err))
+		return 0, err
+	}
+
+	affected, _ := result.RowsAffected()
+	return affected, nil
+}
+
+// dimColumns 将分组维度转换为列表达式
+func dimColumns(groupBy []string, columns map[string]string) ([]string, error) {
+	dims := make([]string, 0, len(groupBy))
+	for _, dim := range groupBy {
+		column, ok := columns[dim]
+		if !ok {
+			return nil, fmt.Errorf("invalid group_by dimension: %s", dim)
+		}
+		dims = append(dims, column)
+	}
+	return dims, nil
+}
+
+// buildReportQuery 拼接报表 SQL（列名均来自白名单，参数通过占位符传入）
+func buildReportQuery(dims []string, aggregates, from string, where []string) string {
+	selectColumns := aggregates
+	if len(dims) > 0 {
+		selectColumns = strings.Join(dims, ", ") + ", " + aggregates
+	}
+
+	query := "SELECT " + selectColumns + "\n\t\t" + from + "\n\t\tWHERE " + strings.Join(where, " AND ")
+	if len(dims) > 0 {
+		query += "\n\t\tGROUP BY " + strings.Join(dims, ", ") + "\n\t\tORDER BY " + strings.Join(dims, ", ")
+	}
+	return query
+}
+
+// runReportQuery 执行报表查询并按分组维度填充结果（配置了从库时读取从库）
+func runReportQuery(ctx context.Context, query string, args []interface{}, groupBy []string) ([]ReportRow, error) {
+	rows, err := reader(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		zap.L().Error("Failed to query report", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []ReportRow
+	for rows.Next() {
+		var row ReportRow
+		var day time.Time
+		dest := make([]interface{}, 0, len(groupBy)+8)
+		for _, dim := range groupBy {
+			switch dim {
+			case ReportDimDay:
+				dest = append(dest, &day)
+			case ReportDimCurrency:
+				dest = append(dest, &row.Currency)
+			case ReportDimMethod:
+				dest = append(dest, &row.PaymentMethod)
+			}
+		}
+		dest = append(dest,
+			&row.Attempts,
+			&row.SucceededCount,
+			&row.SucceededAmount,
+			&row.CanceledCount,
+			&row.RefundedPayments,
+			&row.RefundAmount,
+			&row.NewPayers,
+			&row.ReturningPayers,
+		)
+
+		if err := rows.Scan(dest...); err != nil {
+			zap.L().Error("Failed to scan report row", zap.Error(err))
+			return nil, err
+		}
+		if !day.IsZero() {
+			row.Day = day.Format("2006-01-02")
+		}
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 后台扫描任务名（sweep_failures.job）
+const (
+	SweepJobPaymentExpiry       = "payment_expiry"
+	SweepJobAuthorizationExpiry = "authorization_expiry"
+	SweepJobReconcile           = "reconcile"
+	SweepJobPromoReservation    = "promo_reservation_expiry"
+)
+
+// maxSweepErrorLength sweep_failures.last_error 的最大长度
+const maxSweepErrorLength = 512
+
+// RecordSweepFailure 记录扫描任务处理某条支付记录失败：失败次数加一，
+// 下次重试时间为 baseDelay × 2^(失败次数-1)，最长 maxDelay；到期前扫描会跳过该记录
+func RecordSweepFailure(ctx context.Context, job string, paymentHistoryID int64, cause error, baseDelay, maxDelay time.Duration) error {
+	message := cause.Error()
+	if len(message) > maxSweepErrorLength {
+		message = message[:maxSweepErrorLength]
+	}
+	baseSeconds, maxSeconds := int64(baseDelay/time.Second), int64(maxDelay/time.Second)
+
+	// ON DUPLICATE KEY UPDATE 按顺序赋值，next_attempt_at 使用加一后的 attempts
+	_, err := DB.ExecContext(ctx, `
+		INSERT INTO sweep_failures (job, payment_history_id, attempts, last_error, next_attempt_at)
+		VALUES (?, ?, 1, ?, DATE_ADD(CURRENT_TIMESTAMP, INTERVAL LEAST(?, ?) SECOND))
+		ON DUPLICATE KEY UPDATE
+			attempts = attempts + 1,
+			last_error = VALUES(last_error),
+			next_attempt_at = DATE_ADD(CURRENT_TIMESTAMP, INTERVAL LEAST(? * POW(2, LEAST(attempts - 1, 30)), ?) SECOND)`,
+		job, paymentHistoryID, message, baseSeconds, maxSeconds, baseSeconds, maxSeconds)
+	if err != nil {
+		zap.L().Error("Failed to record sweep failure", zap.Error(err),
+			zap.String("job", job),
+			zap.Int64("payment_history_id", paymentHistoryID))
+	}
+	return err
+}
+
+// ClearSweepFailure 处理成功后删除失败记录
+func ClearSweepFailure(ctx context.Context, job string, paymentHistoryID int64) error {
+	_, err := DB.ExecContext(ctx,
+		`DELETE FROM sweep_failures WHERE job = ? AND payment_history_id = ?`,
+		job, paymentHistoryID)
+	if err != nil {
+		zap.L().Error("Failed to clear sweep failure", zap.Error(err),
+			zap.String("job", job),
+			zap.Int64("payment_history_id", paymentHistoryID))
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"stripe-pay/tenant"
+
+	"go.uber.org/zap"
+)
+
+const tenantColumns = `id, name, api_key_hash, hosts, stripe_account, apple_bundle_id, currency, receipt_settings, active, created_at, updated_at`
+
+// scanTenant 扫描一行租户
+func scanTenant(scanner interface{ Scan(...interface{}) error }) (*tenant.Tenant, error) {
+	var t tenant.Tenant
+	var apiKeyHash, hosts, stripeAccount, appleBundleID, receiptSettings sql.NullString
+	err := scanner.Scan(&t.ID, &t.Name, &apiKeyHash, &hosts, &stripeAccount, &appleBundleID, &t.Currency, &receiptSettings,
+		&t.Active, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	t.APIKeyHash = apiKeyHash.String
+	t.StripeAccount = stripeAccount.String
+	t.AppleBundleID = appleBundleID.String
+	if hosts.Valid && hosts.String != "" {
+		if err := json.Unmarshal([]byte(hosts.String), &t.Hosts); err != nil {
+			zap.L().Warn("Invalid tenant hosts", zap.Error(err), zap.String("tenant_id", t.ID))
+		}
+	}
+	if receiptSettings.Valid && receiptSettings.String != "" {
+		if err := json.Unmarshal([]byte(receiptSettings.String), &t.Receipt); err != nil {
+			zap.L().Warn("Invalid tenant receipt settings", zap.Error(err), zap.String("tenant_id", t.ID))
+		}
+	}
+	return &t, nil
+}
+
+// tenantHosts hosts 列的值（没有时写入 NULL）
+func tenantHosts(hosts []string) interface{} {
+	if len(hosts) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(hosts)
+	return string(b)
+}
+
+// tenantReceiptSettings receipt_settings 列的值（没有时写入 NULL）
+func tenantReceiptSettings(r *tenant.Receipt) interface{} {
+	if r == nil {
+		return nil
+	}
+	b, _ := json.Marshal(r)
+	return string(b)
+}
+
+// CreateTenant 创建租户
+func CreateTenant(ctx context.Context, t *tenant.Tenant) error {
+	query := `INSERT INTO tenants
+		(id, name, api_key_hash, hosts, stripe_account, apple_bundle_id, currency, receipt_settings, active)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := DB.ExecContext(ctx, query, t.ID, t.Name, nullString(t.APIKeyHash), tenantHosts(t.Hosts),
+		nullString(t.StripeAccount), nullString(t.AppleBundleID), t.PricingCurrency(), tenantReceiptSettings(t.Receipt), t.Active)
+	if err != nil {
+		zap.L().Error("Failed to create tenant", zap.Error(err), zap.String("tenant_id", t.ID))
+		return err
+	}
+
+	zap.L().Info("Tenant created", zap.String("tenant_id", t.ID))
+	return nil
+}
+
+// GetTenant 按标识查询租户（不存在时返回 nil）
+func GetTenant(ctx context.Context, id string) (*tenant.Tenant, error) {
+	row := DB.QueryRowContext(ctx, `SELECT `+tenantColumns+` FROM tenants WHERE id = ?`, id)
+	t, err := scanTenant(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		zap.L().Error("Failed to get tenant", zap.Error(err), zap.String("tenant_id", id))
+		return nil, err
+	}
+	return t, nil
+}
+
+// ListTenants 查询租户列表；activeOnly 时只返回启用的租户
+func ListTenants(ctx context.Context, activeOnly bool) ([]tenant.Tenant, error) {
+	query := `SELECT ` + tenantColumns + ` FROM tenants`
+	if activeOnly {
+		query += ` WHERE active = TRUE`
+	}
+	query += ` ORDER BY created_at, id`
+
+	rows, err := DB.QueryContext(ctx, query)
+	if err != nil {
+		zap.L().Error("Failed to list tenants", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []tenant.Tenant
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			zap.L().Error("Failed to scan tenant", zap.Error(err))
+			continue
+		}
+		tenants = append(tenants, *t)
+	}
+	return tenants, rows.Err()
+}
+
+// UpdateTenant 更新租户配置（不修改 API Key），返回是否找到
+func UpdateTenant(ctx context.Context, t *tenant.Tenant) (bool, error) {
+	query := `UPDATE tenants
+		SET name = ?, hosts = ?, stripe_account = ?, apple_bundle_id = ?, currency = ?, receipt_settings = ?, active = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`
+
+	result, err := DB.ExecContext(ctx, query, t.Name, tenantHosts(t.Hosts), nullString(t.StripeAccount),
+		nullString(t.AppleBundleID), t.PricingCurrency(), tenantReceiptSettings(t.Receipt), t.Active, t.ID)
+	if err != nil {
+		zap.L().Error("Failed to update tenant", zap.Error(err), zap.String("tenant_id", t.ID))
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		// 值未变化时 RowsAffected 为 0，确认租户是否存在
+		existing, err := GetTenant(ctx, t.ID)
+		return existing != nil, err
+	}
+	return true, nil
+}
+
+// SetTenantAPIKeyHash 更换租户的 API Key（旧 Key 立即失效），返回是否找到
+func SetTenantAPIKeyHash(ctx context.Context, id, apiKeyHash string) (bool, error) {
+	result, err := DB.ExecContext(ctx,
+		`UPDATE tenants SET api_key_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, apiKeyHash, id)
+	if err != nil {
+		zap.L().Error("Failed to rotate tenant api key", zap.Error(err), zap.String("tenant_id", id))
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
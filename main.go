@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"stripe-pay/biz/handlers"
+	"stripe-pay/biz/jobs"
 	"stripe-pay/cache"
 	"stripe-pay/common"
 	"stripe-pay/conf"
@@ -17,12 +18,16 @@ import (
 	"github.com/cloudwego/hertz/pkg/common/hlog"
 	"github.com/cloudwego/hertz/pkg/common/utils"
 	"github.com/cloudwego/hertz/pkg/protocol/consts"
-	"github.com/hertz-contrib/cors"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 func main() {
+	// secrets 子命令用于生成加密密钥文件，不依赖配置
+	if len(os.Args) > 1 && os.Args[1] == "secrets" {
+		os.Exit(runSecretsCommand(os.Args[2:]))
+	}
+
 	// 初始化配置
 	if err := conf.Init(); err != nil {
 		panic(err)
@@ -31,6 +36,21 @@ func main() {
 	// 初始化日志
 	initLogger()
 
+	// 配置重新加载时更新日志级别
+	conf.Subscribe("logger", func(old, cfg *conf.Config) {
+		if old == nil || old.Log.Level != cfg.Log.Level {
+			level := parseLogLevel(cfg.Log.Level)
+			logLevel.SetLevel(level)
+			hlog.SetLevel(hertzLogLevel(level))
+			zap.L().Info("Log level updated", zap.String("level", level.String()))
+		}
+	})
+
+	// 子命令（migrate 等）执行完直接退出，不启动 HTTP 服务
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// 初始化数据库
 	dbInitialized := false
 	if err := db.Init(); err != nil {
@@ -57,37 +77,8 @@ func main() {
 		server.WithHostPorts(cfg.Server.Host + ":" + cfg.Server.Port),
 	)
 
-	// 添加全局 CORS 头处理（必须放在最前面，确保所有响应都包含 CORS 头）
-	h.Use(func(ctx context.Context, c *app.RequestContext) {
-		origin := string(c.Request.Header.Get("Origin"))
-		// 如果请求包含 Origin 头，使用该 Origin；否则允许所有源
-		if origin != "" {
-			c.Header("Access-Control-Allow-Origin", origin)
-		} else {
-			c.Header("Access-Control-Allow-Origin", "*")
-		}
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept")
-		c.Header("Access-Control-Allow-Credentials", "false")
-		c.Header("Access-Control-Max-Age", "43200") // 12 hours
-
-		if string(c.Request.Method()) == "OPTIONS" {
-			c.JSON(consts.StatusOK, utils.H{})
-			c.Abort()
-			return
-		}
-		c.Next(ctx)
-	})
-
-	// 添加 CORS 中间件（作为备用）
-	h.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Content-Type", "Authorization", "Accept"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: false,
-		MaxAge:           12 * time.Hour,
-	}))
+	// 添加全局 CORS 处理（必须放在最前面，确保所有响应都包含 CORS 头；配置重新加载后立即生效）
+	h.Use(common.CORSMiddleware())
 
 	// 添加监控指标中间件（必须在最前面，以便记录所有请求）
 	h.Use(common.MetricsMiddleware())
@@ -95,20 +86,47 @@ func main() {
 	// 添加请求日志中间件（记录请求开始、结束和耗时）
 	h.Use(common.RequestLogger())
 
+	// 添加租户识别中间件（X-API-Key 或 Host；限流、幂等、缓存和数据库查询都按租户隔离，必须在它们之前）
+	h.Use(common.TenantMiddleware())
+
 	// 添加速率限制中间件（防止恶意刷接口）
 	h.Use(common.RateLimitMiddleware())
 
 	// 添加错误恢复中间件（捕获panic）
 	h.Use(common.RecoveryHandler())
 
+	// 添加幂等中间件（携带 Idempotency-Key 的 POST/PUT 请求保存并重放响应）
+	h.Use(common.IdempotencyMiddleware())
+
+	// 添加读写分离中间件（写请求内的读取固定到主库）
+	h.Use(common.PrimaryReadsMiddleware())
+
+	// 添加 Stripe 账户选择中间件（租户、X-Region 和测试模式请求头）
+	h.Use(common.StripeAccountMiddleware())
+
 	// 注册路由
 	registerRoutes(h)
 
 	// 添加错误处理中间件（处理c.Errors，必须在路由注册之后）
 	h.Use(common.ErrorHandler())
 
+	// 启动后台任务（对账等，依赖数据库）
+	var scheduler *jobs.Scheduler
+	if dbInitialized {
+		scheduler = jobs.NewScheduler()
+		jobs.RegisterJobs(scheduler, cfg)
+		scheduler.Start()
+	}
+
+	// 监听配置文件变化和 SIGHUP，重新加载配置
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	if err := conf.Watch(watchCtx); err != nil {
+		zap.L().Warn("Config hot reload disabled", zap.Error(err))
+	}
+
 	// 设置优雅关闭（必须在启动前设置）
-	setupGracefulShutdown(h, dbInitialized, cacheInitialized)
+	setupGracefulShutdown(h, scheduler, dbInitialized, cacheInitialized)
 
 	// 启动服务器
 	zap.L().Info("Server starting",
@@ -123,6 +141,12 @@ func main() {
 	zap.L().Info("Server stopped, performing cleanup...")
 
 	// 执行清理
+	if scheduler != nil {
+		zap.L().Info("Stopping background jobs...")
+		stopCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		scheduler.Stop(stopCtx)
+		cancel()
+	}
 	if dbInitialized {
 		zap.L().Info("Closing database connections...")
 		db.Close()
@@ -137,11 +161,18 @@ func main() {
 }
 
 // setupGracefulShutdown 设置优雅关闭
-func setupGracefulShutdown(h *server.Hertz, dbInitialized, cacheInitialized bool) *common.ShutdownManager {
+func setupGracefulShutdown(h *server.Hertz, scheduler *jobs.Scheduler, dbInitialized, cacheInitialized bool) *common.ShutdownManager {
 	// 创建关闭管理器
 	shutdownManager := common.NewShutdownManager(h)
 
 	// 注册关闭函数
+	if scheduler != nil {
+		shutdownManager.RegisterShutdownFunc(common.CreateShutdownFunc("jobs", func() error {
+			zap.L().Info("Stopping background jobs...")
+			return scheduler.Stop(context.Background())
+		}))
+	}
+
 	if dbInitialized {
 		shutdownManager.RegisterShutdownFunc(common.CreateShutdownFunc("database", func() error {
 			zap.L().Info("Closing database connections...")
@@ -173,6 +204,10 @@ func registerRoutes(h *server.Hertz) {
 	// 增强的健康检查
 	h.GET("/health", handlers.HealthCheck)
 
+	// Kubernetes 探针：存活检查只看进程，就绪检查看关键依赖
+	h.GET("/livez", handlers.Livez)
+	h.GET("/readyz", handlers.Readyz)
+
 	// Prometheus 指标端点
 	h.GET("/metrics", common.MetricsHandler)
 
@@ -245,10 +280,21 @@ func registerRoutes(h *server.Hertz) {
 			paymentAPI.POST("/create-alipay-payment", handlers.CreateStripeAlipayPayment)
 			paymentAPI.POST("/confirm-payment", handlers.ConfirmStripePayment)
 			paymentAPI.POST("/refund", handlers.RefundPayment)
+			// 手动扣款（capture_method=manual，商户后台调用，需要 admin.api_key，可用 X-Tenant-ID 指定租户）
+			paymentAPI.POST("/capture", common.AdminAuthMiddleware(), common.AdminTenantMiddleware(), handlers.CapturePayment)
+			paymentAPI.POST("/cancel-authorization", common.AdminAuthMiddleware(), common.AdminTenantMiddleware(), handlers.CancelAuthorization)
+			// 保存卡片（SetupIntent，需要请求体中 user_id 的 X-User-Token）
+			paymentAPI.POST("/setup-intent", handlers.CreateSetupIntent)
+			// Stripe Checkout 托管支付页面
+			paymentAPI.POST("/checkout-session", handlers.CreateCheckoutSession)
 		}
 
 		// Webhook 不需要速率限制（由 Stripe 控制）
 		api.POST("/stripe/webhook", handlers.StripeWebhook)
+		api.POST("/stripe/webhook/:account", handlers.StripeWebhook)
+		// Connect 类型端点（连接账户的事件），签名密钥为 connect_webhook_secret
+		api.POST("/stripe/connect-webhook", handlers.StripeConnectWebhook)
+		api.POST("/stripe/connect-webhook/:account", handlers.StripeConnectWebhook)
 
 		// Apple 内购
 		api.POST("/apple/verify", handlers.VerifyApplePurchase)
@@ -259,6 +305,18 @@ func registerRoutes(h *server.Hertz) {
 		api.GET("/user/:user_id/payment-info", handlers.GetUserPaymentInfo)
 		api.GET("/user/:user_id/payment-history", handlers.GetUserPaymentHistory)
 
+		// 已保存的支付方式（需要该用户的 X-User-Token）
+		api.GET("/user/:user_id/payment-methods", common.UserAuthMiddleware(), handlers.ListSavedPaymentMethods)
+		api.DELETE("/user/:user_id/payment-methods/:payment_method_id", common.UserAuthMiddleware(), handlers.DeleteSavedPaymentMethod)
+
+		// 通知设置（邮箱、语言、退订，需要该用户的 X-User-Token）
+		api.GET("/user/:user_id/notification-settings", common.UserAuthMiddleware(), handlers.GetNotificationSettings)
+		api.PUT("/user/:user_id/notification-settings", common.UserAuthMiddleware(), handlers.UpdateNotificationSettings)
+
+		// Stripe Connect 创作者收款账户（需要该用户的 X-User-Token）
+		api.POST("/user/:user_id/connect/onboarding", common.UserAuthMiddleware(), handlers.CreateConnectOnboardingLink)
+		api.GET("/user/:user_id/connect", common.UserAuthMiddleware(), handlers.GetConnectedAccount)
+
 		// 支付状态相关接口（应用更严格的速率限制）
 		paymentStatusAPI := api.Group("/payment")
 		paymentStatusAPI.Use(common.PaymentRateLimitMiddleware())
@@ -269,14 +327,46 @@ func registerRoutes(h *server.Hertz) {
 			paymentStatusAPI.GET("/status/:id", handlers.GetPaymentStatus)
 			// 支付状态变化查询
 			paymentStatusAPI.GET("/status-change/:payment_intent_id", handlers.CheckStatusChange)
+			// 收据（HTML / PDF）
+			paymentStatusAPI.GET("/:id/receipt", handlers.GetPaymentReceipt)
 		}
 
 		// 支付配置管理（管理员接口）
 		api.GET("/payment/config", handlers.GetPaymentConfig)
 		api.PUT("/payment/config", handlers.UpdatePaymentConfig)
+
+		// 管理接口（需要 admin.api_key）
+		adminAPI := api.Group("/admin")
+		adminAPI.Use(common.AdminAuthMiddleware(), common.AdminTenantMiddleware())
+		{
+			// 统计报表：revenue, success-rate, refund-rate, payers
+			adminAPI.GET("/reports/:name", handlers.GetReport)
+			// 支付记录搜索（游标分页）
+			adminAPI.GET("/payments", handlers.SearchPayments)
+			// 优惠码管理
+			adminAPI.POST("/promo-codes", handlers.CreatePromoCode)
+			adminAPI.GET("/promo-codes", handlers.ListPromoCodes)
+			adminAPI.DELETE("/promo-codes/:code", handlers.DeactivatePromoCode)
+			adminAPI.POST("/fraud-rules", handlers.CreateFraudRule)
+			adminAPI.GET("/fraud-rules", handlers.ListFraudRules)
+			adminAPI.DELETE("/fraud-rules/:id", handlers.DeactivateFraudRule)
+			adminAPI.GET("/fraud-decisions", handlers.ListFraudDecisions)
+			// 租户管理
+			adminAPI.POST("/tenants", handlers.CreateTenant)
+			adminAPI.GET("/tenants", handlers.ListTenants)
+			adminAPI.GET("/tenants/:id", handlers.GetTenant)
+			adminAPI.PUT("/tenants/:id", handlers.UpdateTenant)
+			adminAPI.DELETE("/tenants/:id", handlers.DeactivateTenant)
+			adminAPI.POST("/tenants/:id/api-key", handlers.RotateTenantAPIKey)
+			adminAPI.GET("/tenants/:id/pricing", handlers.GetTenantPricing)
+			adminAPI.PUT("/tenants/:id/pricing", handlers.UpdateTenantPricing)
+		}
 	}
 }
 
+// logLevel 全局日志级别，配置重新加载时原地修改
+var logLevel = zap.NewAtomicLevel()
+
 func initLogger() {
 	cfg := conf.GetConf()
 
@@ -290,30 +380,17 @@ func initLogger() {
 	}
 
 	// 解析日志级别
-	var logLevel zapcore.Level
 	levelStr := cfg.Log.Level
 	if levelStr == "" {
 		levelStr = "info"
 	}
-
-	switch levelStr {
-	case "debug":
-		logLevel = zapcore.DebugLevel
-	case "info":
-		logLevel = zapcore.InfoLevel
-	case "warn":
-		logLevel = zapcore.WarnLevel
-	case "error":
-		logLevel = zapcore.ErrorLevel
-	default:
-		logLevel = zapcore.InfoLevel
-	}
+	logLevel.SetLevel(parseLogLevel(levelStr))
 
 	// 根据环境创建日志配置
 	if env == "production" {
 		// 生产环境配置
 		config := zap.NewProductionConfig()
-		config.Level = zap.NewAtomicLevelAt(logLevel)
+		config.Level = logLevel
 
 		// 根据输出格式选择编码器
 		if cfg.Log.Output == "json" {
@@ -329,13 +406,13 @@ func initLogger() {
 
 		// 禁用调用者信息（生产环境性能优化）
 		config.DisableCaller = false
-		config.DisableStacktrace = logLevel > zapcore.ErrorLevel
+		config.DisableStacktrace = logLevel.Level() > zapcore.ErrorLevel
 
 		logger, err = config.Build()
 	} else {
 		// 开发环境配置
 		config := zap.NewDevelopmentConfig()
-		config.Level = zap.NewAtomicLevelAt(logLevel)
+		config.Level = logLevel
 
 		// 开发环境使用彩色控制台输出
 		config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
@@ -350,20 +427,7 @@ func initLogger() {
 	zap.ReplaceGlobals(logger)
 
 	// 设置 Hertz 日志级别
-	var hzLevel hlog.Level
-	switch logLevel {
-	case zapcore.DebugLevel:
-		hzLevel = hlog.LevelDebug
-	case zapcore.InfoLevel:
-		hzLevel = hlog.LevelInfo
-	case zapcore.WarnLevel:
-		hzLevel = hlog.LevelWarn
-	case zapcore.ErrorLevel:
-		hzLevel = hlog.LevelError
-	default:
-		hzLevel = hlog.LevelInfo
-	}
-	hlog.SetLevel(hzLevel)
+	hlog.SetLevel(hertzLogLevel(logLevel.Level()))
 
 	// 记录日志系统初始化信息
 	zap.L().Info("Logger initialized",
@@ -371,3 +435,31 @@ func initLogger() {
 		zap.String("level", levelStr),
 		zap.String("output", cfg.Log.Output))
 }
+
+// parseLogLevel 解析日志级别，无法识别时为 info
+func parseLogLevel(level string) zapcore.Level {
+	switch level {
+	case "debug":
+		return zapcore.DebugLevel
+	case "warn":
+		return zapcore.WarnLevel
+	case "error":
+		return zapcore.ErrorLevel
+	default:
+		return zapcore.InfoLevel
+	}
+}
+
+// hertzLogLevel 将 zap 日志级别转换为 Hertz 日志级别
+func hertzLogLevel(level zapcore.Level) hlog.Level {
+	switch level {
+	case zapcore.DebugLevel:
+		return hlog.LevelDebug
+	case zapcore.WarnLevel:
+		return hlog.LevelWarn
+	case zapcore.ErrorLevel:
+		return hlog.LevelError
+	default:
+		return hlog.LevelInfo
+	}
+}
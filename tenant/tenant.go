@@ -0,0 +1,117 @@
+// Package tenant 租户标识：请求由中间件按 API Key 或 Host 解析出租户，
+// 数据库查询、缓存键和限流键都按 context 中的租户隔离
+package tenant
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// DefaultID 默认租户：迁移前的数据和没有匹配到其他租户的请求都属于它
+const DefaultID = "default"
+
+// DefaultCurrency 租户未配置币种时的定价币种
+const DefaultCurrency = "hkd"
+
+// Tenant 租户配置
+type Tenant struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Hosts         []string  `json:"hosts"`             // 按 Host 请求头识别租户
+	StripeAccount string    `json:"stripe_account"`    // 使用的 Stripe 账户（stripe.accounts[].id），为空时按地区选择
+	AppleBundleID string    `json:"apple_bundle_id"`   // Apple 收据必须属于该 bundle，为空不校验
+	Currency      string    `json:"currency"`          // 定价币种（payment_config.currency）
+	Receipt       *Receipt  `json:"receipt,omitempty"` // 收据设置，为空时使用全局 receipt 配置
+	Active        bool      `json:"active"`
+	APIKeyHash    string    `json:"-"` // API Key 的 SHA-256（十六进制），不保存明文
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Receipt 租户的收据设置：未设置的项使用全局 receipt 配置。
+// 公司信息整体覆盖（设置了 company.name 时使用租户的全部公司信息），避免收据上混用两家公司的税号、地址
+type Receipt struct {
+	NumberPrefix string          `json:"number_prefix,omitempty"` // 收据编号前缀
+	BrandColor   string          `json:"brand_color,omitempty"`
+	LogoURL      string          `json:"logo_url,omitempty"`
+	TaxName      string          `json:"tax_name,omitempty"`
+	TaxRate      *float64        `json:"tax_rate,omitempty"` // 税率（百分比），为空时使用全局配置，0 表示不显示税项
+	Company      *ReceiptCompany `json:"company,omitempty"`
+}
+
+// ReceiptCompany 收据上的公司信息
+type ReceiptCompany struct {
+	Name    string   `json:"name"`
+	Address []string `json:"address,omitempty"`
+	Email   string   `json:"email,omitempty"`
+	Phone   string   `json:"phone,omitempty"`
+	TaxID   string   `json:"tax_id,omitempty"`
+	Website string   `json:"website,omitempty"`
+}
+
+// PricingCurrency 租户的定价币种
+func (t *Tenant) PricingCurrency() string {
+	if t.Currency == "" {
+		return DefaultCurrency
+	}
+	return t.Currency
+}
+
+type contextKey struct{}
+
+// WithTenant 在 context 中记录请求的租户
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// WithID 在 context 中只记录租户标识（Webhook、后台任务按记录所属租户处理时使用）
+func WithID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		id = DefaultID
+	}
+	return WithTenant(ctx, &Tenant{ID: id, Active: true})
+}
+
+// FromContext 读取 context 中的租户，没有时为默认租户
+func FromContext(ctx context.Context) *Tenant {
+	if t, ok := ctx.Value(contextKey{}).(*Tenant); ok && t != nil {
+		return t
+	}
+	return &Tenant{ID: DefaultID, Active: true}
+}
+
+// ID 读取 context 中的租户标识，没有时为 default
+func ID(ctx context.Context) string {
+	return FromContext(ctx).ID
+}
+
+// Key 为缓存和限流键加上租户前缀；默认租户不加前缀，与启用租户前的键保持一致
+func Key(ctx context.Context, key string) string {
+	id := ID(ctx)
+	if id == DefaultID {
+		return key
+	}
+	return "tenant:" + id + ":" + key
+}
+
+// APIKeyPrefix 租户 API Key 的前缀，便于在日志和配置中识别
+const APIKeyPrefix = "tk_"
+
+// HashAPIKey 计算 API Key 的 SHA-256（十六进制），数据库只保存该值
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKey 生成新的 API Key，返回明文（只展示一次）和保存用的哈希
+func NewAPIKey() (key, hash string, err error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + hex.EncodeToString(b)
+	return key, HashAPIKey(key), nil
+}
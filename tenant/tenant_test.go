@@ -0,0 +1,48 @@
+package tenant
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestKey 测试缓存和限流键的租户前缀
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"没有租户", context.Background(), "payment:p1"},
+		{"默认租户", WithID(context.Background(), DefaultID), "payment:p1"},
+		{"空标识按默认租户", WithID(context.Background(), ""), "payment:p1"},
+		{"其他租户", WithID(context.Background(), "acme"), "tenant:acme:payment:p1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Key(tt.ctx, "payment:p1"); got != tt.want {
+				t.Errorf("Key() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNewAPIKey 测试 API Key 生成和哈希
+func TestNewAPIKey(t *testing.T) {
+	key, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatalf("NewAPIKey() error = %v", err)
+	}
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		t.Errorf("NewAPIKey() key = %q, want prefix %q", key, APIKeyPrefix)
+	}
+	if hash != HashAPIKey(key) || len(hash) != 64 {
+		t.Errorf("NewAPIKey() hash = %q, want HashAPIKey(key)", hash)
+	}
+
+	other, _, _ := NewAPIKey()
+	if other == key {
+		t.Error("NewAPIKey() returned the same key twice")
+	}
+}